@@ -31,6 +31,11 @@ function run_e2e_test() {
         --ssh-key ${KETO_SSH_KEY_NAME} \
         --networks ${TEST_NETWORK_IDS} || return
 
+    echo "[INFO] Checking keto cluster health"
+    keto --cloud ${KETO_CLOUD_PROVIDER} check cluster ${CLUSTER_NAME} \
+        --assets-dir ${KETO_ASSETS_DIR} \
+        --wait 15m || return
+
     # TODO: Modify once Keto adds capability to auto generate config using the client cli
     echo "[INFO] Generating Kubernetes config"
     generate_kube_config || return
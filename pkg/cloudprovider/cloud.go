@@ -14,7 +14,7 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-//go:generate mockery -dir $GOPATH/src/github.com/UKHomeOffice/keto/pkg/cloudprovider -all
+//go:generate mockery --dir $GOPATH/src/github.com/UKHomeOffice/keto/pkg/cloudprovider --all
 
 package cloudprovider
 
@@ -35,12 +35,21 @@ type Interface interface {
 	// Node returns a node interface. Also returns true if the interface is
 	// supported, false otherwise.
 	Node() (Node, bool)
+	// Bastions returns a bastion pools interface. Also returns true if the
+	// interface is supported, false otherwise.
+	Bastions() (Bastions, bool)
+	// Checker returns a cluster checks interface. Also returns true if the
+	// interface is supported, false otherwise.
+	Checker() (Checker, bool)
 }
 
 // Clusters is an abstract interface for clusters.
 type Clusters interface {
 	// CreateClusterInfra creates infra components for a new cluster.
 	CreateClusterInfra(model.Cluster) error
+	// UpdateClusterInfra updates infra components of an existing cluster,
+	// e.g. to change its access rules.
+	UpdateClusterInfra(model.Cluster) error
 	// GetClusters returns a list of clusters in the cloud account.
 	GetClusters(name string) ([]*model.Cluster, error)
 	// DescribeCluster describes a given cluster.
@@ -66,12 +75,18 @@ type NodePooler interface {
 	GetMasterPools(clusterName, name string) ([]*model.MasterPool, error)
 	// GetComputePools returns a list of compute pools in the cloud.
 	GetComputePools(clusterName, name string) ([]*model.ComputePool, error)
+	// GetPoolInstances returns a list of instances of master and compute
+	// pools. Instances can be filtered by cluster and pool name.
+	GetPoolInstances(clusterName, poolName string) ([]*model.Instance, error)
+	// GetInstanceConsoleOutput returns console output of an instance.
+	GetInstanceConsoleOutput(instanceID string) (string, error)
 	// DescribeNodePool describes a given node pool.
 	// TODO
 	DescribeNodePool() error
-	// UpgradePool upgrades a node pool.
-	// TODO
-	UpgradeNodePool() error
+	// UpgradeMasterPool updates an existing master node pool in place.
+	UpgradeMasterPool(pool model.MasterPool) error
+	// UpgradeComputePool updates an existing compute node pool in place.
+	UpgradeComputePool(pool model.ComputePool) error
 	// DeleteMasterPool deletes a master node pool.
 	DeleteMasterPool(clusterName string) error
 	// DeleteComputePool deletes a compute node pool.
@@ -86,3 +101,27 @@ type Node interface {
 	// GetNodeData returns node data.
 	GetNodeData() (model.NodeData, error)
 }
+
+// Bastions is an abstract interface for bastion pools, which provide SSH
+// access to nodes of internal clusters.
+type Bastions interface {
+	// CreateBastionPool creates a bastion pool for a cluster.
+	CreateBastionPool(pool model.BastionPool) error
+	// GetBastionPools returns a list of bastion pools in the cloud.
+	GetBastionPools(clusterName string) ([]*model.BastionPool, error)
+	// DeleteBastionPool deletes a bastion pool of a cluster.
+	DeleteBastionPool(clusterName string) error
+}
+
+// Checker is an abstract interface for health checks of cloud resources and
+// pre-flight validation of resources that are about to be created.
+type Checker interface {
+	// CheckClusterInfra runs health checks of cloud resources of a cluster.
+	CheckClusterInfra(clusterName string) ([]model.CheckResult, error)
+	// ValidateCluster runs pre-flight checks of a cluster that is about to
+	// be created.
+	ValidateCluster(cluster model.Cluster) ([]model.CheckResult, error)
+	// ValidateComputePool runs pre-flight checks of a compute pool that is
+	// about to be created.
+	ValidateComputePool(p model.ComputePool) ([]model.CheckResult, error)
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudprovider
+
+import (
+	"fmt"
+
+	"github.com/UKHomeOffice/keto/pkg/constants"
+	"github.com/UKHomeOffice/keto/pkg/model"
+)
+
+// OnDemandOnly returns true if a compute pool instances policy asks for
+// on-demand instances of a single machine type, which all clouds support.
+func OnDemandOnly(p model.InstancesPolicy) bool {
+	if p.PurchaseStrategy != "" && p.PurchaseStrategy != constants.PurchaseStrategyOnDemand {
+		return false
+	}
+	return len(p.MachineTypes) == 0
+}
+
+// FixedSize returns true if a node pool is not meant to be autoscaled.
+func FixedSize(p model.NodePoolSpec) bool {
+	return p.MaxSize == 0 || p.MinSize == p.MaxSize
+}
+
+// DefaultAccess returns true if cluster access rules allow SSH and kube API
+// access from anywhere, which all clouds support.
+func DefaultAccess(a model.Access) bool {
+	for _, cidrs := range [][]string{a.SSHCIDRs, a.APICIDRs} {
+		if len(cidrs) > 1 || (len(cidrs) == 1 && cidrs[0] != constants.DefaultAccessCIDR) {
+			return false
+		}
+	}
+	return !a.DisableSSH && len(a.SourceSecurityGroups) == 0
+}
+
+// DefaultLoadBalancerType returns true if kube API is fronted by the default
+// load balancer type, which all clouds support.
+func DefaultLoadBalancerType(t string) bool {
+	return t == "" || t == constants.DefaultLoadBalancerType
+}
+
+// DefaultAPINames returns true if kube API is only known by its default DNS
+// name, which all clouds support.
+func DefaultAPINames(c model.Cluster) bool {
+	if len(c.APIAliases) > 0 || len(c.APIExtraSANs) > 0 {
+		return false
+	}
+	return c.APIHostname == "" || (c.DNSZone != "" && c.APIHostname == fmt.Sprintf("kube-%s.%s", c.Name, c.DNSZone))
+}
+
+// PublicDNSZone returns true if kube API records are created in a public DNS
+// zone that is looked up by name, which all clouds support.
+func PublicDNSZone(c model.Cluster) bool {
+	return len(c.DNSZoneIDs) == 0 && !c.PrivateDNSZone && !c.SplitHorizonDNS
+}
+
+// ExistingNetworks returns true if a cluster is created in existing networks,
+// which all clouds support.
+func ExistingNetworks(c model.Cluster) bool {
+	return c.NetworkCIDR == ""
+}
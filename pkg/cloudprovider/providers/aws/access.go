@@ -0,0 +1,133 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/UKHomeOffice/keto/pkg/model"
+
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+)
+
+const (
+	sshPort = 22
+	apiPort = 443
+
+	// accessTemplate renders security group ingress rules of cluster access
+	// CIDRs and source security groups. A security group without any rules
+	// gets an empty list, so that stack updates remove existing rules.
+	accessTemplate = `{{ define "access-ingress" }}
+      SecurityGroupIngress:{{ if not (or .CIDRs .SecurityGroups) }} []{{ end }}
+{{- range .CIDRs }}
+        - IpProtocol: "6"
+          CidrIp: "{{ . }}"
+          FromPort: "{{ $.Port }}"
+          ToPort: "{{ $.Port }}"
+{{- end }}
+{{- range .SecurityGroups }}
+        - IpProtocol: "6"
+          SourceSecurityGroupId: "{{ . }}"
+          FromPort: "{{ $.Port }}"
+          ToPort: "{{ $.Port }}"
+{{- end }}
+{{- end }}
+{{ define "access-outputs" }}
+{{- range $k, $v := . }}
+  {{ $k }}:
+    Value: "{{ $v }}"
+{{ end }}
+{{- end }}`
+)
+
+// accessIngress is a set of sources that are allowed access to a port.
+type accessIngress struct {
+	Port           int
+	CIDRs          []string
+	SecurityGroups []string
+}
+
+// makeSSHIngress returns SSH ingress rules of node pool security groups.
+func makeSSHIngress(a model.Access) accessIngress {
+	if a.DisableSSH {
+		return accessIngress{Port: sshPort}
+	}
+	return accessIngress{
+		Port:           sshPort,
+		CIDRs:          a.SSHCIDRs,
+		SecurityGroups: a.SourceSecurityGroups,
+	}
+}
+
+// makeBastionSSHIngress returns SSH ingress rules of a bastion pool security
+// group. Bastion hosts don't follow cluster SSH CIDRs, so that they stay
+// reachable when direct SSH access to nodes is disabled.
+func makeBastionSSHIngress(p model.BastionPool, a model.Access) accessIngress {
+	return accessIngress{
+		Port:           sshPort,
+		CIDRs:          p.AllowedCIDRs,
+		SecurityGroups: a.SourceSecurityGroups,
+	}
+}
+
+// makeAPIIngress returns kube API ingress rules of a load balancer security
+// group.
+func makeAPIIngress(a model.Access) accessIngress {
+	return accessIngress{
+		Port:           apiPort,
+		CIDRs:          a.APICIDRs,
+		SecurityGroups: a.SourceSecurityGroups,
+	}
+}
+
+// makeAccessOutputs returns cluster infra stack outputs of access rules.
+func makeAccessOutputs(a model.Access) map[string]string {
+	return map[string]string{
+		sshCIDRsOutputKey:             strings.Join(a.SSHCIDRs, ","),
+		disableSSHOutputKey:           strconv.FormatBool(a.DisableSSH),
+		apiCIDRsOutputKey:             strings.Join(a.APICIDRs, ","),
+		sourceSecurityGroupsOutputKey: strings.Join(a.SourceSecurityGroups, ","),
+	}
+}
+
+// setAccessOutput sets a cluster access rule from a cluster infra stack
+// output. Other outputs are ignored.
+func setAccessOutput(a *model.Access, o *cloudformation.Output) error {
+	var err error
+	v := *o.OutputValue
+	switch *o.OutputKey {
+	case sshCIDRsOutputKey:
+		a.SSHCIDRs = splitOutputValue(v)
+	case disableSSHOutputKey:
+		a.DisableSSH, err = strconv.ParseBool(v)
+	case apiCIDRsOutputKey:
+		a.APICIDRs = splitOutputValue(v)
+	case sourceSecurityGroupsOutputKey:
+		a.SourceSecurityGroups = splitOutputValue(v)
+	}
+	return err
+}
+
+// splitOutputValue splits a comma separated output value. An empty value
+// results in a nil slice.
+func splitOutputValue(v string) []string {
+	if v == "" {
+		return nil
+	}
+	return strings.Split(v, ",")
+}
@@ -21,22 +21,29 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/UKHomeOffice/keto/pkg/cloudprovider"
+	"github.com/UKHomeOffice/keto/pkg/components"
+	"github.com/UKHomeOffice/keto/pkg/constants"
 	"github.com/UKHomeOffice/keto/pkg/model"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/ec2metadata"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
 	"github.com/aws/aws-sdk-go/service/cloudformation"
 	"github.com/aws/aws-sdk-go/service/cloudformation/cloudformationiface"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/aws/aws-sdk-go/service/elb"
 	"github.com/aws/aws-sdk-go/service/elb/elbiface"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
 	"github.com/aws/aws-sdk-go/service/route53"
 	"github.com/aws/aws-sdk-go/service/route53/route53iface"
 	"github.com/aws/aws-sdk-go/service/s3"
@@ -75,8 +82,10 @@ type Cloud struct {
 	cf     cloudformationiface.CloudFormationAPI
 	ec2    ec2iface.EC2API
 	elb    elbiface.ELBAPI
+	elbv2  elbv2iface.ELBV2API
 	s3     s3iface.S3API
 	r53    route53iface.Route53API
+	asg    autoscalingiface.AutoScalingAPI
 }
 
 // Compile-time check whether Cloud type value implements
@@ -94,20 +103,15 @@ func (c *Cloud) Clusters() (cloudprovider.Clusters, bool) {
 }
 
 // CreateClusterInfra creates a new cluster, by creating ENIs, volumes and other
-// cluster infra related resources.
+// cluster infra related resources. A network is created first if the cluster
+// has a network CIDR, master nodes are placed in its private subnets.
 func (c *Cloud) CreateClusterInfra(cluster model.Cluster) error {
-	// Check whether hosted zone exists before creating any stacks.
-	if cluster.DNSZone != "" {
-		params := &route53.ListHostedZonesByNameInput{
-			DNSName: aws.String(cluster.DNSZone),
-		}
-		r, err := c.r53.ListHostedZonesByName(params)
+	if cluster.NetworkCIDR != "" {
+		n, err := c.createNetwork(cluster)
 		if err != nil {
-			return fmt.Errorf("failed to list route53 dns zone: %v", err)
-		}
-		if r.HostedZones == nil {
-			return fmt.Errorf("dns zone %q does not exist", cluster.DNSZone)
+			return err
 		}
+		cluster.MasterPool.Networks = n.PrivateSubnets
 	}
 
 	subnets, err := c.describeSubnets(cluster.MasterPool.Networks)
@@ -121,6 +125,20 @@ func (c *Cloud) CreateClusterInfra(cluster model.Cluster) error {
 	}
 	c.Logger.Printf("found %q VPC ID", vpcID)
 
+	// Check whether hosted zones exist before creating cluster infra stacks.
+	if cluster.DNSZoneIDs, err = c.getHostedZoneIDs(cluster, vpcID); err != nil {
+		return err
+	}
+
+	// VPC CIDRs are not proxied by nodes.
+	vpcs, err := c.ec2.DescribeVpcs(&ec2.DescribeVpcsInput{VpcIds: []*string{aws.String(vpcID)}})
+	if err != nil {
+		return err
+	}
+	for _, v := range vpcs.Vpcs {
+		cluster.NetworkCIDRs = append(cluster.NetworkCIDRs, aws.StringValue(v.CidrBlock))
+	}
+
 	if err := c.createClusterInfraStack(cluster, vpcID, subnets); err != nil {
 		return err
 	}
@@ -131,6 +149,68 @@ func (c *Cloud) CreateClusterInfra(cluster model.Cluster) error {
 	return c.createLoadBalancer(cluster)
 }
 
+// UpdateClusterInfra updates cluster infra, ELB and bastion stacks in place,
+// e.g. to change cluster access rules. Master pool networks must be set.
+func (c *Cloud) UpdateClusterInfra(cluster model.Cluster) error {
+	subnets, err := c.describeSubnets(cluster.MasterPool.Networks)
+	if err != nil {
+		return err
+	}
+	vpcID, err := getVpcIDFromSubnetList(subnets)
+	if err != nil {
+		return err
+	}
+
+	legacyComputePools, err := c.hasLegacyComputePools(cluster.Name)
+	if err != nil {
+		return err
+	}
+	if legacyComputePools {
+		c.Logger.Printf("cluster %q has computepools without security groups of their own, "+
+			"keeping shared computepool rules until all computepools are updated", cluster.Name)
+	}
+
+	templateBody, err := renderClusterInfraStackTemplate(cluster, vpcID, getNodesDistributionAcrossNetworks(subnets), legacyComputePools)
+	if err != nil {
+		return err
+	}
+	overlays := stackTemplateOverlays(cluster.TemplateOverlays, constants.TemplateOverlayStackInfra)
+	if templateBody, err = applyTemplateOverlays(templateBody, overlays); err != nil {
+		return err
+	}
+	c.Logger.Printf("updating cluster %q infra stack", cluster.Name)
+	if err := c.updateStack(&cloudformation.UpdateStackInput{
+		StackName:    aws.String(makeClusterInfraStackName(cluster.Name)),
+		TemplateBody: aws.String(templateBody),
+	}); err != nil {
+		return err
+	}
+
+	// ELB scheme is determined via Masterpool.Internal
+	cluster.MasterPool.Internal = cluster.Internal
+
+	lbSubnets, err := c.getLoadBalancerSubnets(cluster)
+	if err != nil {
+		return err
+	}
+	if templateBody, err = renderELBStackTemplate(cluster, vpcID, lbSubnets); err != nil {
+		return err
+	}
+	overlays = stackTemplateOverlays(cluster.TemplateOverlays, constants.TemplateOverlayStackLoadBalancer)
+	if templateBody, err = applyTemplateOverlays(templateBody, overlays); err != nil {
+		return err
+	}
+	c.Logger.Printf("updating cluster %q ELB stack", cluster.Name)
+	if err := c.updateStack(&cloudformation.UpdateStackInput{
+		StackName:    aws.String(makeELBStackName(cluster.Name)),
+		TemplateBody: aws.String(templateBody),
+	}); err != nil {
+		return err
+	}
+
+	return c.updateBastionPool(cluster)
+}
+
 // GetClusters returns a cluster by name or all clusters in the region.
 func (c *Cloud) GetClusters(name string) ([]*model.Cluster, error) {
 	clusters := []*model.Cluster{}
@@ -139,6 +219,14 @@ func (c *Cloud) GetClusters(name string) ([]*model.Cluster, error) {
 	if err != nil {
 		return clusters, err
 	}
+	elbStacks, err := c.getStacksByType(elbStackType)
+	if err != nil {
+		return clusters, err
+	}
+	networkStacks, err := c.getStacksByType(networkStackType)
+	if err != nil {
+		return clusters, err
+	}
 
 outer:
 	for _, s := range stacks {
@@ -152,10 +240,71 @@ outer:
 				}
 				c.Name = *o.OutputValue
 			}
+			if *o.OutputKey == networkProviderOutputKey {
+				c.NetworkProvider = *o.OutputValue
+			}
+			if *o.OutputKey == podCIDROutputKey {
+				c.PodCIDR = *o.OutputValue
+			}
+			if *o.OutputKey == serviceCIDROutputKey {
+				c.ServiceCIDR = *o.OutputValue
+			}
+			if *o.OutputKey == registryMirrorOutputKey {
+				c.RegistryMirror = *o.OutputValue
+			}
+			if *o.OutputKey == artifactsURLOutputKey {
+				c.ArtifactsURL = *o.OutputValue
+			}
+			if *o.OutputKey == httpProxyOutputKey {
+				c.Proxy.HTTPProxy = *o.OutputValue
+			}
+			if *o.OutputKey == httpsProxyOutputKey {
+				c.Proxy.HTTPSProxy = *o.OutputValue
+			}
+			if *o.OutputKey == noProxyOutputKey && *o.OutputValue != "" {
+				c.Proxy.NoProxy = strings.Split(*o.OutputValue, ",")
+			}
+			if *o.OutputKey == networkCIDRsOutputKey && *o.OutputValue != "" {
+				c.NetworkCIDRs = strings.Split(*o.OutputValue, ",")
+			}
+			if *o.OutputKey == componentsOutputKey {
+				manifest, err := components.Decode(*o.OutputValue)
+				if err != nil {
+					return clusters, err
+				}
+				c.Components = manifest
+			}
+			if err := setAccessOutput(&c.Access, o); err != nil {
+				return clusters, err
+			}
 		}
 
+		c.KubeAPIURL = getKubeAPIURLFromStacks(elbStacks, c.Name)
+		c.LoadBalancerType = getLoadBalancerTypeFromStacks(elbStacks, c.Name)
+		for _, o := range getELBStackOutputs(elbStacks, c.Name) {
+			if err := setAPIOutput(c, o); err != nil {
+				return clusters, err
+			}
+		}
+		// ELB stacks that predate API names only have default DNS records.
+		if c.APIHostname == "" {
+			c.DNSZone = getDNSZoneFromKubeAPIURL(c.KubeAPIURL, c.Name)
+		}
+		c.APIHostname = makeAPIHostname(*c)
+		n := &clusterNetwork{}
+		for _, o := range getNetworkStackOutputs(networkStacks, c.Name) {
+			if err := setNetworkOutput(n, o); err != nil {
+				return clusters, err
+			}
+		}
+		c.NetworkCIDR = n.CIDR
+		c.NetworkZones = n.Zones
 		c.Internal = clusterInternal(s.Outputs)
 		c.Labels = getStackLabels(s)
+		c.TemplateOverlays = append(
+			getTemplateOverlaysFromOutputs(s.Outputs, constants.TemplateOverlayStackInfra),
+			getTemplateOverlaysFromOutputs(getELBStackOutputs(elbStacks, c.Name), constants.TemplateOverlayStackLoadBalancer)...,
+		)
 		clusters = append(clusters, c)
 	}
 	return clusters, nil
@@ -181,7 +330,8 @@ func (c *Cloud) DescribeCluster(name string) error {
 	return ErrNotImplemented
 }
 
-// getKubeAPIURL returns a full Kubernetes API URL from an ELB stack.
+// getKubeAPIURL returns a full Kubernetes API URL from an ELB stack of either
+// load balancer type.
 func (c Cloud) getKubeAPIURL(clusterName string) (string, error) {
 	stack, err := c.getStack(makeELBStackName(clusterName))
 	if err != nil {
@@ -189,7 +339,7 @@ func (c Cloud) getKubeAPIURL(clusterName string) (string, error) {
 	}
 
 	for _, o := range stack.Outputs {
-		if *o.OutputKey == "ELBDNS" {
+		if *o.OutputKey == elbDNSOutputKey {
 			return formatKubeAPIURL(*o.OutputValue), nil
 		}
 	}
@@ -197,27 +347,123 @@ func (c Cloud) getKubeAPIURL(clusterName string) (string, error) {
 	return "", err
 }
 
+// getELBStackOutputs returns outputs of a cluster ELB stack from a list of
+// ELB stacks or nil if its ELB stack is not found.
+func getELBStackOutputs(stacks []*cloudformation.Stack, clusterName string) []*cloudformation.Output {
+	for _, s := range stacks {
+		if aws.StringValue(s.StackName) == makeELBStackName(clusterName) {
+			return s.Outputs
+		}
+	}
+	return nil
+}
+
+// getKubeAPIURLFromStacks returns a full Kubernetes API URL of a cluster
+// from a list of ELB stacks or an empty string if its ELB stack is not found.
+func getKubeAPIURLFromStacks(stacks []*cloudformation.Stack, clusterName string) string {
+	for _, o := range getELBStackOutputs(stacks, clusterName) {
+		if *o.OutputKey == elbDNSOutputKey {
+			return formatKubeAPIURL(*o.OutputValue)
+		}
+	}
+	return ""
+}
+
+// getLoadBalancerTypeFromStacks returns a kube API load balancer type of a
+// cluster from a list of ELB stacks. ELB stacks that don't record a type
+// front kube API with a classic load balancer.
+func getLoadBalancerTypeFromStacks(stacks []*cloudformation.Stack, clusterName string) string {
+	for _, o := range getELBStackOutputs(stacks, clusterName) {
+		if *o.OutputKey == loadBalancerTypeOutputKey {
+			return *o.OutputValue
+		}
+	}
+	return constants.DefaultLoadBalancerType
+}
+
+// setAPIOutput sets a cluster DNS zone setting or kube API name from an ELB
+// stack output. Other outputs are ignored.
+func setAPIOutput(c *model.Cluster, o *cloudformation.Output) error {
+	var err error
+	v := *o.OutputValue
+	switch *o.OutputKey {
+	case dnsZoneOutputKey:
+		c.DNSZone = v
+	case dnsZoneIDsOutputKey:
+		c.DNSZoneIDs = splitOutputValue(v)
+	case privateDNSZoneOutputKey:
+		c.PrivateDNSZone, err = strconv.ParseBool(v)
+	case splitHorizonDNSOutputKey:
+		c.SplitHorizonDNS, err = strconv.ParseBool(v)
+	case apiHostnameOutputKey:
+		c.APIHostname = v
+	case apiAliasesOutputKey:
+		c.APIAliases = splitOutputValue(v)
+	case apiExtraSANsOutputKey:
+		c.APIExtraSANs = splitOutputValue(v)
+	}
+	return err
+}
+
+// makeAPIHostname returns a kube API hostname of a cluster, which defaults to
+// kube-<cluster>.<DNS zone>, or an empty string if a cluster has neither a
+// hostname nor a DNS zone.
+func makeAPIHostname(c model.Cluster) string {
+	if c.APIHostname != "" || c.DNSZone == "" {
+		return c.APIHostname
+	}
+	return fmt.Sprintf("kube-%s.%s", c.Name, c.DNSZone)
+}
+
+// getDNSZoneFromKubeAPIURL returns a DNS zone of a cluster ELB record or an
+// empty string if a cluster was created without a DNS zone.
+func getDNSZoneFromKubeAPIURL(url, clusterName string) string {
+	prefix := formatKubeAPIURL("kube-" + clusterName + ".")
+	if !strings.HasPrefix(url, prefix) {
+		return ""
+	}
+	return strings.TrimPrefix(url, prefix)
+}
+
 func formatKubeAPIURL(host string) string {
 	// For some reason kubernetes does not like mixed-case dns names.
 	return "https://" + strings.ToLower(host)
 }
 
-// getELBName returns an ELB name from the ELB stack for a given given cluster.
-func (c Cloud) getELBName(clusterName string) (string, error) {
+// loadBalancer is a kube API load balancer that master pools register with.
+// Classic load balancers are referred to by name, network load balancers by
+// their target group.
+type loadBalancer struct {
+	ELBName        string
+	TargetGroupARN string
+}
+
+// getLoadBalancer returns a load balancer from the ELB stack for a given
+// cluster.
+func (c Cloud) getLoadBalancer(clusterName string) (loadBalancer, error) {
+	lb := loadBalancer{}
 	res, err := c.getStackResources(makeELBStackName(clusterName))
 	if err != nil {
-		return "", err
+		return lb, err
 	}
 	for _, r := range res {
-		if *r.ResourceType == "AWS::ElasticLoadBalancing::LoadBalancer" {
-			return *r.PhysicalResourceId, nil
+		switch *r.ResourceType {
+		case "AWS::ElasticLoadBalancing::LoadBalancer":
+			lb.ELBName = *r.PhysicalResourceId
+		case "AWS::ElasticLoadBalancingV2::TargetGroup":
+			lb.TargetGroupARN = *r.PhysicalResourceId
 		}
 	}
-	return "", nil
+	return lb, nil
 }
 
 // DeleteCluster deletes a cluster.
 func (c *Cloud) DeleteCluster(name string) error {
+	c.Logger.Printf("deleting bastion pool that belongs to cluster %q", name)
+	if err := c.DeleteBastionPool(name); err != nil {
+		return err
+	}
+
 	c.Logger.Printf("deleting compute pools that belong to cluster %q", name)
 	if err := c.DeleteComputePool(name, ""); err != nil {
 		return err
@@ -251,6 +497,19 @@ func (c *Cloud) DeleteCluster(name string) error {
 	if err := c.deleteStack(makeClusterInfraStackName(name)); err != nil {
 		return err
 	}
+
+	// Clusters that were created in existing networks don't have a network
+	// stack.
+	exists, err := c.stackExists(makeNetworkStackName(name))
+	if err != nil {
+		return err
+	}
+	if exists {
+		c.Logger.Printf("deleting network stack that belongs to cluster %q", name)
+		if err := c.deleteStack(makeNetworkStackName(name)); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -406,12 +665,45 @@ func (c *Cloud) NodePooler() (cloudprovider.NodePooler, bool) {
 
 // CreateMasterPool creates a master node pool.
 func (c *Cloud) CreateMasterPool(p model.MasterPool) error {
+	templateBody, err := c.makeMasterPoolStackTemplate(p)
+	if err != nil {
+		return err
+	}
+	return c.createMasterPoolStack(p, templateBody)
+}
+
+// UpgradeMasterPool re-renders a master pool stack and updates it in place.
+// Stacks that still use launch configurations are migrated to a launch
+// template. Running nodes are not replaced.
+func (c *Cloud) UpgradeMasterPool(p model.MasterPool) error {
+	stackName := makeMasterPoolStackName(p.ClusterName, "")
+	if p.SSHKey == "" {
+		key, err := c.getLaunchConfigurationSSHKey(stackName)
+		if err != nil {
+			return err
+		}
+		p.SSHKey = key
+	}
+	templateBody, err := c.makeMasterPoolStackTemplate(p)
+	if err != nil {
+		return err
+	}
+	return c.updatePoolStack(stackName, templateBody)
+}
+
+// makeMasterPoolStackTemplate gets cluster resources a master pool depends on
+// and renders its stack template.
+func (c *Cloud) makeMasterPoolStackTemplate(p model.MasterPool) (string, error) {
+	if err := validateLaunchTemplate(p.NodePoolSpec); err != nil {
+		return "", err
+	}
+
 	// At this point a cluster infra has created persistent ENIs, so master
 	// nodes should be created in the same subnets as ENIs, we just
 	// overwrite MasterPool.Networks.
 	enis, err := c.describePersistentENIs(p.ClusterName)
 	if err != nil {
-		return err
+		return "", err
 	}
 	p.Networks = []string{}
 	for _, n := range enis {
@@ -420,26 +712,25 @@ func (c *Cloud) CreateMasterPool(p model.MasterPool) error {
 
 	amiID, err := c.getAMIByName(p.CoreOSVersion)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	elbName, err := c.getELBName(p.ClusterName)
+	lb, err := c.getLoadBalancer(p.ClusterName)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	kubeAPIURL, err := c.getKubeAPIURL(p.ClusterName)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	bucket, err := c.getAssetsBucketName(p.ClusterName)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	infraStackName := makeClusterInfraStackName(p.ClusterName)
-	return c.createMasterPoolStack(p, infraStackName, amiID, elbName, kubeAPIURL, bucket)
+	return c.renderMasterPoolStack(p, amiID, lb, kubeAPIURL, bucket)
 }
 
 // createLoadBalancer ensures a load balancer is created.
@@ -453,44 +744,146 @@ func (c *Cloud) createLoadBalancer(cluster model.Cluster) error {
 		return err
 	}
 
+	lbSubnets, err := c.getLoadBalancerSubnets(cluster)
+	if err != nil {
+		return err
+	}
+
 	infraStackName := makeClusterInfraStackName(cluster.Name)
-	return c.createELBStack(cluster, vpcID, infraStackName)
+	return c.createELBStack(cluster, vpcID, infraStackName, lbSubnets)
 }
 
 // CreateComputePool creates a compute node pool.
 // Creating compute pools in different VPCs from where masterpool sits is
 // not supported. Mainly due to complexities imposed by AWS.
 func (c *Cloud) CreateComputePool(p model.ComputePool) error {
-	vpcID, err := c.getClusterVpcID(p.ClusterName)
+	templateBody, err := c.makeComputePoolStackTemplate(p)
 	if err != nil {
 		return err
 	}
-	subnets, err := c.describeSubnets(p.Networks)
+	return c.createComputePoolStack(p, templateBody)
+}
+
+// UpgradeComputePool re-renders a compute pool stack and updates it in place.
+// Stacks that still use launch configurations are migrated to a launch
+// template. Running nodes are not replaced.
+func (c *Cloud) UpgradeComputePool(p model.ComputePool) error {
+	if len(p.Networks) == 0 {
+		return fmt.Errorf("networks of computepool %q are unknown, they must be specified", p.Name)
+	}
+	stackName := makeComputePoolStackName(p.ClusterName, p.Name, "")
+	if p.SSHKey == "" {
+		key, err := c.getLaunchConfigurationSSHKey(stackName)
+		if err != nil {
+			return err
+		}
+		p.SSHKey = key
+	}
+	if err := c.setAutoscalingPoolSize(stackName, &p); err != nil {
+		return err
+	}
+	templateBody, err := c.makeComputePoolStackTemplate(p)
 	if err != nil {
 		return err
 	}
-	if len(subnets) == 0 {
-		return errors.New("no subnets found")
+	return c.updatePoolStack(stackName, templateBody)
+}
+
+// setAutoscalingPoolSize sets a size of an autoscaling compute pool to the
+// current desired capacity of its ASG, which cluster autoscaler manages, so
+// updates don't scale the pool back to its initial size. Fixed size pools are
+// left as they are.
+func (c *Cloud) setAutoscalingPoolSize(stackName string, p *model.ComputePool) error {
+	if p.MaxSize == 0 || p.MaxSize <= p.MinSize {
+		return nil
 	}
-	if !subnetsBelongToSameVPC(subnets) {
-		return errors.New("networks must be part of the same VPC")
+	groups, err := c.getStackASGs(stackName)
+	if err != nil {
+		return err
 	}
-	if *subnets[0].VpcId != vpcID {
-		return fmt.Errorf("networks must belong to %q VPC", vpcID)
+	if len(groups) != 1 || groups[0].DesiredCapacity == nil {
+		return nil
+	}
+	size := int(*groups[0].DesiredCapacity)
+	if size < p.MinSize {
+		size = p.MinSize
+	}
+	if size > p.MaxSize {
+		size = p.MaxSize
+	}
+	if size != p.Size {
+		c.Logger.Printf("computepool %q is autoscaled, keeping its current size %d", p.Name, size)
+	}
+	p.Size = size
+	return nil
+}
+
+// makeComputePoolStackTemplate validates compute pool networks, gets cluster
+// resources a compute pool depends on and renders its stack template. Compute
+// pools without networks are placed in private subnets of a cluster network.
+func (c *Cloud) makeComputePoolStackTemplate(p model.ComputePool) (string, error) {
+	if err := validateLaunchTemplate(p.NodePoolSpec); err != nil {
+		return "", err
+	}
+	if len(p.Networks) == 0 {
+		n, err := c.getClusterNetwork(p.ClusterName)
+		if err != nil {
+			return "", err
+		}
+		if n == nil {
+			return "", fmt.Errorf("networks of computepool %q must be specified", p.Name)
+		}
+		p.Networks = n.PrivateSubnets
 	}
 
-	infraStackName := makeClusterInfraStackName(p.ClusterName)
+	if err := c.validatePoolNetworks(p.ClusterName, p.Networks); err != nil {
+		return "", err
+	}
 
 	amiID, err := c.getAMIByName(p.CoreOSVersion)
 	if err != nil {
-		return err
+		return "", err
 	}
 	kubeAPIURL, err := c.getKubeAPIURL(p.ClusterName)
 	if err != nil {
-		return err
+		return "", err
+	}
+	// Compute pool SG rules depend on a cluster network provider.
+	clusters, err := c.GetClusters(p.ClusterName)
+	if err != nil {
+		return "", err
 	}
+	if len(clusters) != 1 {
+		return "", fmt.Errorf("cluster %q not found", p.ClusterName)
+	}
+	if err := c.validateAllowFromPools(p); err != nil {
+		return "", err
+	}
+
+	return renderComputePoolStack(p, amiID, kubeAPIURL, clusters[0].NetworkProvider)
+}
 
-	return c.createComputePoolStack(p, infraStackName, amiID, kubeAPIURL)
+// validatePoolNetworks checks that node pool networks exist and belong to
+// the cluster VPC.
+func (c *Cloud) validatePoolNetworks(clusterName string, networks []string) error {
+	vpcID, err := c.getClusterVpcID(clusterName)
+	if err != nil {
+		return err
+	}
+	subnets, err := c.describeSubnets(networks)
+	if err != nil {
+		return err
+	}
+	if len(subnets) == 0 {
+		return errors.New("no subnets found")
+	}
+	if !subnetsBelongToSameVPC(subnets) {
+		return errors.New("networks must be part of the same VPC")
+	}
+	if *subnets[0].VpcId != vpcID {
+		return fmt.Errorf("networks must belong to %q VPC", vpcID)
+	}
+	return nil
 }
 
 // GetMasterPools returns a list of master pools. Pools can be filtered by
@@ -539,6 +932,9 @@ outer:
 			if *o.OutputKey == kubeletExtraArgsOutputKey {
 				p.KubeletExtraArgs = *o.OutputValue
 			}
+			if *o.OutputKey == userDataHashOutputKey {
+				p.UserDataHash = *o.OutputValue
+			}
 			if *o.OutputKey == apiServerExtraArgsOutputKey {
 				p.APIServerExtraArgs = *o.OutputValue
 			}
@@ -548,10 +944,14 @@ outer:
 			if *o.OutputKey == schedulerExtraArgsOutputKey {
 				p.SchedulerExtraArgs = *o.OutputValue
 			}
+			if err := setLaunchTemplateOutput(&p.NodePoolSpec, o); err != nil {
+				return pools, err
+			}
 		}
 
 		p.Labels = getStackLabels(s)
 		p.Taints = getStackTaints(s)
+		p.TemplateOverlays = getStackTemplateOverlays(s)
 		pools = append(pools, p)
 	}
 	return pools, nil
@@ -603,10 +1003,43 @@ outer:
 			if *o.OutputKey == kubeletExtraArgsOutputKey {
 				p.KubeletExtraArgs = *o.OutputValue
 			}
+			if *o.OutputKey == userDataHashOutputKey {
+				p.UserDataHash = *o.OutputValue
+			}
+			if *o.OutputKey == purchaseStrategyOutputKey {
+				p.PurchaseStrategy = *o.OutputValue
+			}
+			if *o.OutputKey == machineTypesOutputKey && *o.OutputValue != "" {
+				p.MachineTypes = strings.Split(*o.OutputValue, ",")
+			}
+			if *o.OutputKey == onDemandBaseCapacityOutputKey {
+				i, err := strconv.Atoi(*o.OutputValue)
+				if err != nil {
+					return pools, err
+				}
+				p.OnDemandBaseCapacity = i
+			}
+			if *o.OutputKey == onDemandPercentageOutputKey {
+				i, err := strconv.Atoi(*o.OutputValue)
+				if err != nil {
+					return pools, err
+				}
+				p.OnDemandPercentage = i
+			}
+			if *o.OutputKey == spotMaxPriceOutputKey {
+				p.SpotMaxPrice = *o.OutputValue
+			}
+			if *o.OutputKey == allowFromPoolsOutputKey {
+				p.AllowFromPools = splitOutputValue(*o.OutputValue)
+			}
+			if err := setLaunchTemplateOutput(&p.NodePoolSpec, o); err != nil {
+				return pools, err
+			}
 		}
 
 		p.Labels = getStackLabels(s)
 		p.Taints = getStackTaints(s)
+		p.TemplateOverlays = getStackTemplateOverlays(s)
 		pools = append(pools, p)
 	}
 	return pools, nil
@@ -617,11 +1050,6 @@ func (c *Cloud) DescribeNodePool() error {
 	return ErrNotImplemented
 }
 
-// UpgradeNodePool upgrades a node pool.
-func (c *Cloud) UpgradeNodePool() error {
-	return ErrNotImplemented
-}
-
 // DeleteMasterPool deletes a master node pool.
 func (c *Cloud) DeleteMasterPool(clusterName string) error {
 	stacks, err := c.getStacksByType(masterPoolStackType)
@@ -642,39 +1070,147 @@ func (c *Cloud) DeleteMasterPool(clusterName string) error {
 	return nil
 }
 
-// DeleteComputePool deletes a node pool.
+// DeleteComputePool deletes a node pool. All compute pools of a cluster are
+// deleted if name is empty. Pools that allow traffic from other pools import
+// their SG exports, so they are deleted first and pools that are still
+// allowed traffic from can't be deleted on their own.
 func (c *Cloud) DeleteComputePool(clusterName, name string) error {
-	stacks, err := c.getStacksByType(computePoolStackType)
+	pools, err := c.getComputePoolStacks(clusterName)
 	if err != nil {
 		return err
 	}
 
-	matched := func(outputs []*cloudformation.Output) bool {
-		n := 0
-		for _, o := range outputs {
-			if *o.OutputKey == clusterNameOutputKey && *o.OutputValue == clusterName {
-				n++
-			}
-			if name != "" {
-				if *o.OutputKey == poolNameOutputKey && *o.OutputValue == name {
-					n++
-				}
+	if name != "" {
+		s, ok := pools[name]
+		if !ok {
+			return nil
+		}
+		if names := poolsAllowingFrom(pools, name); len(names) > 0 {
+			return fmt.Errorf("computepool %q can't be deleted, computepools %s allow traffic from it, "+
+				"remove it from their allowed pools with keto update computepool first", name, strings.Join(names, ", "))
+		}
+		return c.deleteStack(*s.StackId)
+	}
+
+	for len(pools) > 0 {
+		names := []string{}
+		for n := range pools {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+
+		deleted := false
+		for _, n := range names {
+			if len(poolsAllowingFrom(pools, n)) > 0 {
+				continue
 			}
-			if name == "" && *o.OutputKey == poolNameOutputKey {
-				n++
+			if err := c.deleteStack(*pools[n].StackId); err != nil {
+				return err
 			}
+			delete(pools, n)
+			deleted = true
+		}
+		if !deleted {
+			return fmt.Errorf("computepools %s allow traffic from each other and can't be deleted, "+
+				"remove their allowed pools with keto update computepool first", strings.Join(names, ", "))
 		}
-		return n == 2
 	}
+	return nil
+}
 
+// getComputePoolStacks returns compute pool stacks of a cluster by pool name.
+func (c *Cloud) getComputePoolStacks(clusterName string) (map[string]*cloudformation.Stack, error) {
+	pools := make(map[string]*cloudformation.Stack)
+	stacks, err := c.getStacksByType(computePoolStackType)
+	if err != nil {
+		return pools, err
+	}
 	for _, s := range stacks {
-		if matched(s.Outputs) {
-			if err := c.deleteStack(*s.StackId); err != nil {
-				return err
+		cluster, name := "", ""
+		for _, o := range s.Outputs {
+			switch *o.OutputKey {
+			case clusterNameOutputKey:
+				cluster = *o.OutputValue
+			case poolNameOutputKey:
+				name = *o.OutputValue
 			}
 		}
+		if cluster == clusterName && name != "" {
+			pools[name] = s
+		}
+	}
+	return pools, nil
+}
+
+// poolsAllowingFrom returns sorted names of compute pools that allow traffic
+// from a given pool.
+func poolsAllowingFrom(pools map[string]*cloudformation.Stack, name string) []string {
+	names := []string{}
+	for n, s := range pools {
+		if n == name {
+			continue
+		}
+		for _, o := range s.Outputs {
+			if *o.OutputKey != allowFromPoolsOutputKey {
+				continue
+			}
+			for _, allowed := range splitOutputValue(*o.OutputValue) {
+				if allowed == name {
+					names = append(names, n)
+				}
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// stackHasOutput returns true if a stack has an output of a given key.
+func stackHasOutput(s *cloudformation.Stack, key string) bool {
+	for _, o := range s.Outputs {
+		if *o.OutputKey == key {
+			return true
+		}
 	}
+	return false
+}
 
+// hasLegacyComputePools returns true if a cluster has compute pools created
+// by earlier keto versions, which don't have SGs of their own and rely on
+// shared compute pool SG rules of the cluster infra stack.
+func (c *Cloud) hasLegacyComputePools(clusterName string) (bool, error) {
+	pools, err := c.getComputePoolStacks(clusterName)
+	if err != nil {
+		return false, err
+	}
+	for _, s := range pools {
+		if !stackHasOutput(s, poolSGOutputKey) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// validateAllowFromPools checks that compute pools p allows traffic from
+// export SGs, which p stack imports. Pools created by earlier keto versions
+// export them once they are updated.
+func (c *Cloud) validateAllowFromPools(p model.ComputePool) error {
+	if len(p.AllowFromPools) == 0 {
+		return nil
+	}
+	pools, err := c.getComputePoolStacks(p.ClusterName)
+	if err != nil {
+		return err
+	}
+	for _, n := range p.AllowFromPools {
+		s, ok := pools[n]
+		if !ok {
+			return fmt.Errorf("computepool %q to allow traffic from does not exist in cluster %q", n, p.ClusterName)
+		}
+		if !stackHasOutput(s, poolSGOutputKey) {
+			return fmt.Errorf("computepool %q has no security group to allow traffic from, update it with keto update computepool first", n)
+		}
+	}
 	return nil
 }
 
@@ -818,8 +1354,10 @@ func newCloud(sess *session.Session, l cloudprovider.Logger) (*Cloud, error) {
 		cf:     cloudformation.New(sess),
 		ec2:    ec2.New(sess),
 		elb:    elb.New(sess),
+		elbv2:  elbv2.New(sess),
 		s3:     s3.New(sess),
 		r53:    route53.New(sess),
+		asg:    autoscaling.New(sess),
 	}
 	return c, nil
 }
@@ -14,10 +14,12 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-//go:generate mockery -dir $GOPATH/src/github.com/UKHomeOffice/keto/vendor/github.com/aws/aws-sdk-go/service/cloudformation/cloudformationiface -name=CloudFormationAPI
-//go:generate mockery -dir $GOPATH/src/github.com/UKHomeOffice/keto/vendor/github.com/aws/aws-sdk-go/service/ec2/ec2iface -name=EC2API
-//go:generate mockery -dir $GOPATH/src/github.com/UKHomeOffice/keto/vendor/github.com/aws/aws-sdk-go/service/elb/elbiface -name=ELBAPI
-//go:generate mockery -dir $GOPATH/src/github.com/UKHomeOffice/keto/vendor/github.com/aws/aws-sdk-go/service/route53/route53iface -name=Route53API
+//go:generate mockery --dir $GOPATH/src/github.com/UKHomeOffice/keto/vendor/github.com/aws/aws-sdk-go/service/cloudformation/cloudformationiface --name CloudFormationAPI
+//go:generate mockery --dir $GOPATH/src/github.com/UKHomeOffice/keto/vendor/github.com/aws/aws-sdk-go/service/ec2/ec2iface --name EC2API
+//go:generate mockery --dir $GOPATH/src/github.com/UKHomeOffice/keto/vendor/github.com/aws/aws-sdk-go/service/elb/elbiface --name ELBAPI
+//go:generate mockery --dir $GOPATH/src/github.com/UKHomeOffice/keto/vendor/github.com/aws/aws-sdk-go/service/elbv2/elbv2iface --name ELBV2API
+//go:generate mockery --dir $GOPATH/src/github.com/UKHomeOffice/keto/vendor/github.com/aws/aws-sdk-go/service/route53/route53iface --name Route53API
+//go:generate mockery --dir $GOPATH/src/github.com/UKHomeOffice/keto/vendor/github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface --name AutoScalingAPI
 
 package aws
 
@@ -29,10 +31,12 @@ import (
 	"testing"
 
 	"github.com/UKHomeOffice/keto/pkg/cloudprovider/providers/aws/mocks"
+	"github.com/UKHomeOffice/keto/pkg/constants"
 	"github.com/UKHomeOffice/keto/pkg/model"
 	"github.com/UKHomeOffice/keto/testutil"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/cloudformation"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/route53"
@@ -120,12 +124,16 @@ func TestCreateClusterInfra(t *testing.T) {
 
 	mockR53.On("ListHostedZonesByName", &route53.ListHostedZonesByNameInput{
 		DNSName: aws.String(cluster.DNSZone),
-	}).Return(&route53.ListHostedZonesByNameOutput{HostedZones: []*route53.HostedZone{{Name: aws.String(cluster.DNSZone)}}}, nil)
+	}).Return(&route53.ListHostedZonesByNameOutput{HostedZones: []*route53.HostedZone{{Id: aws.String("/hostedzone/Z1"), Name: aws.String(cluster.DNSZone + ".")}}}, nil)
 
 	mockEC2.On("DescribeSubnets", &ec2.DescribeSubnetsInput{
 		SubnetIds: aws.StringSlice(p.Networks),
 	}).Return(returnSubnetsFunc(), nil)
 
+	mockEC2.On("DescribeVpcs", &ec2.DescribeVpcsInput{
+		VpcIds: aws.StringSlice([]string{"vpc0"}),
+	}).Return(&ec2.DescribeVpcsOutput{Vpcs: []*ec2.Vpc{{VpcId: aws.String("vpc0"), CidrBlock: aws.String("10.0.0.0/16")}}}, nil)
+
 	mockCF.On("ValidateTemplate", mock.AnythingOfType("*cloudformation.ValidateTemplateInput")).Return(
 		&cloudformation.ValidateTemplateOutput{}, nil)
 
@@ -289,6 +297,61 @@ func TestDeleteComputePool(t *testing.T) {
 	mockCF.AssertExpectations(t)
 }
 
+func TestDeleteComputePoolAllowedFrom(t *testing.T) {
+	mockCF := &mocks.CloudFormationAPI{}
+	c := &Cloud{
+		Logger: makeLogger(),
+		cf:     mockCF,
+	}
+
+	makeStack := func(name string, allowFrom string) *cloudformation.Stack {
+		return &cloudformation.Stack{
+			StackId:     aws.String(name + "-id"),
+			StackName:   aws.String("keto-foo-" + name),
+			StackStatus: aws.String(cloudformation.StackStatusDeleteComplete),
+			Tags: []*cloudformation.Tag{
+				{Key: aws.String(managedByKetoTagKey), Value: aws.String(managedByKetoTagValue)},
+				{Key: aws.String(clusterNameTagKey), Value: aws.String("foo")},
+			},
+			Outputs: []*cloudformation.Output{
+				{OutputKey: aws.String(stackTypeOutputKey), OutputValue: aws.String(computePoolStackType)},
+				{OutputKey: aws.String(clusterNameOutputKey), OutputValue: aws.String("foo")},
+				{OutputKey: aws.String(poolNameOutputKey), OutputValue: aws.String(name)},
+				{OutputKey: aws.String(allowFromPoolsOutputKey), OutputValue: aws.String(allowFrom)},
+				{OutputKey: aws.String(poolSGOutputKey), OutputValue: aws.String("sg-" + name)},
+			},
+		}
+	}
+	stacks := []*cloudformation.Stack{
+		makeStack("backend", ""),
+		makeStack("frontend", "backend"),
+	}
+	mockCF.On("DescribeStacks", &cloudformation.DescribeStacksInput{}).Return(
+		&cloudformation.DescribeStacksOutput{Stacks: stacks}, nil)
+
+	if err := c.DeleteComputePool("foo", "backend"); err == nil {
+		t.Error("expected an error deleting a computepool that another one allows traffic from")
+	}
+
+	deleted := []string{}
+	for _, s := range stacks {
+		id := *s.StackId
+		mockCF.On("DeleteStack", &cloudformation.DeleteStackInput{StackName: aws.String(id)}).Return(
+			&cloudformation.DeleteStackOutput{}, nil).Run(func(mock.Arguments) {
+			deleted = append(deleted, id)
+		})
+		mockCF.On("DescribeStacks", &cloudformation.DescribeStacksInput{StackName: aws.String(id)}).Return(
+			&cloudformation.DescribeStacksOutput{Stacks: []*cloudformation.Stack{s}}, nil)
+	}
+
+	if err := c.DeleteComputePool("foo", ""); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(deleted, ",") != "frontend-id,backend-id" {
+		t.Errorf("expected computepools to be deleted in dependency order, got %v", deleted)
+	}
+}
+
 func TestCreateMasterPool(t *testing.T) {
 	mockCF := &mocks.CloudFormationAPI{}
 	mockEC2 := &mocks.EC2API{}
@@ -393,7 +456,8 @@ func TestCreateMasterPool(t *testing.T) {
 		// Assume that the cluster infra already exists in a single AZ, so the
 		// specified subnets, when creating this MasterPool, must be ignored and
 		// cluster infra subnets must be used.
-		return strings.Contains(*in.TemplateBody, infraSubnet)
+		return strings.Contains(*in.TemplateBody, infraSubnet) &&
+			strings.Contains(*in.TemplateBody, `"autoscaling:ResourceTag/k8s.io/cluster-autoscaler/foo": "owned"`)
 	})).Return(
 		&cloudformation.CreateStackOutput{
 			StackId: aws.String(masterPoolStackID),
@@ -418,6 +482,54 @@ func TestCreateMasterPool(t *testing.T) {
 	mockEC2.AssertExpectations(t)
 }
 
+func TestSetAutoscalingPoolSize(t *testing.T) {
+	mockCF := &mocks.CloudFormationAPI{}
+	mockASG := &mocks.AutoScalingAPI{}
+	c := &Cloud{
+		Logger: makeLogger(),
+		cf:     mockCF,
+		asg:    mockASG,
+	}
+
+	mockCF.On("DescribeStackResources", &cloudformation.DescribeStackResourcesInput{
+		StackName: aws.String("keto-foo-compute0-blue"),
+	}).Return(makeTestASGResources("asg-compute0"), nil)
+	mockASG.On("DescribeAutoScalingGroups", &autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []*string{aws.String("asg-compute0")},
+	}).Return(&autoscaling.DescribeAutoScalingGroupsOutput{
+		AutoScalingGroups: []*autoscaling.Group{
+			{AutoScalingGroupName: aws.String("asg-compute0"), DesiredCapacity: aws.Int64(7)},
+		},
+	}, nil)
+
+	p := model.ComputePool{NodePool: testutil.MakeNodePool("foo", "compute0")}
+	p.Size, p.MinSize, p.MaxSize = 2, 1, 10
+	if err := c.setAutoscalingPoolSize("keto-foo-compute0-blue", &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Size != 7 {
+		t.Errorf("got size %d; want %d", p.Size, 7)
+	}
+
+	p.MaxSize = 5
+	if err := c.setAutoscalingPoolSize("keto-foo-compute0-blue", &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Size != 5 {
+		t.Errorf("got size %d; want it capped at max size %d", p.Size, 5)
+	}
+
+	// Fixed size pools keep their size without looking up their ASG.
+	p.Size, p.MinSize, p.MaxSize = 3, 0, 0
+	if err := c.setAutoscalingPoolSize("keto-foo-compute1-blue", &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Size != 3 {
+		t.Errorf("got size %d; want %d", p.Size, 3)
+	}
+	mockASG.AssertExpectations(t)
+}
+
 func TestGetKubeAPIURL(t *testing.T) {
 	mockCF := &mocks.CloudFormationAPI{}
 	c := &Cloud{
@@ -455,3 +567,84 @@ func TestGetKubeAPIURL(t *testing.T) {
 
 	mockCF.AssertExpectations(t)
 }
+
+func TestGetLoadBalancer(t *testing.T) {
+	mockCF := &mocks.CloudFormationAPI{}
+	c := &Cloud{
+		Logger: makeLogger(),
+		cf:     mockCF,
+	}
+
+	clusterName := "foo"
+	tg := "arn:aws:elasticloadbalancing:eu-west-2:123:targetgroup/foo/123"
+	mockCF.On("DescribeStackResources", &cloudformation.DescribeStackResourcesInput{
+		StackName: aws.String(makeELBStackName(clusterName)),
+	}).Return(&cloudformation.DescribeStackResourcesOutput{
+		StackResources: []*cloudformation.StackResource{
+			{
+				ResourceType:       aws.String("AWS::ElasticLoadBalancingV2::LoadBalancer"),
+				PhysicalResourceId: aws.String("arn:aws:elasticloadbalancing:eu-west-2:123:loadbalancer/net/foo/123"),
+			},
+			{
+				ResourceType:       aws.String("AWS::ElasticLoadBalancingV2::TargetGroup"),
+				PhysicalResourceId: aws.String(tg),
+			},
+		},
+	}, nil).Once()
+
+	lb, err := c.getLoadBalancer(clusterName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lb.ELBName != "" || lb.TargetGroupARN != tg {
+		t.Errorf("got %+v; want target group %q only", lb, tg)
+	}
+
+	mockCF.AssertExpectations(t)
+}
+
+func TestGetLoadBalancerTypeFromStacks(t *testing.T) {
+	stacks := []*cloudformation.Stack{
+		{
+			StackName: aws.String(makeELBStackName("foo")),
+			Outputs: []*cloudformation.Output{
+				{
+					OutputKey:   aws.String(loadBalancerTypeOutputKey),
+					OutputValue: aws.String(constants.LoadBalancerTypeNetwork),
+				},
+			},
+		},
+		{
+			StackName: aws.String(makeELBStackName("bar")),
+			Outputs: []*cloudformation.Output{
+				{
+					OutputKey:   aws.String(elbDNSOutputKey),
+					OutputValue: aws.String("kube-bar.example.com"),
+				},
+			},
+		},
+	}
+	cases := map[string]string{
+		"foo": constants.LoadBalancerTypeNetwork,
+		"bar": constants.LoadBalancerTypeClassic,
+	}
+	for name, want := range cases {
+		if got := getLoadBalancerTypeFromStacks(stacks, name); got != want {
+			t.Errorf("%q: got %q; want %q", name, got, want)
+		}
+	}
+}
+
+func TestGetDNSZoneFromKubeAPIURL(t *testing.T) {
+	cases := map[string]string{
+		"https://kube-foo.example.com":                         "example.com",
+		"https://kube-foobar.example.com":                      "",
+		"https://keto-foo-elb-123.eu-west-2.elb.amazonaws.com": "",
+		"": "",
+	}
+	for u, want := range cases {
+		if got := getDNSZoneFromKubeAPIURL(u, "foo"); got != want {
+			t.Errorf("%q: got %q; want %q", u, got, want)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/UKHomeOffice/keto/pkg/cloudprovider"
+	"github.com/UKHomeOffice/keto/pkg/model"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Bastions returns an implementation of Bastions interface for AWS Cloud.
+func (c *Cloud) Bastions() (cloudprovider.Bastions, bool) {
+	return c, true
+}
+
+// CreateBastionPool creates a bastion pool stack for a cluster. Bastion hosts
+// are allowed SSH access from the pool allowed CIDRs and cluster source
+// security groups.
+func (c *Cloud) CreateBastionPool(p model.BastionPool) error {
+	clusters, err := c.GetClusters(p.ClusterName)
+	if err != nil {
+		return err
+	}
+	if len(clusters) != 1 {
+		return fmt.Errorf("cluster %q not found", p.ClusterName)
+	}
+	templateBody, err := c.makeBastionPoolStackTemplate(p, clusters[0].Access)
+	if err != nil {
+		return err
+	}
+	return c.createBastionPoolStack(p, templateBody)
+}
+
+// updateBastionPool re-renders a bastion pool stack of a cluster, if there
+// is one, so that bastion hosts follow cluster source security groups.
+// Bastion pools keep their own allowed CIDRs, pools created before those
+// were stored fall back to cluster SSH CIDRs.
+func (c *Cloud) updateBastionPool(cluster model.Cluster) error {
+	pools, err := c.GetBastionPools(cluster.Name)
+	if err != nil {
+		return err
+	}
+	if len(pools) == 0 {
+		return nil
+	}
+	p := *pools[0]
+	if len(p.AllowedCIDRs) == 0 {
+		p.AllowedCIDRs = cluster.Access.SSHCIDRs
+	}
+	if len(p.AllowedCIDRs) == 0 && len(cluster.Access.SourceSecurityGroups) == 0 {
+		c.Logger.Printf("warning: cluster %q bastion hosts are not allowed SSH access from anywhere, recreate the bastion pool with --allowed-cidrs", cluster.Name)
+	}
+	if cluster.Access.DisableSSH {
+		c.Logger.Printf("direct SSH access to cluster %q nodes is disabled, nodes are only reachable through bastion hosts", cluster.Name)
+	}
+	templateBody, err := c.makeBastionPoolStackTemplate(p, cluster.Access)
+	if err != nil {
+		return err
+	}
+	c.Logger.Printf("updating cluster %q bastion stack", cluster.Name)
+	return c.updateStack(&cloudformation.UpdateStackInput{
+		StackName:    aws.String(makeBastionPoolStackName(cluster.Name)),
+		TemplateBody: aws.String(templateBody),
+	})
+}
+
+// makeBastionPoolStackTemplate validates bastion pool networks and renders
+// its stack template. Bastion pools without networks are placed in public
+// subnets of a cluster network.
+func (c *Cloud) makeBastionPoolStackTemplate(p model.BastionPool, a model.Access) (string, error) {
+	if len(p.Networks) == 0 {
+		n, err := c.getClusterNetwork(p.ClusterName)
+		if err != nil {
+			return "", err
+		}
+		if n == nil {
+			return "", fmt.Errorf("networks of cluster %q bastion pool must be specified", p.ClusterName)
+		}
+		p.Networks = n.PublicSubnets
+	}
+	if err := c.validatePoolNetworks(p.ClusterName, p.Networks); err != nil {
+		return "", err
+	}
+
+	amiID, err := c.getAMIByName(p.CoreOSVersion)
+	if err != nil {
+		return "", err
+	}
+	return renderBastionStackTemplate(p, a, amiID)
+}
+
+// GetBastionPools returns a list of bastion pools along with addresses of
+// their running hosts. Pools can be filtered by their cluster.
+func (c *Cloud) GetBastionPools(clusterName string) ([]*model.BastionPool, error) {
+	pools := []*model.BastionPool{}
+
+	stacks, err := c.getStacksByType(bastionStackType)
+	if err != nil {
+		return pools, err
+	}
+
+outer:
+	for _, s := range stacks {
+		p := &model.BastionPool{}
+		var asgName string
+		for _, o := range s.Outputs {
+			if *o.OutputKey == clusterNameOutputKey && clusterName != "" && *o.OutputValue != clusterName {
+				continue outer
+			}
+			if *o.OutputKey == asgOutputKey {
+				asgName = *o.OutputValue
+			}
+			if err := setBastionPoolOutput(p, o); err != nil {
+				return pools, err
+			}
+		}
+
+		p.Labels = getStackLabels(s)
+		if asgName != "" {
+			if p.Hosts, err = c.getBastionHosts(asgName); err != nil {
+				return pools, err
+			}
+		}
+		pools = append(pools, p)
+	}
+	return pools, nil
+}
+
+// setBastionPoolOutput sets a bastion pool value from a bastion stack output.
+// Other outputs are ignored.
+func setBastionPoolOutput(p *model.BastionPool, o *cloudformation.Output) error {
+	var err error
+	v := *o.OutputValue
+	switch *o.OutputKey {
+	case clusterNameOutputKey:
+		p.ClusterName = v
+	case poolNameOutputKey:
+		p.Name = v
+	case coreOSVersionOutputKey:
+		p.CoreOSVersion = v
+	case machineTypeOutputKey:
+		p.MachineType = v
+	case diskSizeOutputKey:
+		p.DiskSize, err = strconv.Atoi(v)
+	case sshKeyOutputKey:
+		p.SSHKey = v
+	case networksOutputKey:
+		p.Networks = splitOutputValue(v)
+	case sizeOutputKey:
+		p.Size, err = strconv.Atoi(v)
+	case bastionCIDRsOutputKey:
+		p.AllowedCIDRs = splitOutputValue(v)
+	}
+	return err
+}
+
+// getBastionHosts returns sorted public IPs of running instances of a bastion
+// pool autoscaling group.
+func (c *Cloud) getBastionHosts(asgName string) ([]string, error) {
+	hosts := []string{}
+	params := &ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("tag:aws:autoscaling:groupName"), Values: []*string{aws.String(asgName)}},
+			{Name: aws.String("instance-state-name"), Values: []*string{aws.String(ec2.InstanceStateNameRunning)}},
+		},
+	}
+	for {
+		r, err := c.ec2.DescribeInstances(params)
+		if err != nil {
+			return hosts, fmt.Errorf("failed to describe bastion instances: %v", err)
+		}
+		for _, res := range r.Reservations {
+			for _, i := range res.Instances {
+				if i.PublicIpAddress != nil {
+					hosts = append(hosts, *i.PublicIpAddress)
+				}
+			}
+		}
+		if r.NextToken == nil {
+			break
+		}
+		params.NextToken = r.NextToken
+	}
+	sort.Strings(hosts)
+	return hosts, nil
+}
+
+// DeleteBastionPool deletes a bastion pool stack of a cluster if it exists.
+func (c *Cloud) DeleteBastionPool(clusterName string) error {
+	name := makeBastionPoolStackName(clusterName)
+	exists, err := c.stackExists(name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+	return c.deleteStack(name)
+}
@@ -0,0 +1,270 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/UKHomeOffice/keto/pkg/cloudprovider"
+	"github.com/UKHomeOffice/keto/pkg/model"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+)
+
+const (
+	stacksCheckName       = "stacks"
+	asgsCheckName         = "autoscaling groups"
+	loadBalancerCheckName = "load balancer"
+	etcdENIsCheckName     = "etcd ENIs"
+
+	asgInServiceState = "InService"
+	elbInServiceState = "InService"
+)
+
+// Checker returns an implementation of Checker interface for AWS Cloud.
+func (c *Cloud) Checker() (cloudprovider.Checker, bool) {
+	return c, true
+}
+
+// CheckClusterInfra checks that all stacks of a cluster are complete, that
+// autoscaling groups have their desired number of instances in service, that
+// kube API load balancer instances are healthy and that every persistent ENI
+// of etcd members is attached to a master in service. Etcd members themselves
+// are listed by keto check with the etcd CA.
+func (c *Cloud) CheckClusterInfra(clusterName string) ([]model.CheckResult, error) {
+	stacks, err := c.getClusterStacks(clusterName)
+	if err != nil {
+		return nil, err
+	}
+	if len(stacks) == 0 {
+		return nil, fmt.Errorf("cluster %q not found", clusterName)
+	}
+
+	results := []model.CheckResult{checkStacksComplete(stacks)}
+
+	asgs, err := c.checkASGs(stacks)
+	if err != nil {
+		return results, err
+	}
+	results = append(results, asgs)
+
+	lb, err := c.checkLoadBalancer(clusterName)
+	if err != nil {
+		return results, err
+	}
+	results = append(results, lb)
+
+	etcd, err := c.checkEtcdENIs(clusterName, stacks)
+	if err != nil {
+		return results, err
+	}
+	return append(results, etcd), nil
+}
+
+// getClusterStacks returns managed stacks of a cluster. Stacks are matched by
+// their tags, as stacks that failed to create have no outputs.
+func (c *Cloud) getClusterStacks(clusterName string) ([]*cloudformation.Stack, error) {
+	allStacks, err := c.describeStacks("")
+	if err != nil {
+		return nil, err
+	}
+	stacks := []*cloudformation.Stack{}
+	for _, s := range allStacks {
+		if isStackManaged(s) && getStackTagValue(s, clusterNameTagKey) == clusterName {
+			stacks = append(stacks, s)
+		}
+	}
+	sort.Slice(stacks, func(i, j int) bool {
+		return aws.StringValue(stacks[i].StackName) < aws.StringValue(stacks[j].StackName)
+	})
+	return stacks, nil
+}
+
+// getStackTagValue returns a value of a stack tag or an empty string if the
+// stack has no such tag.
+func getStackTagValue(s *cloudformation.Stack, key string) string {
+	for _, t := range s.Tags {
+		if aws.StringValue(t.Key) == key {
+			return aws.StringValue(t.Value)
+		}
+	}
+	return ""
+}
+
+// checkStacksComplete checks that stacks were created or updated
+// successfully. Rolled back updates fail the check.
+func checkStacksComplete(stacks []*cloudformation.Stack) model.CheckResult {
+	r := model.CheckResult{Name: stacksCheckName}
+	failed := []string{}
+	for _, s := range stacks {
+		switch status := aws.StringValue(s.StackStatus); status {
+		case cloudformation.StackStatusCreateComplete, cloudformation.StackStatusUpdateComplete:
+		default:
+			failed = append(failed, fmt.Sprintf("%s is %s", aws.StringValue(s.StackName), status))
+		}
+	}
+	if len(failed) > 0 {
+		r.Message = strings.Join(failed, ", ")
+		return r
+	}
+	r.Passed = true
+	r.Message = fmt.Sprintf("%d stacks complete", len(stacks))
+	return r
+}
+
+// checkASGs checks that autoscaling groups of master, compute and bastion
+// pool stacks have their desired number of instances in service.
+func (c *Cloud) checkASGs(stacks []*cloudformation.Stack) (model.CheckResult, error) {
+	r := model.CheckResult{Name: asgsCheckName}
+	failed := []string{}
+	count := 0
+	for _, s := range stacks {
+		switch getStackTagValue(s, stackTypeTagKey) {
+		case masterPoolStackType, computePoolStackType, bastionStackType:
+		default:
+			continue
+		}
+		groups, err := c.getStackASGs(aws.StringValue(s.StackName))
+		if err != nil {
+			return r, err
+		}
+		for _, g := range groups {
+			count++
+			inService := 0
+			for _, i := range g.Instances {
+				if aws.StringValue(i.LifecycleState) == asgInServiceState {
+					inService++
+				}
+			}
+			if desired := int(aws.Int64Value(g.DesiredCapacity)); inService < desired {
+				failed = append(failed, fmt.Sprintf("%s has %d of %d instances in service",
+					aws.StringValue(g.AutoScalingGroupName), inService, desired))
+			}
+		}
+	}
+	if len(failed) > 0 {
+		r.Message = strings.Join(failed, ", ")
+		return r, nil
+	}
+	r.Passed = true
+	r.Message = fmt.Sprintf("%d autoscaling groups at desired capacity", count)
+	return r, nil
+}
+
+// checkLoadBalancer checks that all instances registered with a kube API
+// load balancer are healthy. Both classic ELBs and NLB target groups are
+// checked.
+func (c *Cloud) checkLoadBalancer(clusterName string) (model.CheckResult, error) {
+	r := model.CheckResult{Name: loadBalancerCheckName}
+	lb, err := c.getLoadBalancer(clusterName)
+	if err != nil {
+		return r, err
+	}
+
+	var healthy, total int
+	switch {
+	case lb.ELBName != "":
+		resp, err := c.elb.DescribeInstanceHealth(&elb.DescribeInstanceHealthInput{
+			LoadBalancerName: aws.String(lb.ELBName),
+		})
+		if err != nil {
+			return r, fmt.Errorf("failed to describe ELB %q instance health: %v", lb.ELBName, err)
+		}
+		for _, s := range resp.InstanceStates {
+			total++
+			if aws.StringValue(s.State) == elbInServiceState {
+				healthy++
+			}
+		}
+	case lb.TargetGroupARN != "":
+		resp, err := c.elbv2.DescribeTargetHealth(&elbv2.DescribeTargetHealthInput{
+			TargetGroupArn: aws.String(lb.TargetGroupARN),
+		})
+		if err != nil {
+			return r, fmt.Errorf("failed to describe target group %q health: %v", lb.TargetGroupARN, err)
+		}
+		for _, d := range resp.TargetHealthDescriptions {
+			total++
+			if d.TargetHealth != nil && aws.StringValue(d.TargetHealth.State) == elbv2.TargetHealthStateEnumHealthy {
+				healthy++
+			}
+		}
+	default:
+		r.Message = "no kube API load balancer found"
+		return r, nil
+	}
+
+	r.Message = fmt.Sprintf("%d of %d instances healthy", healthy, total)
+	r.Passed = total > 0 && healthy == total
+	return r, nil
+}
+
+// checkEtcdENIs checks that every persistent ENI of a cluster is attached to
+// a master instance in service. Every etcd member has its own persistent ENI,
+// so a detached ENI means a member can't be running, though an attached one
+// doesn't mean the member is healthy.
+func (c *Cloud) checkEtcdENIs(clusterName string, stacks []*cloudformation.Stack) (model.CheckResult, error) {
+	r := model.CheckResult{Name: etcdENIsCheckName}
+	enis, err := c.describePersistentENIs(clusterName)
+	if err != nil {
+		return r, err
+	}
+	if len(enis) == 0 {
+		r.Message = "no persistent ENIs found"
+		return r, nil
+	}
+
+	inService := make(map[string]bool)
+	for _, s := range stacks {
+		if getStackTagValue(s, stackTypeTagKey) != masterPoolStackType {
+			continue
+		}
+		groups, err := c.getStackASGs(aws.StringValue(s.StackName))
+		if err != nil {
+			return r, err
+		}
+		for _, g := range groups {
+			for _, i := range g.Instances {
+				if aws.StringValue(i.LifecycleState) == asgInServiceState {
+					inService[aws.StringValue(i.InstanceId)] = true
+				}
+			}
+		}
+	}
+
+	members := 0
+	missing := []string{}
+	for _, n := range enis {
+		if n.Attachment != nil && inService[aws.StringValue(n.Attachment.InstanceId)] {
+			members++
+			continue
+		}
+		missing = append(missing, fmt.Sprintf("%s (node %s)", aws.StringValue(n.NetworkInterfaceId), getENINodeID(n)))
+	}
+	r.Message = fmt.Sprintf("%d of %d persistent ENIs attached to masters in service", members, len(enis))
+	if len(missing) > 0 {
+		r.Message += ", missing " + strings.Join(missing, ", ")
+		return r, nil
+	}
+	r.Passed = true
+	return r, nil
+}
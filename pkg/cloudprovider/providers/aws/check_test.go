@@ -0,0 +1,174 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"testing"
+
+	"github.com/UKHomeOffice/keto/pkg/cloudprovider/providers/aws/mocks"
+	"github.com/UKHomeOffice/keto/pkg/model"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elb"
+)
+
+func makeTestClusterStack(name, stackType, clusterName, status string) *cloudformation.Stack {
+	return &cloudformation.Stack{
+		StackName:   aws.String(name),
+		StackStatus: aws.String(status),
+		Tags: []*cloudformation.Tag{
+			{Key: aws.String(managedByKetoTagKey), Value: aws.String(managedByKetoTagValue)},
+			{Key: aws.String(clusterNameTagKey), Value: aws.String(clusterName)},
+			{Key: aws.String(stackTypeTagKey), Value: aws.String(stackType)},
+		},
+	}
+}
+
+func makeTestASGResources(name string) *cloudformation.DescribeStackResourcesOutput {
+	return &cloudformation.DescribeStackResourcesOutput{
+		StackResources: []*cloudformation.StackResource{
+			{ResourceType: aws.String(asgResourceType), PhysicalResourceId: aws.String(name)},
+		},
+	}
+}
+
+func TestCheckClusterInfra(t *testing.T) {
+	mockCF := &mocks.CloudFormationAPI{}
+	mockEC2 := &mocks.EC2API{}
+	mockELB := &mocks.ELBAPI{}
+	mockASG := &mocks.AutoScalingAPI{}
+	c := &Cloud{
+		Logger: makeLogger(),
+		cf:     mockCF,
+		ec2:    mockEC2,
+		elb:    mockELB,
+		asg:    mockASG,
+	}
+
+	mockCF.On("DescribeStacks", &cloudformation.DescribeStacksInput{}).Return(
+		&cloudformation.DescribeStacksOutput{
+			Stacks: []*cloudformation.Stack{
+				makeTestClusterStack("keto-foo-infra", clusterInfraStackType, "foo", cloudformation.StackStatusCreateComplete),
+				makeTestClusterStack("keto-foo-elb", elbStackType, "foo", cloudformation.StackStatusUpdateComplete),
+				makeTestClusterStack("keto-foo-master0", masterPoolStackType, "foo", cloudformation.StackStatusCreateComplete),
+				makeTestClusterStack("keto-foo-compute0", computePoolStackType, "foo", cloudformation.StackStatusUpdateRollbackComplete),
+				makeTestClusterStack("keto-bar-infra", clusterInfraStackType, "bar", cloudformation.StackStatusCreateFailed),
+			},
+		}, nil)
+
+	mockCF.On("DescribeStackResources", &cloudformation.DescribeStackResourcesInput{
+		StackName: aws.String("keto-foo-master0"),
+	}).Return(makeTestASGResources("asg-master0"), nil)
+	mockCF.On("DescribeStackResources", &cloudformation.DescribeStackResourcesInput{
+		StackName: aws.String("keto-foo-compute0"),
+	}).Return(makeTestASGResources("asg-compute0"), nil)
+	mockCF.On("DescribeStackResources", &cloudformation.DescribeStackResourcesInput{
+		StackName: aws.String("keto-foo-elb"),
+	}).Return(&cloudformation.DescribeStackResourcesOutput{
+		StackResources: []*cloudformation.StackResource{
+			{ResourceType: aws.String("AWS::ElasticLoadBalancing::LoadBalancer"), PhysicalResourceId: aws.String("elb-foo")},
+		},
+	}, nil)
+
+	mockASG.On("DescribeAutoScalingGroups", &autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []*string{aws.String("asg-master0")},
+	}).Return(&autoscaling.DescribeAutoScalingGroupsOutput{
+		AutoScalingGroups: []*autoscaling.Group{
+			{
+				AutoScalingGroupName: aws.String("asg-master0"),
+				DesiredCapacity:      aws.Int64(2),
+				Instances: []*autoscaling.Instance{
+					{InstanceId: aws.String("i-m0"), LifecycleState: aws.String("InService")},
+					{InstanceId: aws.String("i-m1"), LifecycleState: aws.String("InService")},
+				},
+			},
+		},
+	}, nil)
+	mockASG.On("DescribeAutoScalingGroups", &autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []*string{aws.String("asg-compute0")},
+	}).Return(&autoscaling.DescribeAutoScalingGroupsOutput{
+		AutoScalingGroups: []*autoscaling.Group{
+			{
+				AutoScalingGroupName: aws.String("asg-compute0"),
+				DesiredCapacity:      aws.Int64(2),
+				Instances: []*autoscaling.Instance{
+					{InstanceId: aws.String("i-c0"), LifecycleState: aws.String("InService")},
+					{InstanceId: aws.String("i-c1"), LifecycleState: aws.String("Pending")},
+				},
+			},
+		},
+	}, nil)
+
+	mockELB.On("DescribeInstanceHealth", &elb.DescribeInstanceHealthInput{
+		LoadBalancerName: aws.String("elb-foo"),
+	}).Return(&elb.DescribeInstanceHealthOutput{
+		InstanceStates: []*elb.InstanceState{
+			{InstanceId: aws.String("i-m0"), State: aws.String("InService")},
+			{InstanceId: aws.String("i-m1"), State: aws.String("InService")},
+		},
+	}, nil).Once()
+
+	mockEC2.On("DescribeNetworkInterfaces", &ec2.DescribeNetworkInterfacesInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("tag:" + managedByKetoTagKey), Values: []*string{aws.String(managedByKetoTagValue)}},
+			{Name: aws.String("tag:" + clusterNameTagKey), Values: []*string{aws.String("foo")}},
+		},
+	}).Return(&ec2.DescribeNetworkInterfacesOutput{
+		NetworkInterfaces: []*ec2.NetworkInterface{
+			{
+				NetworkInterfaceId: aws.String("eni-0"),
+				Attachment:         &ec2.NetworkInterfaceAttachment{InstanceId: aws.String("i-m0")},
+				TagSet:             []*ec2.Tag{{Key: aws.String("NodeID"), Value: aws.String("0")}},
+			},
+			{
+				NetworkInterfaceId: aws.String("eni-1"),
+				TagSet:             []*ec2.Tag{{Key: aws.String("NodeID"), Value: aws.String("1")}},
+			},
+		},
+	}, nil).Once()
+
+	results, err := c.CheckClusterInfra("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []model.CheckResult{
+		{Name: stacksCheckName, Message: "keto-foo-compute0 is UPDATE_ROLLBACK_COMPLETE"},
+		{Name: asgsCheckName, Message: "asg-compute0 has 1 of 2 instances in service"},
+		{Name: loadBalancerCheckName, Passed: true, Message: "2 of 2 instances healthy"},
+		{Name: etcdENIsCheckName, Message: "1 of 2 persistent ENIs attached to masters in service, missing eni-1 (node 1)"},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results; want %d", len(results), len(want))
+	}
+	for i := range want {
+		if results[i] != want[i] {
+			t.Errorf("got result %+v; want %+v", results[i], want[i])
+		}
+	}
+
+	if _, err := c.CheckClusterInfra("baz"); err == nil {
+		t.Error("expected an error for a cluster without stacks")
+	}
+
+	mockCF.AssertExpectations(t)
+	mockASG.AssertExpectations(t)
+	mockELB.AssertExpectations(t)
+	mockEC2.AssertExpectations(t)
+}
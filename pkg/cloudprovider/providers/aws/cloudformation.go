@@ -22,6 +22,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/UKHomeOffice/keto/pkg/constants"
 	"github.com/UKHomeOffice/keto/pkg/keto/util"
 	"github.com/UKHomeOffice/keto/pkg/model"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -53,11 +54,62 @@ const (
 	apiServerExtraArgsOutputKey         = "APIServerExtraArgs"
 	controllerManagerExtraArgsOutputKey = "ControllerManagerExtraArgs"
 	schedulerExtraArgsOutputKey         = "SchedulerExtraArgs"
+	userDataHashOutputKey               = "UserDataHash"
+	networkProviderOutputKey            = "NetworkProvider"
+	podCIDROutputKey                    = "PodCIDR"
+	serviceCIDROutputKey                = "ServiceCIDR"
+	componentsOutputKey                 = "Components"
+	registryMirrorOutputKey             = "RegistryMirror"
+	artifactsURLOutputKey               = "ArtifactsURL"
+	httpProxyOutputKey                  = "HTTPProxy"
+	httpsProxyOutputKey                 = "HTTPSProxy"
+	noProxyOutputKey                    = "NoProxy"
+	networkCIDRsOutputKey               = "NetworkCIDRs"
+	purchaseStrategyOutputKey           = "PurchaseStrategy"
+	machineTypesOutputKey               = "MachineTypes"
+	onDemandBaseCapacityOutputKey       = "OnDemandBaseCapacity"
+	onDemandPercentageOutputKey         = "OnDemandPercentage"
+	spotMaxPriceOutputKey               = "SpotMaxPrice"
+	sshKeyOutputKey                     = "SSHKey"
+	networksOutputKey                   = "Networks"
+	volumeTypeOutputKey                 = "VolumeType"
+	volumeIOPSOutputKey                 = "VolumeIOPS"
+	volumeThroughputOutputKey           = "VolumeThroughput"
+	imdsTokensOutputKey                 = "IMDSTokens"
+	imdsHopLimitOutputKey               = "IMDSHopLimit"
+	creditSpecificationOutputKey        = "CreditSpecification"
+	userDataFormatOutputKey             = "UserDataFormat"
+	sizeOutputKey                       = "Size"
+	minSizeOutputKey                    = "MinSize"
+	maxSizeOutputKey                    = "MaxSize"
+	sshCIDRsOutputKey                   = "SSHCIDRs"
+	disableSSHOutputKey                 = "DisableSSH"
+	apiCIDRsOutputKey                   = "APICIDRs"
+	sourceSecurityGroupsOutputKey       = "SourceSecurityGroups"
+	allowFromPoolsOutputKey             = "AllowFromPools"
+	poolSGOutputKey                     = "PoolSG"
+	loadBalancerTypeOutputKey           = "LoadBalancerType"
+	dnsZoneOutputKey                    = "DNSZone"
+	apiHostnameOutputKey                = "APIHostname"
+	apiAliasesOutputKey                 = "APIAliases"
+	apiExtraSANsOutputKey               = "APIExtraSANs"
+	dnsZoneIDsOutputKey                 = "DNSZoneIDs"
+	privateDNSZoneOutputKey             = "PrivateDNSZone"
+	splitHorizonDNSOutputKey            = "SplitHorizonDNS"
+	vpcIDOutputKey                      = "VpcID"
+	privateSubnetsOutputKey             = "PrivateSubnets"
+	publicSubnetsOutputKey              = "PublicSubnets"
+	networkCIDROutputKey                = "NetworkCIDR"
+	networkZonesOutputKey               = "NetworkZones"
+	asgOutputKey                        = "ASG"
+	bastionCIDRsOutputKey               = "BastionCIDRs"
 
 	clusterInfraStackType = "infra"
 	elbStackType          = "elb"
 	masterPoolStackType   = "masterpool"
 	computePoolStackType  = "computepool"
+	networkStackType      = "network"
+	bastionStackType      = "bastion"
 
 	stackStatusCompleteSuffix   = "COMPLETE"
 	stackStatusInProgressSuffix = "IN_PROGRESS"
@@ -65,6 +117,35 @@ const (
 	stackStatusRollback         = "ROLLBACK"
 )
 
+// networkSGRule is a SG ingress rule that a CNI provider needs.
+type networkSGRule struct {
+	Name       string
+	IPProtocol string
+	FromPort   int
+	ToPort     int
+}
+
+// networkProviderSGRules are SG rules that allow compute pools to reach
+// masters via CNI overlay, master to compute and in-pool traffic is already
+// allowed. Rules are rendered into compute pool stacks, so each pool only
+// reaches masters via its own SG.
+var networkProviderSGRules = map[string][]networkSGRule{
+	constants.NetworkProviderCanal: {
+		{Name: "VXLAN", IPProtocol: "17", FromPort: 8472, ToPort: 8472},
+	},
+	constants.NetworkProviderFlannel: {
+		{Name: "VXLAN", IPProtocol: "17", FromPort: 8472, ToPort: 8472},
+	},
+	constants.NetworkProviderCalico: {
+		{Name: "BGP", IPProtocol: "6", FromPort: 179, ToPort: 179},
+		{Name: "IPIP", IPProtocol: "4", FromPort: -1, ToPort: -1},
+	},
+	constants.NetworkProviderWeave: {
+		{Name: "WeaveTCP", IPProtocol: "6", FromPort: 6783, ToPort: 6783},
+		{Name: "WeaveUDP", IPProtocol: "17", FromPort: 6783, ToPort: 6784},
+	},
+}
+
 // stackExists returns true if a given stack name exists and is managed by keto.
 func (c *Cloud) stackExists(name string) (bool, error) {
 	s, err := c.getStack(name)
@@ -110,6 +191,26 @@ func getStackTaints(s *cloudformation.Stack) model.Taints {
 	return taints
 }
 
+// getStackTemplateOverlays returns a list of template overlays, which were
+// applied to a given cloudformation stack. Overlays only have names set.
+func getStackTemplateOverlays(s *cloudformation.Stack) []model.TemplateOverlay {
+	return getTemplateOverlaysFromOutputs(s.Outputs, "")
+}
+
+// getTemplateOverlaysFromOutputs returns a list of template overlays given
+// stack outputs. Overlays have names and the given stack set.
+func getTemplateOverlaysFromOutputs(outputs []*cloudformation.Output, stack string) []model.TemplateOverlay {
+	var overlays []model.TemplateOverlay
+	for _, o := range outputs {
+		if *o.OutputKey == templateOverlaysOutputKey && *o.OutputValue != "" {
+			for _, n := range strings.Split(*o.OutputValue, ",") {
+				overlays = append(overlays, model.TemplateOverlay{Name: n, Stack: stack})
+			}
+		}
+	}
+	return overlays
+}
+
 // getStacksByType returns a list of stacks by type, also checks if they are
 // managed by keto. An error is returned as well, if any.
 func (c *Cloud) getStacksByType(t string) ([]*cloudformation.Stack, error) {
@@ -179,7 +280,11 @@ func isStackManaged(s *cloudformation.Stack) bool {
 func (c *Cloud) createClusterInfraStack(cluster model.Cluster, vpcID string, subnets []*ec2.Subnet) error {
 	networks := getNodesDistributionAcrossNetworks(subnets)
 
-	templateBody, err := renderClusterInfraStackTemplate(cluster, vpcID, networks)
+	templateBody, err := renderClusterInfraStackTemplate(cluster, vpcID, networks, false)
+	if err != nil {
+		return err
+	}
+	templateBody, err = applyTemplateOverlays(templateBody, stackTemplateOverlays(cluster.TemplateOverlays, constants.TemplateOverlayStackInfra))
 	if err != nil {
 		return err
 	}
@@ -250,30 +355,61 @@ func getNodesDistributionAcrossNetworks(subnets []*ec2.Subnet) []nodesNetwork {
 	return dist
 }
 
+func (c *Cloud) createNetworkStack(cluster model.Cluster, zones []string) error {
+	templateBody, err := renderNetworkStackTemplate(cluster, zones)
+	if err != nil {
+		return err
+	}
+
+	// To ensure stack resources inherit cluster-name.
+	tags := make(map[string]string)
+	tags[clusterNameTagKey] = cluster.Name
+	tags[stackTypeTagKey] = networkStackType
+
+	stack := &cloudformation.CreateStackInput{
+		StackName:    aws.String(makeNetworkStackName(cluster.Name)),
+		Tags:         makeStackTags(tags),
+		TemplateBody: aws.String(templateBody),
+	}
+	return c.createStack(stack)
+}
+
+// makeNetworkStackName returns cluster network stack name.
+// There is no blue/green updates for network stack.
+func makeNetworkStackName(clusterName string) string {
+	return fmt.Sprintf("keto-%s-%s", clusterName, networkStackType)
+}
+
 // makeClusterInfraStackName returns cluster infra stack name.
 // There is no blue/green updates for cluster infra stack. Updates are handled in place.
 func makeClusterInfraStackName(clusterName string) string {
 	return fmt.Sprintf("keto-%s-%s", clusterName, clusterInfraStackType)
 }
 
-func (c *Cloud) createMasterPoolStack(
+// renderMasterPoolStack renders a master pool stack template and applies
+// template overlays.
+func (c *Cloud) renderMasterPoolStack(
 	p model.MasterPool,
-	infraStackName string,
 	amiID string,
-	elbName string,
+	lb loadBalancer,
 	kubeAPIURL string,
 	assetsBucketName string,
-) error {
+) (string, error) {
 	nodesPerSubnet, err := c.calcNodesPerSubnet(p.Networks)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	stackName := makeMasterPoolStackName(p.ClusterName, "")
-	templateBody, err := renderMasterStackTemplate(p, amiID, elbName, assetsBucketName, nodesPerSubnet, kubeAPIURL, stackName)
+	templateBody, err := renderMasterStackTemplate(p, amiID, lb, assetsBucketName, nodesPerSubnet, kubeAPIURL, stackName)
 	if err != nil {
-		return err
+		return "", err
 	}
+	return applyTemplateOverlays(templateBody, p.TemplateOverlays)
+}
+
+func (c *Cloud) createMasterPoolStack(p model.MasterPool, templateBody string) error {
+	stackName := makeMasterPoolStackName(p.ClusterName, "")
 
 	// To ensure stack resources inherit cluster-name.
 	tags := make(map[string]string)
@@ -312,8 +448,12 @@ func makeMasterPoolStackName(clusterName, part string) string {
 	return fmt.Sprintf("keto-%s-%s-%s", clusterName, masterPoolStackType, part)
 }
 
-func (c *Cloud) createELBStack(cluster model.Cluster, vpcID, infraStackName string) error {
-	templateBody, err := renderELBStackTemplate(cluster, vpcID)
+func (c *Cloud) createELBStack(cluster model.Cluster, vpcID, infraStackName string, subnets []string) error {
+	templateBody, err := renderELBStackTemplate(cluster, vpcID, subnets)
+	if err != nil {
+		return err
+	}
+	templateBody, err = applyTemplateOverlays(templateBody, stackTemplateOverlays(cluster.TemplateOverlays, constants.TemplateOverlayStackLoadBalancer))
 	if err != nil {
 		return err
 	}
@@ -337,12 +477,19 @@ func makeELBStackName(clusterName string) string {
 	return fmt.Sprintf("keto-%s-%s", clusterName, elbStackType)
 }
 
-func (c *Cloud) createComputePoolStack(p model.ComputePool, infraStackName string, amiID string, kubeAPIURL string) error {
+// renderComputePoolStack renders a compute pool stack template and applies
+// template overlays.
+func renderComputePoolStack(p model.ComputePool, amiID, kubeAPIURL, networkProvider string) (string, error) {
 	stackName := makeComputePoolStackName(p.ClusterName, p.Name, "")
-	templateBody, err := renderComputeStackTemplate(p, amiID, kubeAPIURL, stackName)
+	templateBody, err := renderComputeStackTemplate(p, amiID, kubeAPIURL, stackName, networkProvider)
 	if err != nil {
-		return err
+		return "", err
 	}
+	return applyTemplateOverlays(templateBody, p.TemplateOverlays)
+}
+
+func (c *Cloud) createComputePoolStack(p model.ComputePool, templateBody string) error {
+	stackName := makeComputePoolStackName(p.ClusterName, p.Name, "")
 
 	// To ensure stack resources inherit cluster-name.
 	tags := make(map[string]string)
@@ -368,6 +515,26 @@ func makeComputePoolStackName(clusterName, name, part string) string {
 	return fmt.Sprintf("keto-%s-%s-%s", clusterName, name, part)
 }
 
+func (c *Cloud) createBastionPoolStack(p model.BastionPool, templateBody string) error {
+	// To ensure stack resources inherit cluster-name.
+	tags := make(map[string]string)
+	tags[clusterNameTagKey] = p.ClusterName
+	tags[stackTypeTagKey] = bastionStackType
+
+	stack := &cloudformation.CreateStackInput{
+		StackName:    aws.String(makeBastionPoolStackName(p.ClusterName)),
+		TemplateBody: aws.String(templateBody),
+		Tags:         makeStackTags(tags),
+	}
+	return c.createStack(stack)
+}
+
+// makeBastionPoolStackName returns bastion pool stack name. A cluster has at
+// most one bastion pool, there is no blue/green updates for it.
+func makeBastionPoolStackName(clusterName string) string {
+	return fmt.Sprintf("keto-%s-%s", clusterName, bastionStackType)
+}
+
 func makeStackTags(m map[string]string) []*cloudformation.Tag {
 	tags := []*cloudformation.Tag{}
 	if m != nil {
@@ -407,6 +574,42 @@ func (c *Cloud) createStack(in *cloudformation.CreateStackInput) error {
 	return c.waitForStackOperationCompletion(*resp.StackId)
 }
 
+// updatePoolStack updates a node pool stack template in place. Stack tags are
+// left as they are.
+func (c *Cloud) updatePoolStack(stackName, templateBody string) error {
+	return c.updateStack(&cloudformation.UpdateStackInput{
+		StackName:    aws.String(stackName),
+		TemplateBody: aws.String(templateBody),
+		Capabilities: aws.StringSlice([]string{
+			cloudformation.CapabilityCapabilityIam, cloudformation.CapabilityCapabilityNamedIam}),
+	})
+}
+
+// updateStack updates a stack and waits for completion. If stack update
+// fails, an error is returned. A stack that is already up to date is not
+// treated as a failure.
+func (c *Cloud) updateStack(in *cloudformation.UpdateStackInput) error {
+	if err := c.validateStackTemplate(in.TemplateBody); err != nil {
+		return err
+	}
+
+	resp, err := c.cf.UpdateStack(in)
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok {
+			if awsErr.Code() == "ValidationError" && strings.Contains(awsErr.Message(), "No updates are to be performed") {
+				c.Logger.Printf("stack %q is up to date", *in.StackName)
+				return nil
+			}
+		}
+		return err
+	}
+	if resp.StackId == nil {
+		return fmt.Errorf("failed to update %q stack, stack id is nil in response", *in.StackName)
+	}
+
+	return c.waitForStackOperationCompletion(*resp.StackId)
+}
+
 func (c *Cloud) validateStackTemplate(tpl *string) error {
 	params := &cloudformation.ValidateTemplateInput{
 		TemplateBody: tpl,
@@ -18,16 +18,229 @@ package aws
 
 import (
 	"bytes"
-	"encoding/base64"
 	"sort"
+	"strconv"
 	"strings"
 	"text/template"
 
+	"github.com/UKHomeOffice/keto/pkg/components"
+	"github.com/UKHomeOffice/keto/pkg/constants"
 	"github.com/UKHomeOffice/keto/pkg/keto/util"
 	"github.com/UKHomeOffice/keto/pkg/model"
+	"github.com/UKHomeOffice/keto/pkg/userdata"
 )
 
-func renderClusterInfraStackTemplate(c model.Cluster, vpcID string, networks []nodesNetwork) (string, error) {
+// renderNetworkStackTemplate renders a network stack template of a VPC with a
+// public and a private subnet in each of given availability zones. Private
+// subnets route egress traffic via a NAT gateway in the same zone.
+func renderNetworkStackTemplate(c model.Cluster, zones []string) (string, error) {
+	const (
+		networkStackTemplate = `---
+Description: "Kubernetes cluster '{{ .Cluster.Name }}' network stack"
+
+Resources:
+  VPC:
+    Type: AWS::EC2::VPC
+    Properties:
+      CidrBlock: "{{ .Cluster.NetworkCIDR }}"
+      EnableDnsSupport: true
+      EnableDnsHostnames: true
+      Tags:
+        - Key: Name
+          Value: "keto-{{ .Cluster.Name }}"
+        - Key: KubernetesCluster
+          Value: "{{ .Cluster.Name }}"
+
+  InternetGateway:
+    Type: AWS::EC2::InternetGateway
+    Properties:
+      Tags:
+        - Key: Name
+          Value: "keto-{{ .Cluster.Name }}"
+
+  InternetGatewayAttachment:
+    Type: AWS::EC2::VPCGatewayAttachment
+    Properties:
+      VpcId: !Ref VPC
+      InternetGatewayId: !Ref InternetGateway
+
+  PublicRouteTable:
+    Type: AWS::EC2::RouteTable
+    Properties:
+      VpcId: !Ref VPC
+      Tags:
+        - Key: Name
+          Value: "keto-{{ .Cluster.Name }}-public"
+
+  PublicRoute:
+    Type: AWS::EC2::Route
+    DependsOn: InternetGatewayAttachment
+    Properties:
+      RouteTableId: !Ref PublicRouteTable
+      DestinationCidrBlock: 0.0.0.0/0
+      GatewayId: !Ref InternetGateway
+{{ range $i, $s := .Subnets }}
+  PublicSubnet{{ $i }}:
+    Type: AWS::EC2::Subnet
+    Properties:
+      VpcId: !Ref VPC
+      AvailabilityZone: {{ $s.AvailabilityZone }}
+      CidrBlock: "{{ $s.PublicCIDR }}"
+      MapPublicIpOnLaunch: true
+      Tags:
+        - Key: Name
+          Value: "keto-{{ $.Cluster.Name }}-public-{{ $s.AvailabilityZone }}"
+        - Key: KubernetesCluster
+          Value: "{{ $.Cluster.Name }}"
+        # Public load balancers of kubernetes services are placed here.
+        - Key: kubernetes.io/role/elb
+          Value: "1"
+
+  PublicSubnet{{ $i }}RouteTableAssociation:
+    Type: AWS::EC2::SubnetRouteTableAssociation
+    Properties:
+      SubnetId: !Ref PublicSubnet{{ $i }}
+      RouteTableId: !Ref PublicRouteTable
+
+  NATGatewayEIP{{ $i }}:
+    Type: AWS::EC2::EIP
+    DependsOn: InternetGatewayAttachment
+    Properties:
+      Domain: vpc
+
+  NATGateway{{ $i }}:
+    Type: AWS::EC2::NatGateway
+    Properties:
+      AllocationId: !GetAtt NATGatewayEIP{{ $i }}.AllocationId
+      SubnetId: !Ref PublicSubnet{{ $i }}
+      Tags:
+        - Key: Name
+          Value: "keto-{{ $.Cluster.Name }}-{{ $s.AvailabilityZone }}"
+
+  PrivateSubnet{{ $i }}:
+    Type: AWS::EC2::Subnet
+    Properties:
+      VpcId: !Ref VPC
+      AvailabilityZone: {{ $s.AvailabilityZone }}
+      CidrBlock: "{{ $s.PrivateCIDR }}"
+      Tags:
+        - Key: Name
+          Value: "keto-{{ $.Cluster.Name }}-private-{{ $s.AvailabilityZone }}"
+        - Key: KubernetesCluster
+          Value: "{{ $.Cluster.Name }}"
+        # Internal load balancers of kubernetes services are placed here.
+        - Key: kubernetes.io/role/internal-elb
+          Value: "1"
+
+  PrivateRouteTable{{ $i }}:
+    Type: AWS::EC2::RouteTable
+    Properties:
+      VpcId: !Ref VPC
+      Tags:
+        - Key: Name
+          Value: "keto-{{ $.Cluster.Name }}-private-{{ $s.AvailabilityZone }}"
+
+  PrivateRoute{{ $i }}:
+    Type: AWS::EC2::Route
+    Properties:
+      RouteTableId: !Ref PrivateRouteTable{{ $i }}
+      DestinationCidrBlock: 0.0.0.0/0
+      NatGatewayId: !Ref NATGateway{{ $i }}
+
+  PrivateSubnet{{ $i }}RouteTableAssociation:
+    Type: AWS::EC2::SubnetRouteTableAssociation
+    Properties:
+      SubnetId: !Ref PrivateSubnet{{ $i }}
+      RouteTableId: !Ref PrivateRouteTable{{ $i }}
+{{ end }}
+Outputs:
+  {{ .VpcIDOutputKey }}:
+    Value: !Ref VPC
+
+  {{ .PrivateSubnetsOutputKey }}:
+    Value:
+      Fn::Join:
+        - ","
+        -
+{{- range $i, $_ := .Subnets }}
+          - !Ref PrivateSubnet{{ $i }}
+{{- end }}
+
+  {{ .PublicSubnetsOutputKey }}:
+    Value:
+      Fn::Join:
+        - ","
+        -
+{{- range $i, $_ := .Subnets }}
+          - !Ref PublicSubnet{{ $i }}
+{{- end }}
+
+  {{ .NetworkCIDROutputKey }}:
+    Value: "{{ .Cluster.NetworkCIDR }}"
+
+  {{ .NetworkZonesOutputKey }}:
+    Value: "{{ len .Subnets }}"
+
+  {{ .ClusterNameOutputKey }}:
+    Value: "{{ .Cluster.Name }}"
+
+  {{ .StackTypeOutputKey }}:
+    Value: "{{ .StackType }}"
+`
+	)
+
+	// Each zone gets a private and a public subnet of the same size.
+	cidrs, err := splitNetworkCIDR(c.NetworkCIDR, 2*len(zones))
+	if err != nil {
+		return "", err
+	}
+	subnets := []networkSubnet{}
+	for i, z := range zones {
+		subnets = append(subnets, networkSubnet{
+			AvailabilityZone: z,
+			PrivateCIDR:      cidrs[i],
+			PublicCIDR:       cidrs[len(zones)+i],
+		})
+	}
+
+	data := struct {
+		Cluster                 model.Cluster
+		Subnets                 []networkSubnet
+		VpcIDOutputKey          string
+		PrivateSubnetsOutputKey string
+		PublicSubnetsOutputKey  string
+		NetworkCIDROutputKey    string
+		NetworkZonesOutputKey   string
+		ClusterNameOutputKey    string
+		StackTypeOutputKey      string
+		StackType               string
+	}{
+		Cluster:                 c,
+		Subnets:                 subnets,
+		VpcIDOutputKey:          vpcIDOutputKey,
+		PrivateSubnetsOutputKey: privateSubnetsOutputKey,
+		PublicSubnetsOutputKey:  publicSubnetsOutputKey,
+		NetworkCIDROutputKey:    networkCIDROutputKey,
+		NetworkZonesOutputKey:   networkZonesOutputKey,
+		ClusterNameOutputKey:    clusterNameOutputKey,
+		StackTypeOutputKey:      stackTypeOutputKey,
+		StackType:               networkStackType,
+	}
+
+	t := template.Must(template.New("network-stack").Parse(networkStackTemplate))
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func renderClusterInfraStackTemplate(c model.Cluster, vpcID string, networks []nodesNetwork, legacyComputePools bool) (string, error) {
+	manifest, err := components.Encode(c.Components)
+	if err != nil {
+		return "", err
+	}
+
 	const (
 		clusterInfraStackTemplate = `---
 Description: "Kubernetes cluster '{{ .Cluster.Name }}' infra stack"
@@ -47,11 +260,7 @@ Resources:
     Properties:
       GroupDescription: "Kubernetes cluster {{ .Cluster.Name }} SG for master nodepool"
       VpcId: {{ .VpcID }}
-      SecurityGroupIngress:
-        - IpProtocol: "6"
-          CidrIp: 0.0.0.0/0
-          FromPort: "22"
-          ToPort: "22"
+{{- template "access-ingress" .SSHIngress }}
       SecurityGroupEgress:
         - IpProtocol: -1
           CidrIp: 0.0.0.0/0
@@ -74,25 +283,15 @@ Resources:
       FromPort: -1
       ToPort: -1
 
-  MasterPoolComputeAPISGIn:
-    Type: AWS::EC2::SecurityGroupIngress
-    Properties:
-      GroupId: !Ref MasterPoolSG
-      IpProtocol: "6"
-      SourceSecurityGroupId: !Ref ComputePoolSG
-      FromPort: 443
-      ToPort: 443
-
+  # ComputePoolSG is shared by all compute pools for cluster wide access.
+  # Compute pool stacks create their own SGs, which isolate pools from each
+  # other and allow traffic to and from master nodes.
   ComputePoolSG:
     Type: "AWS::EC2::SecurityGroup"
     Properties:
       GroupDescription: "Kubernetes cluster {{ .Cluster.Name }} SG for compute nodepools"
       VpcId: {{ .VpcID }}
-      SecurityGroupIngress:
-        - IpProtocol: "6"
-          CidrIp: 0.0.0.0/0
-          FromPort: "22"
-          ToPort: "22"
+{{- template "access-ingress" .SSHIngress }}
       SecurityGroupEgress:
         - IpProtocol: -1
           CidrIp: 0.0.0.0/0
@@ -103,9 +302,18 @@ Resources:
           Value: "keto-{{ .Cluster.Name }}-computepool"
         - Key: KubernetesCluster
           Value: "{{ .Cluster.Name }}"
+{{ if .LegacyComputePools }}
+  # Compute pools created by earlier keto versions have no SGs of their own
+  # and rely on these shared rules until they are updated.
+  MasterPoolComputeAPISGIn:
+    Type: AWS::EC2::SecurityGroupIngress
+    Properties:
+      GroupId: !Ref MasterPoolSG
+      IpProtocol: "6"
+      SourceSecurityGroupId: !Ref ComputePoolSG
+      FromPort: 443
+      ToPort: 443
 
-  # Allow traffic between all compute pools.
-  # TODO(vaijab): would be nice to isolate different compute pools from each other.
   ComputePoolAllTrafficSGIn:
     Type: AWS::EC2::SecurityGroupIngress
     Properties:
@@ -115,17 +323,25 @@ Resources:
       FromPort: -1
       ToPort: -1
 
-  # Allow master nodes to talk to all compute pools.
   MasterPoolToComputePoolSG:
     Type: AWS::EC2::SecurityGroupIngress
     Properties:
       GroupId: !Ref ComputePoolSG
       IpProtocol: "-1"
       SourceSecurityGroupId: !Ref MasterPoolSG
-      # TODO(vaijab): not all ports need to be allowed.
       FromPort: "-1"
       ToPort: "-1"
-
+{{ range $_, $r := .NetworkSGRules }}
+  ComputePoolToMasterPool{{ $r.Name }}SGIn:
+    Type: AWS::EC2::SecurityGroupIngress
+    Properties:
+      GroupId: !Ref MasterPoolSG
+      IpProtocol: "{{ $r.IPProtocol }}"
+      SourceSecurityGroupId: !Ref ComputePoolSG
+      FromPort: {{ $r.FromPort }}
+      ToPort: {{ $r.ToPort }}
+{{ end }}
+{{- end }}
 {{ $clusterName := .Cluster.Name -}}
 {{ range $_, $n := .Networks }}
   ENI{{ $n.NodeID }}:
@@ -192,14 +408,48 @@ Outputs:
   {{ .InternalClusterOutputKey }}:
     Value: "{{ .Cluster.Internal }}"
 
+  {{ .NetworkProviderOutputKey }}:
+    Value: "{{ .Cluster.NetworkProvider }}"
+
+  {{ .PodCIDROutputKey }}:
+    Value: "{{ .Cluster.PodCIDR }}"
+
+  {{ .ServiceCIDROutputKey }}:
+    Value: "{{ .Cluster.ServiceCIDR }}"
+
+  {{ .ComponentsOutputKey }}:
+    Value: {{ printf "%q" .Components }}
+
+  {{ .RegistryMirrorOutputKey }}:
+    Value: "{{ .Cluster.RegistryMirror }}"
+
+  {{ .ArtifactsURLOutputKey }}:
+    Value: "{{ .Cluster.ArtifactsURL }}"
+
+  {{ .HTTPProxyOutputKey }}:
+    Value: "{{ .Cluster.Proxy.HTTPProxy }}"
+
+  {{ .HTTPSProxyOutputKey }}:
+    Value: "{{ .Cluster.Proxy.HTTPSProxy }}"
+
+  {{ .NoProxyOutputKey }}:
+    Value: "{{ .NoProxy }}"
+
+  {{ .NetworkCIDRsOutputKey }}:
+    Value: "{{ .NetworkCIDRs }}"
+
   {{ .StackTypeOutputKey }}:
     Value: "{{ .StackType }}"
-`
+{{ template "access-outputs" .AccessOutputs }}`
 	)
 
 	data := struct {
 		Cluster                   model.Cluster
 		Networks                  []nodesNetwork
+		LegacyComputePools        bool
+		NetworkSGRules            []networkSGRule
+		SSHIngress                accessIngress
+		AccessOutputs             map[string]string
 		VpcID                     string
 		LabelsOutputKey           string
 		Labels                    string
@@ -208,9 +458,26 @@ Outputs:
 		StackType                 string
 		InternalClusterOutputKey  string
 		AssetsBucketNameOutputKey string
+		NetworkProviderOutputKey  string
+		PodCIDROutputKey          string
+		ServiceCIDROutputKey      string
+		ComponentsOutputKey       string
+		Components                string
+		RegistryMirrorOutputKey   string
+		ArtifactsURLOutputKey     string
+		HTTPProxyOutputKey        string
+		HTTPSProxyOutputKey       string
+		NoProxyOutputKey          string
+		NoProxy                   string
+		NetworkCIDRsOutputKey     string
+		NetworkCIDRs              string
 	}{
 		Cluster:                   c,
 		Networks:                  networks,
+		LegacyComputePools:        legacyComputePools,
+		NetworkSGRules:            networkProviderSGRules[c.NetworkProvider],
+		SSHIngress:                makeSSHIngress(c.Access),
+		AccessOutputs:             makeAccessOutputs(c.Access),
 		VpcID:                     vpcID,
 		LabelsOutputKey:           labelsOutputKey,
 		Labels:                    util.StringMapToKVs(c.Labels),
@@ -219,9 +486,23 @@ Outputs:
 		StackType:                 clusterInfraStackType,
 		InternalClusterOutputKey:  internalClusterOutputKey,
 		AssetsBucketNameOutputKey: assetsBucketNameOutputKey,
+		NetworkProviderOutputKey:  networkProviderOutputKey,
+		PodCIDROutputKey:          podCIDROutputKey,
+		ServiceCIDROutputKey:      serviceCIDROutputKey,
+		ComponentsOutputKey:       componentsOutputKey,
+		Components:                manifest,
+		RegistryMirrorOutputKey:   registryMirrorOutputKey,
+		ArtifactsURLOutputKey:     artifactsURLOutputKey,
+		HTTPProxyOutputKey:        httpProxyOutputKey,
+		HTTPSProxyOutputKey:       httpsProxyOutputKey,
+		NoProxyOutputKey:          noProxyOutputKey,
+		NoProxy:                   strings.Join(c.Proxy.NoProxy, ","),
+		NetworkCIDRsOutputKey:     networkCIDRsOutputKey,
+		NetworkCIDRs:              strings.Join(c.NetworkCIDRs, ","),
 	}
 
 	t := template.Must(template.New("cluster-infra-stack").Parse(clusterInfraStackTemplate))
+	template.Must(t.Parse(accessTemplate))
 	var b bytes.Buffer
 	if err := t.Execute(&b, data); err != nil {
 		return "", err
@@ -229,7 +510,9 @@ Outputs:
 	return b.String(), nil
 }
 
-func renderELBStackTemplate(c model.Cluster, vpcID string) (string, error) {
+// renderELBStackTemplate renders an ELB stack template of a load balancer in
+// given subnets.
+func renderELBStackTemplate(c model.Cluster, vpcID string, subnets []string) (string, error) {
 	const (
 		elbStackTemplate = `---
 Description: "Kubernetes cluster '{{ .Cluster.Name }}' ELB stack"
@@ -240,15 +523,30 @@ Resources:
     Properties:
       GroupDescription: "Kubernetes cluster {{ .Cluster.Name }} SG for API ELB"
       VpcId: {{ .VpcID }}
-      SecurityGroupIngress:
-        - IpProtocol: "6"
-          CidrIp: 0.0.0.0/0
-          FromPort: "443"
-          ToPort: "443"
+{{- template "access-ingress" .APIIngress }}
       Tags:
         - Key: Name
           Value: "keto-{{ .Cluster.Name }}-kubeapi"
 
+  # Allow cluster nodes to talk to ELB on 443/tcp regardless of API CIDRs.
+  MasterPoolToELBTrafficSG:
+    Type: AWS::EC2::SecurityGroupIngress
+    Properties:
+      GroupId: !Ref ELBSG
+      IpProtocol: "6"
+      SourceSecurityGroupId: !ImportValue "{{ .ClusterInfraStackName }}-MasterPoolSG"
+      FromPort: "443"
+      ToPort: "443"
+
+  ComputePoolToELBTrafficSG:
+    Type: AWS::EC2::SecurityGroupIngress
+    Properties:
+      GroupId: !Ref ELBSG
+      IpProtocol: "6"
+      SourceSecurityGroupId: !ImportValue "{{ .ClusterInfraStackName }}-ComputePoolSG"
+      FromPort: "443"
+      ToPort: "443"
+
   # Allow ELB to talk to master node pool on 443/tcp
   ELBtoMasterPoolTrafficSG:
     Type: AWS::EC2::SecurityGroupIngress
@@ -259,12 +557,79 @@ Resources:
       FromPort: "443"
       ToPort: "443"
 
+{{ if .NetworkLoadBalancer }}
+{{- if not .Cluster.Internal }}
+{{- range $index, $subnet := .Subnets }}
+  NLBEIP{{ rmdash $subnet }}:
+    Type: AWS::EC2::EIP
+    Properties:
+      Domain: vpc
+      Tags:
+        - Key: Name
+          Value: "keto-{{ $.Cluster.Name }}-kubeapi"
+{{ end }}
+{{- end }}
+  NLB:
+    Type: AWS::ElasticLoadBalancingV2::LoadBalancer
+    Properties:
+      Type: network
+{{- if .Cluster.Internal }}
+      Subnets:
+{{- range $index, $subnet := .Subnets }}
+        - {{ $subnet }}
+{{- end }}
+{{- else }}
+      SubnetMappings:
+{{- range $index, $subnet := .Subnets }}
+        - SubnetId: {{ $subnet }}
+          AllocationId: !GetAtt NLBEIP{{ rmdash $subnet }}.AllocationId
+{{- end }}
+{{- end }}
+      SecurityGroups:
+        - !Ref ELBSG
+      LoadBalancerAttributes:
+        - Key: load_balancing.cross_zone.enabled
+          Value: "true"
+      Scheme: {{ if .Cluster.Internal }}"internal"{{ else }}"internet-facing"{{ end }}
+      Tags:
+        - Key: Name
+          Value: "keto-{{ .Cluster.Name }}-kubeapi"
+
+  # Client IPs aren't preserved, so that master nodes can reach kube API via
+  # the load balancer and the ELB SG rule applies to load balanced traffic.
+  NLBTargetGroup:
+    Type: AWS::ElasticLoadBalancingV2::TargetGroup
+    Properties:
+      VpcId: {{ .VpcID }}
+      Port: 443
+      Protocol: TCP
+      TargetType: instance
+      HealthCheckProtocol: TCP
+      HealthCheckIntervalSeconds: 10
+      HealthyThresholdCount: 2
+      UnhealthyThresholdCount: 2
+      TargetGroupAttributes:
+        - Key: deregistration_delay.timeout_seconds
+          Value: "30"
+        - Key: preserve_client_ip.enabled
+          Value: "false"
+
+  NLBListener:
+    Type: AWS::ElasticLoadBalancingV2::Listener
+    Properties:
+      LoadBalancerArn: !Ref NLB
+      Port: 443
+      Protocol: TCP
+      DefaultActions:
+        - Type: forward
+          TargetGroupArn: !Ref NLBTargetGroup
+{{ else }}
   ELB:
     Type: AWS::ElasticLoadBalancing::LoadBalancer
     Properties:
       CrossZone: true
       Subnets:
-{{- range $index, $subnet := .Cluster.MasterPool.Networks }}
+{{- range $index, $subnet := .Subnets }}
         - {{ $subnet }}
 {{ end }}
       SecurityGroups:
@@ -286,56 +651,152 @@ Resources:
       ConnectionSettings:
         IdleTimeout: 600
       Scheme: {{ if .Cluster.Internal }}"internal"{{ else }}"internet-facing"{{ end }}
-
-{{ if ne .Cluster.DNSZone "" }}
+{{ end }}
+{{ if .DNSRecords }}
+{{- range $i, $id := .Cluster.DNSZoneIDs }}
+  ELBDNS{{ if $i }}{{ $i }}{{ end }}:
+    Type: AWS::Route53::RecordSetGroup
+    Properties:
+      HostedZoneId: {{ $id }}
+{{- template "elb-dns-records" $ }}
+{{ else }}
+  # Stacks that predate DNS zone IDs keep looking zones up by name.
   ELBDNS:
     Type: AWS::Route53::RecordSetGroup
     Properties:
       HostedZoneName: {{ .Cluster.DNSZone }}.
+{{- template "elb-dns-records" . }}
+{{ end }}
+{{- end }}
+
+Outputs:
+  {{ .LoadBalancer }}:
+    Value: !Ref {{ .LoadBalancer }}
+{{- if .NetworkLoadBalancer }}
+  NLBTargetGroup:
+    Value: !Ref NLBTargetGroup
+{{- end }}
+  {{ .ELBDNSOutputKey }}:
+    {{ if ne .APIHostname "" }}Value: {{ .APIHostname }}{{ else }}Value: {'Fn::GetAtt': [ {{ .LoadBalancer }}, {{ .LoadBalancerDNSAttr }} ]}{{ end }}
+  {{ .LoadBalancerTypeOutputKey }}:
+    Value: "{{ .Cluster.LoadBalancerType }}"
+  {{ .DNSZoneOutputKey }}:
+    Value: "{{ .Cluster.DNSZone }}"
+  {{ .APIHostnameOutputKey }}:
+    Value: "{{ .APIHostname }}"
+  {{ .APIAliasesOutputKey }}:
+    Value: "{{ .APIAliases }}"
+  {{ .APIExtraSANsOutputKey }}:
+    Value: "{{ .APIExtraSANs }}"
+  {{ .DNSZoneIDsOutputKey }}:
+    Value: "{{ .DNSZoneIDs }}"
+  {{ .PrivateDNSZoneOutputKey }}:
+    Value: "{{ .Cluster.PrivateDNSZone }}"
+  {{ .SplitHorizonDNSOutputKey }}:
+    Value: "{{ .Cluster.SplitHorizonDNS }}"
+{{ define "elb-dns-records" }}
       RecordSets:
-        - Name: kube-{{ .Cluster.Name }}.{{ .Cluster.DNSZone }}
+{{- range .DNSRecords }}
+        - Name: {{ . }}
           Type: A
           AliasTarget:
             HostedZoneId:
               'Fn::GetAtt':
-                - ELB
-                - CanonicalHostedZoneNameID
+                - {{ $.LoadBalancer }}
+                - {{ if $.NetworkLoadBalancer }}CanonicalHostedZoneID{{ else }}CanonicalHostedZoneNameID{{ end }}
             DNSName:
-              'Fn::GetAtt': [ ELB, {{ if .Cluster.Internal }}DNSName{{ else }}CanonicalHostedZoneName{{ end }} ]
-{{ end }}
-
-Outputs:
-  ELB:
-    Value: !Ref ELB
-  {{ .ELBDNSOutputKey }}:
-    {{ if ne .Cluster.DNSZone "" }}Value: kube-{{ .Cluster.Name }}.{{ .Cluster.DNSZone }}{{ else }}Value: {'Fn::GetAtt': [ ELB, {{ if .Cluster.Internal }}DNSName}{{ else }}CanonicalHostedZoneName{{ end }} ]}{{ end }}
+              'Fn::GetAtt': [ {{ $.LoadBalancer }}, {{ $.LoadBalancerDNSAttr }} ]
+{{- end }}
+{{- end }}
 `
 	)
 
-	// Make sure networks are always in the same order.
-	sort.Strings(c.MasterPool.Networks)
+	// Make sure subnets are always in the same order.
+	subnets = append([]string{}, subnets...)
+	sort.Strings(subnets)
 
 	data := struct {
-		Cluster                  model.Cluster
-		VpcID                    string
-		ClusterInfraStackName    string
-		ClusterNameOutputKey     string
-		StackTypeOutputKey       string
-		StackType                string
-		InternalClusterOutputKey string
-		ELBDNSOutputKey          string
+		Cluster                   model.Cluster
+		VpcID                     string
+		Subnets                   []string
+		APIIngress                accessIngress
+		ClusterInfraStackName     string
+		ClusterNameOutputKey      string
+		StackTypeOutputKey        string
+		StackType                 string
+		InternalClusterOutputKey  string
+		ELBDNSOutputKey           string
+		LoadBalancerTypeOutputKey string
+		NetworkLoadBalancer       bool
+		LoadBalancer              string
+		LoadBalancerDNSAttr       string
+		APIHostname               string
+		DNSRecords                []string
+		DNSZoneOutputKey          string
+		APIHostnameOutputKey      string
+		APIAliasesOutputKey       string
+		APIAliases                string
+		APIExtraSANsOutputKey     string
+		APIExtraSANs              string
+		DNSZoneIDsOutputKey       string
+		DNSZoneIDs                string
+		PrivateDNSZoneOutputKey   string
+		SplitHorizonDNSOutputKey  string
 	}{
-		Cluster: c,
-		VpcID:   vpcID,
-		ClusterInfraStackName:    makeClusterInfraStackName(c.Name),
-		ClusterNameOutputKey:     clusterNameOutputKey,
-		StackTypeOutputKey:       stackTypeOutputKey,
-		StackType:                elbStackType,
-		InternalClusterOutputKey: internalClusterOutputKey,
-		ELBDNSOutputKey:          elbDNSOutputKey,
+		Cluster:                   c,
+		VpcID:                     vpcID,
+		Subnets:                   subnets,
+		APIIngress:                makeAPIIngress(c.Access),
+		ClusterInfraStackName:     makeClusterInfraStackName(c.Name),
+		ClusterNameOutputKey:      clusterNameOutputKey,
+		StackTypeOutputKey:        stackTypeOutputKey,
+		StackType:                 elbStackType,
+		InternalClusterOutputKey:  internalClusterOutputKey,
+		ELBDNSOutputKey:           elbDNSOutputKey,
+		LoadBalancerTypeOutputKey: loadBalancerTypeOutputKey,
+		LoadBalancer:              "ELB",
+		LoadBalancerDNSAttr:       "CanonicalHostedZoneName",
+		APIHostname:               makeAPIHostname(c),
+		DNSZoneOutputKey:          dnsZoneOutputKey,
+		APIHostnameOutputKey:      apiHostnameOutputKey,
+		APIAliasesOutputKey:       apiAliasesOutputKey,
+		APIAliases:                strings.Join(c.APIAliases, ","),
+		APIExtraSANsOutputKey:     apiExtraSANsOutputKey,
+		APIExtraSANs:              strings.Join(c.APIExtraSANs, ","),
+		DNSZoneIDsOutputKey:       dnsZoneIDsOutputKey,
+		DNSZoneIDs:                strings.Join(c.DNSZoneIDs, ","),
+		PrivateDNSZoneOutputKey:   privateDNSZoneOutputKey,
+		SplitHorizonDNSOutputKey:  splitHorizonDNSOutputKey,
+	}
+	// Records are only managed for API names in the DNS zone.
+	if c.DNSZone != "" {
+		if strings.HasSuffix(strings.ToLower(data.APIHostname), "."+strings.ToLower(c.DNSZone)) {
+			data.DNSRecords = append(data.DNSRecords, data.APIHostname)
+		}
+		data.DNSRecords = append(data.DNSRecords, c.APIAliases...)
+	}
+	if c.LoadBalancerType == "" {
+		data.Cluster.LoadBalancerType = constants.DefaultLoadBalancerType
+	}
+	if c.LoadBalancerType == constants.LoadBalancerTypeNetwork {
+		data.NetworkLoadBalancer = true
+		data.LoadBalancer = "NLB"
+	}
+	// Internal load balancers don't resolve via canonical hosted zone names
+	// and network load balancers don't have them.
+	if c.Internal || data.NetworkLoadBalancer {
+		data.LoadBalancerDNSAttr = "DNSName"
 	}
 
-	t := template.Must(template.New("elb-stack").Parse(elbStackTemplate))
+	funcMap := template.FuncMap{
+		// Deletes dashes from a string.
+		"rmdash": func(s string) string {
+			return strings.Replace(s, "-", "", -1)
+		},
+	}
+
+	t := template.Must(template.New("elb-stack").Funcs(funcMap).Parse(elbStackTemplate))
+	template.Must(t.Parse(accessTemplate))
 	var b bytes.Buffer
 	if err := t.Execute(&b, data); err != nil {
 		return "", err
@@ -346,7 +807,7 @@ Outputs:
 func renderMasterStackTemplate(
 	p model.MasterPool,
 	amiID string,
-	elbName string,
+	lb loadBalancer,
 	assetsBucketName string,
 	nodesPerSubnet map[string]int,
 	kubeAPIURL string,
@@ -390,9 +851,22 @@ Resources:
             Effect: Allow
             Action:
               - autoscaling:DescribeAutoScalingGroups
+              - autoscaling:DescribeAutoScalingInstances
+              - autoscaling:DescribeTags
               - ec2:CreateTags
               - ec2:DescribeTags
               - ec2:DescribeInstances
+              - ec2:DescribeInstanceTypes
+              - ec2:DescribeLaunchTemplateVersions
+          # Cluster autoscaler may only scale pools of this cluster.
+          - Resource: "*"
+            Effect: Allow
+            Action:
+              - autoscaling:SetDesiredCapacity
+              - autoscaling:TerminateInstanceInAutoScalingGroup
+            Condition:
+              StringEquals:
+                "autoscaling:ResourceTag/k8s.io/cluster-autoscaler/{{ .MasterPool.ClusterName }}": "owned"
           - Resource: "arn:aws:s3:::{{ .AssetsBucketName }}"
             Effect: Allow
             Action:
@@ -446,19 +920,23 @@ Resources:
               - elasticloadbalancing:SetLoadBalancerPoliciesForBackendServer
 
 {{ $masterPool := .MasterPool -}}
-{{ $userData := .UserData -}}
-{{ $amiID := .AmiID -}}
-{{ $elbName := .ELBName -}}
-{{ $clusterInfraStackName := .ClusterInfraStackName -}}
+{{ $lb := .LoadBalancer -}}
 {{ range $subnet, $num := .NodesPerSubnet }}
   ASG{{ rmdash $subnet }}:
     Type: AWS::AutoScaling::AutoScalingGroup
     Properties:
-      LaunchConfigurationName: !Ref LaunchConfiguration{{ rmdash $subnet }}
+      LaunchTemplate:
+        LaunchTemplateId: !Ref LaunchTemplate
+        Version: !GetAtt LaunchTemplate.LatestVersionNumber
       VPCZoneIdentifier:
         - "{{ $subnet }}"
+{{- if $lb.TargetGroupARN }}
+      TargetGroupARNs:
+        - "{{ $lb.TargetGroupARN }}"
+{{- else }}
       LoadBalancerNames:
-        - "{{ $elbName }}"
+        - "{{ $lb.ELBName }}"
+{{- end }}
       TerminationPolicies:
         - 'OldestInstance'
         - 'Default'
@@ -471,25 +949,8 @@ Resources:
         - Key: KubernetesCluster
           Value: "{{ $masterPool.ClusterName }}"
           PropagateAtLaunch: true
-  LaunchConfiguration{{ rmdash $subnet }}:
-    Type: AWS::AutoScaling::LaunchConfiguration
-    Properties:
-      AssociatePublicIpAddress: {{ if $masterPool.Internal }}false{{ else }}true{{ end }}
-      IamInstanceProfile: !Ref InstanceProfile
-      ImageId: "{{ $amiID }}"
-      InstanceMonitoring: false
-      InstanceType: "{{ $masterPool.MachineType }}"
-      KeyName: "{{ $masterPool.SSHKey }}"
-      SecurityGroups:
-        - !ImportValue "{{ $clusterInfraStackName }}-MasterPoolSG"
-      BlockDeviceMappings:
-        - DeviceName: "/dev/xvda"
-          Ebs:
-            VolumeSize: "{{ $masterPool.DiskSize }}"
-            DeleteOnTermination: true
-            VolumeType: "gp2"
-      UserData: {{ $userData }}
 {{ end -}}
+{{ template "launch-template" .LaunchTemplate }}
 
 Outputs:
   {{ .AssetsBucketNameOutputKey }}:
@@ -539,7 +1000,10 @@ Outputs:
 
   {{ .SchedulerExtraArgsOutputKey }}:
     Value: "{{ .MasterPool.SchedulerExtraArgs }}"
-`
+
+  {{ .UserDataHashOutputKey }}:
+    Value: "{{ .UserDataHash }}"
+{{ template "launch-template-outputs" .LaunchTemplate }}`
 	)
 
 	// Make sure networks are always in the same order.
@@ -547,11 +1011,9 @@ Outputs:
 
 	data := struct {
 		MasterPool                          model.MasterPool
-		ClusterInfraStackName               string
+		LaunchTemplate                      launchTemplate
 		StackName                           string
-		AmiID                               string
-		ELBName                             string
-		UserData                            string
+		LoadBalancer                        loadBalancer
 		NodesPerSubnet                      map[string]int
 		KubeAPIURL                          string
 		LabelsOutputKey                     string
@@ -574,13 +1036,13 @@ Outputs:
 		APIServerExtraArgsOutputKey         string
 		ControllerManagerExtraArgsOutputKey string
 		SchedulerExtraArgsOutputKey         string
+		UserDataHashOutputKey               string
+		UserDataHash                        string
 	}{
 		MasterPool:                          p,
-		ClusterInfraStackName:               makeClusterInfraStackName(p.ClusterName),
+		LaunchTemplate:                      makeLaunchTemplate(p.NodePool, amiID, makeClusterInfraStackName(p.ClusterName)+"-MasterPoolSG"),
 		StackName:                           stackName,
-		AmiID:                               amiID,
-		ELBName:                             elbName,
-		UserData:                            base64.StdEncoding.EncodeToString(p.UserData),
+		LoadBalancer:                        lb,
 		NodesPerSubnet:                      nodesPerSubnet,
 		KubeAPIURL:                          kubeAPIURL,
 		LabelsOutputKey:                     labelsOutputKey,
@@ -603,6 +1065,8 @@ Outputs:
 		APIServerExtraArgsOutputKey:         apiServerExtraArgsOutputKey,
 		ControllerManagerExtraArgsOutputKey: controllerManagerExtraArgsOutputKey,
 		SchedulerExtraArgsOutputKey:         schedulerExtraArgsOutputKey,
+		UserDataHashOutputKey:               userDataHashOutputKey,
+		UserDataHash:                        userdata.Hash(p.UserData),
 	}
 
 	funcMap := template.FuncMap{
@@ -613,6 +1077,7 @@ Outputs:
 	}
 
 	t := template.Must(template.New("master-stack").Funcs(funcMap).Parse(masterStackTemplate))
+	template.Must(t.Parse(launchTemplateTemplate))
 	var b bytes.Buffer
 	if err := t.Execute(&b, data); err != nil {
 		return "", err
@@ -625,6 +1090,7 @@ func renderComputeStackTemplate(
 	amiID string,
 	kubeAPIURL string,
 	stackName string,
+	networkProvider string,
 ) (string, error) {
 
 	const (
@@ -673,10 +1139,98 @@ Resources:
             Action:
               - cloudformation:DescribeStacks
 
+  PoolSG:
+    Type: "AWS::EC2::SecurityGroup"
+    Properties:
+      GroupDescription: "Kubernetes cluster {{ .ComputePool.ClusterName }} SG for computepool {{ .ComputePool.Name }}"
+      VpcId: !ImportValue "{{ .ClusterInfraStackName }}-VpcID"
+      SecurityGroupEgress:
+        - IpProtocol: -1
+          CidrIp: 0.0.0.0/0
+          FromPort: -1
+          ToPort: -1
+      Tags:
+        - Key: Name
+          Value: "keto-{{ .ComputePool.ClusterName }}-{{ .ComputePool.Name }}"
+        - Key: KubernetesCluster
+          Value: "{{ .ComputePool.ClusterName }}"
+
+  # Allow traffic between nodes of this compute pool.
+  PoolAllTrafficSGIn:
+    Type: AWS::EC2::SecurityGroupIngress
+    Properties:
+      GroupId: !Ref PoolSG
+      IpProtocol: -1
+      SourceSecurityGroupId: !Ref PoolSG
+      FromPort: -1
+      ToPort: -1
+
+  # Allow master nodes to talk to this compute pool.
+  MasterPoolToPoolSGIn:
+    Type: AWS::EC2::SecurityGroupIngress
+    Properties:
+      GroupId: !Ref PoolSG
+      IpProtocol: -1
+      SourceSecurityGroupId: !ImportValue "{{ .ClusterInfraStackName }}-MasterPoolSG"
+      # TODO(vaijab): not all ports need to be allowed.
+      FromPort: -1
+      ToPort: -1
+
+  PoolToMasterPoolAPISGIn:
+    Type: AWS::EC2::SecurityGroupIngress
+    Properties:
+      GroupId: !ImportValue "{{ .ClusterInfraStackName }}-MasterPoolSG"
+      IpProtocol: "6"
+      SourceSecurityGroupId: !Ref PoolSG
+      FromPort: 443
+      ToPort: 443
+{{ range $_, $r := .NetworkSGRules }}
+  # Allow {{ $r.Name }} CNI traffic from this compute pool to master nodes.
+  PoolToMasterPool{{ $r.Name }}SGIn:
+    Type: AWS::EC2::SecurityGroupIngress
+    Properties:
+      GroupId: !ImportValue "{{ $.ClusterInfraStackName }}-MasterPoolSG"
+      IpProtocol: "{{ $r.IPProtocol }}"
+      SourceSecurityGroupId: !Ref PoolSG
+      FromPort: {{ $r.FromPort }}
+      ToPort: {{ $r.ToPort }}
+{{ end }}
+{{- range $name, $stack := .AllowFromPools }}
+  # Allow traffic from {{ $name }} compute pool.
+  AllowFrom{{ rmdash $name }}SGIn:
+    Type: AWS::EC2::SecurityGroupIngress
+    Properties:
+      GroupId: !Ref PoolSG
+      IpProtocol: -1
+      SourceSecurityGroupId: !ImportValue "{{ $stack }}-PoolSG"
+      FromPort: -1
+      ToPort: -1
+{{ end }}
   ASG:
     Type: AWS::AutoScaling::AutoScalingGroup
     Properties:
-      LaunchConfigurationName: !Ref LaunchConfiguration
+{{- if .MixedInstances }}
+      MixedInstancesPolicy:
+        LaunchTemplate:
+          LaunchTemplateSpecification:
+            LaunchTemplateId: !Ref LaunchTemplate
+            Version: !GetAtt LaunchTemplate.LatestVersionNumber
+          Overrides:
+{{- range .MachineTypes }}
+            - InstanceType: "{{ . }}"
+{{- end }}
+        InstancesDistribution:
+          OnDemandBaseCapacity: {{ .ComputePool.OnDemandBaseCapacity }}
+          OnDemandPercentageAboveBaseCapacity: {{ .OnDemandPercentage }}
+          SpotAllocationStrategy: "capacity-optimized"
+{{- if .ComputePool.SpotMaxPrice }}
+          SpotMaxPrice: "{{ .ComputePool.SpotMaxPrice }}"
+{{- end }}
+{{- else }}
+      LaunchTemplate:
+        LaunchTemplateId: !Ref LaunchTemplate
+        Version: !GetAtt LaunchTemplate.LatestVersionNumber
+{{- end }}
       VPCZoneIdentifier:
 {{- range $index, $subnet := .ComputePool.Networks }}
         - "{{ $subnet }}"
@@ -684,8 +1238,9 @@ Resources:
       TerminationPolicies:
         - 'OldestInstance'
         - 'Default'
-      MaxSize: 100
-      MinSize: {{ .ComputePool.Size }}
+      MaxSize: {{ .MaxSize }}
+      MinSize: {{ .MinSize }}
+      DesiredCapacity: {{ .ComputePool.Size }}
       Tags:
         - Key: Name
           Value: "keto-{{ .ComputePool.ClusterName }}-{{ .ComputePool.Name }}"
@@ -693,24 +1248,25 @@ Resources:
         - Key: KubernetesCluster
           Value: "{{ .ComputePool.ClusterName }}"
           PropagateAtLaunch: true
-  LaunchConfiguration:
-    Type: AWS::AutoScaling::LaunchConfiguration
-    Properties:
-      AssociatePublicIpAddress: {{ if .ComputePool.Internal }}false{{ else }}true{{ end }}
-      IamInstanceProfile: !Ref InstanceProfile
-      ImageId: "{{ .AmiID }}"
-      InstanceMonitoring: false
-      InstanceType: "{{ .ComputePool.MachineType }}"
-      KeyName: "{{ .ComputePool.SSHKey }}"
-      SecurityGroups:
-        - !ImportValue "{{ .ClusterInfraStackName }}-ComputePoolSG"
-      BlockDeviceMappings:
-        - DeviceName: "/dev/xvda"
-          Ebs:
-            VolumeSize: "{{ .ComputePool.DiskSize }}"
-            DeleteOnTermination: true
-            VolumeType: "gp2"
-      UserData: {{ .UserData }}
+{{- if .Autoscaling }}
+        - Key: "k8s.io/cluster-autoscaler/enabled"
+          Value: "true"
+          PropagateAtLaunch: false
+        - Key: "k8s.io/cluster-autoscaler/{{ .ComputePool.ClusterName }}"
+          Value: "owned"
+          PropagateAtLaunch: false
+{{- range $k, $v := .ComputePool.Labels }}
+        - Key: "k8s.io/cluster-autoscaler/node-template/label/{{ $k }}"
+          Value: "{{ $v }}"
+          PropagateAtLaunch: false
+{{- end }}
+{{- range $k, $v := .ComputePool.Taints }}
+        - Key: "k8s.io/cluster-autoscaler/node-template/taint/{{ $k }}"
+          Value: "{{ $v }}"
+          PropagateAtLaunch: false
+{{- end }}
+{{- end }}
+{{ template "launch-template" .LaunchTemplate }}
 
 Outputs:
   {{ .ClusterNameOutputKey }}:
@@ -748,59 +1304,305 @@ Outputs:
 
   {{ .KubeletExtraArgsOutputKey }}:
     Value: "{{ .ComputePool.KubeletExtraArgs }}"
-`
+
+  {{ .UserDataHashOutputKey }}:
+    Value: "{{ .UserDataHash }}"
+
+  {{ .PurchaseStrategyOutputKey }}:
+    Value: "{{ .ComputePool.PurchaseStrategy }}"
+
+  {{ .MachineTypesOutputKey }}:
+    Value: "{{ .ExtraMachineTypes }}"
+
+  {{ .OnDemandBaseCapacityOutputKey }}:
+    Value: "{{ .ComputePool.OnDemandBaseCapacity }}"
+
+  {{ .OnDemandPercentageOutputKey }}:
+    Value: "{{ .OnDemandPercentage }}"
+
+  {{ .SpotMaxPriceOutputKey }}:
+    Value: "{{ .ComputePool.SpotMaxPrice }}"
+
+  {{ .AllowFromPoolsOutputKey }}:
+    Value: "{{ .AllowFromPoolsOutput }}"
+
+  {{ .PoolSGOutputKey }}:
+    Value: !Ref PoolSG
+    Export:
+      Name:
+        Fn::Sub: "${AWS::StackName}-PoolSG"
+{{ template "launch-template-outputs" .LaunchTemplate }}`
 	)
 
 	// Make sure networks are always in the same order.
 	sort.Strings(p.Networks)
 
+	lt := makeLaunchTemplate(p.NodePool, amiID, makeClusterInfraStackName(p.ClusterName)+"-ComputePoolSG")
+	lt.PoolSecurityGroup = true
+	lt.Outputs[sizeOutputKey] = strconv.Itoa(p.Size)
+
+	// Pools created without min/max sizes are fixed in size.
+	minSize, maxSize := p.MinSize, p.MaxSize
+	if maxSize == 0 {
+		minSize, maxSize = p.Size, p.Size
+	}
+	lt.Outputs[minSizeOutputKey] = strconv.Itoa(minSize)
+	lt.Outputs[maxSizeOutputKey] = strconv.Itoa(maxSize)
+
+	// The main machine type always goes first, the rest are overrides.
+	machineTypes := []string{p.MachineType}
+	for _, t := range p.MachineTypes {
+		if t != p.MachineType {
+			machineTypes = append(machineTypes, t)
+		}
+	}
+	onDemand := p.PurchaseStrategy == "" || p.PurchaseStrategy == constants.PurchaseStrategyOnDemand
+	onDemandPercentage := p.OnDemandPercentage
+	if onDemand {
+		onDemandPercentage = 100
+	}
+
+	// Allowed pools are referred to by their stack SG exports.
+	allowFromPools := map[string]string{}
+	for _, name := range p.AllowFromPools {
+		allowFromPools[name] = makeComputePoolStackName(p.ClusterName, name, "")
+	}
+
 	data := struct {
-		ComputePool               model.ComputePool
-		ClusterInfraStackName     string
-		StackName                 string
-		AmiID                     string
-		UserData                  string
-		KubeAPIURL                string
-		LabelsOutputKey           string
-		Labels                    string
-		Taints                    string
-		ClusterNameOutputKey      string
-		PoolNameOutputKey         string
-		CoreOSVersionOutputKey    string
-		StackTypeOutputKey        string
-		StackType                 string
-		InternalClusterOutputKey  string
-		KubeAPIURLOutputKey       string
-		MachineTypeOutputKey      string
-		KubeVersionOutputKey      string
-		DiskSizeOutputKey         string
-		TaintsOutputKey           string
-		KubeletExtraArgsOutputKey string
+		ComputePool                   model.ComputePool
+		LaunchTemplate                launchTemplate
+		ClusterInfraStackName         string
+		NetworkSGRules                []networkSGRule
+		AllowFromPools                map[string]string
+		AllowFromPoolsOutputKey       string
+		AllowFromPoolsOutput          string
+		PoolSGOutputKey               string
+		MixedInstances                bool
+		Autoscaling                   bool
+		MinSize                       int
+		MaxSize                       int
+		OnDemandPercentage            int
+		MachineTypes                  []string
+		ExtraMachineTypes             string
+		PurchaseStrategyOutputKey     string
+		MachineTypesOutputKey         string
+		OnDemandBaseCapacityOutputKey string
+		OnDemandPercentageOutputKey   string
+		SpotMaxPriceOutputKey         string
+		StackName                     string
+		KubeAPIURL                    string
+		LabelsOutputKey               string
+		Labels                        string
+		Taints                        string
+		ClusterNameOutputKey          string
+		PoolNameOutputKey             string
+		CoreOSVersionOutputKey        string
+		StackTypeOutputKey            string
+		StackType                     string
+		InternalClusterOutputKey      string
+		KubeAPIURLOutputKey           string
+		MachineTypeOutputKey          string
+		KubeVersionOutputKey          string
+		DiskSizeOutputKey             string
+		TaintsOutputKey               string
+		KubeletExtraArgsOutputKey     string
+		UserDataHashOutputKey         string
+		UserDataHash                  string
 	}{
-		ComputePool:               p,
-		ClusterInfraStackName:     makeClusterInfraStackName(p.ClusterName),
-		StackName:                 stackName,
-		AmiID:                     amiID,
-		UserData:                  base64.StdEncoding.EncodeToString(p.UserData),
-		KubeAPIURL:                kubeAPIURL,
-		LabelsOutputKey:           labelsOutputKey,
-		Labels:                    util.StringMapToKVs(p.Labels),
-		Taints:                    util.StringMapToKVs(p.Taints),
-		ClusterNameOutputKey:      clusterNameOutputKey,
-		CoreOSVersionOutputKey:    coreOSVersionOutputKey,
-		PoolNameOutputKey:         poolNameOutputKey,
-		StackTypeOutputKey:        stackTypeOutputKey,
-		StackType:                 computePoolStackType,
-		InternalClusterOutputKey:  internalClusterOutputKey,
-		KubeAPIURLOutputKey:       kubeAPIURLOutputKey,
-		MachineTypeOutputKey:      machineTypeOutputKey,
-		KubeVersionOutputKey:      kubeVersionOutputKey,
-		DiskSizeOutputKey:         diskSizeOutputKey,
-		TaintsOutputKey:           taintsOutputKey,
-		KubeletExtraArgsOutputKey: kubeletExtraArgsOutputKey,
+		ComputePool:                   p,
+		LaunchTemplate:                lt,
+		ClusterInfraStackName:         makeClusterInfraStackName(p.ClusterName),
+		NetworkSGRules:                networkProviderSGRules[networkProvider],
+		AllowFromPools:                allowFromPools,
+		AllowFromPoolsOutputKey:       allowFromPoolsOutputKey,
+		AllowFromPoolsOutput:          strings.Join(p.AllowFromPools, ","),
+		PoolSGOutputKey:               poolSGOutputKey,
+		MixedInstances:                !onDemand || len(machineTypes) > 1,
+		Autoscaling:                   maxSize > minSize,
+		MinSize:                       minSize,
+		MaxSize:                       maxSize,
+		OnDemandPercentage:            onDemandPercentage,
+		MachineTypes:                  machineTypes,
+		ExtraMachineTypes:             strings.Join(machineTypes[1:], ","),
+		PurchaseStrategyOutputKey:     purchaseStrategyOutputKey,
+		MachineTypesOutputKey:         machineTypesOutputKey,
+		OnDemandBaseCapacityOutputKey: onDemandBaseCapacityOutputKey,
+		OnDemandPercentageOutputKey:   onDemandPercentageOutputKey,
+		SpotMaxPriceOutputKey:         spotMaxPriceOutputKey,
+		StackName:                     stackName,
+		KubeAPIURL:                    kubeAPIURL,
+		LabelsOutputKey:               labelsOutputKey,
+		Labels:                        util.StringMapToKVs(p.Labels),
+		Taints:                        util.StringMapToKVs(p.Taints),
+		ClusterNameOutputKey:          clusterNameOutputKey,
+		CoreOSVersionOutputKey:        coreOSVersionOutputKey,
+		PoolNameOutputKey:             poolNameOutputKey,
+		StackTypeOutputKey:            stackTypeOutputKey,
+		StackType:                     computePoolStackType,
+		InternalClusterOutputKey:      internalClusterOutputKey,
+		KubeAPIURLOutputKey:           kubeAPIURLOutputKey,
+		MachineTypeOutputKey:          machineTypeOutputKey,
+		KubeVersionOutputKey:          kubeVersionOutputKey,
+		DiskSizeOutputKey:             diskSizeOutputKey,
+		TaintsOutputKey:               taintsOutputKey,
+		KubeletExtraArgsOutputKey:     kubeletExtraArgsOutputKey,
+		UserDataHashOutputKey:         userDataHashOutputKey,
+		UserDataHash:                  userdata.Hash(p.UserData),
+	}
+
+	funcMap := template.FuncMap{
+		// Deletes dashes from a string.
+		"rmdash": func(s string) string {
+			return strings.Replace(s, "-", "", -1)
+		},
+	}
+
+	t := template.Must(template.New("compute-stack").Funcs(funcMap).Parse(computeStackTemplate))
+	template.Must(t.Parse(launchTemplateTemplate))
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+// renderBastionStackTemplate renders a bastion pool stack template. Bastion
+// hosts are reachable over SSH from their allowed CIDRs and cluster source
+// security groups and are allowed SSH access to master and compute nodes.
+func renderBastionStackTemplate(p model.BastionPool, a model.Access, amiID string) (string, error) {
+	const (
+		bastionStackTemplate = `---
+Description: "Kubernetes cluster '{{ .Pool.ClusterName }}' bastion pool stack"
+
+Resources:
+  BastionSG:
+    Type: "AWS::EC2::SecurityGroup"
+    Properties:
+      GroupDescription: "Kubernetes cluster {{ .Pool.ClusterName }} SG for bastion hosts"
+      VpcId: !ImportValue "{{ .ClusterInfraStackName }}-VpcID"
+{{- template "access-ingress" .SSHIngress }}
+      SecurityGroupEgress:
+        - IpProtocol: -1
+          CidrIp: 0.0.0.0/0
+          FromPort: -1
+          ToPort: -1
+      Tags:
+        - Key: Name
+          Value: "keto-{{ .Pool.ClusterName }}-{{ .Pool.Name }}"
+
+  # Allow SSH from bastion hosts to master nodes.
+  BastionToMasterPoolSSHSGIn:
+    Type: AWS::EC2::SecurityGroupIngress
+    Properties:
+      GroupId: !ImportValue "{{ .ClusterInfraStackName }}-MasterPoolSG"
+      IpProtocol: "6"
+      SourceSecurityGroupId: !Ref BastionSG
+      FromPort: {{ .SSHPort }}
+      ToPort: {{ .SSHPort }}
+
+  # Allow SSH from bastion hosts to compute nodes.
+  BastionToComputePoolSSHSGIn:
+    Type: AWS::EC2::SecurityGroupIngress
+    Properties:
+      GroupId: !ImportValue "{{ .ClusterInfraStackName }}-ComputePoolSG"
+      IpProtocol: "6"
+      SourceSecurityGroupId: !Ref BastionSG
+      FromPort: {{ .SSHPort }}
+      ToPort: {{ .SSHPort }}
+
+  LaunchTemplate:
+    Type: AWS::EC2::LaunchTemplate
+    Properties:
+      LaunchTemplateData:
+        ImageId: "{{ .AmiID }}"
+        InstanceType: "{{ .Pool.MachineType }}"
+{{- if .Pool.SSHKey }}
+        KeyName: "{{ .Pool.SSHKey }}"
+{{- end }}
+        Monitoring:
+          Enabled: false
+        MetadataOptions:
+          HttpEndpoint: "enabled"
+          HttpTokens: "required"
+        NetworkInterfaces:
+          - DeviceIndex: 0
+            AssociatePublicIpAddress: true
+            Groups:
+              - !Ref BastionSG
+        BlockDeviceMappings:
+          - DeviceName: "/dev/xvda"
+            Ebs:
+              VolumeSize: "{{ .Pool.DiskSize }}"
+              DeleteOnTermination: true
+              VolumeType: "{{ .VolumeType }}"
+
+  ASG:
+    Type: AWS::AutoScaling::AutoScalingGroup
+    Properties:
+      LaunchTemplate:
+        LaunchTemplateId: !Ref LaunchTemplate
+        Version: !GetAtt LaunchTemplate.LatestVersionNumber
+      VPCZoneIdentifier:
+{{- range .Pool.Networks }}
+        - "{{ . }}"
+{{- end }}
+      TerminationPolicies:
+        - 'OldestInstance'
+        - 'Default'
+      MaxSize: {{ .Pool.Size }}
+      MinSize: {{ .Pool.Size }}
+      DesiredCapacity: {{ .Pool.Size }}
+      Tags:
+        - Key: Name
+          Value: "keto-{{ .Pool.ClusterName }}-{{ .Pool.Name }}"
+          PropagateAtLaunch: true
+
+Outputs:
+  {{ .ASGOutputKey }}:
+    Value: !Ref ASG
+{{ template "access-outputs" .Outputs }}`
+	)
+
+	// Make sure networks are always in the same order.
+	networks := append([]string{}, p.Networks...)
+	sort.Strings(networks)
+	p.Networks = networks
+
+	data := struct {
+		Pool                  model.BastionPool
+		AmiID                 string
+		VolumeType            string
+		ClusterInfraStackName string
+		SSHIngress            accessIngress
+		SSHPort               int
+		ASGOutputKey          string
+		Outputs               map[string]string
+	}{
+		Pool:                  p,
+		AmiID:                 amiID,
+		VolumeType:            defaultVolumeType,
+		ClusterInfraStackName: makeClusterInfraStackName(p.ClusterName),
+		SSHIngress:            makeBastionSSHIngress(p, a),
+		SSHPort:               sshPort,
+		ASGOutputKey:          asgOutputKey,
+		Outputs: map[string]string{
+			stackTypeOutputKey:     bastionStackType,
+			clusterNameOutputKey:   p.ClusterName,
+			poolNameOutputKey:      p.Name,
+			coreOSVersionOutputKey: p.CoreOSVersion,
+			machineTypeOutputKey:   p.MachineType,
+			diskSizeOutputKey:      strconv.Itoa(p.DiskSize),
+			sshKeyOutputKey:        p.SSHKey,
+			networksOutputKey:      strings.Join(networks, ","),
+			sizeOutputKey:          strconv.Itoa(p.Size),
+			labelsOutputKey:        util.StringMapToKVs(p.Labels),
+			bastionCIDRsOutputKey:  strings.Join(p.AllowedCIDRs, ","),
+		},
 	}
 
-	t := template.Must(template.New("compute-stack").Parse(computeStackTemplate))
+	t := template.Must(template.New("bastion-stack").Parse(bastionStackTemplate))
+	template.Must(t.Parse(accessTemplate))
 	var b bytes.Buffer
 	if err := t.Execute(&b, data); err != nil {
 		return "", err
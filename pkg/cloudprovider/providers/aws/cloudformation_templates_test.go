@@ -18,8 +18,10 @@ package aws
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
+	"github.com/UKHomeOffice/keto/pkg/constants"
 	"github.com/UKHomeOffice/keto/pkg/model"
 	"github.com/UKHomeOffice/keto/testutil"
 
@@ -52,13 +54,55 @@ func TestRenderClusterInfraStackTemplate(t *testing.T) {
 		},
 	}
 
-	s, err := renderClusterInfraStackTemplate(cluster, vpc, networks)
+	s, err := renderClusterInfraStackTemplate(cluster, vpc, networks, false)
 	if err != nil {
 		t.Error(err)
 	}
 	testutil.CheckTemplate(t, s, vpc)
 }
 
+func TestRenderClusterInfraStackTemplateNetworkProviders(t *testing.T) {
+	subnets := []*ec2.Subnet{
+		{
+			SubnetId:         aws.String("subnet0"),
+			AvailabilityZone: aws.String("az0"),
+		},
+	}
+	networks := getNodesDistributionAcrossNetworks(subnets)
+
+	for _, p := range constants.NetworkProviders {
+		cluster := model.Cluster{
+			ResourceMeta:    model.ResourceMeta{Name: "foo"},
+			NetworkProvider: p,
+			PodCIDR:         constants.DefaultPodCIDR,
+			ServiceCIDR:     constants.DefaultServiceCIDR,
+		}
+		s, err := renderClusterInfraStackTemplate(cluster, vpc, networks, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := parseTemplate(s); err != nil {
+			t.Errorf("%s: invalid template: %v", p, err)
+		}
+
+		testutil.CheckTemplate(t, s, fmt.Sprintf("%s:\n    Value: %q", networkProviderOutputKey, p))
+
+		// CNI traffic to masters is allowed per compute pool.
+		pool := model.ComputePool{NodePool: testutil.MakeNodePool("foo", "compute0")}
+		s, err = renderComputeStackTemplate(pool, ami, "https://foo", "mystack", p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules := networkProviderSGRules[p]
+		if len(rules) == 0 {
+			t.Errorf("no SG rules for %q network provider", p)
+		}
+		for _, r := range rules {
+			testutil.CheckTemplate(t, s, "PoolToMasterPool"+r.Name+"SGIn:")
+		}
+	}
+}
+
 func TestRenderELBStackTemplate(t *testing.T) {
 	c := model.Cluster{
 		MasterPool: model.MasterPool{
@@ -69,13 +113,282 @@ func TestRenderELBStackTemplate(t *testing.T) {
 		},
 	}
 
-	s, err := renderELBStackTemplate(c, vpc)
+	s, err := renderELBStackTemplate(c, vpc, c.MasterPool.Networks)
 	if err != nil {
 		t.Error(err)
 	}
 	testutil.CheckTemplate(t, s, vpc)
 }
 
+func TestRenderELBStackTemplateNetworkLoadBalancer(t *testing.T) {
+	c := model.Cluster{
+		ResourceMeta:     model.ResourceMeta{Name: "foo"},
+		DNSZone:          "example.com",
+		LoadBalancerType: constants.LoadBalancerTypeNetwork,
+	}
+	c.MasterPool.Networks = []string{"subnet-1", "subnet-0"}
+
+	s, err := renderELBStackTemplate(c, vpc, c.MasterPool.Networks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseTemplate(s); err != nil {
+		t.Errorf("invalid template: %v", err)
+	}
+	for _, m := range []string{
+		"Type: AWS::ElasticLoadBalancingV2::LoadBalancer",
+		"load_balancing.cross_zone.enabled",
+		"NLBEIPsubnet0:",
+		"AllocationId: !GetAtt NLBEIPsubnet1.AllocationId",
+		"Type: AWS::ElasticLoadBalancingV2::TargetGroup",
+		"TargetGroupArn: !Ref NLBTargetGroup",
+		"CanonicalHostedZoneID",
+		fmt.Sprintf("%s:\n    Value: %q", loadBalancerTypeOutputKey, constants.LoadBalancerTypeNetwork),
+	} {
+		testutil.CheckTemplate(t, s, m)
+	}
+	if strings.Contains(s, "AWS::ElasticLoadBalancing::LoadBalancer") {
+		t.Error("classic load balancer must not be created")
+	}
+
+	// Internal load balancers use private IPs of master pool subnets.
+	c.Internal = true
+	if s, err = renderELBStackTemplate(c, vpc, c.MasterPool.Networks); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseTemplate(s); err != nil {
+		t.Errorf("invalid template: %v", err)
+	}
+	testutil.CheckTemplate(t, s, "Subnets:\n        - subnet-0\n        - subnet-1")
+	if strings.Contains(s, "AWS::EC2::EIP") {
+		t.Error("internal load balancer must not have EIPs")
+	}
+}
+
+func TestRenderELBStackTemplateAPINames(t *testing.T) {
+	c := model.Cluster{
+		ResourceMeta: model.ResourceMeta{Name: "foo"},
+		DNSZone:      "example.com",
+		APIHostname:  "api.example.com",
+		APIAliases:   []string{"kube.example.com"},
+		APIExtraSANs: []string{"kube.corp.local"},
+	}
+	c.MasterPool.Networks = []string{"subnet0"}
+
+	s, err := renderELBStackTemplate(c, vpc, c.MasterPool.Networks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseTemplate(s); err != nil {
+		t.Errorf("invalid template: %v", err)
+	}
+	for _, m := range []string{
+		"- Name: api.example.com\n",
+		"- Name: kube.example.com\n",
+		fmt.Sprintf("%s:\n    Value: api.example.com", elbDNSOutputKey),
+		fmt.Sprintf("%s:\n    Value: %q", apiAliasesOutputKey, "kube.example.com"),
+		fmt.Sprintf("%s:\n    Value: %q", apiExtraSANsOutputKey, "kube.corp.local"),
+		fmt.Sprintf("%s:\n    Value: %q", dnsZoneOutputKey, "example.com"),
+	} {
+		testutil.CheckTemplate(t, s, m)
+	}
+	if strings.Contains(s, "kube-foo.example.com") {
+		t.Error("default API hostname must not be used")
+	}
+
+	// Records of API hostnames outside of the DNS zone aren't managed.
+	c.APIHostname = "kube.corp.local"
+	c.APIAliases = nil
+	if s, err = renderELBStackTemplate(c, vpc, c.MasterPool.Networks); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(s, "AWS::Route53::RecordSetGroup") {
+		t.Error("DNS records must not be created outside of the DNS zone")
+	}
+	testutil.CheckTemplate(t, s, fmt.Sprintf("%s:\n    Value: kube.corp.local", elbDNSOutputKey))
+}
+
+func TestRenderELBStackTemplateDNSZoneIDs(t *testing.T) {
+	c := model.Cluster{
+		ResourceMeta:    model.ResourceMeta{Name: "foo"},
+		DNSZone:         "example.com",
+		DNSZoneIDs:      []string{"ZPUBLIC", "ZPRIVATE"},
+		SplitHorizonDNS: true,
+	}
+	c.MasterPool.Networks = []string{"subnet0"}
+
+	s, err := renderELBStackTemplate(c, vpc, c.MasterPool.Networks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseTemplate(s); err != nil {
+		t.Errorf("invalid template: %v", err)
+	}
+	for _, m := range []string{
+		"ELBDNS:\n    Type: AWS::Route53::RecordSetGroup\n    Properties:\n      HostedZoneId: ZPUBLIC",
+		"ELBDNS1:\n    Type: AWS::Route53::RecordSetGroup\n    Properties:\n      HostedZoneId: ZPRIVATE",
+		fmt.Sprintf("%s:\n    Value: %q", dnsZoneIDsOutputKey, "ZPUBLIC,ZPRIVATE"),
+		fmt.Sprintf("%s:\n    Value: %q", splitHorizonDNSOutputKey, "true"),
+	} {
+		testutil.CheckTemplate(t, s, m)
+	}
+	if strings.Contains(s, "HostedZoneName:") {
+		t.Error("zones must not be looked up by name")
+	}
+}
+
+func TestRenderNetworkStackTemplate(t *testing.T) {
+	c := model.Cluster{
+		ResourceMeta: model.ResourceMeta{Name: "foo"},
+		NetworkCIDR:  "10.0.0.0/16",
+	}
+
+	s, err := renderNetworkStackTemplate(c, []string{"az0", "az1", "az2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseTemplate(s); err != nil {
+		t.Errorf("invalid template: %v", err)
+	}
+	for _, m := range []string{
+		"CidrBlock: \"10.0.0.0/16\"",
+		"PrivateSubnet0:\n    Type: AWS::EC2::Subnet\n    Properties:\n      VpcId: !Ref VPC\n      AvailabilityZone: az0\n      CidrBlock: \"10.0.0.0/19\"",
+		"PublicSubnet2:\n    Type: AWS::EC2::Subnet\n    Properties:\n      VpcId: !Ref VPC\n      AvailabilityZone: az2\n      CidrBlock: \"10.0.160.0/19\"",
+		"NatGatewayId: !Ref NATGateway2",
+		"- !Ref PrivateSubnet2",
+		fmt.Sprintf("%s:\n    Value: %q", networkZonesOutputKey, "3"),
+		fmt.Sprintf("%s:\n    Value: %q", stackTypeOutputKey, networkStackType),
+	} {
+		testutil.CheckTemplate(t, s, m)
+	}
+
+	c.NetworkCIDR = "10.0.0.0/27"
+	if _, err := renderNetworkStackTemplate(c, []string{"az0", "az1", "az2"}); err == nil {
+		t.Error("expected an error for a network CIDR that is too small")
+	}
+}
+
+func TestRenderBastionStackTemplate(t *testing.T) {
+	p := model.BastionPool{}
+	p.Name = "bastion"
+	p.ClusterName = "foo"
+	p.MachineType = "t3.nano"
+	p.SSHKey = "key"
+	p.DiskSize = 10
+	p.Size = 1
+	p.Networks = []string{"subnet1", "subnet0"}
+	p.AllowedCIDRs = []string{"10.1.0.0/16"}
+	a := model.Access{SSHCIDRs: []string{"10.2.0.0/16"}, SourceSecurityGroups: []string{"sg-123"}}
+
+	s, err := renderBastionStackTemplate(p, a, "ami-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseTemplate(s); err != nil {
+		t.Errorf("invalid template: %v", err)
+	}
+	for _, m := range []string{
+		"VpcId: !ImportValue \"keto-foo-infra-VpcID\"",
+		"CidrIp: \"10.1.0.0/16\"",
+		"SourceSecurityGroupId: \"sg-123\"",
+		"GroupId: !ImportValue \"keto-foo-infra-MasterPoolSG\"",
+		"GroupId: !ImportValue \"keto-foo-infra-ComputePoolSG\"",
+		"AssociatePublicIpAddress: true",
+		"VPCZoneIdentifier:\n        - \"subnet0\"\n        - \"subnet1\"",
+		fmt.Sprintf("%s:\n    Value: !Ref ASG", asgOutputKey),
+		fmt.Sprintf("%s:\n    Value: %q", stackTypeOutputKey, bastionStackType),
+		fmt.Sprintf("%s:\n    Value: \"10.1.0.0/16\"", bastionCIDRsOutputKey),
+	} {
+		testutil.CheckTemplate(t, s, m)
+	}
+	if strings.Contains(s, "10.2.0.0/16") {
+		t.Error("bastion hosts must not follow cluster SSH CIDRs")
+	}
+
+	// Disabled SSH access to nodes keeps bastion access.
+	a = model.Access{DisableSSH: true}
+	if s, err = renderBastionStackTemplate(p, a, "ami-1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []string{
+		"CidrIp: \"10.1.0.0/16\"",
+		"BastionToMasterPoolSSHSGIn:",
+		"BastionToComputePoolSSHSGIn:",
+	} {
+		testutil.CheckTemplate(t, s, m)
+	}
+
+	p.AllowedCIDRs = nil
+	if s, err = renderBastionStackTemplate(p, a, "ami-1"); err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckTemplate(t, s, "SecurityGroupIngress: []")
+}
+
+func TestRenderStackTemplatesAccess(t *testing.T) {
+	subnets := []*ec2.Subnet{
+		{
+			SubnetId:         aws.String("subnet0"),
+			AvailabilityZone: aws.String("az0"),
+		},
+	}
+	c := model.Cluster{
+		ResourceMeta: model.ResourceMeta{Name: "foo"},
+		Access: model.Access{
+			SSHCIDRs:             []string{"10.0.0.0/8"},
+			APICIDRs:             []string{"192.168.0.0/16", "172.16.0.0/12"},
+			SourceSecurityGroups: []string{"sg-123"},
+		},
+	}
+	c.MasterPool.Networks = []string{"subnet0"}
+
+	infra, err := renderClusterInfraStackTemplate(c, vpc, getNodesDistributionAcrossNetworks(subnets), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	elb, err := renderELBStackTemplate(c, vpc, c.MasterPool.Networks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, s := range map[string]string{"infra": infra, "elb": elb} {
+		if _, err := parseTemplate(s); err != nil {
+			t.Errorf("%s: invalid template: %v", name, err)
+		}
+		if strings.Contains(s, "0.0.0.0/0\"\n          FromPort: \"22\"") {
+			t.Errorf("%s: SSH must not be allowed from anywhere", name)
+		}
+	}
+	for _, m := range []string{
+		"CidrIp: \"10.0.0.0/8\"\n          FromPort: \"22\"",
+		"SourceSecurityGroupId: \"sg-123\"\n          FromPort: \"22\"",
+		fmt.Sprintf("%s:\n    Value: %q", apiCIDRsOutputKey, "192.168.0.0/16,172.16.0.0/12"),
+		fmt.Sprintf("%s:\n    Value: %q", disableSSHOutputKey, "false"),
+	} {
+		testutil.CheckTemplate(t, infra, m)
+	}
+	for _, m := range []string{
+		"CidrIp: \"172.16.0.0/12\"\n          FromPort: \"443\"",
+		"SourceSecurityGroupId: \"sg-123\"\n          FromPort: \"443\"",
+		"ComputePoolToELBTrafficSG:",
+	} {
+		testutil.CheckTemplate(t, elb, m)
+	}
+
+	// Security groups without SSH access get an empty list of rules.
+	c.Access = model.Access{DisableSSH: true, SourceSecurityGroups: []string{"sg-123"}}
+	infra, err = renderClusterInfraStackTemplate(c, vpc, getNodesDistributionAcrossNetworks(subnets), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseTemplate(infra); err != nil {
+		t.Errorf("invalid template: %v", err)
+	}
+	testutil.CheckTemplate(t, infra, "SecurityGroupIngress: []")
+	if strings.Contains(infra, "FromPort: \"22\"") {
+		t.Error("SSH must not be allowed when it is disabled")
+	}
+}
+
 func TestRenderMasterStackTemplate(t *testing.T) {
 	nodesPerSubnet := map[string]int{
 		"network0": 2,
@@ -90,13 +403,35 @@ func TestRenderMasterStackTemplate(t *testing.T) {
 		},
 	}
 
-	s, err := renderMasterStackTemplate(pool, ami, "myelb", "assets-bucket", nodesPerSubnet, "https://kube", "mystack")
+	s, err := renderMasterStackTemplate(pool, ami, loadBalancer{ELBName: "myelb"}, "assets-bucket", nodesPerSubnet, "https://kube", "mystack")
 	if err != nil {
 		t.Error(err)
 	}
 	testutil.CheckTemplate(t, s, ami)
 }
 
+func TestRenderMasterStackTemplateTargetGroup(t *testing.T) {
+	pool := model.MasterPool{
+		NodePool: model.NodePool{
+			ResourceMeta: model.ResourceMeta{ClusterName: "foo"},
+			NodePoolSpec: model.NodePoolSpec{Networks: []string{"network0"}},
+		},
+	}
+	lb := loadBalancer{TargetGroupARN: "arn:aws:elasticloadbalancing:eu-west-2:123:targetgroup/foo/123"}
+
+	s, err := renderMasterStackTemplate(pool, ami, lb, "assets-bucket", map[string]int{"network0": 1}, "https://kube", "mystack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseTemplate(s); err != nil {
+		t.Errorf("invalid template: %v", err)
+	}
+	testutil.CheckTemplate(t, s, "TargetGroupARNs:\n        - \""+lb.TargetGroupARN+"\"")
+	if strings.Contains(s, "LoadBalancerNames:") {
+		t.Error("master pool must register via target group only")
+	}
+}
+
 func TestRenderComputeStackTemplate(t *testing.T) {
 	pool := model.ComputePool{
 		NodePool: model.NodePool{
@@ -105,13 +440,214 @@ func TestRenderComputeStackTemplate(t *testing.T) {
 		},
 	}
 
-	s, err := renderComputeStackTemplate(pool, "infra-foo-stack", ami, "mystack")
+	s, err := renderComputeStackTemplate(pool, "infra-foo-stack", ami, "mystack", constants.DefaultNetworkProvider)
 	if err != nil {
 		t.Error(err)
 	}
 	testutil.CheckTemplate(t, s, ami)
 }
 
+func TestRenderComputeStackTemplateInstancesPolicy(t *testing.T) {
+	pool := model.ComputePool{
+		NodePool: model.NodePool{
+			ResourceMeta: model.ResourceMeta{ClusterName: "foo"},
+			NodePoolSpec: model.NodePoolSpec{
+				MachineType: "m5.large",
+				Networks:    []string{"network0"},
+			},
+		},
+		InstancesPolicy: model.InstancesPolicy{
+			PurchaseStrategy:     constants.PurchaseStrategyMixed,
+			MachineTypes:         []string{"m5.large", "m4.large"},
+			OnDemandBaseCapacity: 1,
+			OnDemandPercentage:   20,
+			SpotMaxPrice:         "0.05",
+		},
+	}
+
+	s, err := renderComputeStackTemplate(pool, ami, "https://foo", "mystack", constants.DefaultNetworkProvider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseTemplate(s); err != nil {
+		t.Errorf("invalid template: %v", err)
+	}
+	for _, m := range []string{
+		"Type: AWS::EC2::LaunchTemplate",
+		"- InstanceType: \"m4.large\"",
+		"OnDemandBaseCapacity: 1",
+		"OnDemandPercentageAboveBaseCapacity: 20",
+		"SpotMaxPrice: \"0.05\"",
+		fmt.Sprintf("%s:\n    Value: %q", machineTypesOutputKey, "m4.large"),
+	} {
+		testutil.CheckTemplate(t, s, m)
+	}
+
+	// On-demand pools of a single machine type refer to a launch template
+	// directly.
+	pool.InstancesPolicy = model.InstancesPolicy{PurchaseStrategy: constants.PurchaseStrategyOnDemand}
+	s, err = renderComputeStackTemplate(pool, ami, "https://foo", "mystack", constants.DefaultNetworkProvider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckTemplate(t, s, "LaunchTemplateId: !Ref LaunchTemplate")
+	if strings.Contains(s, "MixedInstancesPolicy") {
+		t.Error("on-demand compute pool must not use a mixed instances policy")
+	}
+}
+
+func TestRenderComputeStackTemplateAutoscaling(t *testing.T) {
+	pool := model.ComputePool{
+		NodePool: model.NodePool{
+			ResourceMeta: model.ResourceMeta{
+				ClusterName: "foo",
+				Labels:      model.Labels{"role": "worker"},
+			},
+			NodePoolSpec: model.NodePoolSpec{
+				MachineType: "m5.large",
+				Networks:    []string{"network0"},
+				Taints:      model.Taints{"dedicated": "gpu:NoSchedule"},
+				Size:        3,
+				MinSize:     1,
+				MaxSize:     10,
+			},
+		},
+	}
+
+	s, err := renderComputeStackTemplate(pool, ami, "https://foo", "mystack", constants.DefaultNetworkProvider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseTemplate(s); err != nil {
+		t.Errorf("invalid template: %v", err)
+	}
+	for _, m := range []string{
+		"MaxSize: 10",
+		"MinSize: 1",
+		"DesiredCapacity: 3",
+		"Key: \"k8s.io/cluster-autoscaler/enabled\"",
+		"Key: \"k8s.io/cluster-autoscaler/foo\"\n          Value: \"owned\"",
+		"Key: \"k8s.io/cluster-autoscaler/node-template/label/role\"\n          Value: \"worker\"",
+		"Key: \"k8s.io/cluster-autoscaler/node-template/taint/dedicated\"\n          Value: \"gpu:NoSchedule\"",
+		fmt.Sprintf("%s:\n    Value: %q", maxSizeOutputKey, "10"),
+	} {
+		testutil.CheckTemplate(t, s, m)
+	}
+
+	// Fixed size pools are not discovered by cluster-autoscaler.
+	pool.MinSize, pool.MaxSize = 0, 0
+	s, err = renderComputeStackTemplate(pool, ami, "https://foo", "mystack", constants.DefaultNetworkProvider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckTemplate(t, s, "MaxSize: 3")
+	if strings.Contains(s, "k8s.io/cluster-autoscaler") {
+		t.Error("fixed size compute pool must not have cluster-autoscaler tags")
+	}
+}
+
+func TestRenderComputeStackTemplatePoolIsolation(t *testing.T) {
+	pool := model.ComputePool{
+		NodePool:       testutil.MakeNodePool("foo", "compute0"),
+		AllowFromPools: []string{"compute-1"},
+	}
+
+	s, err := renderComputeStackTemplate(pool, ami, "https://foo", "mystack", constants.DefaultNetworkProvider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseTemplate(s); err != nil {
+		t.Errorf("invalid template: %v", err)
+	}
+	for _, m := range []string{
+		"PoolSG:\n    Type: \"AWS::EC2::SecurityGroup\"",
+		"SourceSecurityGroupId: !Ref PoolSG",
+		"- !Ref PoolSG",
+		"MasterPoolToPoolSGIn:",
+		"PoolToMasterPoolAPISGIn:",
+		"AllowFromcompute1SGIn:",
+		fmt.Sprintf("SourceSecurityGroupId: !ImportValue %q", makeComputePoolStackName("foo", "compute-1", "")+"-PoolSG"),
+		fmt.Sprintf("%s:\n    Value: %q", allowFromPoolsOutputKey, "compute-1"),
+	} {
+		testutil.CheckTemplate(t, s, m)
+	}
+
+	subnets := []*ec2.Subnet{{SubnetId: aws.String("subnet0"), AvailabilityZone: aws.String("az0")}}
+	cluster := model.Cluster{ResourceMeta: model.ResourceMeta{Name: "foo"}, NetworkProvider: constants.NetworkProviderCanal}
+	infra, err := renderClusterInfraStackTemplate(cluster, vpc, getNodesDistributionAcrossNetworks(subnets), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(infra, "SourceSecurityGroupId: !Ref ComputePoolSG") {
+		t.Error("compute pools must not share traffic rules via the infra stack")
+	}
+
+	// Shared rules are kept while pools without SGs of their own exist.
+	if infra, err = renderClusterInfraStackTemplate(cluster, vpc, getNodesDistributionAcrossNetworks(subnets), true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseTemplate(infra); err != nil {
+		t.Errorf("invalid template: %v", err)
+	}
+	for _, m := range []string{
+		"MasterPoolComputeAPISGIn:",
+		"ComputePoolAllTrafficSGIn:",
+		"MasterPoolToComputePoolSG:",
+		"ComputePoolToMasterPoolVXLANSGIn:",
+	} {
+		testutil.CheckTemplate(t, infra, m)
+	}
+}
+
+func TestRenderStackTemplatesLaunchTemplate(t *testing.T) {
+	np := model.NodePool{
+		ResourceMeta: model.ResourceMeta{ClusterName: "foo"},
+		NodePoolSpec: model.NodePoolSpec{
+			MachineType:         "t3.medium",
+			SSHKey:              "mykey",
+			Networks:            []string{"network0"},
+			VolumeType:          "gp3",
+			VolumeIOPS:          4000,
+			VolumeThroughput:    250,
+			IMDSTokens:          imdsTokensRequired,
+			IMDSHopLimit:        2,
+			CreditSpecification: "unlimited",
+		},
+	}
+
+	master, err := renderMasterStackTemplate(model.MasterPool{NodePool: np}, ami, loadBalancer{ELBName: "elb"}, "bucket",
+		map[string]int{"network0": 1}, "https://foo", "mystack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	compute, err := renderComputeStackTemplate(model.ComputePool{NodePool: np}, ami, "https://foo", "mystack", constants.DefaultNetworkProvider)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, s := range map[string]string{"master": master, "compute": compute} {
+		if _, err := parseTemplate(s); err != nil {
+			t.Errorf("%s: invalid template: %v", name, err)
+		}
+		if strings.Contains(s, "LaunchConfiguration") {
+			t.Errorf("%s: launch configurations must not be used", name)
+		}
+		for _, m := range []string{
+			"Type: AWS::EC2::LaunchTemplate",
+			"HttpTokens: \"required\"",
+			"HttpPutResponseHopLimit: 2",
+			"CpuCredits: \"unlimited\"",
+			"VolumeType: \"gp3\"",
+			"Iops: 4000",
+			"Throughput: 250",
+			fmt.Sprintf("%s:\n    Value: %q", sshKeyOutputKey, "mykey"),
+			fmt.Sprintf("%s:\n    Value: %q", networksOutputKey, "network0"),
+		} {
+			testutil.CheckTemplate(t, s, m)
+		}
+	}
+}
+
 func TestGetNodesDistribution(t *testing.T) {
 	cases := [][]*ec2.Subnet{
 		[]*ec2.Subnet{
@@ -0,0 +1,189 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/UKHomeOffice/keto/pkg/model"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// getHostedZoneIDs returns IDs of hosted zones that kube API records of a
+// cluster are created in. Given zone IDs must belong to zones named after the
+// cluster DNS zone. Otherwise, a public zone, a private zone or both are
+// looked up by name. Private zones must be associated with the cluster VPC.
+func (c *Cloud) getHostedZoneIDs(cluster model.Cluster, vpcID string) ([]string, error) {
+	if cluster.DNSZone == "" {
+		return nil, nil
+	}
+	zones, err := c.listHostedZonesByName(cluster.DNSZone)
+	if err != nil {
+		return nil, err
+	}
+	if len(zones) == 0 {
+		return nil, fmt.Errorf("dns zone %q does not exist", cluster.DNSZone)
+	}
+
+	if len(cluster.DNSZoneIDs) > 0 {
+		ids := []string{}
+		for _, id := range cluster.DNSZoneIDs {
+			z := findHostedZone(zones, id)
+			if z == nil {
+				return nil, fmt.Errorf("dns zone %q with ID %q does not exist", cluster.DNSZone, id)
+			}
+			if isPrivateHostedZone(z) {
+				associated, err := c.hostedZoneAssociated(z, vpcID)
+				if err != nil {
+					return nil, err
+				}
+				if !associated {
+					return nil, fmt.Errorf("private dns zone %q is not associated with VPC %q", id, vpcID)
+				}
+			}
+			ids = append(ids, trimHostedZoneID(aws.StringValue(z.Id)))
+		}
+		return ids, nil
+	}
+
+	public := []*route53.HostedZone{}
+	private := []*route53.HostedZone{}
+	for _, z := range zones {
+		if !isPrivateHostedZone(z) {
+			public = append(public, z)
+			continue
+		}
+		associated, err := c.hostedZoneAssociated(z, vpcID)
+		if err != nil {
+			return nil, err
+		}
+		if associated {
+			private = append(private, z)
+		}
+	}
+
+	selected := []*route53.HostedZone{}
+	if !cluster.PrivateDNSZone || cluster.SplitHorizonDNS {
+		z, err := selectHostedZone(public, "public", cluster.DNSZone)
+		if err != nil {
+			return nil, err
+		}
+		selected = append(selected, z)
+	}
+	if cluster.PrivateDNSZone || cluster.SplitHorizonDNS {
+		z, err := selectHostedZone(private, "private", cluster.DNSZone)
+		if err != nil {
+			return nil, err
+		}
+		selected = append(selected, z)
+	}
+	if cluster.Internal && !cluster.PrivateDNSZone && !cluster.SplitHorizonDNS {
+		c.Logger.Printf("internal cluster %q kube API records are created in public dns zone %q", cluster.Name, cluster.DNSZone)
+	}
+
+	ids := []string{}
+	for _, z := range selected {
+		ids = append(ids, trimHostedZoneID(aws.StringValue(z.Id)))
+	}
+	return ids, nil
+}
+
+// listHostedZonesByName returns all public and private hosted zones with a
+// given name.
+func (c *Cloud) listHostedZonesByName(name string) ([]*route53.HostedZone, error) {
+	zones := []*route53.HostedZone{}
+	params := &route53.ListHostedZonesByNameInput{
+		DNSName: aws.String(name),
+	}
+	for {
+		r, err := c.r53.ListHostedZonesByName(params)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list route53 dns zones: %v", err)
+		}
+		// Zones are sorted by name, starting with the given one.
+		for _, z := range r.HostedZones {
+			if !sameDNSName(aws.StringValue(z.Name), name) {
+				return zones, nil
+			}
+			zones = append(zones, z)
+		}
+		if !aws.BoolValue(r.IsTruncated) {
+			return zones, nil
+		}
+		params.DNSName = r.NextDNSName
+		params.HostedZoneId = r.NextHostedZoneId
+	}
+}
+
+// hostedZoneAssociated checks whether a private hosted zone is associated
+// with a given VPC.
+func (c *Cloud) hostedZoneAssociated(z *route53.HostedZone, vpcID string) (bool, error) {
+	r, err := c.r53.GetHostedZone(&route53.GetHostedZoneInput{Id: z.Id})
+	if err != nil {
+		return false, fmt.Errorf("failed to get route53 dns zone %q: %v", aws.StringValue(z.Id), err)
+	}
+	for _, v := range r.VPCs {
+		if aws.StringValue(v.VPCId) == vpcID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// selectHostedZone returns the only zone of a given kind or an error if
+// there are none or several of them.
+func selectHostedZone(zones []*route53.HostedZone, kind, name string) (*route53.HostedZone, error) {
+	switch len(zones) {
+	case 0:
+		return nil, fmt.Errorf("%s dns zone %q does not exist", kind, name)
+	case 1:
+		return zones[0], nil
+	}
+	ids := []string{}
+	for _, z := range zones {
+		ids = append(ids, trimHostedZoneID(aws.StringValue(z.Id)))
+	}
+	return nil, fmt.Errorf("there are several %s dns zones %q, select one by ID: %s", kind, name, strings.Join(ids, ", "))
+}
+
+// findHostedZone returns a hosted zone by ID or nil if it is not found.
+func findHostedZone(zones []*route53.HostedZone, id string) *route53.HostedZone {
+	for _, z := range zones {
+		if trimHostedZoneID(aws.StringValue(z.Id)) == trimHostedZoneID(id) {
+			return z
+		}
+	}
+	return nil
+}
+
+func isPrivateHostedZone(z *route53.HostedZone) bool {
+	return z.Config != nil && aws.BoolValue(z.Config.PrivateZone)
+}
+
+// trimHostedZoneID strips a resource path from a hosted zone ID, e.g.
+// /hostedzone/Z123 becomes Z123.
+func trimHostedZoneID(id string) string {
+	return strings.TrimPrefix(id, "/hostedzone/")
+}
+
+// sameDNSName compares DNS names regardless of their case and trailing dots.
+func sameDNSName(a, b string) bool {
+	return strings.EqualFold(strings.TrimSuffix(a, "."), strings.TrimSuffix(b, "."))
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/UKHomeOffice/keto/pkg/cloudprovider/providers/aws/mocks"
+	"github.com/UKHomeOffice/keto/pkg/model"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+func TestGetHostedZoneIDs(t *testing.T) {
+	mockR53 := &mocks.Route53API{}
+	c := &Cloud{
+		Logger: makeLogger(),
+		r53:    mockR53,
+	}
+
+	privateZone := &route53.HostedZoneConfig{PrivateZone: aws.Bool(true)}
+	mockR53.On("ListHostedZonesByName", &route53.ListHostedZonesByNameInput{
+		DNSName: aws.String("example.com"),
+	}).Return(&route53.ListHostedZonesByNameOutput{
+		HostedZones: []*route53.HostedZone{
+			{Id: aws.String("/hostedzone/ZPUBLIC"), Name: aws.String("example.com.")},
+			{Id: aws.String("/hostedzone/ZPRIVATE"), Name: aws.String("example.com."), Config: privateZone},
+			{Id: aws.String("/hostedzone/ZOTHER"), Name: aws.String("example.com."), Config: privateZone},
+			{Id: aws.String("/hostedzone/ZSUB"), Name: aws.String("sub.example.com.")},
+		},
+	}, nil)
+	mockR53.On("GetHostedZone", &route53.GetHostedZoneInput{Id: aws.String("/hostedzone/ZPRIVATE")}).Return(
+		&route53.GetHostedZoneOutput{VPCs: []*route53.VPC{{VPCId: aws.String("vpc0")}}}, nil)
+	mockR53.On("GetHostedZone", &route53.GetHostedZoneInput{Id: aws.String("/hostedzone/ZOTHER")}).Return(
+		&route53.GetHostedZoneOutput{VPCs: []*route53.VPC{{VPCId: aws.String("vpc1")}}}, nil)
+
+	testCases := []struct {
+		name    string
+		cluster model.Cluster
+		want    []string
+		wantErr bool
+	}{
+		{"public", model.Cluster{DNSZone: "example.com"}, []string{"ZPUBLIC"}, false},
+		{"private", model.Cluster{DNSZone: "example.com", PrivateDNSZone: true}, []string{"ZPRIVATE"}, false},
+		{"split horizon", model.Cluster{DNSZone: "example.com", SplitHorizonDNS: true}, []string{"ZPUBLIC", "ZPRIVATE"}, false},
+		{"zone IDs", model.Cluster{DNSZone: "example.com", DNSZoneIDs: []string{"/hostedzone/ZPRIVATE"}}, []string{"ZPRIVATE"}, false},
+		{"zone of another VPC", model.Cluster{DNSZone: "example.com", DNSZoneIDs: []string{"ZOTHER"}}, nil, true},
+		{"zone of another name", model.Cluster{DNSZone: "example.com", DNSZoneIDs: []string{"ZSUB"}}, nil, true},
+		{"no DNS zone", model.Cluster{}, nil, false},
+	}
+	for _, tc := range testCases {
+		got, err := c.getHostedZoneIDs(tc.cluster, "vpc0")
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: got error %v; want error %t", tc.name, err, tc.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v; want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestGetHostedZoneIDsAmbiguous(t *testing.T) {
+	mockR53 := &mocks.Route53API{}
+	c := &Cloud{
+		Logger: makeLogger(),
+		r53:    mockR53,
+	}
+
+	mockR53.On("ListHostedZonesByName", &route53.ListHostedZonesByNameInput{
+		DNSName: aws.String("example.com"),
+	}).Return(&route53.ListHostedZonesByNameOutput{
+		HostedZones: []*route53.HostedZone{
+			{Id: aws.String("/hostedzone/Z1"), Name: aws.String("example.com.")},
+		},
+		IsTruncated:      aws.Bool(true),
+		NextDNSName:      aws.String("example.com."),
+		NextHostedZoneId: aws.String("Z2"),
+	}, nil).Once()
+	mockR53.On("ListHostedZonesByName", &route53.ListHostedZonesByNameInput{
+		DNSName:      aws.String("example.com."),
+		HostedZoneId: aws.String("Z2"),
+	}).Return(&route53.ListHostedZonesByNameOutput{
+		HostedZones: []*route53.HostedZone{
+			{Id: aws.String("/hostedzone/Z2"), Name: aws.String("example.com.")},
+		},
+	}, nil).Once()
+
+	if _, err := c.getHostedZoneIDs(model.Cluster{DNSZone: "example.com"}, "vpc0"); err == nil {
+		t.Error("expected an error for several public zones")
+	}
+
+	mockR53.AssertExpectations(t)
+}
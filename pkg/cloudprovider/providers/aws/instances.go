@@ -0,0 +1,234 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/UKHomeOffice/keto/pkg/model"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const asgResourceType = "AWS::AutoScaling::AutoScalingGroup"
+
+// GetPoolInstances returns instances of master and compute pool ASGs sorted
+// by cluster, pool and instance ID. Master instances include their persistent
+// ENI and NodeID.
+func (c *Cloud) GetPoolInstances(clusterName, poolName string) ([]*model.Instance, error) {
+	instances := []*model.Instance{}
+
+	for _, t := range []string{masterPoolStackType, computePoolStackType} {
+		stacks, err := c.getStacksByType(t)
+		if err != nil {
+			return instances, err
+		}
+		for _, s := range stacks {
+			cluster := getStackOutputValue(s, clusterNameOutputKey)
+			pool := getStackOutputValue(s, poolNameOutputKey)
+			if (clusterName != "" && cluster != clusterName) || (poolName != "" && pool != poolName) {
+				continue
+			}
+
+			stackInstances, err := c.getStackInstances(*s.StackName)
+			if err != nil {
+				return instances, err
+			}
+			internal, _ := strconv.ParseBool(getStackOutputValue(s, internalClusterOutputKey))
+			for _, i := range stackInstances {
+				i.ClusterName = cluster
+				i.PoolName = pool
+				i.Internal = internal
+			}
+			if t == masterPoolStackType {
+				if err := c.setMasterInstancesENIs(cluster, stackInstances); err != nil {
+					return instances, err
+				}
+			}
+			instances = append(instances, stackInstances...)
+		}
+	}
+
+	sort.Slice(instances, func(i, j int) bool {
+		a, b := instances[i], instances[j]
+		if a.ClusterName != b.ClusterName {
+			return a.ClusterName < b.ClusterName
+		}
+		if a.PoolName != b.PoolName {
+			return a.PoolName < b.PoolName
+		}
+		return a.ID < b.ID
+	})
+	return instances, nil
+}
+
+// getStackInstances returns instances of all ASGs of a given stack.
+func (c *Cloud) getStackInstances(stackName string) ([]*model.Instance, error) {
+	instances := []*model.Instance{}
+
+	groups, err := c.getStackASGs(stackName)
+	if err != nil {
+		return instances, err
+	}
+	for _, g := range groups {
+		for _, i := range g.Instances {
+			instances = append(instances, &model.Instance{
+				ID:             aws.StringValue(i.InstanceId),
+				Zone:           aws.StringValue(i.AvailabilityZone),
+				LifecycleState: aws.StringValue(i.LifecycleState),
+				HealthStatus:   aws.StringValue(i.HealthStatus),
+			})
+		}
+	}
+
+	if err := c.setInstancesDetails(instances); err != nil {
+		return instances, err
+	}
+	return instances, nil
+}
+
+// getStackASGs returns descriptions of all ASGs of a given stack.
+func (c *Cloud) getStackASGs(stackName string) ([]*autoscaling.Group, error) {
+	groups := []*autoscaling.Group{}
+
+	resources, err := c.getStackResources(stackName)
+	if err != nil {
+		return groups, err
+	}
+	names := []*string{}
+	for _, r := range resources {
+		if aws.StringValue(r.ResourceType) == asgResourceType && r.PhysicalResourceId != nil {
+			names = append(names, r.PhysicalResourceId)
+		}
+	}
+	if len(names) == 0 {
+		return groups, nil
+	}
+
+	params := &autoscaling.DescribeAutoScalingGroupsInput{AutoScalingGroupNames: names}
+	for {
+		resp, err := c.asg.DescribeAutoScalingGroups(params)
+		if err != nil {
+			return groups, fmt.Errorf("failed to describe stack %q autoscaling groups: %v", stackName, err)
+		}
+		groups = append(groups, resp.AutoScalingGroups...)
+		if resp.NextToken == nil {
+			return groups, nil
+		}
+		params.NextToken = resp.NextToken
+	}
+}
+
+// setInstancesDetails sets IPs, AMI and launch time of instances from their
+// EC2 descriptions. Instances that are already terminated are left as they
+// are.
+func (c *Cloud) setInstancesDetails(instances []*model.Instance) error {
+	if len(instances) == 0 {
+		return nil
+	}
+	byID := make(map[string]*model.Instance)
+	ids := []*string{}
+	for _, i := range instances {
+		byID[i.ID] = i
+		ids = append(ids, aws.String(i.ID))
+	}
+
+	params := &ec2.DescribeInstancesInput{InstanceIds: ids}
+	for {
+		resp, err := c.ec2.DescribeInstances(params)
+		if err != nil {
+			return fmt.Errorf("failed to describe instances: %v", err)
+		}
+		for _, r := range resp.Reservations {
+			for _, d := range r.Instances {
+				i, ok := byID[aws.StringValue(d.InstanceId)]
+				if !ok {
+					continue
+				}
+				i.PrivateIP = aws.StringValue(d.PrivateIpAddress)
+				i.PublicIP = aws.StringValue(d.PublicIpAddress)
+				i.ImageID = aws.StringValue(d.ImageId)
+				if d.LaunchTime != nil {
+					i.Launched = d.LaunchTime.Unix()
+				}
+			}
+		}
+		if resp.NextToken == nil {
+			return nil
+		}
+		params.NextToken = resp.NextToken
+	}
+}
+
+// setMasterInstancesENIs sets persistent ENIs and NodeIDs of master
+// instances that have them attached.
+func (c *Cloud) setMasterInstancesENIs(clusterName string, instances []*model.Instance) error {
+	enis, err := c.describePersistentENIs(clusterName)
+	if err != nil {
+		return err
+	}
+	attached := make(map[string]*ec2.NetworkInterface)
+	for _, n := range enis {
+		if n.Attachment != nil && n.Attachment.InstanceId != nil {
+			attached[*n.Attachment.InstanceId] = n
+		}
+	}
+	for _, i := range instances {
+		if n, ok := attached[i.ID]; ok {
+			i.NetworkInterface = aws.StringValue(n.NetworkInterfaceId)
+			i.NodeID = getENINodeID(n)
+		}
+	}
+	return nil
+}
+
+// GetInstanceConsoleOutput returns decoded console output of an instance.
+// EC2 keeps only the most recent output, which may be empty shortly after an
+// instance is launched.
+func (c *Cloud) GetInstanceConsoleOutput(instanceID string) (string, error) {
+	resp, err := c.ec2.GetConsoleOutput(&ec2.GetConsoleOutputInput{
+		InstanceId: aws.String(instanceID),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get instance %q console output: %v", instanceID, err)
+	}
+	if resp.Output == nil {
+		return "", nil
+	}
+	output, err := base64.StdEncoding.DecodeString(*resp.Output)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode instance %q console output: %v", instanceID, err)
+	}
+	return string(output), nil
+}
+
+// getStackOutputValue returns a value of a stack output or an empty string
+// if the stack has no such output.
+func getStackOutputValue(s *cloudformation.Stack, key string) string {
+	for _, o := range s.Outputs {
+		if aws.StringValue(o.OutputKey) == key {
+			return aws.StringValue(o.OutputValue)
+		}
+	}
+	return ""
+}
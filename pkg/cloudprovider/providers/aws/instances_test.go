@@ -0,0 +1,182 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"encoding/base64"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/UKHomeOffice/keto/pkg/cloudprovider/providers/aws/mocks"
+	"github.com/UKHomeOffice/keto/pkg/model"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/mock"
+)
+
+func makeTestPoolStack(name, stackType, clusterName, poolName string, internal bool) *cloudformation.Stack {
+	return &cloudformation.Stack{
+		StackName: aws.String(name),
+		Tags: []*cloudformation.Tag{
+			{Key: aws.String(managedByKetoTagKey), Value: aws.String(managedByKetoTagValue)},
+		},
+		Outputs: []*cloudformation.Output{
+			{OutputKey: aws.String(stackTypeOutputKey), OutputValue: aws.String(stackType)},
+			{OutputKey: aws.String(clusterNameOutputKey), OutputValue: aws.String(clusterName)},
+			{OutputKey: aws.String(poolNameOutputKey), OutputValue: aws.String(poolName)},
+			{OutputKey: aws.String(internalClusterOutputKey), OutputValue: aws.String(strconv.FormatBool(internal))},
+		},
+	}
+}
+
+func TestGetPoolInstances(t *testing.T) {
+	mockCF := &mocks.CloudFormationAPI{}
+	mockEC2 := &mocks.EC2API{}
+	mockASG := &mocks.AutoScalingAPI{}
+	c := &Cloud{
+		Logger: makeLogger(),
+		cf:     mockCF,
+		ec2:    mockEC2,
+		asg:    mockASG,
+	}
+
+	mockCF.On("DescribeStacks", &cloudformation.DescribeStacksInput{}).Return(
+		&cloudformation.DescribeStacksOutput{
+			Stacks: []*cloudformation.Stack{
+				makeTestPoolStack("keto-foo-compute0-blue", computePoolStackType, "foo", "compute0", false),
+				makeTestPoolStack("keto-foo-master-blue", masterPoolStackType, "foo", "master", true),
+				makeTestPoolStack("keto-bar-master-blue", masterPoolStackType, "bar", "master", true),
+			},
+		}, nil)
+	for stack, asg := range map[string]string{"keto-foo-master-blue": "master-asg", "keto-foo-compute0-blue": "compute0-asg"} {
+		mockCF.On("DescribeStackResources", &cloudformation.DescribeStackResourcesInput{
+			StackName: aws.String(stack),
+		}).Return(&cloudformation.DescribeStackResourcesOutput{
+			StackResources: []*cloudformation.StackResource{
+				{ResourceType: aws.String("AWS::EC2::LaunchTemplate"), PhysicalResourceId: aws.String("lt")},
+				{ResourceType: aws.String(asgResourceType), PhysicalResourceId: aws.String(asg)},
+			},
+		}, nil).Once()
+	}
+	mockASG.On("DescribeAutoScalingGroups", &autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: aws.StringSlice([]string{"master-asg"}),
+	}).Return(&autoscaling.DescribeAutoScalingGroupsOutput{
+		AutoScalingGroups: []*autoscaling.Group{{
+			Instances: []*autoscaling.Instance{
+				{InstanceId: aws.String("i-m1"), AvailabilityZone: aws.String("az1"), LifecycleState: aws.String("InService"), HealthStatus: aws.String("Healthy")},
+				{InstanceId: aws.String("i-m0"), AvailabilityZone: aws.String("az0"), LifecycleState: aws.String("InService"), HealthStatus: aws.String("Healthy")},
+			},
+		}},
+	}, nil).Once()
+	mockASG.On("DescribeAutoScalingGroups", &autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: aws.StringSlice([]string{"compute0-asg"}),
+	}).Return(&autoscaling.DescribeAutoScalingGroupsOutput{
+		AutoScalingGroups: []*autoscaling.Group{{
+			Instances: []*autoscaling.Instance{
+				{InstanceId: aws.String("i-c0"), AvailabilityZone: aws.String("az0"), LifecycleState: aws.String("Pending"), HealthStatus: aws.String("Healthy")},
+			},
+		}},
+	}, nil).Once()
+
+	launched := time.Unix(1500000000, 0)
+	mockEC2.On("DescribeInstances", mock.AnythingOfType("*ec2.DescribeInstancesInput")).Return(
+		&ec2.DescribeInstancesOutput{
+			Reservations: []*ec2.Reservation{{
+				Instances: []*ec2.Instance{
+					{InstanceId: aws.String("i-m0"), PrivateIpAddress: aws.String("10.0.0.10"), ImageId: aws.String("ami-1"), LaunchTime: &launched},
+					{InstanceId: aws.String("i-m1"), PrivateIpAddress: aws.String("10.0.1.10"), ImageId: aws.String("ami-1"), LaunchTime: &launched},
+					{InstanceId: aws.String("i-c0"), PrivateIpAddress: aws.String("10.0.0.20"), PublicIpAddress: aws.String("1.2.3.4"), ImageId: aws.String("ami-1")},
+				},
+			}},
+		}, nil)
+	mockEC2.On("DescribeNetworkInterfaces", mock.AnythingOfType("*ec2.DescribeNetworkInterfacesInput")).Return(
+		&ec2.DescribeNetworkInterfacesOutput{
+			NetworkInterfaces: []*ec2.NetworkInterface{
+				{
+					NetworkInterfaceId: aws.String("eni-0"),
+					Attachment:         &ec2.NetworkInterfaceAttachment{InstanceId: aws.String("i-m0")},
+					TagSet:             []*ec2.Tag{{Key: aws.String("NodeID"), Value: aws.String("0")}},
+				},
+				{
+					NetworkInterfaceId: aws.String("eni-1"),
+					TagSet:             []*ec2.Tag{{Key: aws.String("NodeID"), Value: aws.String("1")}},
+				},
+			},
+		}, nil).Once()
+
+	instances, err := c.GetPoolInstances("foo", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []model.Instance{
+		{ID: "i-c0", ClusterName: "foo", PoolName: "compute0", Zone: "az0", PrivateIP: "10.0.0.20", PublicIP: "1.2.3.4",
+			ImageID: "ami-1", LifecycleState: "Pending", HealthStatus: "Healthy"},
+		{ID: "i-m0", ClusterName: "foo", PoolName: "master", Zone: "az0", PrivateIP: "10.0.0.10", ImageID: "ami-1", Internal: true,
+			Launched: launched.Unix(), LifecycleState: "InService", HealthStatus: "Healthy", NetworkInterface: "eni-0", NodeID: "0"},
+		{ID: "i-m1", ClusterName: "foo", PoolName: "master", Zone: "az1", PrivateIP: "10.0.1.10", ImageID: "ami-1", Internal: true,
+			Launched: launched.Unix(), LifecycleState: "InService", HealthStatus: "Healthy"},
+	}
+	if len(instances) != len(want) {
+		t.Fatalf("got %d instances; want %d", len(instances), len(want))
+	}
+	for i := range want {
+		if *instances[i] != want[i] {
+			t.Errorf("got instance %+v; want %+v", *instances[i], want[i])
+		}
+	}
+
+	mockCF.AssertExpectations(t)
+	mockASG.AssertExpectations(t)
+}
+
+func TestGetInstanceConsoleOutput(t *testing.T) {
+	mockEC2 := &mocks.EC2API{}
+	c := &Cloud{
+		Logger: makeLogger(),
+		ec2:    mockEC2,
+	}
+
+	mockEC2.On("GetConsoleOutput", &ec2.GetConsoleOutputInput{InstanceId: aws.String("i-0")}).Return(
+		&ec2.GetConsoleOutputOutput{
+			InstanceId: aws.String("i-0"),
+			Output:     aws.String(base64.StdEncoding.EncodeToString([]byte("boot log\n"))),
+		}, nil).Once()
+	mockEC2.On("GetConsoleOutput", &ec2.GetConsoleOutputInput{InstanceId: aws.String("i-1")}).Return(
+		&ec2.GetConsoleOutputOutput{InstanceId: aws.String("i-1")}, nil).Once()
+
+	output, err := c.GetInstanceConsoleOutput("i-0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "boot log\n" {
+		t.Errorf("got output %q; want %q", output, "boot log\n")
+	}
+
+	output, err = c.GetInstanceConsoleOutput("i-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "" {
+		t.Errorf("got output %q; want empty output", output)
+	}
+
+	mockEC2.AssertExpectations(t)
+}
@@ -0,0 +1,257 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/UKHomeOffice/keto/pkg/model"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+)
+
+const (
+	defaultVolumeType  = "gp2"
+	imdsTokensOptional = "optional"
+	imdsTokensRequired = "required"
+	maxIMDSHopLimit    = 64
+
+	// launchConfigurationResourceType is a resource type of launch
+	// configurations, which pool stacks used before launch templates.
+	launchConfigurationResourceType = "AWS::AutoScaling::LaunchConfiguration"
+
+	// launchTemplateTemplate is a node pool launch template resource shared by
+	// master and compute pool stack templates.
+	launchTemplateTemplate = `{{ define "launch-template" }}
+  LaunchTemplate:
+    Type: AWS::EC2::LaunchTemplate
+    Properties:
+      LaunchTemplateData:
+        IamInstanceProfile:
+          Arn: !GetAtt InstanceProfile.Arn
+        ImageId: "{{ .AmiID }}"
+        InstanceType: "{{ .Pool.MachineType }}"
+{{- if .Pool.SSHKey }}
+        KeyName: "{{ .Pool.SSHKey }}"
+{{- end }}
+        Monitoring:
+          Enabled: false
+        MetadataOptions:
+          HttpEndpoint: "enabled"
+          HttpTokens: "{{ .IMDSTokens }}"
+{{- if .Pool.IMDSHopLimit }}
+          HttpPutResponseHopLimit: {{ .Pool.IMDSHopLimit }}
+{{- end }}
+{{- if .Pool.CreditSpecification }}
+        CreditSpecification:
+          CpuCredits: "{{ .Pool.CreditSpecification }}"
+{{- end }}
+        NetworkInterfaces:
+          - DeviceIndex: 0
+            AssociatePublicIpAddress: {{ if .Pool.Internal }}false{{ else }}true{{ end }}
+            Groups:
+              - !ImportValue "{{ .SecurityGroup }}"
+{{- if .PoolSecurityGroup }}
+              - !Ref PoolSG
+{{- end }}
+        BlockDeviceMappings:
+          - DeviceName: "/dev/xvda"
+            Ebs:
+              VolumeSize: "{{ .Pool.DiskSize }}"
+              DeleteOnTermination: true
+              VolumeType: "{{ .VolumeType }}"
+{{- if .Pool.VolumeIOPS }}
+              Iops: {{ .Pool.VolumeIOPS }}
+{{- end }}
+{{- if .Pool.VolumeThroughput }}
+              Throughput: {{ .Pool.VolumeThroughput }}
+{{- end }}
+        UserData: {{ .UserData }}
+{{- end }}
+{{- define "launch-template-outputs" }}
+{{- range $key, $value := .Outputs }}
+  {{ $key }}:
+    Value: "{{ $value }}"
+{{ end }}
+{{- end }}`
+)
+
+// volumeTypes is a map of supported EBS volume types to whether they accept
+// provisioned IOPS and throughput.
+var volumeTypes = map[string]struct{ iops, throughput bool }{
+	"gp2":      {},
+	"gp3":      {iops: true, throughput: true},
+	"io1":      {iops: true},
+	"io2":      {iops: true},
+	"st1":      {},
+	"sc1":      {},
+	"standard": {},
+}
+
+// launchTemplate is a node pool launch template data.
+type launchTemplate struct {
+	Pool          model.NodePool
+	AmiID         string
+	UserData      string
+	SecurityGroup string
+	// PoolSecurityGroup is true if a pool stack has its own security group,
+	// which is attached in addition to SecurityGroup.
+	PoolSecurityGroup bool
+	VolumeType        string
+	IMDSTokens        string
+	// Outputs are stack outputs of node pool spec values that are not
+	// otherwise persisted, so pools can be upgraded later.
+	Outputs map[string]string
+}
+
+// makeLaunchTemplate returns a launch template data of a node pool, unset
+// values are defaulted.
+func makeLaunchTemplate(p model.NodePool, amiID, securityGroup string) launchTemplate {
+	t := launchTemplate{
+		Pool:          p,
+		AmiID:         amiID,
+		UserData:      base64.StdEncoding.EncodeToString(p.UserData),
+		SecurityGroup: securityGroup,
+		VolumeType:    p.VolumeType,
+		IMDSTokens:    p.IMDSTokens,
+	}
+	if t.VolumeType == "" {
+		t.VolumeType = defaultVolumeType
+	}
+	if t.IMDSTokens == "" {
+		t.IMDSTokens = imdsTokensOptional
+	}
+	t.Outputs = map[string]string{
+		sshKeyOutputKey:              p.SSHKey,
+		networksOutputKey:            strings.Join(p.Networks, ","),
+		volumeTypeOutputKey:          t.VolumeType,
+		volumeIOPSOutputKey:          strconv.Itoa(p.VolumeIOPS),
+		volumeThroughputOutputKey:    strconv.Itoa(p.VolumeThroughput),
+		imdsTokensOutputKey:          t.IMDSTokens,
+		imdsHopLimitOutputKey:        strconv.Itoa(p.IMDSHopLimit),
+		creditSpecificationOutputKey: p.CreditSpecification,
+		userDataFormatOutputKey:      p.UserDataFormat,
+	}
+	return t
+}
+
+// validateLaunchTemplate checks whether launch template options of a node
+// pool are supported.
+func validateLaunchTemplate(p model.NodePoolSpec) error {
+	volumeType := p.VolumeType
+	if volumeType == "" {
+		volumeType = defaultVolumeType
+	}
+	v, ok := volumeTypes[volumeType]
+	if !ok {
+		return fmt.Errorf("unsupported volume type %q", p.VolumeType)
+	}
+	if p.VolumeIOPS < 0 || (p.VolumeIOPS > 0 && !v.iops) {
+		return fmt.Errorf("volume IOPS can not be set for %q volume type", volumeType)
+	}
+	if p.VolumeThroughput < 0 || (p.VolumeThroughput > 0 && !v.throughput) {
+		return fmt.Errorf("volume throughput can not be set for %q volume type", volumeType)
+	}
+	switch p.IMDSTokens {
+	case "", imdsTokensOptional, imdsTokensRequired:
+	default:
+		return fmt.Errorf("invalid IMDS tokens %q, must be %q or %q", p.IMDSTokens, imdsTokensOptional, imdsTokensRequired)
+	}
+	if p.IMDSHopLimit < 0 || p.IMDSHopLimit > maxIMDSHopLimit {
+		return fmt.Errorf("invalid IMDS hop limit %d, must be between 1 and %d", p.IMDSHopLimit, maxIMDSHopLimit)
+	}
+	switch p.CreditSpecification {
+	case "", "standard", "unlimited":
+	default:
+		return fmt.Errorf("invalid credit specification %q, must be standard or unlimited", p.CreditSpecification)
+	}
+	return nil
+}
+
+// getLaunchConfigurationSSHKey returns an SSH key of a pool stack launch
+// configuration. Stacks that still use launch configurations have no SSH key
+// output, so the key would be lost when they are migrated to a launch
+// template. An empty key is returned for stacks without launch configurations.
+func (c *Cloud) getLaunchConfigurationSSHKey(stackName string) (string, error) {
+	res, err := c.getStackResources(stackName)
+	if err != nil {
+		return "", err
+	}
+	names := []*string{}
+	for _, r := range res {
+		if *r.ResourceType == launchConfigurationResourceType && r.PhysicalResourceId != nil {
+			names = append(names, r.PhysicalResourceId)
+		}
+	}
+	if len(names) == 0 {
+		return "", nil
+	}
+
+	resp, err := c.asg.DescribeLaunchConfigurations(&autoscaling.DescribeLaunchConfigurationsInput{
+		LaunchConfigurationNames: names,
+	})
+	if err != nil {
+		return "", err
+	}
+	for _, lc := range resp.LaunchConfigurations {
+		if k := aws.StringValue(lc.KeyName); k != "" {
+			return k, nil
+		}
+	}
+	return "", nil
+}
+
+// setLaunchTemplateOutput sets a node pool spec value from a launch template
+// stack output. Other outputs are ignored.
+func setLaunchTemplateOutput(p *model.NodePoolSpec, o *cloudformation.Output) error {
+	var err error
+	v := *o.OutputValue
+	switch *o.OutputKey {
+	case sshKeyOutputKey:
+		p.SSHKey = v
+	case networksOutputKey:
+		if v != "" {
+			p.Networks = strings.Split(v, ",")
+		}
+	case volumeTypeOutputKey:
+		p.VolumeType = v
+	case volumeIOPSOutputKey:
+		p.VolumeIOPS, err = strconv.Atoi(v)
+	case volumeThroughputOutputKey:
+		p.VolumeThroughput, err = strconv.Atoi(v)
+	case imdsTokensOutputKey:
+		p.IMDSTokens = v
+	case imdsHopLimitOutputKey:
+		p.IMDSHopLimit, err = strconv.Atoi(v)
+	case creditSpecificationOutputKey:
+		p.CreditSpecification = v
+	case userDataFormatOutputKey:
+		p.UserDataFormat = v
+	case sizeOutputKey:
+		p.Size, err = strconv.Atoi(v)
+	case minSizeOutputKey:
+		p.MinSize, err = strconv.Atoi(v)
+	case maxSizeOutputKey:
+		p.MaxSize, err = strconv.Atoi(v)
+	}
+	return err
+}
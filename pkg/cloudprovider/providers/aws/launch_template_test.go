@@ -0,0 +1,130 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"testing"
+
+	"github.com/UKHomeOffice/keto/pkg/cloudprovider/providers/aws/mocks"
+	"github.com/UKHomeOffice/keto/pkg/model"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+)
+
+func TestValidateLaunchTemplate(t *testing.T) {
+	testCases := []struct {
+		name  string
+		input model.NodePoolSpec
+		valid bool
+	}{
+		{"defaults", model.NodePoolSpec{}, true},
+		{"gp3 with iops and throughput", model.NodePoolSpec{VolumeType: "gp3", VolumeIOPS: 3000, VolumeThroughput: 125}, true},
+		{"required tokens", model.NodePoolSpec{IMDSTokens: imdsTokensRequired, IMDSHopLimit: 2}, true},
+		{"unlimited credits", model.NodePoolSpec{CreditSpecification: "unlimited"}, true},
+		{"unsupported volume type", model.NodePoolSpec{VolumeType: "foo"}, false},
+		{"gp2 with iops", model.NodePoolSpec{VolumeIOPS: 3000}, false},
+		{"io1 with throughput", model.NodePoolSpec{VolumeType: "io1", VolumeThroughput: 125}, false},
+		{"invalid tokens", model.NodePoolSpec{IMDSTokens: "foo"}, false},
+		{"invalid hop limit", model.NodePoolSpec{IMDSHopLimit: 65}, false},
+		{"invalid credits", model.NodePoolSpec{CreditSpecification: "foo"}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateLaunchTemplate(tc.input)
+			if tc.valid && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestSetLaunchTemplateOutput(t *testing.T) {
+	want := model.NodePoolSpec{
+		SSHKey:              "mykey",
+		Networks:            []string{"network0", "network1"},
+		VolumeType:          "gp3",
+		VolumeIOPS:          3000,
+		IMDSTokens:          imdsTokensRequired,
+		IMDSHopLimit:        2,
+		CreditSpecification: "standard",
+	}
+
+	got := model.NodePoolSpec{}
+	for k, v := range makeLaunchTemplate(model.NodePool{NodePoolSpec: want}, ami, "sg").Outputs {
+		o := &cloudformation.Output{OutputKey: aws.String(k), OutputValue: aws.String(v)}
+		if err := setLaunchTemplateOutput(&got, o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got.SSHKey != want.SSHKey || len(got.Networks) != 2 || got.VolumeType != want.VolumeType ||
+		got.VolumeIOPS != want.VolumeIOPS || got.IMDSTokens != want.IMDSTokens ||
+		got.IMDSHopLimit != want.IMDSHopLimit || got.CreditSpecification != want.CreditSpecification {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+}
+
+func TestGetLaunchConfigurationSSHKey(t *testing.T) {
+	mockCF := &mocks.CloudFormationAPI{}
+	mockASG := &mocks.AutoScalingAPI{}
+	c := &Cloud{
+		Logger: makeLogger(),
+		cf:     mockCF,
+		asg:    mockASG,
+	}
+
+	mockCF.On("DescribeStackResources", &cloudformation.DescribeStackResourcesInput{
+		StackName: aws.String("keto-foo-compute0-blue"),
+	}).Return(&cloudformation.DescribeStackResourcesOutput{
+		StackResources: []*cloudformation.StackResource{
+			{ResourceType: aws.String(asgResourceType), PhysicalResourceId: aws.String("asg-compute0")},
+			{ResourceType: aws.String(launchConfigurationResourceType), PhysicalResourceId: aws.String("lc-compute0")},
+		},
+	}, nil)
+	mockCF.On("DescribeStackResources", &cloudformation.DescribeStackResourcesInput{
+		StackName: aws.String("keto-foo-compute1-blue"),
+	}).Return(makeTestASGResources("asg-compute1"), nil)
+	mockASG.On("DescribeLaunchConfigurations", &autoscaling.DescribeLaunchConfigurationsInput{
+		LaunchConfigurationNames: []*string{aws.String("lc-compute0")},
+	}).Return(&autoscaling.DescribeLaunchConfigurationsOutput{
+		LaunchConfigurations: []*autoscaling.LaunchConfiguration{
+			{LaunchConfigurationName: aws.String("lc-compute0"), KeyName: aws.String("foo-key")},
+		},
+	}, nil)
+
+	key, err := c.getLaunchConfigurationSSHKey("keto-foo-compute0-blue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "foo-key" {
+		t.Errorf("got SSH key %q; want %q", key, "foo-key")
+	}
+
+	// Stacks that use launch templates have an SSH key output instead.
+	if key, err = c.getLaunchConfigurationSSHKey("keto-foo-compute1-blue"); err != nil {
+		t.Fatal(err)
+	}
+	if key != "" {
+		t.Errorf("expected no SSH key, got %q", key)
+	}
+	mockASG.AssertExpectations(t)
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+
+	"github.com/UKHomeOffice/keto/pkg/model"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// minSubnetPrefix is the smallest subnet size AWS allows.
+const minSubnetPrefix = 28
+
+// clusterNetwork is a VPC with public and private subnets that is created for
+// a cluster when its networks aren't given.
+type clusterNetwork struct {
+	VpcID          string
+	CIDR           string
+	Zones          int
+	PrivateSubnets []string
+	PublicSubnets  []string
+}
+
+// networkSubnet is a pair of private and public subnet CIDRs in a single
+// availability zone.
+type networkSubnet struct {
+	AvailabilityZone string
+	PrivateCIDR      string
+	PublicCIDR       string
+}
+
+// createNetwork creates a network stack for a cluster and returns the network.
+func (c *Cloud) createNetwork(cluster model.Cluster) (*clusterNetwork, error) {
+	zones, err := c.getAvailabilityZones(cluster.NetworkZones)
+	if err != nil {
+		return nil, err
+	}
+	c.Logger.Printf("creating cluster %q network %q in zones %v", cluster.Name, cluster.NetworkCIDR, zones)
+	if err := c.createNetworkStack(cluster, zones); err != nil {
+		return nil, err
+	}
+	n, err := c.getClusterNetwork(cluster.Name)
+	if err != nil {
+		return nil, err
+	}
+	if n == nil {
+		return nil, fmt.Errorf("network of cluster %q not found", cluster.Name)
+	}
+	return n, nil
+}
+
+// getClusterNetwork returns a network that was created for a cluster or nil
+// if the cluster is in existing networks.
+func (c *Cloud) getClusterNetwork(clusterName string) (*clusterNetwork, error) {
+	s, err := c.getStack(makeNetworkStackName(clusterName))
+	if err != nil {
+		return nil, err
+	}
+	if len(s.Outputs) == 0 {
+		return nil, nil
+	}
+	n := &clusterNetwork{}
+	for _, o := range s.Outputs {
+		if err := setNetworkOutput(n, o); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+// getNetworkStackOutputs returns outputs of a cluster network stack from a
+// list of network stacks or nil if the cluster has no network stack.
+func getNetworkStackOutputs(stacks []*cloudformation.Stack, clusterName string) []*cloudformation.Output {
+	for _, s := range stacks {
+		if aws.StringValue(s.StackName) == makeNetworkStackName(clusterName) {
+			return s.Outputs
+		}
+	}
+	return nil
+}
+
+// setNetworkOutput sets a cluster network property from a network stack
+// output. Other outputs are ignored.
+func setNetworkOutput(n *clusterNetwork, o *cloudformation.Output) error {
+	var err error
+	v := *o.OutputValue
+	switch *o.OutputKey {
+	case vpcIDOutputKey:
+		n.VpcID = v
+	case networkCIDROutputKey:
+		n.CIDR = v
+	case networkZonesOutputKey:
+		n.Zones, err = strconv.Atoi(v)
+	case privateSubnetsOutputKey:
+		n.PrivateSubnets = splitOutputValue(v)
+	case publicSubnetsOutputKey:
+		n.PublicSubnets = splitOutputValue(v)
+	}
+	return err
+}
+
+// getLoadBalancerSubnets returns subnets of a cluster kube API load balancer.
+// Internet facing load balancers of clusters in a created network are placed
+// in its public subnets, others in master pool networks.
+func (c *Cloud) getLoadBalancerSubnets(cluster model.Cluster) ([]string, error) {
+	if cluster.Internal || cluster.NetworkCIDR == "" {
+		return cluster.MasterPool.Networks, nil
+	}
+	n, err := c.getClusterNetwork(cluster.Name)
+	if err != nil {
+		return nil, err
+	}
+	if n == nil {
+		return nil, fmt.Errorf("network of cluster %q not found", cluster.Name)
+	}
+	return n.PublicSubnets, nil
+}
+
+// getAvailabilityZones returns names of the first n available zones in the
+// region.
+func (c *Cloud) getAvailabilityZones(n int) ([]string, error) {
+	resp, err := c.ec2.DescribeAvailabilityZones(&ec2.DescribeAvailabilityZonesInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("state"), Values: []*string{aws.String("available")}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	zones := []string{}
+	for _, z := range resp.AvailabilityZones {
+		zones = append(zones, aws.StringValue(z.ZoneName))
+	}
+	if len(zones) < n {
+		return nil, fmt.Errorf("there are %d available zones in the region, %d network zones requested", len(zones), n)
+	}
+	sort.Strings(zones)
+	return zones[:n], nil
+}
+
+// splitNetworkCIDR splits an IPv4 CIDR into n subnets of the same size, e.g.
+// 10.0.0.0/16 is split into 6 subnets of /19.
+func splitNetworkCIDR(cidr string, n int) ([]string, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	ip := network.IP.To4()
+	if ip == nil {
+		return nil, fmt.Errorf("network CIDR %q is not an IPv4 CIDR", cidr)
+	}
+	prefix, _ := network.Mask.Size()
+	bits := 0
+	for 1<<uint(bits) < n {
+		bits++
+	}
+	if prefix+bits > minSubnetPrefix {
+		return nil, fmt.Errorf("network CIDR %q is too small to be split into %d subnets", cidr, n)
+	}
+
+	base := binary.BigEndian.Uint32(ip)
+	size := uint32(1) << uint(32-prefix-bits)
+	subnets := []string{}
+	for i := 0; i < n; i++ {
+		subnet := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(subnet, base+uint32(i)*size)
+		subnets = append(subnets, fmt.Sprintf("%s/%d", subnet, prefix+bits))
+	}
+	return subnets, nil
+}
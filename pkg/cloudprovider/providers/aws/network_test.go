@@ -0,0 +1,131 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/UKHomeOffice/keto/pkg/cloudprovider/providers/aws/mocks"
+	"github.com/UKHomeOffice/keto/pkg/model"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestSplitNetworkCIDR(t *testing.T) {
+	testCases := []struct {
+		cidr    string
+		n       int
+		want    []string
+		wantErr bool
+	}{
+		{"10.0.0.0/16", 2, []string{"10.0.0.0/17", "10.0.128.0/17"}, false},
+		{"10.0.0.0/16", 6, []string{"10.0.0.0/19", "10.0.32.0/19", "10.0.64.0/19", "10.0.96.0/19", "10.0.128.0/19", "10.0.160.0/19"}, false},
+		{"172.16.4.0/24", 1, []string{"172.16.4.0/24"}, false},
+		{"10.0.0.0/26", 4, []string{"10.0.0.0/28", "10.0.0.16/28", "10.0.0.32/28", "10.0.0.48/28"}, false},
+		{"10.0.0.0/26", 6, nil, true},
+		{"fd00::/48", 2, nil, true},
+		{"10.0.0.0", 2, nil, true},
+	}
+	for _, tc := range testCases {
+		got, err := splitNetworkCIDR(tc.cidr, tc.n)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s into %d: got error %v; want error %t", tc.cidr, tc.n, err, tc.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s into %d: got %v; want %v", tc.cidr, tc.n, got, tc.want)
+		}
+	}
+}
+
+func TestGetAvailabilityZones(t *testing.T) {
+	mockEC2 := &mocks.EC2API{}
+	c := &Cloud{
+		Logger: makeLogger(),
+		ec2:    mockEC2,
+	}
+
+	mockEC2.On("DescribeAvailabilityZones", &ec2.DescribeAvailabilityZonesInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("state"), Values: []*string{aws.String("available")}},
+		},
+	}).Return(&ec2.DescribeAvailabilityZonesOutput{
+		AvailabilityZones: []*ec2.AvailabilityZone{
+			{ZoneName: aws.String("eu-west-2c")},
+			{ZoneName: aws.String("eu-west-2a")},
+			{ZoneName: aws.String("eu-west-2b")},
+		},
+	}, nil)
+
+	got, err := c.getAvailabilityZones(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"eu-west-2a", "eu-west-2b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+	if _, err := c.getAvailabilityZones(4); err == nil {
+		t.Error("expected an error for more zones than the region has")
+	}
+}
+
+func TestGetLoadBalancerSubnets(t *testing.T) {
+	mockCF := &mocks.CloudFormationAPI{}
+	c := &Cloud{
+		Logger: makeLogger(),
+		cf:     mockCF,
+	}
+
+	mockCF.On("DescribeStacks", &cloudformation.DescribeStacksInput{StackName: aws.String(makeNetworkStackName("foo"))}).Return(
+		&cloudformation.DescribeStacksOutput{
+			Stacks: []*cloudformation.Stack{
+				{
+					StackName: aws.String(makeNetworkStackName("foo")),
+					Outputs: []*cloudformation.Output{
+						{OutputKey: aws.String(vpcIDOutputKey), OutputValue: aws.String("vpc0")},
+						{OutputKey: aws.String(privateSubnetsOutputKey), OutputValue: aws.String("private0,private1")},
+						{OutputKey: aws.String(publicSubnetsOutputKey), OutputValue: aws.String("public0,public1")},
+					},
+				},
+			},
+		}, nil)
+
+	testCases := []struct {
+		name    string
+		cluster model.Cluster
+		want    []string
+	}{
+		{"existing networks", model.Cluster{}, []string{"network0"}},
+		{"created network", model.Cluster{NetworkCIDR: "10.0.0.0/16"}, []string{"public0", "public1"}},
+		{"internal cluster", model.Cluster{ResourceMeta: model.ResourceMeta{Internal: true}, NetworkCIDR: "10.0.0.0/16"}, []string{"network0"}},
+	}
+	for _, tc := range testCases {
+		tc.cluster.Name = "foo"
+		tc.cluster.MasterPool.Networks = []string{"network0"}
+		got, err := c.getLoadBalancerSubnets(tc.cluster)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v; want %v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,586 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/UKHomeOffice/keto/pkg/model"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	keyPairCheckName       = "ssh key"
+	imageCheckName         = "ami"
+	instanceTypesCheckName = "instance types"
+	freeIPsCheckName       = "free ips"
+	dnsZoneCheckName       = "dns zone"
+	stackNamesCheckName    = "stack names"
+	quotasCheckName        = "quotas"
+
+	maxStackNameLength = 128
+	// masterNodeIPs is a number of IPs a master node takes up, one for its
+	// own interface and one for its persistent ENI.
+	masterNodeIPs = 2
+
+	keyPairNotFoundErrCode    = "InvalidKeyPair.NotFound"
+	maxInstancesAttributeName = "max-instances"
+	stackLimitName            = "StackLimit"
+)
+
+var stackNameRegexp = regexp.MustCompile("^[a-zA-Z][-a-zA-Z0-9]*$")
+
+// preflightPool is a node pool that is about to be created.
+type preflightPool struct {
+	model.NodePoolSpec
+	// machineTypes are all machine types pool instances can be launched as.
+	machineTypes []string
+	// zones are availability zones of pool subnets.
+	zones []string
+	// ips are numbers of IPs pool nodes take up in each of pool subnets.
+	ips map[string]int
+	// subnets are pool subnets, unless they are created with a cluster.
+	subnets []*ec2.Subnet
+	nodes   int
+	asgs    int
+}
+
+// ValidateCluster runs pre-flight checks of a cluster that is about to be
+// created along with its master and compute pools.
+func (c *Cloud) ValidateCluster(cluster model.Cluster) ([]model.CheckResult, error) {
+	stackNames := []string{makeClusterInfraStackName(cluster.Name), makeELBStackName(cluster.Name)}
+	var zones []string
+	vpcID := ""
+	if cluster.NetworkCIDR != "" {
+		stackNames = append(stackNames, makeNetworkStackName(cluster.Name))
+		var err error
+		if zones, err = c.getAvailabilityZones(cluster.NetworkZones); err != nil {
+			return nil, err
+		}
+	}
+
+	master, err := c.makePreflightPool(cluster.MasterPool.NodePoolSpec, nil, zones, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(master.subnets) > 0 {
+		if vpcID, err = getVpcIDFromSubnetList(master.subnets); err != nil {
+			return nil, err
+		}
+	}
+	pools := []preflightPool{master}
+	for _, p := range []string{blueStack, greenStack} {
+		stackNames = append(stackNames, makeMasterPoolStackName(cluster.Name, p))
+	}
+	for _, p := range cluster.ComputePools {
+		pool, err := c.makePreflightPool(p.NodePoolSpec, p.MachineTypes, zones, false)
+		if err != nil {
+			return nil, err
+		}
+		pools = append(pools, pool)
+		stackNames = append(stackNames, makeComputePoolStackName(cluster.Name, p.Name, blueStack),
+			makeComputePoolStackName(cluster.Name, p.Name, greenStack))
+	}
+
+	results, err := c.checkPreflightPools(pools)
+	if err != nil {
+		return results, err
+	}
+	dns, err := c.checkDNSZone(cluster, vpcID)
+	if err != nil {
+		return results, err
+	}
+	results = append(results, dns, checkStackNames(stackNames))
+
+	// Green stacks are only created during upgrades.
+	quotas, err := c.checkQuotas(pools, len(stackNames)-len(pools))
+	if err != nil {
+		return results, err
+	}
+	return append(results, quotas), nil
+}
+
+// ValidateComputePool runs pre-flight checks of a compute pool that is about
+// to be created. Pools without networks are placed in cluster network
+// private subnets.
+func (c *Cloud) ValidateComputePool(p model.ComputePool) ([]model.CheckResult, error) {
+	if len(p.Networks) == 0 {
+		n, err := c.getClusterNetwork(p.ClusterName)
+		if err != nil {
+			return nil, err
+		}
+		if n == nil {
+			return nil, fmt.Errorf("networks of computepool %q must be specified", p.Name)
+		}
+		p.Networks = n.PrivateSubnets
+	}
+	pool, err := c.makePreflightPool(p.NodePoolSpec, p.MachineTypes, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	pools := []preflightPool{pool}
+
+	results, err := c.checkPreflightPools(pools)
+	if err != nil {
+		return results, err
+	}
+	results = append(results, checkStackNames([]string{
+		makeComputePoolStackName(p.ClusterName, p.Name, blueStack),
+		makeComputePoolStackName(p.ClusterName, p.Name, greenStack),
+	}))
+	quotas, err := c.checkQuotas(pools, 1)
+	if err != nil {
+		return results, err
+	}
+	return append(results, quotas), nil
+}
+
+// makePreflightPool works out zones and subnet IPs of a node pool. Pools in
+// a network that is created with a cluster use given zones instead.
+func (c *Cloud) makePreflightPool(spec model.NodePoolSpec, machineTypes, zones []string, master bool) (preflightPool, error) {
+	p := preflightPool{
+		NodePoolSpec: spec,
+		machineTypes: append([]string{spec.MachineType}, machineTypes...),
+		zones:        zones,
+		ips:          make(map[string]int),
+	}
+
+	if len(spec.Networks) > 0 {
+		subnets, err := c.describeSubnets(spec.Networks)
+		if err != nil {
+			return p, err
+		}
+		p.subnets = subnets
+		p.zones = []string{}
+		for _, s := range subnets {
+			p.zones = appendUnique(p.zones, aws.StringValue(s.AvailabilityZone))
+		}
+	}
+
+	if master {
+		subnets := p.subnets
+		if len(subnets) == 0 {
+			// Networks created with a cluster have a private subnet per zone.
+			for _, z := range zones {
+				subnets = append(subnets, &ec2.Subnet{SubnetId: aws.String(z), AvailabilityZone: aws.String(z)})
+			}
+		}
+		if len(subnets) == 0 {
+			return p, nil
+		}
+		asgs := make(map[string]bool)
+		for _, n := range getNodesDistributionAcrossNetworks(subnets) {
+			if len(p.subnets) > 0 {
+				p.ips[n.Subnet] += masterNodeIPs
+			}
+			asgs[n.Subnet] = true
+			p.nodes++
+		}
+		p.asgs = len(asgs)
+		return p, nil
+	}
+
+	p.nodes = spec.Size
+	if spec.MaxSize > p.nodes {
+		p.nodes = spec.MaxSize
+	}
+	p.asgs = 1
+	for _, s := range p.subnets {
+		p.ips[aws.StringValue(s.SubnetId)] = (p.nodes + len(p.subnets) - 1) / len(p.subnets)
+	}
+	return p, nil
+}
+
+// checkPreflightPools checks that SSH key pairs and AMIs of pools exist, that
+// their machine types are offered in their zones and that their subnets
+// have enough free IPs.
+func (c *Cloud) checkPreflightPools(pools []preflightPool) ([]model.CheckResult, error) {
+	results := []model.CheckResult{}
+
+	keys, err := c.checkKeyPairs(pools)
+	if err != nil {
+		return results, err
+	}
+	results = append(results, keys, c.checkImages(pools))
+
+	types, err := c.checkInstanceTypes(pools)
+	if err != nil {
+		return results, err
+	}
+	return append(results, types, checkFreeIPs(pools)), nil
+}
+
+// checkKeyPairs checks that EC2 key pairs of pools exist.
+func (c *Cloud) checkKeyPairs(pools []preflightPool) (model.CheckResult, error) {
+	r := model.CheckResult{Name: keyPairCheckName}
+	names := []string{}
+	for _, p := range pools {
+		if p.SSHKey != "" {
+			names = appendUnique(names, p.SSHKey)
+		}
+	}
+	if len(names) == 0 {
+		r.Passed = true
+		r.Message = "no SSH key given"
+		return r, nil
+	}
+
+	missing := []string{}
+	for _, n := range names {
+		_, err := c.ec2.DescribeKeyPairs(&ec2.DescribeKeyPairsInput{KeyNames: []*string{aws.String(n)}})
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == keyPairNotFoundErrCode {
+			missing = append(missing, n)
+			continue
+		}
+		if err != nil {
+			return r, fmt.Errorf("failed to describe key pair %q: %v", n, err)
+		}
+	}
+	if len(missing) > 0 {
+		r.Message = fmt.Sprintf("key pairs %s do not exist", strings.Join(missing, ", "))
+		return r, nil
+	}
+	r.Passed = true
+	r.Message = fmt.Sprintf("key pairs %s exist", strings.Join(names, ", "))
+	return r, nil
+}
+
+// checkImages checks that CoreOS versions of pools resolve to AMIs.
+func (c *Cloud) checkImages(pools []preflightPool) model.CheckResult {
+	r := model.CheckResult{Name: imageCheckName}
+	versions := []string{}
+	for _, p := range pools {
+		versions = appendUnique(versions, p.CoreOSVersion)
+	}
+
+	found := []string{}
+	failed := []string{}
+	for _, v := range versions {
+		id, err := c.getAMIByName(v)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%q: %v", v, err))
+			continue
+		}
+		found = append(found, fmt.Sprintf("%q is %s", v, id))
+	}
+	if len(failed) > 0 {
+		r.Message = strings.Join(failed, ", ")
+		return r
+	}
+	r.Passed = true
+	r.Message = strings.Join(found, ", ")
+	return r
+}
+
+// checkInstanceTypes checks that machine types of pools are offered in all
+// of their zones.
+func (c *Cloud) checkInstanceTypes(pools []preflightPool) (model.CheckResult, error) {
+	r := model.CheckResult{Name: instanceTypesCheckName}
+	checked := make(map[string]bool)
+	failed := []string{}
+	for _, p := range pools {
+		for _, t := range p.machineTypes {
+			for _, z := range p.zones {
+				key := t + "/" + z
+				if checked[key] {
+					continue
+				}
+				checked[key] = true
+				offered, err := c.instanceTypeOffered(t, z)
+				if err != nil {
+					return r, err
+				}
+				if !offered {
+					failed = append(failed, fmt.Sprintf("%s is not offered in %s", t, z))
+				}
+			}
+		}
+	}
+	if len(failed) > 0 {
+		r.Message = strings.Join(failed, ", ")
+		return r, nil
+	}
+	r.Passed = true
+	r.Message = fmt.Sprintf("%d machine type and zone pairs offered", len(checked))
+	return r, nil
+}
+
+// instanceTypeOffered returns whether an instance type can be launched in an
+// availability zone. Unknown instance types have no offerings.
+func (c *Cloud) instanceTypeOffered(instanceType, zone string) (bool, error) {
+	resp, err := c.ec2.DescribeInstanceTypeOfferings(&ec2.DescribeInstanceTypeOfferingsInput{
+		LocationType: aws.String(ec2.LocationTypeAvailabilityZone),
+		Filters: []*ec2.Filter{
+			{Name: aws.String("location"), Values: []*string{aws.String(zone)}},
+			{Name: aws.String("instance-type"), Values: []*string{aws.String(instanceType)}},
+		},
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to describe %s offerings in %s: %v", instanceType, zone, err)
+	}
+	return len(resp.InstanceTypeOfferings) > 0, nil
+}
+
+// checkFreeIPs checks that pool subnets have enough free IPs for all pool
+// nodes, assuming pools are scaled up to their maximum size.
+func checkFreeIPs(pools []preflightPool) model.CheckResult {
+	r := model.CheckResult{Name: freeIPsCheckName}
+	needed := make(map[string]int)
+	free := make(map[string]int)
+	for _, p := range pools {
+		for _, s := range p.subnets {
+			free[aws.StringValue(s.SubnetId)] = int(aws.Int64Value(s.AvailableIpAddressCount))
+		}
+		for id, n := range p.ips {
+			needed[id] += n
+		}
+	}
+	if len(needed) == 0 {
+		r.Passed = true
+		r.Message = "subnets are created with the cluster"
+		return r
+	}
+
+	ids := []string{}
+	for id := range needed {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	failed := []string{}
+	for _, id := range ids {
+		if free[id] < needed[id] {
+			failed = append(failed, fmt.Sprintf("%s has %d free IPs, %d needed", id, free[id], needed[id]))
+		}
+	}
+	if len(failed) > 0 {
+		r.Message = strings.Join(failed, ", ")
+		return r
+	}
+	r.Passed = true
+	r.Message = fmt.Sprintf("%d subnets have enough free IPs", len(ids))
+	return r
+}
+
+// checkDNSZone checks that a kube API hostname belongs to a cluster DNS zone
+// and that the zone exists. Private zones can only be matched once a cluster
+// VPC is known.
+func (c *Cloud) checkDNSZone(cluster model.Cluster, vpcID string) (model.CheckResult, error) {
+	r := model.CheckResult{Name: dnsZoneCheckName}
+	if cluster.DNSZone == "" {
+		r.Passed = true
+		r.Message = "no DNS zone given"
+		return r, nil
+	}
+
+	hostname := makeAPIHostname(cluster)
+	if !strings.HasSuffix(strings.ToLower(hostname), "."+strings.ToLower(strings.TrimSuffix(cluster.DNSZone, "."))) {
+		r.Message = fmt.Sprintf("kube API hostname %q is not in dns zone %q", hostname, cluster.DNSZone)
+		return r, nil
+	}
+
+	if vpcID == "" {
+		zones, err := c.listHostedZonesByName(cluster.DNSZone)
+		if err != nil {
+			return r, err
+		}
+		if len(zones) == 0 {
+			r.Message = fmt.Sprintf("dns zone %q does not exist", cluster.DNSZone)
+			return r, nil
+		}
+		r.Passed = true
+		r.Message = fmt.Sprintf("dns zone %q exists", cluster.DNSZone)
+		return r, nil
+	}
+
+	ids, err := c.getHostedZoneIDs(cluster, vpcID)
+	if err != nil {
+		r.Message = err.Error()
+		return r, nil
+	}
+	r.Passed = true
+	r.Message = fmt.Sprintf("kube API records go in dns zone %q %s", cluster.DNSZone, strings.Join(ids, ", "))
+	return r, nil
+}
+
+// checkStackNames checks that stack names are valid CloudFormation stack
+// names.
+func checkStackNames(names []string) model.CheckResult {
+	r := model.CheckResult{Name: stackNamesCheckName}
+	failed := []string{}
+	for _, n := range names {
+		switch {
+		case len(n) > maxStackNameLength:
+			failed = append(failed, fmt.Sprintf("%q is longer than %d characters", n, maxStackNameLength))
+		case !stackNameRegexp.MatchString(n):
+			failed = append(failed, fmt.Sprintf("%q may only contain letters, digits and dashes", n))
+		}
+	}
+	if len(failed) > 0 {
+		r.Message = strings.Join(failed, ", ")
+		return r
+	}
+	r.Passed = true
+	r.Message = fmt.Sprintf("%d stack names are valid", len(names))
+	return r
+}
+
+// checkQuotas checks that account limits of instances, stacks and
+// autoscaling groups leave room for new pools and stacks. The instances limit
+// is the legacy max-instances account attribute, which accounts with vCPU
+// based limits don't enforce, so an unknown or exceeded instances limit is
+// only a warning.
+func (c *Cloud) checkQuotas(pools []preflightPool, newStacks int) (model.CheckResult, error) {
+	r := model.CheckResult{Name: quotasCheckName}
+	newInstances, newASGs := 0, 0
+	for _, p := range pools {
+		newInstances += p.nodes
+		newASGs += p.asgs
+	}
+
+	type quota struct {
+		name         string
+		used, needed int
+		limit        int
+		// legacy quotas may not be enforced.
+		legacy bool
+	}
+	quotas := []quota{}
+
+	maxInstances, err := c.getMaxInstances()
+	if err != nil {
+		return r, err
+	}
+	instances, err := c.countRunningInstances()
+	if err != nil {
+		return r, err
+	}
+	quotas = append(quotas, quota{"instances", instances, newInstances, maxInstances, true})
+
+	cfLimits, err := c.cf.DescribeAccountLimits(&cloudformation.DescribeAccountLimitsInput{})
+	if err != nil {
+		return r, fmt.Errorf("failed to describe cloudformation account limits: %v", err)
+	}
+	stacks, err := c.describeStacks("")
+	if err != nil {
+		return r, err
+	}
+	for _, l := range cfLimits.AccountLimits {
+		if aws.StringValue(l.Name) == stackLimitName {
+			quotas = append(quotas, quota{"stacks", len(stacks), newStacks, int(aws.Int64Value(l.Value)), false})
+		}
+	}
+
+	asgLimits, err := c.asg.DescribeAccountLimits(&autoscaling.DescribeAccountLimitsInput{})
+	if err != nil {
+		return r, fmt.Errorf("failed to describe autoscaling account limits: %v", err)
+	}
+	quotas = append(quotas, quota{"autoscaling groups", int(aws.Int64Value(asgLimits.NumberOfAutoScalingGroups)),
+		newASGs, int(aws.Int64Value(asgLimits.MaxNumberOfAutoScalingGroups)), false})
+
+	usage := []string{}
+	failed := []string{}
+	warnings := []string{}
+	for _, q := range quotas {
+		if q.limit <= 0 {
+			usage = append(usage, fmt.Sprintf("%s %d+%d of unknown", q.name, q.used, q.needed))
+			if q.legacy {
+				warnings = append(warnings, fmt.Sprintf("%s limit is unknown", q.name))
+			}
+			continue
+		}
+		s := fmt.Sprintf("%s %d+%d of %d", q.name, q.used, q.needed, q.limit)
+		usage = append(usage, s)
+		switch {
+		case q.used+q.needed <= q.limit:
+		case q.legacy:
+			warnings = append(warnings, fmt.Sprintf("%s exceed the legacy %s limit", q.name, maxInstancesAttributeName))
+		default:
+			failed = append(failed, s)
+		}
+	}
+	if len(failed) > 0 {
+		r.Message = "limits exceeded: " + strings.Join(failed, ", ")
+		return r, nil
+	}
+	r.Passed = true
+	r.Message = strings.Join(usage, ", ")
+	if len(warnings) > 0 {
+		r.Warning = true
+		r.Message += "; " + strings.Join(warnings, ", ")
+	}
+	return r, nil
+}
+
+// getMaxInstances returns the legacy account limit of running on-demand
+// instances or 0 if the account doesn't report one.
+func (c *Cloud) getMaxInstances() (int, error) {
+	resp, err := c.ec2.DescribeAccountAttributes(&ec2.DescribeAccountAttributesInput{
+		AttributeNames: []*string{aws.String(maxInstancesAttributeName)},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to describe account attributes: %v", err)
+	}
+	for _, a := range resp.AccountAttributes {
+		if len(a.AttributeValues) > 0 {
+			return strconv.Atoi(aws.StringValue(a.AttributeValues[0].AttributeValue))
+		}
+	}
+	return 0, nil
+}
+
+// countRunningInstances returns a number of pending and running instances.
+func (c *Cloud) countRunningInstances() (int, error) {
+	count := 0
+	params := &ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("instance-state-name"), Values: []*string{
+				aws.String(ec2.InstanceStateNamePending), aws.String(ec2.InstanceStateNameRunning)}},
+		},
+	}
+	for {
+		resp, err := c.ec2.DescribeInstances(params)
+		if err != nil {
+			return count, fmt.Errorf("failed to describe instances: %v", err)
+		}
+		for _, r := range resp.Reservations {
+			count += len(r.Instances)
+		}
+		if resp.NextToken == nil {
+			return count, nil
+		}
+		params.NextToken = resp.NextToken
+	}
+}
+
+// appendUnique appends v to s unless s already contains it.
+func appendUnique(s []string, v string) []string {
+	for _, i := range s {
+		if i == v {
+			return s
+		}
+	}
+	return append(s, v)
+}
@@ -0,0 +1,235 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"testing"
+
+	"github.com/UKHomeOffice/keto/pkg/cloudprovider/providers/aws/mocks"
+	"github.com/UKHomeOffice/keto/pkg/model"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestValidateComputePool(t *testing.T) {
+	mockCF := &mocks.CloudFormationAPI{}
+	mockEC2 := &mocks.EC2API{}
+	mockASG := &mocks.AutoScalingAPI{}
+	c := &Cloud{
+		Logger: makeLogger(),
+		cf:     mockCF,
+		ec2:    mockEC2,
+		asg:    mockASG,
+	}
+
+	p := model.ComputePool{}
+	p.Name = "compute0"
+	p.ClusterName = "foo"
+	p.MachineType = "m5.large"
+	p.MachineTypes = []string{"m4.large"}
+	p.CoreOSVersion = "CoreOS-stable-1632.3.0-hvm"
+	p.SSHKey = "foo"
+	p.Size = 2
+	p.MaxSize = 6
+	p.Networks = []string{"subnet-a", "subnet-b"}
+
+	mockEC2.On("DescribeSubnets", &ec2.DescribeSubnetsInput{
+		SubnetIds: []*string{aws.String("subnet-a"), aws.String("subnet-b")},
+	}).Return(&ec2.DescribeSubnetsOutput{
+		Subnets: []*ec2.Subnet{
+			{SubnetId: aws.String("subnet-a"), AvailabilityZone: aws.String("eu-west-2a"), AvailableIpAddressCount: aws.Int64(250)},
+			{SubnetId: aws.String("subnet-b"), AvailabilityZone: aws.String("eu-west-2b"), AvailableIpAddressCount: aws.Int64(2)},
+		},
+	}, nil)
+	mockEC2.On("DescribeKeyPairs", &ec2.DescribeKeyPairsInput{
+		KeyNames: []*string{aws.String("foo")},
+	}).Return(nil, awserr.New(keyPairNotFoundErrCode, "not found", nil))
+	mockEC2.On("DescribeImages", mock.AnythingOfType("*ec2.DescribeImagesInput")).Return(
+		&ec2.DescribeImagesOutput{Images: []*ec2.Image{{ImageId: aws.String("ami-1")}}}, nil)
+	mockEC2.On("DescribeInstanceTypeOfferings", mock.MatchedBy(func(in *ec2.DescribeInstanceTypeOfferingsInput) bool {
+		if aws.StringValue(in.LocationType) != ec2.LocationTypeAvailabilityZone {
+			return false
+		}
+		filters := make(map[string]string)
+		for _, f := range in.Filters {
+			filters[aws.StringValue(f.Name)] = aws.StringValue(f.Values[0])
+		}
+		return filters["instance-type"] != "m5.large" || filters["location"] != "eu-west-2b"
+	})).Return(&ec2.DescribeInstanceTypeOfferingsOutput{
+		InstanceTypeOfferings: []*ec2.InstanceTypeOffering{{}},
+	}, nil)
+	mockEC2.On("DescribeInstanceTypeOfferings", mock.AnythingOfType("*ec2.DescribeInstanceTypeOfferingsInput")).Return(
+		&ec2.DescribeInstanceTypeOfferingsOutput{}, nil)
+
+	mockEC2.On("DescribeAccountAttributes", mock.AnythingOfType("*ec2.DescribeAccountAttributesInput")).Return(
+		&ec2.DescribeAccountAttributesOutput{
+			AccountAttributes: []*ec2.AccountAttribute{
+				{AttributeValues: []*ec2.AccountAttributeValue{{AttributeValue: aws.String("20")}}},
+			},
+		}, nil)
+	mockEC2.On("DescribeInstances", mock.AnythingOfType("*ec2.DescribeInstancesInput")).Return(
+		&ec2.DescribeInstancesOutput{
+			Reservations: []*ec2.Reservation{{Instances: []*ec2.Instance{{}, {}, {}}}},
+		}, nil)
+	mockCF.On("DescribeAccountLimits", &cloudformation.DescribeAccountLimitsInput{}).Return(
+		&cloudformation.DescribeAccountLimitsOutput{
+			AccountLimits: []*cloudformation.AccountLimit{{Name: aws.String(stackLimitName), Value: aws.Int64(200)}},
+		}, nil)
+	mockCF.On("DescribeStacks", &cloudformation.DescribeStacksInput{}).Return(
+		&cloudformation.DescribeStacksOutput{Stacks: []*cloudformation.Stack{{}, {}}}, nil)
+	mockASG.On("DescribeAccountLimits", &autoscaling.DescribeAccountLimitsInput{}).Return(
+		&autoscaling.DescribeAccountLimitsOutput{
+			NumberOfAutoScalingGroups:    aws.Int64(4),
+			MaxNumberOfAutoScalingGroups: aws.Int64(200),
+		}, nil)
+
+	results, err := c.ValidateComputePool(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []model.CheckResult{
+		{Name: keyPairCheckName, Message: "key pairs foo do not exist"},
+		{Name: imageCheckName, Passed: true, Message: `"CoreOS-stable-1632.3.0-hvm" is ami-1`},
+		{Name: instanceTypesCheckName, Message: "m5.large is not offered in eu-west-2b"},
+		{Name: freeIPsCheckName, Message: "subnet-b has 2 free IPs, 3 needed"},
+		{Name: stackNamesCheckName, Passed: true, Message: "2 stack names are valid"},
+		{Name: quotasCheckName, Passed: true, Message: "instances 3+6 of 20, stacks 2+1 of 200, autoscaling groups 4+1 of 200"},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results; want %d", len(results), len(want))
+	}
+	for i := range want {
+		if results[i] != want[i] {
+			t.Errorf("got result %+v; want %+v", results[i], want[i])
+		}
+	}
+}
+
+func TestCheckQuotasInstancesWarning(t *testing.T) {
+	mockCF := &mocks.CloudFormationAPI{}
+	mockEC2 := &mocks.EC2API{}
+	mockASG := &mocks.AutoScalingAPI{}
+	c := &Cloud{
+		Logger: makeLogger(),
+		cf:     mockCF,
+		ec2:    mockEC2,
+		asg:    mockASG,
+	}
+
+	mockEC2.On("DescribeAccountAttributes", mock.AnythingOfType("*ec2.DescribeAccountAttributesInput")).Return(
+		&ec2.DescribeAccountAttributesOutput{
+			AccountAttributes: []*ec2.AccountAttribute{
+				{AttributeValues: []*ec2.AccountAttributeValue{{AttributeValue: aws.String("5")}}},
+			},
+		}, nil).Once()
+	mockEC2.On("DescribeAccountAttributes", mock.AnythingOfType("*ec2.DescribeAccountAttributesInput")).Return(
+		&ec2.DescribeAccountAttributesOutput{}, nil).Once()
+	mockEC2.On("DescribeInstances", mock.AnythingOfType("*ec2.DescribeInstancesInput")).Return(
+		&ec2.DescribeInstancesOutput{
+			Reservations: []*ec2.Reservation{{Instances: []*ec2.Instance{{}, {}, {}}}},
+		}, nil)
+	mockCF.On("DescribeAccountLimits", &cloudformation.DescribeAccountLimitsInput{}).Return(
+		&cloudformation.DescribeAccountLimitsOutput{
+			AccountLimits: []*cloudformation.AccountLimit{{Name: aws.String(stackLimitName), Value: aws.Int64(200)}},
+		}, nil)
+	mockCF.On("DescribeStacks", &cloudformation.DescribeStacksInput{}).Return(
+		&cloudformation.DescribeStacksOutput{Stacks: []*cloudformation.Stack{{}, {}}}, nil)
+	mockASG.On("DescribeAccountLimits", &autoscaling.DescribeAccountLimitsInput{}).Return(
+		&autoscaling.DescribeAccountLimitsOutput{
+			NumberOfAutoScalingGroups:    aws.Int64(4),
+			MaxNumberOfAutoScalingGroups: aws.Int64(200),
+		}, nil)
+
+	pools := []preflightPool{{nodes: 6, asgs: 1}}
+	want := []model.CheckResult{
+		{Name: quotasCheckName, Passed: true, Warning: true,
+			Message: "instances 3+6 of 5, stacks 2+1 of 200, autoscaling groups 4+1 of 200; instances exceed the legacy max-instances limit"},
+		{Name: quotasCheckName, Passed: true, Warning: true,
+			Message: "instances 3+6 of unknown, stacks 2+1 of 200, autoscaling groups 4+1 of 200; instances limit is unknown"},
+	}
+	for _, w := range want {
+		r, err := c.checkQuotas(pools, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r != w {
+			t.Errorf("got result %+v; want %+v", r, w)
+		}
+	}
+}
+
+func TestMakePreflightPoolMaster(t *testing.T) {
+	mockEC2 := &mocks.EC2API{}
+	c := &Cloud{Logger: makeLogger(), ec2: mockEC2}
+
+	spec := model.NodePoolSpec{MachineType: "m5.large", Networks: []string{"subnet-a", "subnet-b"}}
+	mockEC2.On("DescribeSubnets", &ec2.DescribeSubnetsInput{
+		SubnetIds: []*string{aws.String("subnet-a"), aws.String("subnet-b")},
+	}).Return(&ec2.DescribeSubnetsOutput{
+		Subnets: []*ec2.Subnet{
+			{SubnetId: aws.String("subnet-a"), AvailabilityZone: aws.String("eu-west-2a")},
+			{SubnetId: aws.String("subnet-b"), AvailabilityZone: aws.String("eu-west-2b")},
+		},
+	}, nil)
+
+	p, err := c.makePreflightPool(spec, nil, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes := len(getNodesDistributionAcrossNetworks(p.subnets))
+	if p.nodes != nodes {
+		t.Errorf("got %d nodes; want %d", p.nodes, nodes)
+	}
+	ips := p.ips["subnet-a"] + p.ips["subnet-b"]
+	if ips != nodes*masterNodeIPs {
+		t.Errorf("got %d IPs; want %d", ips, nodes*masterNodeIPs)
+	}
+	if p.asgs != 2 {
+		t.Errorf("got %d autoscaling groups; want 2", p.asgs)
+	}
+
+	// Master pools in a network created with a cluster don't use up IPs
+	// of existing subnets.
+	spec.Networks = nil
+	p, err = c.makePreflightPool(spec, nil, []string{"eu-west-2a", "eu-west-2b", "eu-west-2c"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.ips) != 0 || p.asgs != 3 || len(p.zones) != 3 {
+		t.Errorf("got IPs %v, %d autoscaling groups, zones %v; want no IPs, 3 groups and 3 zones", p.ips, p.asgs, p.zones)
+	}
+}
+
+func TestCheckStackNames(t *testing.T) {
+	if r := checkStackNames([]string{"keto-foo-infra", "keto-foo-compute0-blue"}); !r.Passed {
+		t.Errorf("expected stack names to be valid: %s", r.Message)
+	}
+	long := "keto-"
+	for len(long) <= maxStackNameLength {
+		long += "a"
+	}
+	for _, n := range []string{"keto-foo_bar-infra", "keto-foo.bar-infra", long} {
+		if r := checkStackNames([]string{"keto-foo-infra", n}); r.Passed {
+			t.Errorf("expected stack name %q to be invalid", n)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/UKHomeOffice/keto/pkg/keto/util"
+	"github.com/UKHomeOffice/keto/pkg/model"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// templateOverlaysOutputKey is a stack output key which holds a comma
+// separated list of overlay names applied to a stack template.
+const templateOverlaysOutputKey = "TemplateOverlays"
+
+// jsonPatchOperation is a single RFC 6902 JSON patch operation. Only add,
+// remove and replace operations are supported.
+type jsonPatchOperation struct {
+	Op    string    `yaml:"op"`
+	Path  string    `yaml:"path"`
+	Value yaml.Node `yaml:"value"`
+}
+
+// applyTemplateOverlays applies overlays to a stack template in order and
+// records overlay names in the template outputs. An overlay is either a list
+// of JSON patch operations or a fragment, which is merged into a template:
+// maps are merged recursively, lists are appended to and anything else is
+// replaced. The template is returned as is if there are no overlays.
+func applyTemplateOverlays(tpl string, overlays []model.TemplateOverlay) (string, error) {
+	if len(overlays) == 0 {
+		return tpl, nil
+	}
+
+	doc, err := parseTemplate(tpl)
+	if err != nil {
+		return "", err
+	}
+
+	names := []string{}
+	for _, o := range overlays {
+		var overlay yaml.Node
+		if err := yaml.Unmarshal(o.Data, &overlay); err != nil {
+			return "", fmt.Errorf("failed to parse %q overlay: %v", o.Name, err)
+		}
+		if len(overlay.Content) == 0 {
+			continue
+		}
+
+		switch overlay.Content[0].Kind {
+		case yaml.SequenceNode:
+			var ops []jsonPatchOperation
+			if err := overlay.Content[0].Decode(&ops); err != nil {
+				return "", fmt.Errorf("failed to parse %q overlay: %v", o.Name, err)
+			}
+			for _, op := range ops {
+				if err := applyJSONPatchOperation(doc, op); err != nil {
+					return "", fmt.Errorf("failed to apply %q overlay: %v", o.Name, err)
+				}
+			}
+		case yaml.MappingNode:
+			util.MergeYAMLNodes(doc, overlay.Content[0])
+		default:
+			return "", fmt.Errorf("%q overlay must be either a list of JSON patch operations or a map", o.Name)
+		}
+		names = append(names, o.Name)
+	}
+
+	outputs := util.MappingValue(doc, "Outputs")
+	if outputs == nil {
+		outputs = &yaml.Node{Kind: yaml.MappingNode}
+		util.SetMappingValue(doc, "Outputs", outputs)
+	}
+	output := &yaml.Node{Kind: yaml.MappingNode}
+	util.SetMappingValue(output, "Value", &yaml.Node{
+		Kind:  yaml.ScalarNode,
+		Style: yaml.DoubleQuotedStyle,
+		Value: strings.Join(names, ","),
+	})
+	util.SetMappingValue(outputs, templateOverlaysOutputKey, output)
+
+	var b bytes.Buffer
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return "", err
+	}
+	if err := enc.Close(); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// stackTemplateOverlays returns cluster template overlays that target a given
+// stack, either constants.TemplateOverlayStackInfra or
+// constants.TemplateOverlayStackLoadBalancer.
+func stackTemplateOverlays(overlays []model.TemplateOverlay, stack string) []model.TemplateOverlay {
+	list := []model.TemplateOverlay{}
+	for _, o := range overlays {
+		if o.Stack == stack {
+			list = append(list, o)
+		}
+	}
+	return list
+}
+
+// parseTemplate parses a stack template and returns its top level map.
+func parseTemplate(tpl string) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(tpl), &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse stack template: %v", err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("stack template must be a map")
+	}
+	return doc.Content[0], nil
+}
+
+// applyJSONPatchOperation applies a JSON patch operation to a document.
+func applyJSONPatchOperation(doc *yaml.Node, op jsonPatchOperation) error {
+	if !strings.HasPrefix(op.Path, "/") {
+		return fmt.Errorf("invalid path %q", op.Path)
+	}
+	tokens := strings.Split(op.Path[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(t)
+	}
+
+	// Find a parent of the target node.
+	parent := doc
+	for _, t := range tokens[:len(tokens)-1] {
+		child, err := childNode(parent, t)
+		if err != nil {
+			return fmt.Errorf("%s: %v", op.Path, err)
+		}
+		parent = child
+	}
+	last := tokens[len(tokens)-1]
+
+	switch op.Op {
+	case "add":
+		if parent.Kind == yaml.SequenceNode {
+			if last == "-" {
+				parent.Content = append(parent.Content, &op.Value)
+				return nil
+			}
+			i, err := sequenceIndex(parent, last, true)
+			if err != nil {
+				return fmt.Errorf("%s: %v", op.Path, err)
+			}
+			parent.Content = append(parent.Content[:i], append([]*yaml.Node{&op.Value}, parent.Content[i:]...)...)
+			return nil
+		}
+		if parent.Kind != yaml.MappingNode {
+			return fmt.Errorf("%s: parent is neither a map nor a list", op.Path)
+		}
+		util.SetMappingValue(parent, last, &op.Value)
+	case "remove", "replace":
+		if parent.Kind == yaml.SequenceNode {
+			i, err := sequenceIndex(parent, last, false)
+			if err != nil {
+				return fmt.Errorf("%s: %v", op.Path, err)
+			}
+			if op.Op == "replace" {
+				parent.Content[i] = &op.Value
+				return nil
+			}
+			parent.Content = append(parent.Content[:i], parent.Content[i+1:]...)
+			return nil
+		}
+		if util.MappingValue(parent, last) == nil {
+			return fmt.Errorf("%s: %q does not exist", op.Path, last)
+		}
+		if op.Op == "replace" {
+			util.SetMappingValue(parent, last, &op.Value)
+			return nil
+		}
+		for i := 0; i+1 < len(parent.Content); i += 2 {
+			if parent.Content[i].Value == last {
+				parent.Content = append(parent.Content[:i], parent.Content[i+2:]...)
+				break
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported operation %q", op.Op)
+	}
+	return nil
+}
+
+// childNode returns a child node of a map or a list given a path token.
+func childNode(n *yaml.Node, token string) (*yaml.Node, error) {
+	switch n.Kind {
+	case yaml.MappingNode:
+		if v := util.MappingValue(n, token); v != nil {
+			return v, nil
+		}
+		return nil, fmt.Errorf("%q does not exist", token)
+	case yaml.SequenceNode:
+		i, err := sequenceIndex(n, token, false)
+		if err != nil {
+			return nil, err
+		}
+		return n.Content[i], nil
+	}
+	return nil, fmt.Errorf("%q is neither a map nor a list", token)
+}
+
+// sequenceIndex returns a list index given a path token. An index equal to
+// the list length is only valid when adding.
+func sequenceIndex(n *yaml.Node, token string, add bool) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil {
+		return 0, fmt.Errorf("invalid list index %q", token)
+	}
+	max := len(n.Content) - 1
+	if add {
+		max++
+	}
+	if i < 0 || i > max {
+		return 0, fmt.Errorf("list index %d is out of range", i)
+	}
+	return i, nil
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/UKHomeOffice/keto/pkg/constants"
+	"github.com/UKHomeOffice/keto/pkg/model"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+const testOverlayTemplate = `Resources:
+  SG:
+    Type: AWS::EC2::SecurityGroup
+    Properties:
+      GroupDescription: "test"
+      Tags:
+        - Key: Name
+          Value: "test"
+  SGIngress:
+    Type: AWS::EC2::SecurityGroupIngress
+    Properties:
+      GroupId: !Ref SG
+      IpProtocol: tcp
+      FromPort: 22
+      ToPort: 22
+
+Outputs:
+  StackType:
+    Value: "infra"
+`
+
+func TestApplyTemplateOverlays(t *testing.T) {
+	tpl, err := applyTemplateOverlays(testOverlayTemplate, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tpl != testOverlayTemplate {
+		t.Errorf("expected template to be unchanged without overlays")
+	}
+
+	overlays := []model.TemplateOverlay{
+		{
+			Name: "tags.yaml",
+			Data: []byte(`Resources:
+  SG:
+    Properties:
+      Tags:
+        - Key: team
+          Value: infra
+`),
+		},
+		{
+			Name: "ssh.json",
+			Data: []byte(`[
+  {"op": "replace", "path": "/Resources/SGIngress/Properties/FromPort", "value": 2222},
+  {"op": "remove", "path": "/Resources/SGIngress/Properties/ToPort"},
+  {"op": "add", "path": "/Resources/SG/Properties/Tags/0", "value": {"Key": "first", "Value": "1"}}
+]`),
+		},
+	}
+	tpl, err = applyTemplateOverlays(testOverlayTemplate, overlays)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := validateTemplate(tpl); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(tpl, "GroupId: !Ref SG") {
+		t.Errorf("expected short form functions to be preserved:\n%s", tpl)
+	}
+
+	doc, err := parseTemplate(tpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var template struct {
+		Resources struct {
+			SG struct {
+				Properties struct {
+					Tags []map[string]string `yaml:"Tags"`
+				} `yaml:"Properties"`
+			} `yaml:"SG"`
+			SGIngress struct {
+				Properties map[string]interface{} `yaml:"Properties"`
+			} `yaml:"SGIngress"`
+		} `yaml:"Resources"`
+		Outputs map[string]map[string]string `yaml:"Outputs"`
+	}
+	if err := doc.Decode(&template); err != nil {
+		t.Fatal(err)
+	}
+
+	tags := template.Resources.SG.Properties.Tags
+	if len(tags) != 3 || tags[0]["Key"] != "first" || tags[2]["Key"] != "team" {
+		t.Errorf("unexpected tags %v", tags)
+	}
+	props := template.Resources.SGIngress.Properties
+	if props["FromPort"] != 2222 {
+		t.Errorf("expected FromPort to be replaced, got %v", props["FromPort"])
+	}
+	if _, ok := props["ToPort"]; ok {
+		t.Errorf("expected ToPort to be removed")
+	}
+	if v := template.Outputs[templateOverlaysOutputKey]["Value"]; v != "tags.yaml,ssh.json" {
+		t.Errorf("unexpected %s output value %q", templateOverlaysOutputKey, v)
+	}
+}
+
+func TestApplyTemplateOverlaysErrors(t *testing.T) {
+	cases := map[string]string{
+		"missing path":  `[{"op": "replace", "path": "/Resources/Foo/Type", "value": "x"}]`,
+		"bad index":     `[{"op": "remove", "path": "/Resources/SG/Properties/Tags/5"}]`,
+		"unsupported":   `[{"op": "move", "from": "/Resources/SG", "path": "/Resources/Foo"}]`,
+		"not a map":     `"foo"`,
+		"invalid input": `{`,
+	}
+	for name, data := range cases {
+		overlays := []model.TemplateOverlay{{Name: name, Data: []byte(data)}}
+		if _, err := applyTemplateOverlays(testOverlayTemplate, overlays); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestStackTemplateOverlays(t *testing.T) {
+	overlays := []model.TemplateOverlay{
+		{Name: "infra.yaml", Stack: constants.TemplateOverlayStackInfra},
+		{Name: "elb.yaml", Stack: constants.TemplateOverlayStackLoadBalancer},
+		{Name: "tags.yaml", Stack: constants.TemplateOverlayStackInfra},
+	}
+	for stack, expected := range map[string][]string{
+		constants.TemplateOverlayStackInfra:        {"infra.yaml", "tags.yaml"},
+		constants.TemplateOverlayStackLoadBalancer: {"elb.yaml"},
+	} {
+		names := []string{}
+		for _, o := range stackTemplateOverlays(overlays, stack) {
+			names = append(names, o.Name)
+		}
+		if strings.Join(names, ",") != strings.Join(expected, ",") {
+			t.Errorf("expected %s overlays %v, got %v", stack, expected, names)
+		}
+	}
+}
+
+// validateTemplate checks whether a template is a valid YAML document.
+func validateTemplate(tpl string) error {
+	var v interface{}
+	return yaml.Unmarshal([]byte(tpl), &v)
+}
@@ -0,0 +1,511 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/UKHomeOffice/keto/pkg/cloudprovider"
+	"github.com/UKHomeOffice/keto/pkg/components"
+	"github.com/UKHomeOffice/keto/pkg/keto/util"
+	"github.com/UKHomeOffice/keto/pkg/model"
+
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/dns/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/api/storage/v1"
+)
+
+const (
+	// ProviderName is the name of this provider.
+	ProviderName = "gce"
+
+	// projectEnv and regionEnv are environment variables that specify a
+	// project and a region keto manages clusters in.
+	projectEnv = "KETO_GCE_PROJECT"
+	regionEnv  = "KETO_GCE_REGION"
+
+	// managedByKeto label is needed for the cloudprovider to know which cloud
+	// resources are managed by keto.
+	managedByKetoLabelKey   = "managed-by-keto"
+	managedByKetoLabelValue = "true"
+
+	clusterNameLabelKey = "cluster-name"
+	stackTypeLabelKey   = "stack-type"
+	// nodeIDLabelKey is a persistent disk label and master instance metadata
+	// key of a NodeID.
+	nodeIDLabelKey = "keto-node-id"
+
+	etcdCACertObjectName = "etcd_ca.crt"
+	etcdCAKeyObjectName  = "etcd_ca.key"
+	kubeCACertObjectName = "kube_ca.crt"
+	kubeCAKeyObjectName  = "kube_ca.key"
+	// outputsObjectName is an assets bucket object that holds cluster infra
+	// outputs.
+	outputsObjectName = "outputs.json"
+
+	// Outputs key names. These match the AWS provider stack outputs, so all
+	// providers store the same data about clusters and node pools. Node pool
+	// outputs are stored as instance template metadata items.
+	stackTypeOutputKey                  = "StackType"
+	clusterNameOutputKey                = "ClusterName"
+	poolNameOutputKey                   = "PoolName"
+	coreOSVersionOutputKey              = "CoreOSVersion"
+	kubeVersionOutputKey                = "KubeVersion"
+	kubeAPIURLOutputKey                 = "KubeAPIURL"
+	machineTypeOutputKey                = "MachineType"
+	diskSizeOutputKey                   = "DiskSize"
+	assetsBucketNameOutputKey           = "AssetsBucketName"
+	internalClusterOutputKey            = "InternalCluster"
+	labelsOutputKey                     = "Labels"
+	taintsOutputKey                     = "Taints"
+	kubeletExtraArgsOutputKey           = "KubeletExtraArgs"
+	apiServerExtraArgsOutputKey         = "APIServerExtraArgs"
+	controllerManagerExtraArgsOutputKey = "ControllerManagerExtraArgs"
+	schedulerExtraArgsOutputKey         = "SchedulerExtraArgs"
+	userDataHashOutputKey               = "UserDataHash"
+	subnetworkOutputKey                 = "Subnetwork"
+	dnsZoneOutputKey                    = "DNSZone"
+	apiHostOutputKey                    = "APIHost"
+	networkProviderOutputKey            = "NetworkProvider"
+	podCIDROutputKey                    = "PodCIDR"
+	serviceCIDROutputKey                = "ServiceCIDR"
+	componentsOutputKey                 = "Components"
+	registryMirrorOutputKey             = "RegistryMirror"
+	artifactsURLOutputKey               = "ArtifactsURL"
+	httpProxyOutputKey                  = "HTTPProxy"
+	httpsProxyOutputKey                 = "HTTPSProxy"
+	noProxyOutputKey                    = "NoProxy"
+	networkCIDRsOutputKey               = "NetworkCIDRs"
+
+	clusterInfraStackType = "infra"
+	masterPoolStackType   = "masterpool"
+	computePoolStackType  = "computepool"
+
+	blueStack = "blue"
+)
+
+var (
+	// ErrNotImplemented defines an error for not implemented features.
+	ErrNotImplemented = errors.New("not implemented")
+	// ErrInstancesPolicyNotSupported defines an error for compute pools that
+	// use spot instances or multiple machine types.
+	ErrInstancesPolicyNotSupported = errors.New("spot and mixed-instance compute pools are not supported")
+	// ErrAutoscalingNotSupported defines an error for compute pools that have
+	// different min and max sizes.
+	ErrAutoscalingNotSupported = errors.New("autoscaling compute pools are not supported")
+	// ErrAccessNotSupported defines an error for clusters that restrict SSH
+	// or kube API access.
+	ErrAccessNotSupported = errors.New("cluster access rules are not supported")
+	// ErrAllowFromPoolsNotSupported defines an error for compute pools that
+	// declare allowed traffic from other pools.
+	ErrAllowFromPoolsNotSupported = errors.New("compute pool isolation is not supported")
+	// ErrLoadBalancerTypeNotSupported defines an error for clusters that ask
+	// for a kube API load balancer other than the default one.
+	ErrLoadBalancerTypeNotSupported = errors.New("load balancer type is not supported")
+	// ErrAPINamesNotSupported defines an error for clusters that set a custom
+	// kube API hostname, aliases or certificate SANs.
+	ErrAPINamesNotSupported = errors.New("custom kube API names are not supported")
+	// ErrDNSZoneSelectionNotSupported defines an error for clusters that
+	// select DNS zones by ID or ask for private DNS zones.
+	ErrDNSZoneSelectionNotSupported = errors.New("DNS zone selection is not supported")
+	// ErrNetworkCreationNotSupported defines an error for clusters that are
+	// created without networks.
+	ErrNetworkCreationNotSupported = errors.New("creating cluster networks is not supported, networks must be given")
+)
+
+// Cloud is an implementation of cloudprovider.Interface.
+type Cloud struct {
+	Logger  cloudprovider.Logger
+	compute *compute.Service
+	storage *storage.Service
+	dns     *dns.Service
+	project string
+	region  string
+	// metadataURL is the metadata server instance attributes URL, only
+	// used from a node.
+	metadataURL string
+}
+
+// Compile-time check whether Cloud type value implements
+// cloudprovider.Interface interface.
+var _ cloudprovider.Interface = (*Cloud)(nil)
+
+// ProviderName returns the cloud provider ID.
+func (c *Cloud) ProviderName() string {
+	return ProviderName
+}
+
+// Clusters returns an implementation of Clusters interface for GCE Cloud.
+func (c *Cloud) Clusters() (cloudprovider.Clusters, bool) {
+	return c, true
+}
+
+// NodePooler returns an implementation of NodePooler interface for GCE Cloud.
+func (c *Cloud) NodePooler() (cloudprovider.NodePooler, bool) {
+	return c, true
+}
+
+// Bastions returns nil and false, because bastion pools are not supported on
+// GCE.
+func (c *Cloud) Bastions() (cloudprovider.Bastions, bool) {
+	return nil, false
+}
+
+// Checker returns nil and false, because cluster checks are not supported on
+// GCE.
+func (c *Cloud) Checker() (cloudprovider.Checker, bool) {
+	return nil, false
+}
+
+// CreateClusterInfra creates a new cluster, by creating an assets bucket,
+// firewall rules, reserved internal IPs and persistent disks for masters as
+// well as a TCP load balancer for the Kubernetes API.
+func (c *Cloud) CreateClusterInfra(cluster model.Cluster) error {
+	if err := c.checkRegion(); err != nil {
+		return err
+	}
+	if len(cluster.MasterPool.Networks) == 0 {
+		return errors.New("no networks specified")
+	}
+	if !cloudprovider.DefaultAccess(cluster.Access) {
+		return ErrAccessNotSupported
+	}
+	if !cloudprovider.DefaultLoadBalancerType(cluster.LoadBalancerType) {
+		return ErrLoadBalancerTypeNotSupported
+	}
+	if !cloudprovider.DefaultAPINames(cluster) {
+		return ErrAPINamesNotSupported
+	}
+	if !cloudprovider.PublicDNSZone(cluster) {
+		return ErrDNSZoneSelectionNotSupported
+	}
+	if !cloudprovider.ExistingNetworks(cluster) {
+		return ErrNetworkCreationNotSupported
+	}
+
+	var zoneName string
+	if cluster.DNSZone != "" {
+		z, err := c.getManagedZone(cluster.DNSZone)
+		if err != nil {
+			return err
+		}
+		zoneName = z.Name
+	}
+
+	subnet, err := c.getSubnetwork(cluster.MasterPool.Networks[0])
+	if err != nil {
+		return err
+	}
+	zones, err := c.getZones()
+	if err != nil {
+		return err
+	}
+
+	bucket := makeAssetsBucketName(c.project, cluster.Name)
+	if err := c.createBucket(bucket, makeLabels(cluster.Name, clusterInfraStackType)); err != nil {
+		return err
+	}
+	if err := c.createFirewalls(cluster, subnet); err != nil {
+		return err
+	}
+	for _, n := range getNodesDistributionAcrossZones(zones) {
+		if err := c.createMasterPersistentResources(cluster.Name, n, subnet); err != nil {
+			return err
+		}
+	}
+
+	ip, err := c.createLoadBalancer(cluster, subnet)
+	if err != nil {
+		return err
+	}
+	host := ip
+	if zoneName != "" {
+		host = makeAPIHostname(cluster.Name, cluster.DNSZone)
+		if err := c.createDNSRecord(zoneName, host, ip); err != nil {
+			return err
+		}
+	}
+
+	manifest, err := components.Encode(cluster.Components)
+	if err != nil {
+		return err
+	}
+	outputs := map[string]string{
+		stackTypeOutputKey:        clusterInfraStackType,
+		clusterNameOutputKey:      cluster.Name,
+		internalClusterOutputKey:  strconv.FormatBool(cluster.Internal),
+		labelsOutputKey:           util.StringMapToKVs(cluster.Labels),
+		assetsBucketNameOutputKey: bucket,
+		subnetworkOutputKey:       subnet.SelfLink,
+		dnsZoneOutputKey:          zoneName,
+		apiHostOutputKey:          host,
+		networkProviderOutputKey:  cluster.NetworkProvider,
+		podCIDROutputKey:          cluster.PodCIDR,
+		serviceCIDROutputKey:      cluster.ServiceCIDR,
+		componentsOutputKey:       manifest,
+		registryMirrorOutputKey:   cluster.RegistryMirror,
+		artifactsURLOutputKey:     cluster.ArtifactsURL,
+		httpProxyOutputKey:        cluster.Proxy.HTTPProxy,
+		httpsProxyOutputKey:       cluster.Proxy.HTTPSProxy,
+		noProxyOutputKey:          strings.Join(cluster.Proxy.NoProxy, ","),
+		// Subnetwork CIDR is not proxied by nodes.
+		networkCIDRsOutputKey: subnet.IpCidrRange,
+	}
+	return c.putOutputs(bucket, outputs)
+}
+
+// UpdateClusterInfra updates infra components of an existing cluster.
+func (c *Cloud) UpdateClusterInfra(cluster model.Cluster) error {
+	return ErrNotImplemented
+}
+
+// GetClusters returns a cluster by name or all clusters.
+func (c *Cloud) GetClusters(name string) ([]*model.Cluster, error) {
+	clusters := []*model.Cluster{}
+
+	buckets, err := c.getBucketsByType(clusterInfraStackType)
+	if err != nil {
+		return clusters, err
+	}
+	for _, b := range buckets {
+		if name != "" && b.Labels[clusterNameLabelKey] != name {
+			continue
+		}
+		o, err := c.getOutputs(b.Name)
+		if err != nil {
+			return clusters, err
+		}
+		cl := &model.Cluster{}
+		cl.Name = o[clusterNameOutputKey]
+		cl.Internal, _ = strconv.ParseBool(o[internalClusterOutputKey])
+		cl.Labels = util.KVsToStringMap(strings.Split(o[labelsOutputKey], ","))
+		cl.NetworkProvider = o[networkProviderOutputKey]
+		cl.PodCIDR = o[podCIDROutputKey]
+		cl.ServiceCIDR = o[serviceCIDROutputKey]
+		cl.RegistryMirror = o[registryMirrorOutputKey]
+		cl.ArtifactsURL = o[artifactsURLOutputKey]
+		cl.Proxy.HTTPProxy = o[httpProxyOutputKey]
+		cl.Proxy.HTTPSProxy = o[httpsProxyOutputKey]
+		if o[noProxyOutputKey] != "" {
+			cl.Proxy.NoProxy = strings.Split(o[noProxyOutputKey], ",")
+		}
+		if o[networkCIDRsOutputKey] != "" {
+			cl.NetworkCIDRs = strings.Split(o[networkCIDRsOutputKey], ",")
+		}
+		if o[apiHostOutputKey] != "" {
+			cl.KubeAPIURL = formatKubeAPIURL(o[apiHostOutputKey])
+		}
+		if cl.Components, err = components.Decode(o[componentsOutputKey]); err != nil {
+			return clusters, err
+		}
+		clusters = append(clusters, cl)
+	}
+	return clusters, nil
+}
+
+// DescribeCluster describes a given cluster.
+func (c *Cloud) DescribeCluster(name string) error {
+	return ErrNotImplemented
+}
+
+// DeleteCluster deletes a cluster.
+func (c *Cloud) DeleteCluster(name string) error {
+	if err := c.checkRegion(); err != nil {
+		return err
+	}
+
+	c.Logger.Printf("deleting compute pools that belong to cluster %q", name)
+	if err := c.DeleteComputePool(name, ""); err != nil {
+		return err
+	}
+
+	c.Logger.Printf("deleting master pool that belongs to cluster %q", name)
+	if err := c.DeleteMasterPool(name); err != nil {
+		return err
+	}
+
+	bucket := makeAssetsBucketName(c.project, name)
+	o, err := c.getOutputs(bucket)
+	if err != nil {
+		return err
+	}
+	if o[dnsZoneOutputKey] != "" {
+		if err := c.deleteDNSRecord(o[dnsZoneOutputKey], o[apiHostOutputKey]); err != nil {
+			return err
+		}
+	}
+
+	c.Logger.Printf("deleting load balancer that belongs to cluster %q", name)
+	if err := c.deleteLoadBalancer(name); err != nil {
+		return err
+	}
+	if err := c.deleteFirewalls(name); err != nil {
+		return err
+	}
+	if err := c.deleteMasterPersistentResources(name); err != nil {
+		return err
+	}
+	return c.deleteBucket(bucket)
+}
+
+// GetMasterPersistentIPs returns a map of master persistent NodeID
+// values and private IPs for a given clusterName.
+func (c *Cloud) GetMasterPersistentIPs(clusterName string) (map[string]string, error) {
+	m := make(map[string]string)
+
+	if err := c.checkRegion(); err != nil {
+		return m, err
+	}
+	addresses, err := c.describeMasterAddresses(clusterName)
+	if err != nil {
+		return m, err
+	}
+	for _, a := range addresses {
+		id := getAddressNodeID(clusterName, a.Name)
+		if id == "" {
+			continue
+		}
+		m[id] = a.Address
+	}
+	return m, nil
+}
+
+// PushAssets pushes assets to a GCS bucket.
+func (c *Cloud) PushAssets(clusterName string, a model.Assets) error {
+	bucket := makeAssetsBucketName(c.project, clusterName)
+
+	assets := map[string][]byte{
+		etcdCACertObjectName: a.EtcdCACert,
+		etcdCAKeyObjectName:  a.EtcdCAKey,
+		kubeCACertObjectName: a.KubeCACert,
+		kubeCAKeyObjectName:  a.KubeCAKey,
+	}
+	names := []string{}
+	for n := range assets {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	for _, n := range names {
+		if err := c.putObject(bucket, n, assets[n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkRegion returns an error if the region is not set.
+func (c *Cloud) checkRegion() error {
+	if c.region == "" {
+		return fmt.Errorf("%s must be set", regionEnv)
+	}
+	return nil
+}
+
+// makeLabels returns a map of labels set on keto managed resources.
+func makeLabels(clusterName, stackType string) map[string]string {
+	return map[string]string{
+		managedByKetoLabelKey: managedByKetoLabelValue,
+		clusterNameLabelKey:   clusterName,
+		stackTypeLabelKey:     stackType,
+	}
+}
+
+// isManaged returns true if labels match a given stack type and are managed
+// by keto.
+func isManaged(labels map[string]string, stackType string) bool {
+	return labels[managedByKetoLabelKey] == managedByKetoLabelValue &&
+		labels[stackTypeLabelKey] == stackType
+}
+
+// lastSegment returns the last path segment of a resource URL, which is
+// usually the resource name.
+func lastSegment(url string) string {
+	return url[strings.LastIndex(url, "/")+1:]
+}
+
+// init registers GCE cloud with the cloudprovider.
+func init() {
+	// f knows how to initialize the cloud
+	f := func(l cloudprovider.Logger) (cloudprovider.Interface, error) {
+		ctx := context.Background()
+		creds, err := google.FindDefaultCredentials(ctx,
+			compute.ComputeScope,
+			storage.DevstorageReadWriteScope,
+			dns.NdevClouddnsReadwriteScope,
+		)
+		if err != nil {
+			return &Cloud{}, err
+		}
+
+		project := os.Getenv(projectEnv)
+		if project == "" {
+			project = creds.ProjectID
+		}
+		if project == "" {
+			return &Cloud{}, fmt.Errorf("%s must be set", projectEnv)
+		}
+
+		return newCloud(ctx, l, project, os.Getenv(regionEnv), option.WithCredentials(creds))
+	}
+	cloudprovider.Register(ProviderName, f)
+}
+
+// newCloud creates a new instance of GCE Cloud.
+func newCloud(ctx context.Context, l cloudprovider.Logger, project, region string, opts ...option.ClientOption) (*Cloud, error) {
+	computeService, err := compute.NewService(ctx, opts...)
+	if err != nil {
+		return &Cloud{}, err
+	}
+	storageService, err := storage.NewService(ctx, opts...)
+	if err != nil {
+		return &Cloud{}, err
+	}
+	dnsService, err := dns.NewService(ctx, opts...)
+	if err != nil {
+		return &Cloud{}, err
+	}
+
+	c := &Cloud{
+		Logger:      l,
+		compute:     computeService,
+		storage:     storageService,
+		dns:         dnsService,
+		project:     project,
+		region:      region,
+		metadataURL: defaultMetadataURL,
+	}
+	return c, nil
+}
+
+// isNotFound returns true if err is a not found API error.
+func isNotFound(err error) bool {
+	if e, ok := err.(*googleapi.Error); ok {
+		return e.Code == http.StatusNotFound
+	}
+	return false
+}
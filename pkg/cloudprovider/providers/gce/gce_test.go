@@ -0,0 +1,361 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/UKHomeOffice/keto/pkg/model"
+	"github.com/UKHomeOffice/keto/testutil"
+
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/dns/v1"
+	"google.golang.org/api/option"
+	"google.golang.org/api/storage/v1"
+)
+
+const (
+	project     = "proj"
+	region      = "europe-west2"
+	clusterName = "foo"
+
+	outputsJSON = `{
+  "ClusterName": "foo",
+  "InternalCluster": "true",
+  "Labels": "env=test",
+  "AssetsBucketName": "proj-keto-foo-assets",
+  "Subnetwork": "https://compute/projects/proj/regions/europe-west2/subnetworks/subnet0",
+  "APIHost": "10.0.0.100"
+}`
+
+	doneOperationJSON = `{"name": "op", "status": "DONE"}`
+)
+
+// newTestCloud returns a Cloud with all service clients pointing at a local
+// fake of the Compute, Storage and DNS REST APIs.
+func newTestCloud(t *testing.T, mux *http.ServeMux) (*Cloud, func()) {
+	server := httptest.NewServer(mux)
+	ctx := context.Background()
+
+	opts := func(path string) []option.ClientOption {
+		return []option.ClientOption{
+			option.WithEndpoint(server.URL + path),
+			option.WithHTTPClient(server.Client()),
+		}
+	}
+	computeService, err := compute.NewService(ctx, opts("/compute/v1/")...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storageService, err := storage.NewService(ctx, opts("/storage/v1/")...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dnsService, err := dns.NewService(ctx, opts("/dns/v1/")...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Cloud{
+		Logger:      log.New(ioutil.Discard, "", 0),
+		compute:     computeService,
+		storage:     storageService,
+		dns:         dnsService,
+		project:     project,
+		region:      region,
+		metadataURL: server.URL + "/computeMetadata/v1/instance/attributes/",
+	}
+	return c, server.Close
+}
+
+func writeJSON(w http.ResponseWriter, s string) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, s)
+}
+
+func TestGetNodesDistributionAcrossZones(t *testing.T) {
+	cases := []struct {
+		zones    []string
+		expected int
+	}{
+		{[]string{}, 0},
+		{[]string{"a"}, 3},
+		{[]string{"a", "b"}, 5},
+		{[]string{"a", "b", "c"}, 5},
+		{[]string{"a", "b", "c", "d", "e", "f"}, 7},
+	}
+	for _, c := range cases {
+		dist := getNodesDistributionAcrossZones(c.zones)
+		if len(dist) != c.expected {
+			t.Errorf("expected %d nodes for zones %v, got %d", c.expected, c.zones, len(dist))
+		}
+	}
+}
+
+func TestGetAddressNodeID(t *testing.T) {
+	cases := map[string]string{
+		"keto-foo-master0":  "0",
+		"keto-foo-master12": "12",
+		"keto-foo-api":      "",
+		"keto-foo-masterx":  "",
+		"keto-bar-master0":  "",
+	}
+	for name, expected := range cases {
+		if id := getAddressNodeID(clusterName, name); id != expected {
+			t.Errorf("expected %q for %q, got %q", expected, name, id)
+		}
+	}
+}
+
+func TestGetClusters(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/storage/v1/b", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, `{"items": [
+  {"name": "proj-keto-foo-assets", "labels": {"managed-by-keto": "true", "cluster-name": "foo", "stack-type": "infra"}},
+  {"name": "unmanaged"}
+]}`)
+	})
+	mux.HandleFunc("/storage/v1/b/proj-keto-foo-assets/o/outputs.json", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, outputsJSON)
+	})
+
+	c, done := newTestCloud(t, mux)
+	defer done()
+
+	clusters, err := c.GetClusters("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clusters) != 1 {
+		t.Fatalf("expected 1 cluster, got %d", len(clusters))
+	}
+
+	expected := &model.Cluster{}
+	expected.Name = clusterName
+	expected.Internal = true
+	expected.Labels = model.Labels{"env": "test"}
+	expected.KubeAPIURL = "https://10.0.0.100"
+	if !reflect.DeepEqual(clusters[0], expected) {
+		t.Errorf("expected %#v, got %#v", expected, clusters[0])
+	}
+}
+
+func TestGetMasterPersistentIPs(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/compute/v1/projects/proj/regions/europe-west2/addresses", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, `{"items": [
+  {"name": "keto-foo-master0", "address": "10.0.0.10"},
+  {"name": "keto-foo-master1", "address": "10.0.0.11"},
+  {"name": "keto-foo-api", "address": "10.0.0.100"}
+]}`)
+	})
+
+	c, done := newTestCloud(t, mux)
+	defer done()
+
+	ips, err := c.GetMasterPersistentIPs(clusterName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"0": "10.0.0.10",
+		"1": "10.0.0.11",
+	}
+	if !reflect.DeepEqual(ips, expected) {
+		t.Errorf("expected %v, got %v", expected, ips)
+	}
+}
+
+func TestMakeMasterInstanceConfigs(t *testing.T) {
+	disks := []*compute.Disk{
+		{Name: "keto-foo-etcd0", Zone: "zones/europe-west2-a", SelfLink: "disks/etcd0", Labels: map[string]string{"keto-node-id": "0"}},
+		{Name: "keto-foo-etcd1", Zone: "zones/europe-west2-b", SelfLink: "disks/etcd1", Labels: map[string]string{"keto-node-id": "1"}},
+		{Name: "keto-foo-etcd2", Zone: "zones/europe-west2-a", SelfLink: "disks/etcd2", Labels: map[string]string{"keto-node-id": "2"}},
+	}
+	addresses := []*compute.Address{
+		{Name: "keto-foo-master0", Address: "10.0.0.10", SelfLink: "addresses/master0"},
+		{Name: "keto-foo-master1", Address: "10.0.0.11", SelfLink: "addresses/master1"},
+		{Name: "keto-foo-master2", Address: "10.0.0.12", SelfLink: "addresses/master2"},
+	}
+
+	configs, err := makeMasterInstanceConfigs(clusterName, disks, addresses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs["europe-west2-a"]) != 2 || len(configs["europe-west2-b"]) != 1 {
+		t.Fatalf("unexpected instance configs per zone %v", configs)
+	}
+	ic := configs["europe-west2-a"][1]
+	if ic.Name != "keto-foo-master2" {
+		t.Errorf("unexpected instance name %q", ic.Name)
+	}
+	if d := ic.PreservedState.Disks["keto-persistent"]; d.Source != "disks/etcd2" || d.AutoDelete != "NEVER" {
+		t.Errorf("unexpected preserved disk %#v", d)
+	}
+	if ip := ic.PreservedState.InternalIPs["nic0"]; ip.IpAddress.Address != "addresses/master2" {
+		t.Errorf("unexpected preserved IP %#v", ip.IpAddress)
+	}
+	expected := map[string]string{"keto-node-id": "2", "keto-node-ip": "10.0.0.12"}
+	if !reflect.DeepEqual(ic.PreservedState.Metadata, expected) {
+		t.Errorf("expected metadata %v, got %v", expected, ic.PreservedState.Metadata)
+	}
+
+	if _, err := makeMasterInstanceConfigs(clusterName, disks, addresses[:2]); err == nil {
+		t.Error("expected an error on a missing persistent address")
+	}
+}
+
+func TestCreateComputePool(t *testing.T) {
+	var template compute.InstanceTemplate
+	var group compute.InstanceGroupManager
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/storage/v1/b/proj-keto-foo-assets/o/outputs.json", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, outputsJSON)
+	})
+	mux.HandleFunc("/compute/v1/projects/proj/regions/europe-west2/subnetworks/network0", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, `{"name": "network0", "selfLink": "subnetworks/network0"}`)
+	})
+	mux.HandleFunc("/compute/v1/projects/coreos-cloud/global/images", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, `{"items": [
+  {"name": "coreos-beta-1409-1-0-v20170601", "selfLink": "images/old"},
+  {"name": "coreos-beta-1409-1-0-v20170701", "selfLink": "images/new"}
+]}`)
+	})
+	mux.HandleFunc("/compute/v1/projects/proj/global/instanceTemplates", func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&template); err != nil {
+			t.Error(err)
+		}
+		writeJSON(w, doneOperationJSON)
+	})
+	mux.HandleFunc("/compute/v1/projects/proj/regions/europe-west2/instanceGroupManagers", func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&group); err != nil {
+			t.Error(err)
+		}
+		writeJSON(w, doneOperationJSON)
+	})
+
+	c, done := newTestCloud(t, mux)
+	defer done()
+
+	p := model.ComputePool{NodePool: testutil.MakeNodePool(clusterName, "compute")}
+	if err := c.CreateComputePool(p); err != nil {
+		t.Fatal(err)
+	}
+
+	if template.Name != "keto-foo-compute-blue" {
+		t.Errorf("unexpected instance template name %q", template.Name)
+	}
+	if img := template.Properties.Disks[0].InitializeParams.SourceImage; img != "images/new" {
+		t.Errorf("expected the most recent image, got %q", img)
+	}
+	o := getTemplateOutputs(&template)
+	if o[kubeAPIURLOutputKey] != "https://10.0.0.100" {
+		t.Errorf("unexpected kube API URL %q", o[kubeAPIURLOutputKey])
+	}
+	if o[poolNameOutputKey] != "compute" {
+		t.Errorf("unexpected pool name %q", o[poolNameOutputKey])
+	}
+	if group.TargetSize != 1 || group.InstanceTemplate != "global/instanceTemplates/keto-foo-compute-blue" {
+		t.Errorf("unexpected instance group %#v", group)
+	}
+}
+
+func TestGetComputePools(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/compute/v1/projects/proj/global/instanceTemplates", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, `{"items": [{
+  "name": "keto-foo-compute-blue",
+  "properties": {
+    "labels": {"managed-by-keto": "true", "cluster-name": "foo", "stack-type": "computepool"},
+    "metadata": {"items": [
+      {"key": "user-data", "value": "#cloud-config"},
+      {"key": "ClusterName", "value": "foo"},
+      {"key": "PoolName", "value": "compute"},
+      {"key": "DiskSize", "value": "10"},
+      {"key": "Labels", "value": "a=b"}
+    ]}
+  }
+}]}`)
+	})
+
+	c, done := newTestCloud(t, mux)
+	defer done()
+
+	pools, err := c.GetComputePools(clusterName, "compute")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pools) != 1 {
+		t.Fatalf("expected 1 pool, got %d", len(pools))
+	}
+	if pools[0].DiskSize != 10 || pools[0].Labels["a"] != "b" {
+		t.Errorf("unexpected compute pool %#v", pools[0])
+	}
+
+	masters, err := c.GetMasterPools(clusterName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(masters) != 0 {
+		t.Errorf("expected no master pools, got %d", len(masters))
+	}
+}
+
+func TestGetNodeData(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/computeMetadata/v1/instance/attributes/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			http.Error(w, "missing Metadata-Flavor header", http.StatusForbidden)
+			return
+		}
+		writeJSON(w, `{
+  "user-data": "#cloud-config",
+  "ClusterName": "foo",
+  "KubeAPIURL": "https://kube-foo.example.com",
+  "KubeVersion": "v1.7.0",
+  "Labels": "role=compute",
+  "Taints": ""
+}`)
+	})
+
+	c, done := newTestCloud(t, mux)
+	defer done()
+
+	data, err := c.GetNodeData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := model.NodeData{
+		ClusterName: clusterName,
+		KubeAPIURL:  "https://kube-foo.example.com",
+		KubeVersion: "v1.7.0",
+		Labels:      model.Labels{"role": "compute"},
+		Taints:      model.Taints{},
+	}
+	if !reflect.DeepEqual(data, expected) {
+		t.Errorf("expected %#v, got %#v", expected, data)
+	}
+}
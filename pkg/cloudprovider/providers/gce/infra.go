@@ -0,0 +1,327 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/UKHomeOffice/keto/pkg/model"
+
+	"google.golang.org/api/compute/v1"
+)
+
+const (
+	operationStatusDone = "DONE"
+
+	// persistentDiskSize is a size in GB of master persistent disks, used
+	// by etcd.
+	persistentDiskSize = 10
+)
+
+// Source ranges of GCE load balancer health checks.
+var healthCheckSourceRanges = []string{"35.191.0.0/16", "130.211.0.0/22"}
+
+// nodesZone is a persistent master node placement in a zone.
+type nodesZone struct {
+	Zone   string
+	NodeID int
+}
+
+// getNodesDistributionAcrossZones calculates a number of nodes per zone,
+// given a list of zones. Single zone setup gets 3 nodes. Multi-zone setup
+// get at least 5 nodes or more, always an odd number in total.
+func getNodesDistributionAcrossZones(zones []string) []nodesZone {
+	sort.Strings(zones)
+
+	dist := []nodesZone{}
+	if len(zones) == 0 {
+		return dist
+	}
+
+	total := 3
+	if len(zones) > 1 {
+		total = len(zones)
+		if total < 5 {
+			total = 5
+		}
+		if total%2 == 0 {
+			total++
+		}
+	}
+	for i := 0; i < total; i++ {
+		dist = append(dist, nodesZone{Zone: zones[i%len(zones)], NodeID: i})
+	}
+	return dist
+}
+
+// getZones returns a list of zone names in the region.
+func (c *Cloud) getZones() ([]string, error) {
+	r, err := c.compute.Regions.Get(c.project, c.region).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe region %q: %v", c.region, err)
+	}
+	zones := []string{}
+	for _, z := range r.Zones {
+		zones = append(zones, lastSegment(z))
+	}
+	sort.Strings(zones)
+	return zones, nil
+}
+
+// getSubnetwork returns a subnetwork in the region given its name.
+func (c *Cloud) getSubnetwork(name string) (*compute.Subnetwork, error) {
+	c.Logger.Printf("describing subnetwork %q", name)
+	s, err := c.compute.Subnetworks.Get(c.project, c.region, lastSegment(name)).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe subnetwork %q: %v", name, err)
+	}
+	return s, nil
+}
+
+// makeMasterTag returns a network tag of master nodes.
+func makeMasterTag(clusterName string) string {
+	return fmt.Sprintf("keto-%s-master", clusterName)
+}
+
+// makeComputeTag returns a network tag of compute nodes.
+func makeComputeTag(clusterName string) string {
+	return fmt.Sprintf("keto-%s-compute", clusterName)
+}
+
+// firewallNames returns names of all cluster firewall rules.
+func firewallNames(clusterName string) []string {
+	return []string{
+		fmt.Sprintf("keto-%s-ssh", clusterName),
+		fmt.Sprintf("keto-%s-api", clusterName),
+		fmt.Sprintf("keto-%s-internal", clusterName),
+	}
+}
+
+// createFirewalls creates cluster firewall rules. SSH is allowed to all
+// nodes, API to master nodes and all traffic between cluster nodes.
+func (c *Cloud) createFirewalls(cluster model.Cluster, subnet *compute.Subnetwork) error {
+	names := firewallNames(cluster.Name)
+	tags := []string{makeMasterTag(cluster.Name), makeComputeTag(cluster.Name)}
+
+	// Load balanced traffic preserves client IPs.
+	apiSourceRanges := []string{"0.0.0.0/0"}
+	if cluster.Internal {
+		apiSourceRanges = append([]string{subnet.IpCidrRange}, healthCheckSourceRanges...)
+	}
+
+	rules := []*compute.Firewall{
+		{
+			Name:         names[0],
+			Network:      subnet.Network,
+			SourceRanges: []string{"0.0.0.0/0"},
+			TargetTags:   tags,
+			Allowed:      []*compute.FirewallAllowed{{IPProtocol: "tcp", Ports: []string{"22"}}},
+		},
+		{
+			Name:         names[1],
+			Network:      subnet.Network,
+			SourceRanges: apiSourceRanges,
+			TargetTags:   []string{makeMasterTag(cluster.Name)},
+			Allowed:      []*compute.FirewallAllowed{{IPProtocol: "tcp", Ports: []string{"443"}}},
+		},
+		{
+			// TODO: not all traffic needs to be allowed.
+			Name:       names[2],
+			Network:    subnet.Network,
+			SourceTags: tags,
+			TargetTags: tags,
+			Allowed:    []*compute.FirewallAllowed{{IPProtocol: "all"}},
+		},
+	}
+	for _, r := range rules {
+		c.Logger.Printf("creating firewall rule %q", r.Name)
+		r.Description = fmt.Sprintf("Kubernetes cluster %s, managed by keto", cluster.Name)
+		if err := c.wait(c.compute.Firewalls.Insert(c.project, r).Do()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteFirewalls deletes cluster firewall rules.
+func (c *Cloud) deleteFirewalls(clusterName string) error {
+	for _, n := range firewallNames(clusterName) {
+		c.Logger.Printf("deleting firewall rule %q", n)
+		if err := ignoreNotFound(c.wait(c.compute.Firewalls.Delete(c.project, n).Do())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// makeMasterAddressName returns a name of a master persistent IP address.
+func makeMasterAddressName(clusterName string, nodeID int) string {
+	return fmt.Sprintf("keto-%s-master%d", clusterName, nodeID)
+}
+
+// makeMasterDiskName returns a name of a master persistent disk.
+func makeMasterDiskName(clusterName string, nodeID int) string {
+	return fmt.Sprintf("keto-%s-etcd%d", clusterName, nodeID)
+}
+
+// getAddressNodeID extracts a NodeID from a master persistent IP address
+// name. Returns an empty string if the name does not match.
+func getAddressNodeID(clusterName, name string) string {
+	prefix := fmt.Sprintf("keto-%s-master", clusterName)
+	if !strings.HasPrefix(name, prefix) {
+		return ""
+	}
+	id := strings.TrimPrefix(name, prefix)
+	if _, err := strconv.Atoi(id); err != nil {
+		return ""
+	}
+	return id
+}
+
+// createMasterPersistentResources reserves an internal IP address and
+// creates a persistent disk for a master node.
+func (c *Cloud) createMasterPersistentResources(clusterName string, n nodesZone, subnet *compute.Subnetwork) error {
+	a := &compute.Address{
+		Name:        makeMasterAddressName(clusterName, n.NodeID),
+		Description: fmt.Sprintf("Kubernetes cluster %s master, managed by keto", clusterName),
+		AddressType: "INTERNAL",
+		Subnetwork:  subnet.SelfLink,
+	}
+	c.Logger.Printf("reserving address %q", a.Name)
+	if err := c.wait(c.compute.Addresses.Insert(c.project, c.region, a).Do()); err != nil {
+		return err
+	}
+
+	labels := makeLabels(clusterName, clusterInfraStackType)
+	// Master instances are matched to their disk by NodeID.
+	labels[nodeIDLabelKey] = strconv.Itoa(n.NodeID)
+	d := &compute.Disk{
+		Name:   makeMasterDiskName(clusterName, n.NodeID),
+		SizeGb: persistentDiskSize,
+		Type:   fmt.Sprintf("zones/%s/diskTypes/pd-ssd", n.Zone),
+		Labels: labels,
+	}
+	c.Logger.Printf("creating disk %q in zone %q", d.Name, n.Zone)
+	return c.wait(c.compute.Disks.Insert(c.project, n.Zone, d).Do())
+}
+
+// describeMasterAddresses returns a list of master persistent IP addresses.
+func (c *Cloud) describeMasterAddresses(clusterName string) ([]*compute.Address, error) {
+	list := []*compute.Address{}
+	err := c.compute.Addresses.List(c.project, c.region).Pages(context.Background(), func(l *compute.AddressList) error {
+		for _, a := range l.Items {
+			if getAddressNodeID(clusterName, a.Name) != "" {
+				list = append(list, a)
+			}
+		}
+		return nil
+	})
+	return list, err
+}
+
+// describeMasterDisks returns a list of master persistent disks across all
+// zones in the region.
+func (c *Cloud) describeMasterDisks(clusterName string) ([]*compute.Disk, error) {
+	list := []*compute.Disk{}
+
+	zones, err := c.getZones()
+	if err != nil {
+		return list, err
+	}
+	for _, z := range zones {
+		call := c.compute.Disks.List(c.project, z).Filter(fmt.Sprintf("labels.%s=%s", clusterNameLabelKey, clusterName))
+		err := call.Pages(context.Background(), func(l *compute.DiskList) error {
+			for _, d := range l.Items {
+				if isManaged(d.Labels, clusterInfraStackType) && d.Labels[clusterNameLabelKey] == clusterName {
+					list = append(list, d)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return list, err
+		}
+	}
+	return list, nil
+}
+
+// deleteMasterPersistentResources deletes master persistent IP addresses
+// and disks.
+func (c *Cloud) deleteMasterPersistentResources(clusterName string) error {
+	addresses, err := c.describeMasterAddresses(clusterName)
+	if err != nil {
+		return err
+	}
+	for _, a := range addresses {
+		c.Logger.Printf("releasing address %q", a.Name)
+		if err := ignoreNotFound(c.wait(c.compute.Addresses.Delete(c.project, c.region, a.Name).Do())); err != nil {
+			return err
+		}
+	}
+
+	disks, err := c.describeMasterDisks(clusterName)
+	if err != nil {
+		return err
+	}
+	for _, d := range disks {
+		c.Logger.Printf("deleting disk %q", d.Name)
+		if err := ignoreNotFound(c.wait(c.compute.Disks.Delete(c.project, lastSegment(d.Zone), d.Name).Do())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wait waits for a compute operation to complete. An error is returned if
+// either the API call or the operation fails.
+func (c *Cloud) wait(op *compute.Operation, err error) error {
+	if err != nil {
+		return err
+	}
+	for op.Status != operationStatusDone {
+		time.Sleep(2 * time.Second)
+
+		switch {
+		case op.Zone != "":
+			op, err = c.compute.ZoneOperations.Get(c.project, lastSegment(op.Zone), op.Name).Do()
+		case op.Region != "":
+			op, err = c.compute.RegionOperations.Get(c.project, lastSegment(op.Region), op.Name).Do()
+		default:
+			op, err = c.compute.GlobalOperations.Get(c.project, op.Name).Do()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if op.Error != nil && len(op.Error.Errors) > 0 {
+		return fmt.Errorf("operation %q failed: %s", op.Name, op.Error.Errors[0].Message)
+	}
+	return nil
+}
+
+// ignoreNotFound returns nil if err is a not found API error.
+func ignoreNotFound(err error) error {
+	if isNotFound(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/UKHomeOffice/keto/pkg/model"
+
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/dns/v1"
+)
+
+// makeLoadBalancerName returns a name that is shared by all Kubernetes API
+// load balancer resources.
+func makeLoadBalancerName(clusterName string) string {
+	return fmt.Sprintf("keto-%s-api", clusterName)
+}
+
+// makeAPIHostname returns a Kubernetes API DNS name.
+func makeAPIHostname(clusterName, dnsZone string) string {
+	return fmt.Sprintf("kube-%s.%s", clusterName, dnsZone)
+}
+
+// loadBalancingScheme returns a load balancing scheme depending on whether
+// the cluster is internal.
+func loadBalancingScheme(internal bool) string {
+	if internal {
+		return "INTERNAL"
+	}
+	return "EXTERNAL"
+}
+
+// createLoadBalancer creates a regional TCP load balancer for the Kubernetes
+// API and returns its IP address. Master instance groups are added as
+// backends when the master pool is created.
+func (c *Cloud) createLoadBalancer(cluster model.Cluster, subnet *compute.Subnetwork) (string, error) {
+	name := makeLoadBalancerName(cluster.Name)
+	scheme := loadBalancingScheme(cluster.Internal)
+
+	c.Logger.Printf("creating load balancer %q", name)
+	hc := &compute.HealthCheck{
+		Name:               name,
+		Type:               "TCP",
+		TcpHealthCheck:     &compute.TCPHealthCheck{Port: 443},
+		CheckIntervalSec:   10,
+		TimeoutSec:         5,
+		HealthyThreshold:   2,
+		UnhealthyThreshold: 2,
+	}
+	if err := c.wait(c.compute.RegionHealthChecks.Insert(c.project, c.region, hc).Do()); err != nil {
+		return "", err
+	}
+	hc, err := c.compute.RegionHealthChecks.Get(c.project, c.region, name).Do()
+	if err != nil {
+		return "", err
+	}
+
+	bs := &compute.BackendService{
+		Name:                name,
+		Protocol:            "TCP",
+		LoadBalancingScheme: scheme,
+		HealthChecks:        []string{hc.SelfLink},
+	}
+	if err := c.wait(c.compute.RegionBackendServices.Insert(c.project, c.region, bs).Do()); err != nil {
+		return "", err
+	}
+	bs, err = c.compute.RegionBackendServices.Get(c.project, c.region, name).Do()
+	if err != nil {
+		return "", err
+	}
+
+	addr := &compute.Address{
+		Name:        name,
+		Description: fmt.Sprintf("Kubernetes cluster %s API, managed by keto", cluster.Name),
+		AddressType: scheme,
+	}
+	if cluster.Internal {
+		addr.Subnetwork = subnet.SelfLink
+	}
+	if err := c.wait(c.compute.Addresses.Insert(c.project, c.region, addr).Do()); err != nil {
+		return "", err
+	}
+	addr, err = c.compute.Addresses.Get(c.project, c.region, name).Do()
+	if err != nil {
+		return "", err
+	}
+
+	fr := &compute.ForwardingRule{
+		Name:                name,
+		IPAddress:           addr.Address,
+		IPProtocol:          "TCP",
+		Ports:               []string{"443"},
+		LoadBalancingScheme: scheme,
+		BackendService:      bs.SelfLink,
+	}
+	if cluster.Internal {
+		fr.Network = subnet.Network
+		fr.Subnetwork = subnet.SelfLink
+	}
+	if err := c.wait(c.compute.ForwardingRules.Insert(c.project, c.region, fr).Do()); err != nil {
+		return "", err
+	}
+	return addr.Address, nil
+}
+
+// deleteLoadBalancer deletes Kubernetes API load balancer resources.
+func (c *Cloud) deleteLoadBalancer(clusterName string) error {
+	name := makeLoadBalancerName(clusterName)
+
+	if err := ignoreNotFound(c.wait(c.compute.ForwardingRules.Delete(c.project, c.region, name).Do())); err != nil {
+		return err
+	}
+	if err := ignoreNotFound(c.wait(c.compute.Addresses.Delete(c.project, c.region, name).Do())); err != nil {
+		return err
+	}
+	if err := ignoreNotFound(c.wait(c.compute.RegionBackendServices.Delete(c.project, c.region, name).Do())); err != nil {
+		return err
+	}
+	return ignoreNotFound(c.wait(c.compute.RegionHealthChecks.Delete(c.project, c.region, name).Do()))
+}
+
+// setLoadBalancerBackends replaces Kubernetes API load balancer backends
+// with given instance groups.
+func (c *Cloud) setLoadBalancerBackends(clusterName string, groups []string) error {
+	name := makeLoadBalancerName(clusterName)
+
+	backends := []*compute.Backend{}
+	for _, g := range groups {
+		backends = append(backends, &compute.Backend{Group: g, BalancingMode: "CONNECTION"})
+	}
+	bs := &compute.BackendService{
+		Backends: backends,
+		// Make sure an empty list of backends is sent as well.
+		ForceSendFields: []string{"Backends"},
+	}
+	c.Logger.Printf("updating load balancer %q backends to %v", name, groups)
+	return c.wait(c.compute.RegionBackendServices.Patch(c.project, c.region, name, bs).Do())
+}
+
+// getManagedZone returns a Cloud DNS managed zone given its DNS name.
+func (c *Cloud) getManagedZone(dnsName string) (*dns.ManagedZone, error) {
+	resp, err := c.dns.ManagedZones.List(c.project).DnsName(dnsName + ".").Do()
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.ManagedZones) == 0 {
+		return nil, fmt.Errorf("dns zone %q does not exist", dnsName)
+	}
+	return resp.ManagedZones[0], nil
+}
+
+// createDNSRecord creates an A record in a managed zone.
+func (c *Cloud) createDNSRecord(zone, host, ip string) error {
+	c.Logger.Printf("creating dns record %q", host)
+	change := &dns.Change{
+		Additions: []*dns.ResourceRecordSet{
+			{Name: host + ".", Type: "A", Ttl: 300, Rrdatas: []string{ip}},
+		},
+	}
+	_, err := c.dns.Changes.Create(c.project, zone, change).Do()
+	return err
+}
+
+// deleteDNSRecord deletes an A record from a managed zone. Records that do
+// not exist are ignored.
+func (c *Cloud) deleteDNSRecord(zone, host string) error {
+	name := host
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	resp, err := c.dns.ResourceRecordSets.List(c.project, zone).Name(name).Type("A").Do()
+	if err != nil {
+		return ignoreNotFound(err)
+	}
+	if len(resp.Rrsets) == 0 {
+		return nil
+	}
+
+	c.Logger.Printf("deleting dns record %q", host)
+	change := &dns.Change{Deletions: resp.Rrsets}
+	_, err = c.dns.Changes.Create(c.project, zone, change).Do()
+	return err
+}
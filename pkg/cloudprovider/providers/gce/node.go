@@ -0,0 +1,121 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/UKHomeOffice/keto/pkg/cloudprovider"
+	"github.com/UKHomeOffice/keto/pkg/keto/util"
+	"github.com/UKHomeOffice/keto/pkg/model"
+)
+
+// defaultMetadataURL is the GCE metadata server instance attributes URL.
+const defaultMetadataURL = "http://metadata.google.internal/computeMetadata/v1/instance/attributes/?recursive=true"
+
+// Node returns an implementation of Node interface for GCE Cloud.
+func (c *Cloud) Node() (cloudprovider.Node, bool) {
+	return c, true
+}
+
+// GetNodeData returns model.NodeData which contains information like node
+// labels, kube version, etc.
+func (c *Cloud) GetNodeData() (model.NodeData, error) {
+	var data model.NodeData
+
+	o, err := c.getInstanceAttributes()
+	if err != nil {
+		return data, err
+	}
+
+	data.KubeAPIURL = o[kubeAPIURLOutputKey]
+	data.ClusterName = o[clusterNameOutputKey]
+	data.KubeVersion = o[kubeVersionOutputKey]
+	data.Labels = util.KVsToStringMap(strings.Split(o[labelsOutputKey], ","))
+	data.Taints = util.KVsToStringMap(strings.Split(o[taintsOutputKey], ","))
+	data.KubeletExtraArgs = o[kubeletExtraArgsOutputKey]
+	data.APIServerExtraArgs = o[apiServerExtraArgsOutputKey]
+	data.ControllerManagerExtraArgs = o[controllerManagerExtraArgsOutputKey]
+	data.SchedulerExtraArgs = o[schedulerExtraArgsOutputKey]
+
+	return data, nil
+}
+
+// GetAssets gets assets from a cloud.
+func (c *Cloud) GetAssets() (model.Assets, error) {
+	var a model.Assets
+
+	o, err := c.getInstanceAttributes()
+	if err != nil {
+		return a, err
+	}
+	bucket := o[assetsBucketNameOutputKey]
+
+	etcdCACert, err := c.getObject(bucket, etcdCACertObjectName)
+	if err != nil {
+		return a, err
+	}
+	etcdCAKey, err := c.getObject(bucket, etcdCAKeyObjectName)
+	if err != nil {
+		return a, err
+	}
+	kubeCACert, err := c.getObject(bucket, kubeCACertObjectName)
+	if err != nil {
+		return a, err
+	}
+	kubeCAKey, err := c.getObject(bucket, kubeCAKeyObjectName)
+	if err != nil {
+		return a, err
+	}
+
+	a.EtcdCAKey = etcdCAKey
+	a.EtcdCACert = etcdCACert
+	a.KubeCAKey = kubeCAKey
+	a.KubeCACert = kubeCACert
+
+	return a, nil
+}
+
+// getInstanceAttributes returns instance metadata attributes from the GCE
+// metadata server. Node pool outputs are stored as instance attributes.
+// Should only be used from a node.
+func (c *Cloud) getInstanceAttributes() (map[string]string, error) {
+	attrs := make(map[string]string)
+
+	req, err := http.NewRequest("GET", c.metadataURL, nil)
+	if err != nil {
+		return attrs, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return attrs, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return attrs, fmt.Errorf("metadata server returned %s", resp.Status)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&attrs)
+	return attrs, err
+}
@@ -0,0 +1,561 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/UKHomeOffice/keto/pkg/cloudprovider"
+	"github.com/UKHomeOffice/keto/pkg/keto/util"
+	"github.com/UKHomeOffice/keto/pkg/model"
+	"github.com/UKHomeOffice/keto/pkg/userdata"
+
+	"google.golang.org/api/compute/v1"
+)
+
+const (
+	// coreOSImageProject is a project CoreOS images are published in.
+	coreOSImageProject = "coreos-cloud"
+
+	userDataMetadataKey = "user-data"
+	sshKeysMetadataKey  = "ssh-keys"
+	sshUser             = "core"
+
+	// nodeIPMetadataKey is a master instance metadata key of a persistent IP,
+	// read by the master cloud-config along with nodeIDLabelKey.
+	nodeIPMetadataKey = "keto-node-ip"
+	// persistentDiskDeviceName is a device name master persistent disks are
+	// attached as, i.e. /dev/disk/by-id/google-keto-persistent.
+	persistentDiskDeviceName = "keto-persistent"
+)
+
+// makeMasterPoolName returns master pool instance template name for either
+// blue or green pool.
+func makeMasterPoolName(clusterName, part string) string {
+	if part == "" {
+		part = blueStack
+	}
+	return fmt.Sprintf("keto-%s-%s-%s", clusterName, masterPoolStackType, part)
+}
+
+// makeComputePoolName returns compute pool instance template name for either
+// blue or green pool.
+func makeComputePoolName(clusterName, name, part string) string {
+	if part == "" {
+		part = blueStack
+	}
+	return fmt.Sprintf("keto-%s-%s-%s", clusterName, name, part)
+}
+
+// CreateMasterPool creates a master node pool. A zonal managed instance
+// group is created in each zone that has master persistent disks. Each
+// instance keeps a persistent disk and IP address as its stateful config.
+func (c *Cloud) CreateMasterPool(p model.MasterPool) error {
+	if err := c.checkRegion(); err != nil {
+		return err
+	}
+	o, err := c.getOutputs(makeAssetsBucketName(c.project, p.ClusterName))
+	if err != nil {
+		return err
+	}
+	if o[clusterNameOutputKey] == "" {
+		return fmt.Errorf("infra of cluster %q not found", p.ClusterName)
+	}
+
+	// Master nodes must be created in the same zones as persistent disks.
+	disks, err := c.describeMasterDisks(p.ClusterName)
+	if err != nil {
+		return err
+	}
+	addresses, err := c.describeMasterAddresses(p.ClusterName)
+	if err != nil {
+		return err
+	}
+	nodesPerZone, err := makeMasterInstanceConfigs(p.ClusterName, disks, addresses)
+	if err != nil {
+		return err
+	}
+	zones := []string{}
+	for z := range nodesPerZone {
+		zones = append(zones, z)
+	}
+	sort.Strings(zones)
+
+	outputs := makeNodePoolOutputs(p.NodePool, masterPoolStackType, formatKubeAPIURL(o[apiHostOutputKey]))
+	outputs[assetsBucketNameOutputKey] = o[assetsBucketNameOutputKey]
+
+	name := makeMasterPoolName(p.ClusterName, "")
+	scopes := []string{compute.ComputeScope, compute.DevstorageReadOnlyScope}
+	if err := c.createInstanceTemplate(name, p.NodePool, masterPoolStackType, makeMasterTag(p.ClusterName), o[subnetworkOutputKey], scopes, outputs); err != nil {
+		return err
+	}
+
+	groups := []string{}
+	for _, z := range zones {
+		m := &compute.InstanceGroupManager{
+			Name:             makeMasterInstanceGroupName(name, z),
+			BaseInstanceName: makeMasterTag(p.ClusterName),
+			InstanceTemplate: "global/instanceTemplates/" + name,
+		}
+		c.Logger.Printf("creating instance group %q in zone %q", m.Name, z)
+		if err := c.wait(c.compute.InstanceGroupManagers.Insert(c.project, z, m).Do()); err != nil {
+			return err
+		}
+		req := &compute.InstanceGroupManagersCreateInstancesRequest{Instances: nodesPerZone[z]}
+		if err := c.wait(c.compute.InstanceGroupManagers.CreateInstances(c.project, z, m.Name, req).Do()); err != nil {
+			return err
+		}
+		m, err := c.compute.InstanceGroupManagers.Get(c.project, z, m.Name).Do()
+		if err != nil {
+			return err
+		}
+		groups = append(groups, m.InstanceGroup)
+	}
+	return c.setLoadBalancerBackends(p.ClusterName, groups)
+}
+
+// makeMasterInstanceConfigs returns master instance configs per zone given
+// master persistent disks and addresses. Instances are named after their
+// NodeID and get the NodeID and IP as metadata.
+func makeMasterInstanceConfigs(clusterName string, disks []*compute.Disk, addresses []*compute.Address) (map[string][]*compute.PerInstanceConfig, error) {
+	ips := make(map[string]*compute.Address)
+	for _, a := range addresses {
+		ips[getAddressNodeID(clusterName, a.Name)] = a
+	}
+
+	configs := make(map[string][]*compute.PerInstanceConfig)
+	for _, d := range disks {
+		id := d.Labels[nodeIDLabelKey]
+		a, ok := ips[id]
+		if !ok {
+			return configs, fmt.Errorf("persistent address of master node %q not found", id)
+		}
+		zone := lastSegment(d.Zone)
+		configs[zone] = append(configs[zone], &compute.PerInstanceConfig{
+			Name: fmt.Sprintf("%s%s", makeMasterTag(clusterName), id),
+			PreservedState: &compute.PreservedState{
+				Disks: map[string]compute.PreservedStatePreservedDisk{
+					persistentDiskDeviceName: {Source: d.SelfLink, Mode: "READ_WRITE", AutoDelete: "NEVER"},
+				},
+				InternalIPs: map[string]compute.PreservedStatePreservedNetworkIp{
+					"nic0": {
+						IpAddress:  &compute.PreservedStatePreservedNetworkIpIpAddress{Address: a.SelfLink},
+						AutoDelete: "NEVER",
+					},
+				},
+				Metadata: map[string]string{
+					nodeIDLabelKey:    id,
+					nodeIPMetadataKey: a.Address,
+				},
+			},
+		})
+	}
+	for _, list := range configs {
+		sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	}
+	return configs, nil
+}
+
+// CreateComputePool creates a compute node pool as a regional managed
+// instance group.
+func (c *Cloud) CreateComputePool(p model.ComputePool) error {
+	if err := c.checkRegion(); err != nil {
+		return err
+	}
+	if len(p.Networks) == 0 {
+		return fmt.Errorf("no networks specified")
+	}
+	if !cloudprovider.OnDemandOnly(p.InstancesPolicy) {
+		return ErrInstancesPolicyNotSupported
+	}
+	if !cloudprovider.FixedSize(p.NodePoolSpec) {
+		return ErrAutoscalingNotSupported
+	}
+	if len(p.AllowFromPools) > 0 {
+		return ErrAllowFromPoolsNotSupported
+	}
+	o, err := c.getOutputs(makeAssetsBucketName(c.project, p.ClusterName))
+	if err != nil {
+		return err
+	}
+	if o[clusterNameOutputKey] == "" {
+		return fmt.Errorf("infra of cluster %q not found", p.ClusterName)
+	}
+	subnet, err := c.getSubnetwork(p.Networks[0])
+	if err != nil {
+		return err
+	}
+
+	outputs := makeNodePoolOutputs(p.NodePool, computePoolStackType, formatKubeAPIURL(o[apiHostOutputKey]))
+
+	name := makeComputePoolName(p.ClusterName, p.Name, "")
+	scopes := []string{compute.ComputeReadonlyScope, compute.DevstorageReadOnlyScope}
+	if err := c.createInstanceTemplate(name, p.NodePool, computePoolStackType, makeComputeTag(p.ClusterName), subnet.SelfLink, scopes, outputs); err != nil {
+		return err
+	}
+
+	m := &compute.InstanceGroupManager{
+		Name:             name,
+		BaseInstanceName: fmt.Sprintf("keto-%s-%s", p.ClusterName, p.Name),
+		InstanceTemplate: "global/instanceTemplates/" + name,
+		TargetSize:       int64(p.Size),
+	}
+	c.Logger.Printf("creating instance group %q", m.Name)
+	return c.wait(c.compute.RegionInstanceGroupManagers.Insert(c.project, c.region, m).Do())
+}
+
+// GetMasterPools returns a list of master pools. Pools can be filtered by
+// their name / cluster.
+func (c *Cloud) GetMasterPools(clusterName, name string) ([]*model.MasterPool, error) {
+	pools := []*model.MasterPool{}
+
+	templates, err := c.getInstanceTemplatesByType(masterPoolStackType)
+	if err != nil {
+		return pools, err
+	}
+	for _, t := range templates {
+		np, ok, err := nodePoolFromOutputs(getTemplateOutputs(t), clusterName, name)
+		if err != nil {
+			return pools, err
+		}
+		if ok {
+			pools = append(pools, &model.MasterPool{NodePool: np})
+		}
+	}
+	return pools, nil
+}
+
+// GetComputePools returns a list of compute pools. Pools can be filtered by
+// their name / cluster.
+func (c *Cloud) GetComputePools(clusterName, name string) ([]*model.ComputePool, error) {
+	pools := []*model.ComputePool{}
+
+	templates, err := c.getInstanceTemplatesByType(computePoolStackType)
+	if err != nil {
+		return pools, err
+	}
+	for _, t := range templates {
+		np, ok, err := nodePoolFromOutputs(getTemplateOutputs(t), clusterName, name)
+		if err != nil {
+			return pools, err
+		}
+		if ok {
+			pools = append(pools, &model.ComputePool{NodePool: np})
+		}
+	}
+	return pools, nil
+}
+
+// DescribeNodePool describes a node pool.
+func (c *Cloud) DescribeNodePool() error {
+	return ErrNotImplemented
+}
+
+// GetPoolInstances returns a list of node pool instances.
+func (c *Cloud) GetPoolInstances(clusterName, poolName string) ([]*model.Instance, error) {
+	return nil, ErrNotImplemented
+}
+
+// GetInstanceConsoleOutput returns console output of an instance.
+func (c *Cloud) GetInstanceConsoleOutput(instanceID string) (string, error) {
+	return "", ErrNotImplemented
+}
+
+// UpgradeMasterPool upgrades a master node pool.
+func (c *Cloud) UpgradeMasterPool(p model.MasterPool) error {
+	return ErrNotImplemented
+}
+
+// UpgradeComputePool upgrades a compute node pool.
+func (c *Cloud) UpgradeComputePool(p model.ComputePool) error {
+	return ErrNotImplemented
+}
+
+// DeleteMasterPool deletes a master node pool.
+func (c *Cloud) DeleteMasterPool(clusterName string) error {
+	if err := c.checkRegion(); err != nil {
+		return err
+	}
+	templates, err := c.getInstanceTemplatesByType(masterPoolStackType)
+	if err != nil {
+		return err
+	}
+	zones, err := c.getZones()
+	if err != nil {
+		return err
+	}
+
+	for _, t := range templates {
+		if t.Properties.Labels[clusterNameLabelKey] != clusterName {
+			continue
+		}
+		// Instance groups can not be deleted while they are load balancer
+		// backends.
+		if err := ignoreNotFound(c.setLoadBalancerBackends(clusterName, nil)); err != nil {
+			return err
+		}
+		for _, z := range zones {
+			n := makeMasterInstanceGroupName(t.Name, z)
+			c.Logger.Printf("deleting instance group %q in zone %q", n, z)
+			if err := ignoreNotFound(c.wait(c.compute.InstanceGroupManagers.Delete(c.project, z, n).Do())); err != nil {
+				return err
+			}
+		}
+		if err := c.deleteInstanceTemplate(t.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteComputePool deletes a compute node pool. All compute pools of the
+// cluster are deleted if name is empty.
+func (c *Cloud) DeleteComputePool(clusterName, name string) error {
+	if err := c.checkRegion(); err != nil {
+		return err
+	}
+	templates, err := c.getInstanceTemplatesByType(computePoolStackType)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range templates {
+		o := getTemplateOutputs(t)
+		if o[clusterNameOutputKey] != clusterName {
+			continue
+		}
+		if name != "" && o[poolNameOutputKey] != name {
+			continue
+		}
+		c.Logger.Printf("deleting instance group %q", t.Name)
+		if err := ignoreNotFound(c.wait(c.compute.RegionInstanceGroupManagers.Delete(c.project, c.region, t.Name).Do())); err != nil {
+			return err
+		}
+		if err := c.deleteInstanceTemplate(t.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// makeMasterInstanceGroupName returns a zonal master instance group name.
+func makeMasterInstanceGroupName(templateName, zone string) string {
+	return templateName + "-" + zone
+}
+
+// createInstanceTemplate creates an instance template. Node pool outputs are
+// stored as template metadata items, so nodes can read them from the
+// metadata server.
+func (c *Cloud) createInstanceTemplate(
+	name string,
+	p model.NodePool,
+	stackType string,
+	tag string,
+	subnetwork string,
+	scopes []string,
+	outputs map[string]string,
+) error {
+	image, err := c.getImage(p.CoreOSVersion)
+	if err != nil {
+		return err
+	}
+
+	items := []*compute.MetadataItems{
+		{Key: userDataMetadataKey, Value: stringPtr(string(p.UserData))},
+	}
+	if p.SSHKey != "" {
+		items = append(items, &compute.MetadataItems{Key: sshKeysMetadataKey, Value: stringPtr(sshUser + ":" + p.SSHKey)})
+	}
+	keys := []string{}
+	for k := range outputs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		items = append(items, &compute.MetadataItems{Key: k, Value: stringPtr(outputs[k])})
+	}
+
+	ni := &compute.NetworkInterface{Subnetwork: subnetwork}
+	if !p.Internal {
+		ni.AccessConfigs = []*compute.AccessConfig{{Name: "External NAT", Type: "ONE_TO_ONE_NAT"}}
+	}
+
+	t := &compute.InstanceTemplate{
+		Name:        name,
+		Description: fmt.Sprintf("Kubernetes cluster %s %s, managed by keto", p.ClusterName, stackType),
+		Properties: &compute.InstanceProperties{
+			MachineType: p.MachineType,
+			Labels:      makeLabels(p.ClusterName, stackType),
+			Tags:        &compute.Tags{Items: []string{tag}},
+			Metadata:    &compute.Metadata{Items: items},
+			Disks: []*compute.AttachedDisk{
+				{
+					Boot:       true,
+					AutoDelete: true,
+					InitializeParams: &compute.AttachedDiskInitializeParams{
+						SourceImage: image,
+						DiskSizeGb:  int64(p.DiskSize),
+					},
+				},
+			},
+			NetworkInterfaces: []*compute.NetworkInterface{ni},
+			ServiceAccounts: []*compute.ServiceAccount{
+				{Email: "default", Scopes: scopes},
+			},
+		},
+	}
+	c.Logger.Printf("creating instance template %q", name)
+	return c.wait(c.compute.InstanceTemplates.Insert(c.project, t).Do())
+}
+
+// deleteInstanceTemplate deletes an instance template. Templates that do not
+// exist are ignored.
+func (c *Cloud) deleteInstanceTemplate(name string) error {
+	c.Logger.Printf("deleting instance template %q", name)
+	return ignoreNotFound(c.wait(c.compute.InstanceTemplates.Delete(c.project, name).Do()))
+}
+
+// getInstanceTemplatesByType returns a list of keto managed instance
+// templates by type.
+func (c *Cloud) getInstanceTemplatesByType(t string) ([]*compute.InstanceTemplate, error) {
+	list := []*compute.InstanceTemplate{}
+	err := c.compute.InstanceTemplates.List(c.project).Pages(context.Background(), func(l *compute.InstanceTemplateList) error {
+		for _, it := range l.Items {
+			if it.Properties != nil && isManaged(it.Properties.Labels, t) {
+				list = append(list, it)
+			}
+		}
+		return nil
+	})
+	return list, err
+}
+
+// getTemplateOutputs returns node pool outputs of an instance template.
+func getTemplateOutputs(t *compute.InstanceTemplate) map[string]string {
+	o := make(map[string]string)
+	if t.Properties == nil || t.Properties.Metadata == nil {
+		return o
+	}
+	for _, i := range t.Properties.Metadata.Items {
+		if i.Key == userDataMetadataKey || i.Key == sshKeysMetadataKey || i.Value == nil {
+			continue
+		}
+		o[i.Key] = *i.Value
+	}
+	return o
+}
+
+// makeNodePoolOutputs returns node pool outputs.
+func makeNodePoolOutputs(p model.NodePool, stackType, kubeAPIURL string) map[string]string {
+	return map[string]string{
+		stackTypeOutputKey:                  stackType,
+		clusterNameOutputKey:                p.ClusterName,
+		poolNameOutputKey:                   p.Name,
+		coreOSVersionOutputKey:              p.CoreOSVersion,
+		kubeAPIURLOutputKey:                 kubeAPIURL,
+		machineTypeOutputKey:                p.MachineType,
+		kubeVersionOutputKey:                p.KubeVersion,
+		diskSizeOutputKey:                   strconv.Itoa(p.DiskSize),
+		labelsOutputKey:                     util.StringMapToKVs(p.Labels),
+		taintsOutputKey:                     util.StringMapToKVs(p.Taints),
+		internalClusterOutputKey:            strconv.FormatBool(p.Internal),
+		kubeletExtraArgsOutputKey:           p.KubeletExtraArgs,
+		apiServerExtraArgsOutputKey:         p.APIServerExtraArgs,
+		controllerManagerExtraArgsOutputKey: p.ControllerManagerExtraArgs,
+		schedulerExtraArgsOutputKey:         p.SchedulerExtraArgs,
+		userDataHashOutputKey:               userdata.Hash(p.UserData),
+	}
+}
+
+// nodePoolFromOutputs returns a model.NodePool made from node pool outputs.
+// It returns false if the outputs do not match the clusterName / name filters.
+func nodePoolFromOutputs(o map[string]string, clusterName, name string) (model.NodePool, bool, error) {
+	p := model.NodePool{}
+	if clusterName != "" && o[clusterNameOutputKey] != clusterName {
+		return p, false, nil
+	}
+	if name != "" && o[poolNameOutputKey] != name {
+		return p, false, nil
+	}
+
+	p.ClusterName = o[clusterNameOutputKey]
+	p.Name = o[poolNameOutputKey]
+	p.KubeVersion = o[kubeVersionOutputKey]
+	p.CoreOSVersion = o[coreOSVersionOutputKey]
+	p.MachineType = o[machineTypeOutputKey]
+	p.KubeletExtraArgs = o[kubeletExtraArgsOutputKey]
+	p.APIServerExtraArgs = o[apiServerExtraArgsOutputKey]
+	p.ControllerManagerExtraArgs = o[controllerManagerExtraArgsOutputKey]
+	p.SchedulerExtraArgs = o[schedulerExtraArgsOutputKey]
+	p.UserDataHash = o[userDataHashOutputKey]
+	if v := o[diskSizeOutputKey]; v != "" {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return p, false, err
+		}
+		p.DiskSize = i
+	}
+	p.Internal, _ = strconv.ParseBool(o[internalClusterOutputKey])
+	p.Labels = util.KVsToStringMap(strings.Split(o[labelsOutputKey], ","))
+	p.Taints = util.KVsToStringMap(strings.Split(o[taintsOutputKey], ","))
+	return p, true, nil
+}
+
+// getImage returns an image URL given a CoreOS version name, e.g.
+// CoreOS-stable-1409.7.0-hvm. Image URLs are returned as is.
+func (c *Cloud) getImage(name string) (string, error) {
+	if strings.Contains(name, "/") {
+		return name, nil
+	}
+
+	// CoreOS-stable-1409.7.0-hvm is published as coreos-stable-1409-7-0-vYYYYMMDD.
+	prefix := strings.ToLower(strings.TrimSuffix(name, "-hvm"))
+	prefix = strings.Replace(prefix, ".", "-", -1)
+
+	images := []*compute.Image{}
+	call := c.compute.Images.List(coreOSImageProject).Filter(fmt.Sprintf("name eq %s-v.*", prefix))
+	err := call.Pages(context.Background(), func(l *compute.ImageList) error {
+		for _, i := range l.Items {
+			if strings.HasPrefix(i.Name, prefix+"-v") {
+				images = append(images, i)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(images) == 0 {
+		return "", fmt.Errorf("image %q not found", name)
+	}
+	// Pick the most recent build.
+	sort.Slice(images, func(i, j int) bool { return images[i].Name > images[j].Name })
+	return images[0].SelfLink, nil
+}
+
+func formatKubeAPIURL(host string) string {
+	// For some reason kubernetes does not like mixed-case dns names.
+	return "https://" + strings.ToLower(host)
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
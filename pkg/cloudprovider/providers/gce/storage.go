@@ -0,0 +1,125 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"google.golang.org/api/storage/v1"
+)
+
+// makeAssetsBucketName returns an assets bucket name. Bucket names are
+// global, so the project is part of the name.
+func makeAssetsBucketName(project, clusterName string) string {
+	return fmt.Sprintf("%s-keto-%s-assets", project, clusterName)
+}
+
+// createBucket creates a regional GCS bucket.
+func (c *Cloud) createBucket(name string, labels map[string]string) error {
+	c.Logger.Printf("creating bucket %q", name)
+	b := &storage.Bucket{
+		Name:     name,
+		Location: c.region,
+		Labels:   labels,
+	}
+	_, err := c.storage.Buckets.Insert(c.project, b).Do()
+	return err
+}
+
+// getBucketsByType returns a list of keto managed buckets by stack type.
+func (c *Cloud) getBucketsByType(t string) ([]*storage.Bucket, error) {
+	list := []*storage.Bucket{}
+	err := c.storage.Buckets.List(c.project).Pages(context.Background(), func(l *storage.Buckets) error {
+		for _, b := range l.Items {
+			if isManaged(b.Labels, t) {
+				list = append(list, b)
+			}
+		}
+		return nil
+	})
+	return list, err
+}
+
+// deleteBucket deletes all objects in a bucket and the bucket itself.
+// Buckets that do not exist are ignored.
+func (c *Cloud) deleteBucket(name string) error {
+	c.Logger.Printf("deleting bucket %q", name)
+	err := c.storage.Objects.List(name).Pages(context.Background(), func(l *storage.Objects) error {
+		for _, o := range l.Items {
+			if err := c.storage.Objects.Delete(name, o.Name).Do(); err != nil && !isNotFound(err) {
+				return err
+			}
+		}
+		return nil
+	})
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := c.storage.Buckets.Delete(name).Do(); err != nil && !isNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// putObject uploads b as objectName into a bucket.
+func (c *Cloud) putObject(bucket, objectName string, b []byte) error {
+	c.Logger.Printf("uploading object %q to bucket %q", objectName, bucket)
+	_, err := c.storage.Objects.Insert(bucket, &storage.Object{Name: objectName}).Media(bytes.NewReader(b)).Do()
+	return err
+}
+
+// getObject downloads objectName from a bucket.
+func (c *Cloud) getObject(bucket, objectName string) ([]byte, error) {
+	c.Logger.Printf("fetching object %q from bucket %q", objectName, bucket)
+	resp, err := c.storage.Objects.Get(bucket, objectName).Download()
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+// putOutputs stores cluster infra outputs in a bucket.
+func (c *Cloud) putOutputs(bucket string, outputs map[string]string) error {
+	b, err := json.Marshal(outputs)
+	if err != nil {
+		return err
+	}
+	return c.putObject(bucket, outputsObjectName, b)
+}
+
+// getOutputs returns cluster infra outputs from a bucket. Empty outputs are
+// returned if they do not exist.
+func (c *Cloud) getOutputs(bucket string) (map[string]string, error) {
+	outputs := make(map[string]string)
+	b, err := c.getObject(bucket, outputsObjectName)
+	if isNotFound(err) {
+		return outputs, nil
+	}
+	if err != nil {
+		return outputs, err
+	}
+	err = json.Unmarshal(b, &outputs)
+	return outputs, err
+}
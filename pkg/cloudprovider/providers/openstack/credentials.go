@@ -0,0 +1,148 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/applicationcredentials"
+)
+
+const (
+	// nodeCredentialObjectName is a swift object that holds the application
+	// credential nodes use, so that pools created later can pass it on.
+	nodeCredentialObjectName = "node_credential.json"
+
+	// Server metadata keys that nodes read their credential from.
+	authURLMetadataKey          = "keto-auth-url"
+	regionMetadataKey           = "keto-region"
+	credentialIDMetadataKey     = "keto-credential-id"
+	credentialSecretMetadataKey = "keto-credential-secret"
+)
+
+// nodeCredential is a keystone application credential that nodes use to
+// read their stack outputs and cluster assets.
+type nodeCredential struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
+}
+
+// makeNodeCredentialName returns a name of a cluster node credential.
+func makeNodeCredentialName(clusterName string) string {
+	return fmt.Sprintf("keto-%s-nodes", clusterName)
+}
+
+// makeNodeAccessRules returns access rules of a cluster node credential.
+// Nodes may only read keto stacks and the assets container of the cluster.
+func makeNodeAccessRules(clusterName, container string) []applicationcredentials.AccessRule {
+	stacks := fmt.Sprintf("/v1/*/stacks/keto-%s-*", clusterName)
+	return []applicationcredentials.AccessRule{
+		{Service: "orchestration", Method: "GET", Path: stacks},
+		{Service: "orchestration", Method: "GET", Path: stacks + "/*"},
+		{Service: "object-store", Method: "GET", Path: fmt.Sprintf("/v1/*/%s/*", container)},
+	}
+}
+
+// createNodeCredential creates an application credential for cluster nodes
+// and stores it in the cluster assets container.
+func (c *Cloud) createNodeCredential(clusterName, container string) error {
+	name := makeNodeCredentialName(clusterName)
+	c.Logger.Printf("creating application credential %q", name)
+	opts := applicationcredentials.CreateOpts{
+		Name:        name,
+		Description: fmt.Sprintf("Kubernetes cluster %s nodes, managed by keto", clusterName),
+		AccessRules: makeNodeAccessRules(clusterName, container),
+	}
+	ac, err := applicationcredentials.Create(c.identity, c.userID, opts).Extract()
+	if err != nil {
+		return fmt.Errorf("failed to create application credential %q: %v", name, err)
+	}
+
+	b, err := json.Marshal(nodeCredential{ID: ac.ID, Secret: ac.Secret})
+	if err != nil {
+		return err
+	}
+	return c.putObject(container, nodeCredentialObjectName, b)
+}
+
+// getNodeCredential returns a cluster node credential from the cluster
+// assets container.
+func (c *Cloud) getNodeCredential(container string) (nodeCredential, error) {
+	var nc nodeCredential
+	b, err := c.getObject(container, nodeCredentialObjectName)
+	if err != nil {
+		return nc, fmt.Errorf("failed to get node credential: %v", err)
+	}
+	err = json.Unmarshal(b, &nc)
+	return nc, err
+}
+
+// deleteNodeCredential deletes a cluster node credential. Credentials can
+// only be listed by the user who created them, so a credential that isn't
+// found is left behind with a warning.
+func (c *Cloud) deleteNodeCredential(clusterName string) error {
+	name := makeNodeCredentialName(clusterName)
+	pages, err := applicationcredentials.List(c.identity, c.userID, applicationcredentials.ListOpts{Name: name}).AllPages()
+	if err != nil {
+		return err
+	}
+	list, err := applicationcredentials.ExtractApplicationCredentials(pages)
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		c.Logger.Printf("application credential %q not found, it may belong to another user", name)
+		return nil
+	}
+	for _, ac := range list {
+		c.Logger.Printf("deleting application credential %q", name)
+		err := applicationcredentials.Delete(c.identity, c.userID, ac.ID).ExtractErr()
+		if _, ok := err.(gophercloud.ErrDefault404); ok {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nodeStackParameters returns heat parameters that pass a node credential
+// on to pool servers.
+func nodeStackParameters(nc nodeCredential) map[string]interface{} {
+	return map[string]interface{}{
+		"NodeCredentialID":     nc.ID,
+		"NodeCredentialSecret": nc.Secret,
+	}
+}
+
+// nodeAuthOptions returns auth options and a region of a node credential
+// given a node instance metadata.
+func nodeAuthOptions(m instanceMetadata) (gophercloud.AuthOptions, string, error) {
+	opts := gophercloud.AuthOptions{
+		IdentityEndpoint:            m.Meta[authURLMetadataKey],
+		ApplicationCredentialID:     m.Meta[credentialIDMetadataKey],
+		ApplicationCredentialSecret: m.Meta[credentialSecretMetadataKey],
+		AllowReauth:                 true,
+	}
+	if opts.IdentityEndpoint == "" || opts.ApplicationCredentialID == "" || opts.ApplicationCredentialSecret == "" {
+		return opts, "", fmt.Errorf("node credential not found in instance metadata")
+	}
+	return opts, m.Meta[regionMetadataKey], nil
+}
@@ -0,0 +1,433 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/UKHomeOffice/keto/pkg/constants"
+	"github.com/UKHomeOffice/keto/pkg/keto/util"
+	"github.com/UKHomeOffice/keto/pkg/model"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/subnets"
+	"github.com/gophercloud/gophercloud/openstack/orchestration/v1/stacks"
+)
+
+const (
+	blueStack = "blue"
+
+	// Stack Outputs key names. These match the AWS provider, so both
+	// providers store the same data about clusters and node pools.
+	stackTypeOutputKey                  = "StackType"
+	clusterNameOutputKey                = "ClusterName"
+	poolNameOutputKey                   = "PoolName"
+	coreOSVersionOutputKey              = "CoreOSVersion"
+	kubeVersionOutputKey                = "KubeVersion"
+	kubeAPIURLOutputKey                 = "KubeAPIURL"
+	machineTypeOutputKey                = "MachineType"
+	diskSizeOutputKey                   = "DiskSize"
+	assetsBucketNameOutputKey           = "AssetsBucketName"
+	internalClusterOutputKey            = "InternalCluster"
+	labelsOutputKey                     = "Labels"
+	taintsOutputKey                     = "Taints"
+	kubeletExtraArgsOutputKey           = "KubeletExtraArgs"
+	apiServerExtraArgsOutputKey         = "APIServerExtraArgs"
+	controllerManagerExtraArgsOutputKey = "ControllerManagerExtraArgs"
+	schedulerExtraArgsOutputKey         = "SchedulerExtraArgs"
+	userDataHashOutputKey               = "UserDataHash"
+	masterPoolSGOutputKey               = "MasterPoolSG"
+	computePoolSGOutputKey              = "ComputePoolSG"
+	apiAddressOutputKey                 = "APIAddress"
+	lbPoolOutputKey                     = "LoadBalancerPool"
+	networkProviderOutputKey            = "NetworkProvider"
+	podCIDROutputKey                    = "PodCIDR"
+	serviceCIDROutputKey                = "ServiceCIDR"
+	componentsOutputKey                 = "Components"
+	registryMirrorOutputKey             = "RegistryMirror"
+	artifactsURLOutputKey               = "ArtifactsURL"
+	httpProxyOutputKey                  = "HTTPProxy"
+	httpsProxyOutputKey                 = "HTTPSProxy"
+	noProxyOutputKey                    = "NoProxy"
+	networkCIDRsOutputKey               = "NetworkCIDRs"
+	volumeOutputKeyPrefix               = "Volume"
+
+	clusterInfraStackType = "infra"
+	loadBalancerStackType = "lb"
+	masterPoolStackType   = "masterpool"
+	computePoolStackType  = "computepool"
+
+	stackStatusCompleteSuffix   = "COMPLETE"
+	stackStatusInProgressSuffix = "IN_PROGRESS"
+	stackStatusFailedSuffix     = "FAILED"
+	stackStatusDeleteComplete   = "DELETE_COMPLETE"
+)
+
+// networkSGRule is a SG ingress rule that a CNI provider needs. Rules without
+// ports allow all traffic of a protocol.
+type networkSGRule struct {
+	Name     string
+	Protocol string
+	PortMin  int
+	PortMax  int
+}
+
+// networkProviderSGRules are SG rules that allow compute pools to reach
+// masters via CNI overlay, master to compute and in-pool traffic is already
+// allowed.
+var networkProviderSGRules = map[string][]networkSGRule{
+	constants.NetworkProviderCanal: {
+		{Name: "VXLAN", Protocol: "udp", PortMin: 8472, PortMax: 8472},
+	},
+	constants.NetworkProviderFlannel: {
+		{Name: "VXLAN", Protocol: "udp", PortMin: 8472, PortMax: 8472},
+	},
+	constants.NetworkProviderCalico: {
+		{Name: "BGP", Protocol: "tcp", PortMin: 179, PortMax: 179},
+		{Name: "IPIP", Protocol: "4"},
+	},
+	constants.NetworkProviderWeave: {
+		{Name: "WeaveTCP", Protocol: "tcp", PortMin: 6783, PortMax: 6783},
+		{Name: "WeaveUDP", Protocol: "udp", PortMin: 6783, PortMax: 6784},
+	},
+}
+
+// stack is a heat stack.
+type stack struct {
+	stacks.RetrievedStack
+}
+
+// outputs returns stack outputs as a map of output keys to values.
+func (s *stack) outputs() map[string]string {
+	m := make(map[string]string)
+	for _, o := range s.Outputs {
+		k, ok := o["output_key"].(string)
+		if !ok {
+			continue
+		}
+		switch v := o["output_value"].(type) {
+		case string:
+			m[k] = v
+		case nil:
+			m[k] = ""
+		default:
+			m[k] = fmt.Sprintf("%v", v)
+		}
+	}
+	return m
+}
+
+// isManaged returns true if the stack is tagged as managed by keto.
+func (s *stack) isManaged() bool {
+	for _, t := range s.Tags {
+		if t == managedByKetoTag {
+			return true
+		}
+	}
+	return false
+}
+
+// getStackLabels returns model.Labels of a given stack.
+func getStackLabels(s *stack) model.Labels {
+	if v, ok := s.outputs()[labelsOutputKey]; ok {
+		return util.KVsToStringMap(strings.Split(v, ","))
+	}
+	return nil
+}
+
+// getStackTaints returns model.Taints of a given stack.
+func getStackTaints(s *stack) model.Taints {
+	if v, ok := s.outputs()[taintsOutputKey]; ok {
+		return util.KVsToStringMap(strings.Split(v, ","))
+	}
+	return nil
+}
+
+// getStack returns a stack given its name or ID. A nil stack is returned if
+// the stack does not exist.
+func (c *Cloud) getStack(name string) (*stack, error) {
+	s, err := stacks.Find(c.heat, name).Extract()
+	if err != nil {
+		if _, ok := err.(gophercloud.ErrDefault404); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &stack{RetrievedStack: *s}, nil
+}
+
+// getStacksByType returns a list of keto managed stacks by type.
+func (c *Cloud) getStacksByType(t string) ([]*stack, error) {
+	list := []*stack{}
+
+	opts := stacks.ListOpts{
+		Tags: strings.Join([]string{managedByKetoTag, makeTag(stackTypeTagKey, t)}, ","),
+	}
+	pages, err := stacks.List(c.heat, opts).AllPages()
+	if err != nil {
+		return list, err
+	}
+	listed, err := stacks.ExtractStacks(pages)
+	if err != nil {
+		return list, err
+	}
+
+	// Stack outputs are not part of a list response, each stack has to be
+	// retrieved on its own.
+	for _, l := range listed {
+		s, err := c.getStack(l.Name)
+		if err != nil {
+			return list, err
+		}
+		if s == nil || !s.isManaged() {
+			continue
+		}
+		list = append(list, s)
+	}
+	return list, nil
+}
+
+// makeStackTags returns a list of heat stack tags.
+func makeStackTags(clusterName, stackType string) []string {
+	return []string{
+		managedByKetoTag,
+		makeTag(clusterNameTagKey, clusterName),
+		makeTag(stackTypeTagKey, stackType),
+	}
+}
+
+// createStack creates a new stack and waits for completion. If stack creation
+// fails, an error is returned.
+func (c *Cloud) createStack(name string, tags []string, templateBody string, params map[string]interface{}) error {
+	opts := stacks.CreateOpts{
+		Name:       name,
+		Tags:       tags,
+		Parameters: params,
+		TemplateOpts: &stacks.Template{
+			TE: stacks.TE{Bin: []byte(templateBody)},
+		},
+	}
+
+	c.Logger.Printf("creating stack %q", name)
+	if _, err := stacks.Create(c.heat, opts).Extract(); err != nil {
+		return fmt.Errorf("failed to create %q stack: %v", name, err)
+	}
+	return c.waitForStackOperationCompletion(name)
+}
+
+// deleteStack deletes a stack and waits for the deletion to complete. Stacks
+// that do not exist are ignored.
+func (c *Cloud) deleteStack(name string) error {
+	s, err := c.getStack(name)
+	if err != nil || s == nil {
+		return err
+	}
+
+	c.Logger.Printf("deleting stack %q", name)
+	if err := stacks.Delete(c.heat, s.Name, s.ID).ExtractErr(); err != nil {
+		return err
+	}
+	return c.waitForStackOperationCompletion(s.ID)
+}
+
+// waitForStackOperationCompletion returns an error if a stack
+// create/update/delete operation fails. Otherwise an error returned is nil.
+func (c *Cloud) waitForStackOperationCompletion(name string) error {
+	for {
+		s, err := c.getStack(name)
+		switch {
+		case err != nil:
+			return err
+		// stack is gone
+		case s == nil || s.Status == stackStatusDeleteComplete:
+			return nil
+		// wait for any status that is in progress to complete
+		case strings.HasSuffix(s.Status, stackStatusInProgressSuffix):
+			// do nothing
+		// a failed status is always treated as a failure
+		case strings.HasSuffix(s.Status, stackStatusFailedSuffix):
+			return fmt.Errorf("stack %q operation failed: %s", s.Name, s.StatusReason)
+		// and finally a complete status is treated as a success
+		case strings.HasSuffix(s.Status, stackStatusCompleteSuffix):
+			return nil
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// nodesNetwork is a persistent master node placement in a network. Port,
+// IP and Volume are only known once the cluster infra stack exists.
+type nodesNetwork struct {
+	Subnet  string
+	Network string
+	NodeID  int
+	Port    string
+	IP      string
+	Volume  string
+}
+
+// makeVolumeOutputKey returns an infra stack output key of a master node
+// persistent volume ID.
+func makeVolumeOutputKey(nodeID int) string {
+	return fmt.Sprintf("%s%d", volumeOutputKeyPrefix, nodeID)
+}
+
+// getNodesDistributionAcrossNetworks calculates a number of nodes per network,
+// given a list of networks. Single network setup gets 3 nodes. Multi-network
+// setup get at least 5 nodes or more, always an odd number in total.
+func getNodesDistributionAcrossNetworks(list []subnets.Subnet) []nodesNetwork {
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+
+	dist := []nodesNetwork{}
+	if len(list) == 0 {
+		return dist
+	}
+
+	total := 3
+	if len(list) > 1 {
+		total = len(list)
+		if total < 5 {
+			total = 5
+		}
+		if total%2 == 0 {
+			total++
+		}
+	}
+	for i := 0; i < total; i++ {
+		s := list[i%len(list)]
+		dist = append(dist, nodesNetwork{Subnet: s.ID, Network: s.NetworkID, NodeID: i})
+	}
+	return dist
+}
+
+// makeClusterInfraStackName returns cluster infra stack name.
+func makeClusterInfraStackName(clusterName string) string {
+	return fmt.Sprintf("keto-%s-%s", clusterName, clusterInfraStackType)
+}
+
+// makeLoadBalancerStackName returns load balancer stack name.
+func makeLoadBalancerStackName(clusterName string) string {
+	return fmt.Sprintf("keto-%s-%s", clusterName, loadBalancerStackType)
+}
+
+// makeMasterPoolStackName returns master stack name for either blue or green stack.
+func makeMasterPoolStackName(clusterName, part string) string {
+	if part == "" {
+		part = blueStack
+	}
+	return fmt.Sprintf("keto-%s-%s-%s", clusterName, masterPoolStackType, part)
+}
+
+// makeComputePoolStackName returns compute pool stack name for either blue or
+// green stack.
+func makeComputePoolStackName(clusterName, name, part string) string {
+	if part == "" {
+		part = blueStack
+	}
+	return fmt.Sprintf("keto-%s-%s-%s", clusterName, name, part)
+}
+
+func (c *Cloud) createClusterInfraStack(cluster model.Cluster, list []subnets.Subnet) error {
+	networks := getNodesDistributionAcrossNetworks(list)
+
+	templateBody, err := renderClusterInfraStackTemplate(cluster, networks)
+	if err != nil {
+		return err
+	}
+	return c.createStack(
+		makeClusterInfraStackName(cluster.Name),
+		makeStackTags(cluster.Name, clusterInfraStackType),
+		templateBody,
+		nil,
+	)
+}
+
+func (c *Cloud) createLoadBalancerStack(cluster model.Cluster, list []subnets.Subnet) error {
+	templateBody, err := renderLoadBalancerStackTemplate(cluster, list[0].ID, c.externalNetwork)
+	if err != nil {
+		return err
+	}
+	return c.createStack(
+		makeLoadBalancerStackName(cluster.Name),
+		makeStackTags(cluster.Name, loadBalancerStackType),
+		templateBody,
+		nil,
+	)
+}
+
+func (c *Cloud) createMasterPoolStack(
+	p model.MasterPool,
+	nodes []nodesNetwork,
+	infraOutputs map[string]string,
+	lbOutputs map[string]string,
+) error {
+	nc, err := c.getNodeCredential(infraOutputs[assetsBucketNameOutputKey])
+	if err != nil {
+		return err
+	}
+
+	stackName := makeMasterPoolStackName(p.ClusterName, "")
+	templateBody, err := renderMasterStackTemplate(
+		p,
+		nodes,
+		infraOutputs[assetsBucketNameOutputKey],
+		lbOutputs[lbPoolOutputKey],
+		formatKubeAPIURL(lbOutputs[apiAddressOutputKey]),
+		stackName,
+		c.authURL,
+		c.region,
+	)
+	if err != nil {
+		return err
+	}
+	return c.createStack(stackName, makeStackTags(p.ClusterName, masterPoolStackType), templateBody, nodeStackParameters(nc))
+}
+
+func (c *Cloud) createComputePoolStack(
+	p model.ComputePool,
+	list []subnets.Subnet,
+	infraOutputs map[string]string,
+	lbOutputs map[string]string,
+) error {
+	nc, err := c.getNodeCredential(infraOutputs[assetsBucketNameOutputKey])
+	if err != nil {
+		return err
+	}
+
+	stackName := makeComputePoolStackName(p.ClusterName, p.Name, "")
+	templateBody, err := renderComputeStackTemplate(
+		p,
+		list[0],
+		infraOutputs[computePoolSGOutputKey],
+		formatKubeAPIURL(lbOutputs[apiAddressOutputKey]),
+		stackName,
+		c.authURL,
+		c.region,
+	)
+	if err != nil {
+		return err
+	}
+	return c.createStack(stackName, makeStackTags(p.ClusterName, computePoolStackType), templateBody, nodeStackParameters(nc))
+}
+
+func formatKubeAPIURL(host string) string {
+	// For some reason kubernetes does not like mixed-case dns names.
+	return "https://" + strings.ToLower(host)
+}
@@ -0,0 +1,778 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"text/template"
+
+	"github.com/UKHomeOffice/keto/pkg/components"
+	"github.com/UKHomeOffice/keto/pkg/keto/util"
+	"github.com/UKHomeOffice/keto/pkg/model"
+	"github.com/UKHomeOffice/keto/pkg/userdata"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/subnets"
+)
+
+// funcMap holds helper functions available in all heat templates.
+var funcMap = template.FuncMap{
+	// Quotes a string, so it is safe to be used as a YAML scalar, user data
+	// in particular.
+	"quote": func(s string) (string, error) {
+		b, err := json.Marshal(s)
+		return string(b), err
+	},
+}
+
+func renderClusterInfraStackTemplate(c model.Cluster, networks []nodesNetwork) (string, error) {
+	manifest, err := components.Encode(c.Components)
+	if err != nil {
+		return "", err
+	}
+
+	const (
+		clusterInfraStackTemplate = `---
+heat_template_version: rocky
+
+description: "Kubernetes cluster '{{ .Cluster.Name }}' infra stack"
+
+resources:
+  AssetsContainer:
+    type: OS::Swift::Container
+    properties:
+      name: "keto-{{ .Cluster.Name }}-assets"
+      PurgeOnDelete: true
+
+  MasterPoolSG:
+    type: OS::Neutron::SecurityGroup
+    properties:
+      name: "keto-{{ .Cluster.Name }}-masterpool"
+      description: "Kubernetes cluster {{ .Cluster.Name }} SG for master nodepool"
+      rules:
+        - protocol: tcp
+          remote_ip_prefix: 0.0.0.0/0
+          port_range_min: 22
+          port_range_max: 22
+        # Octavia health checks and load balanced traffic.
+        - protocol: tcp
+          remote_ip_prefix: 0.0.0.0/0
+          port_range_min: 443
+          port_range_max: 443
+        # Allow traffic between master nodes.
+        # TODO(vaijab): not all traffic needs to be allowed, maybe just etcd?
+        - remote_mode: remote_group_id
+
+  ComputePoolSG:
+    type: OS::Neutron::SecurityGroup
+    properties:
+      name: "keto-{{ .Cluster.Name }}-computepool"
+      description: "Kubernetes cluster {{ .Cluster.Name }} SG for compute nodepools"
+      rules:
+        - protocol: tcp
+          remote_ip_prefix: 0.0.0.0/0
+          port_range_min: 22
+          port_range_max: 22
+        # Allow traffic between all compute pools.
+        - remote_mode: remote_group_id
+
+  # Allow master nodes to talk to all compute pools.
+  MasterPoolToComputePoolSG:
+    type: OS::Neutron::SecurityGroupRule
+    properties:
+      security_group: { get_resource: ComputePoolSG }
+      remote_group: { get_resource: MasterPoolSG }
+      direction: ingress
+
+  # Allow compute pools to talk to master nodes API.
+  ComputePoolToMasterPoolAPISG:
+    type: OS::Neutron::SecurityGroupRule
+    properties:
+      security_group: { get_resource: MasterPoolSG }
+      remote_group: { get_resource: ComputePoolSG }
+      direction: ingress
+      protocol: tcp
+      port_range_min: 443
+      port_range_max: 443
+
+{{ range $_, $r := .NetworkSGRules }}
+  # Allow {{ $r.Name }} CNI traffic from compute pools to master nodes.
+  ComputePoolToMasterPool{{ $r.Name }}SG:
+    type: OS::Neutron::SecurityGroupRule
+    properties:
+      security_group: { get_resource: MasterPoolSG }
+      remote_group: { get_resource: ComputePoolSG }
+      direction: ingress
+      protocol: "{{ $r.Protocol }}"
+      {{- if $r.PortMin }}
+      port_range_min: {{ $r.PortMin }}
+      port_range_max: {{ $r.PortMax }}
+      {{- end }}
+{{ end }}
+{{ $clusterName := .Cluster.Name -}}
+{{ range $_, $n := .Networks }}
+  Port{{ $n.NodeID }}:
+    type: OS::Neutron::Port
+    properties:
+      name: "keto-{{ $clusterName }}-port{{ $n.NodeID }}"
+      network: "{{ $n.Network }}"
+      fixed_ips:
+        - subnet: "{{ $n.Subnet }}"
+      security_groups:
+        - { get_resource: MasterPoolSG }
+      # Pod traffic is routed via master nodes.
+      allowed_address_pairs:
+        - ip_address: 0.0.0.0/0
+      tags:
+        # Master nodes are matched to their persistent resources by ID.
+        - "{{ $.ManagedByKetoTag }}"
+        - "{{ $.ClusterNameTag }}"
+        - "{{ $.NodeIDTagKey }}={{ $n.NodeID }}"
+
+  Volume{{ $n.NodeID }}:
+    type: OS::Cinder::Volume
+    properties:
+      name: "keto-{{ $clusterName }}-volume{{ $n.NodeID }}"
+      size: 10
+      metadata:
+        # Master nodes are matched to their persistent resources by ID.
+        NodeID: "{{ $n.NodeID }}"
+        {{ $.ClusterNameTagKey }}: "{{ $clusterName }}"
+{{ end }}
+
+outputs:
+  {{ .MasterPoolSGOutputKey }}:
+    value: { get_resource: MasterPoolSG }
+
+  {{ .ComputePoolSGOutputKey }}:
+    value: { get_resource: ComputePoolSG }
+
+  {{ .AssetsBucketNameOutputKey }}:
+    value: { get_resource: AssetsContainer }
+
+  {{ .ClusterNameOutputKey }}:
+    value: "{{ .Cluster.Name }}"
+
+  {{ .LabelsOutputKey }}:
+    value: "{{ .Labels }}"
+
+  {{ .InternalClusterOutputKey }}:
+    value: "{{ .Cluster.Internal }}"
+
+  {{ .NetworkProviderOutputKey }}:
+    value: "{{ .Cluster.NetworkProvider }}"
+
+  {{ .PodCIDROutputKey }}:
+    value: "{{ .Cluster.PodCIDR }}"
+
+  {{ .ServiceCIDROutputKey }}:
+    value: "{{ .Cluster.ServiceCIDR }}"
+
+  {{ .ComponentsOutputKey }}:
+    value: {{ printf "%q" .Components }}
+
+  {{ .RegistryMirrorOutputKey }}:
+    value: "{{ .Cluster.RegistryMirror }}"
+
+  {{ .ArtifactsURLOutputKey }}:
+    value: "{{ .Cluster.ArtifactsURL }}"
+
+  {{ .HTTPProxyOutputKey }}:
+    value: "{{ .Cluster.Proxy.HTTPProxy }}"
+
+  {{ .HTTPSProxyOutputKey }}:
+    value: "{{ .Cluster.Proxy.HTTPSProxy }}"
+
+  {{ .NoProxyOutputKey }}:
+    value: "{{ .NoProxy }}"
+
+  {{ .NetworkCIDRsOutputKey }}:
+    value: "{{ .NetworkCIDRs }}"
+
+  {{ .StackTypeOutputKey }}:
+    value: "{{ .StackType }}"
+{{ range $_, $n := .Networks }}
+  {{ $.VolumeOutputKeyPrefix }}{{ $n.NodeID }}:
+    value: { get_resource: Volume{{ $n.NodeID }} }
+{{ end }}
+`
+	)
+
+	data := struct {
+		Cluster                   model.Cluster
+		Networks                  []nodesNetwork
+		NetworkSGRules            []networkSGRule
+		ManagedByKetoTag          string
+		ClusterNameTagKey         string
+		ClusterNameTag            string
+		NodeIDTagKey              string
+		LabelsOutputKey           string
+		Labels                    string
+		ClusterNameOutputKey      string
+		StackTypeOutputKey        string
+		StackType                 string
+		InternalClusterOutputKey  string
+		AssetsBucketNameOutputKey string
+		MasterPoolSGOutputKey     string
+		ComputePoolSGOutputKey    string
+		NetworkProviderOutputKey  string
+		PodCIDROutputKey          string
+		ServiceCIDROutputKey      string
+		ComponentsOutputKey       string
+		Components                string
+		RegistryMirrorOutputKey   string
+		ArtifactsURLOutputKey     string
+		HTTPProxyOutputKey        string
+		HTTPSProxyOutputKey       string
+		NoProxyOutputKey          string
+		NoProxy                   string
+		NetworkCIDRsOutputKey     string
+		NetworkCIDRs              string
+		VolumeOutputKeyPrefix     string
+	}{
+		Cluster:                   c,
+		Networks:                  networks,
+		NetworkSGRules:            networkProviderSGRules[c.NetworkProvider],
+		ManagedByKetoTag:          managedByKetoTag,
+		ClusterNameTagKey:         clusterNameTagKey,
+		ClusterNameTag:            makeTag(clusterNameTagKey, c.Name),
+		NodeIDTagKey:              nodeIDTagKey,
+		LabelsOutputKey:           labelsOutputKey,
+		Labels:                    util.StringMapToKVs(c.Labels),
+		ClusterNameOutputKey:      clusterNameOutputKey,
+		StackTypeOutputKey:        stackTypeOutputKey,
+		StackType:                 clusterInfraStackType,
+		InternalClusterOutputKey:  internalClusterOutputKey,
+		AssetsBucketNameOutputKey: assetsBucketNameOutputKey,
+		MasterPoolSGOutputKey:     masterPoolSGOutputKey,
+		ComputePoolSGOutputKey:    computePoolSGOutputKey,
+		NetworkProviderOutputKey:  networkProviderOutputKey,
+		PodCIDROutputKey:          podCIDROutputKey,
+		ServiceCIDROutputKey:      serviceCIDROutputKey,
+		ComponentsOutputKey:       componentsOutputKey,
+		Components:                manifest,
+		RegistryMirrorOutputKey:   registryMirrorOutputKey,
+		ArtifactsURLOutputKey:     artifactsURLOutputKey,
+		HTTPProxyOutputKey:        httpProxyOutputKey,
+		HTTPSProxyOutputKey:       httpsProxyOutputKey,
+		NoProxyOutputKey:          noProxyOutputKey,
+		NoProxy:                   strings.Join(c.Proxy.NoProxy, ","),
+		NetworkCIDRsOutputKey:     networkCIDRsOutputKey,
+		NetworkCIDRs:              strings.Join(c.NetworkCIDRs, ","),
+		VolumeOutputKeyPrefix:     volumeOutputKeyPrefix,
+	}
+
+	t := template.Must(template.New("cluster-infra-stack").Parse(clusterInfraStackTemplate))
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func renderLoadBalancerStackTemplate(c model.Cluster, subnetID, externalNetwork string) (string, error) {
+	const (
+		lbStackTemplate = `---
+heat_template_version: rocky
+
+description: "Kubernetes cluster '{{ .Cluster.Name }}' load balancer stack"
+
+resources:
+  LoadBalancer:
+    type: OS::Octavia::LoadBalancer
+    properties:
+      name: "keto-{{ .Cluster.Name }}-kubeapi"
+      vip_subnet: "{{ .SubnetID }}"
+
+  Listener:
+    type: OS::Octavia::Listener
+    properties:
+      loadbalancer: { get_resource: LoadBalancer }
+      protocol: TCP
+      protocol_port: 443
+
+  Pool:
+    type: OS::Octavia::Pool
+    properties:
+      listener: { get_resource: Listener }
+      lb_algorithm: ROUND_ROBIN
+      protocol: TCP
+
+  HealthMonitor:
+    type: OS::Octavia::HealthMonitor
+    properties:
+      pool: { get_resource: Pool }
+      type: TCP
+      delay: 10
+      timeout: 5
+      max_retries: 2
+
+{{ if not .Cluster.Internal }}
+  FloatingIP:
+    type: OS::Neutron::FloatingIP
+    properties:
+      floating_network: "{{ .ExternalNetwork }}"
+      port_id: { get_attr: [ LoadBalancer, vip_port_id ] }
+{{ end }}
+
+{{ if ne .Cluster.DNSZone "" }}
+  DNS:
+    type: OS::Designate::RecordSet
+    properties:
+      zone: "{{ .Cluster.DNSZone }}."
+      name: "kube-{{ .Cluster.Name }}.{{ .Cluster.DNSZone }}."
+      type: A
+      records:
+        - {{ if .Cluster.Internal }}{ get_attr: [ LoadBalancer, vip_address ] }{{ else }}{ get_attr: [ FloatingIP, floating_ip_address ] }{{ end }}
+{{ end }}
+
+outputs:
+  {{ .LBPoolOutputKey }}:
+    value: { get_resource: Pool }
+
+  {{ .APIAddressOutputKey }}:
+    {{ if ne .Cluster.DNSZone "" }}value: "kube-{{ .Cluster.Name }}.{{ .Cluster.DNSZone }}"{{ else }}value: {{ if .Cluster.Internal }}{ get_attr: [ LoadBalancer, vip_address ] }{{ else }}{ get_attr: [ FloatingIP, floating_ip_address ] }{{ end }}{{ end }}
+
+  {{ .ClusterNameOutputKey }}:
+    value: "{{ .Cluster.Name }}"
+
+  {{ .StackTypeOutputKey }}:
+    value: "{{ .StackType }}"
+`
+	)
+
+	data := struct {
+		Cluster              model.Cluster
+		SubnetID             string
+		ExternalNetwork      string
+		ClusterNameOutputKey string
+		StackTypeOutputKey   string
+		StackType            string
+		LBPoolOutputKey      string
+		APIAddressOutputKey  string
+	}{
+		Cluster:              c,
+		SubnetID:             subnetID,
+		ExternalNetwork:      externalNetwork,
+		ClusterNameOutputKey: clusterNameOutputKey,
+		StackTypeOutputKey:   stackTypeOutputKey,
+		StackType:            loadBalancerStackType,
+		LBPoolOutputKey:      lbPoolOutputKey,
+		APIAddressOutputKey:  apiAddressOutputKey,
+	}
+
+	t := template.Must(template.New("lb-stack").Parse(lbStackTemplate))
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func renderMasterStackTemplate(
+	p model.MasterPool,
+	nodes []nodesNetwork,
+	assetsContainerName string,
+	lbPool string,
+	kubeAPIURL string,
+	stackName string,
+	authURL string,
+	region string,
+) (string, error) {
+
+	const (
+		masterStackTemplate = `---
+heat_template_version: rocky
+
+description: "Kubernetes cluster '{{ .MasterPool.ClusterName }}' master nodepool stack"
+
+parameters:
+  NodeCredentialID:
+    type: string
+  NodeCredentialSecret:
+    type: string
+    hidden: true
+
+resources:
+  ServerGroup:
+    type: OS::Nova::ServerGroup
+    properties:
+      name: "keto-{{ .MasterPool.ClusterName }}-master"
+      policies:
+        - soft-anti-affinity
+
+{{ range $_, $n := .Nodes }}
+  Master{{ $n.NodeID }}:
+    type: OS::Nova::Server
+    properties:
+      name: "keto-{{ $.MasterPool.ClusterName }}-master{{ $n.NodeID }}"
+      flavor: "{{ $.MasterPool.MachineType }}"
+      key_name: "{{ $.MasterPool.SSHKey }}"
+      block_device_mapping_v2:
+        - boot_index: 0
+          image: "{{ $.MasterPool.CoreOSVersion }}"
+          volume_size: {{ $.MasterPool.DiskSize }}
+          delete_on_termination: true
+      # The persistent port keeps the node IP and security groups.
+      networks:
+        - port: "{{ $n.Port }}"
+      scheduler_hints:
+        group: { get_resource: ServerGroup }
+      metadata:
+        # Nodes find their stack, persistent volume and credential via the
+        # metadata service.
+        {{ $.StackNameMetadataKey }}: "{{ $.StackName }}"
+        {{ $.NodeIDMetadataKey }}: "{{ $n.NodeID }}"
+        {{ $.NodeIPMetadataKey }}: "{{ $n.IP }}"
+        {{ $.VolumeIDMetadataKey }}: "{{ $n.Volume }}"
+        {{ $.AuthURLMetadataKey }}: "{{ $.AuthURL }}"
+        {{ $.RegionMetadataKey }}: "{{ $.Region }}"
+        {{ $.CredentialIDMetadataKey }}: { get_param: NodeCredentialID }
+        {{ $.CredentialSecretMetadataKey }}: { get_param: NodeCredentialSecret }
+      user_data_format: RAW
+      user_data: {{ quote $.UserData }}
+
+  VolumeAttachment{{ $n.NodeID }}:
+    type: OS::Cinder::VolumeAttachment
+    properties:
+      instance_uuid: { get_resource: Master{{ $n.NodeID }} }
+      volume_id: "{{ $n.Volume }}"
+
+  Member{{ $n.NodeID }}:
+    type: OS::Octavia::PoolMember
+    properties:
+      pool: "{{ $.LBPool }}"
+      address: "{{ $n.IP }}"
+      protocol_port: 443
+      subnet: "{{ $n.Subnet }}"
+{{ end }}
+
+outputs:
+  {{ .AssetsBucketNameOutputKey }}:
+    value: "{{ .AssetsBucketName }}"
+
+  {{ .ClusterNameOutputKey }}:
+    value: "{{ .MasterPool.ClusterName }}"
+
+  {{ .PoolNameOutputKey }}:
+    value: "{{ .MasterPool.Name }}"
+
+  {{ .CoreOSVersionOutputKey }}:
+    value: "{{ .MasterPool.CoreOSVersion }}"
+
+  {{ .KubeAPIURLOutputKey }}:
+    value: "{{ .KubeAPIURL }}"
+
+  {{ .MachineTypeOutputKey }}:
+    value: "{{ .MasterPool.MachineType }}"
+
+  {{ .KubeVersionOutputKey }}:
+    value: "{{ .MasterPool.KubeVersion }}"
+
+  {{ .DiskSizeOutputKey }}:
+    value: "{{ .MasterPool.DiskSize }}"
+
+  {{ .LabelsOutputKey }}:
+    value: "{{ .Labels }}"
+
+  {{ .InternalClusterOutputKey }}:
+    value: "{{ .MasterPool.Internal }}"
+
+  {{ .StackTypeOutputKey }}:
+    value: "{{ .StackType }}"
+
+  {{ .TaintsOutputKey }}:
+    value: "{{ .Taints }}"
+
+  {{ .KubeletExtraArgsOutputKey }}:
+    value: "{{ .MasterPool.KubeletExtraArgs }}"
+
+  {{ .APIServerExtraArgsOutputKey }}:
+    value: "{{ .MasterPool.APIServerExtraArgs }}"
+
+  {{ .ControllerManagerExtraArgsOutputKey }}:
+    value: "{{ .MasterPool.ControllerManagerExtraArgs }}"
+
+  {{ .SchedulerExtraArgsOutputKey }}:
+    value: "{{ .MasterPool.SchedulerExtraArgs }}"
+
+  {{ .UserDataHashOutputKey }}:
+    value: "{{ .UserDataHash }}"
+`
+	)
+
+	data := struct {
+		MasterPool                          model.MasterPool
+		Nodes                               []nodesNetwork
+		LBPool                              string
+		StackName                           string
+		StackNameMetadataKey                string
+		NodeIDMetadataKey                   string
+		NodeIPMetadataKey                   string
+		VolumeIDMetadataKey                 string
+		AuthURLMetadataKey                  string
+		AuthURL                             string
+		RegionMetadataKey                   string
+		Region                              string
+		CredentialIDMetadataKey             string
+		CredentialSecretMetadataKey         string
+		UserData                            string
+		KubeAPIURL                          string
+		LabelsOutputKey                     string
+		Labels                              string
+		Taints                              string
+		ClusterNameOutputKey                string
+		PoolNameOutputKey                   string
+		CoreOSVersionOutputKey              string
+		StackTypeOutputKey                  string
+		StackType                           string
+		InternalClusterOutputKey            string
+		AssetsBucketNameOutputKey           string
+		AssetsBucketName                    string
+		KubeAPIURLOutputKey                 string
+		MachineTypeOutputKey                string
+		KubeVersionOutputKey                string
+		DiskSizeOutputKey                   string
+		TaintsOutputKey                     string
+		KubeletExtraArgsOutputKey           string
+		APIServerExtraArgsOutputKey         string
+		ControllerManagerExtraArgsOutputKey string
+		SchedulerExtraArgsOutputKey         string
+		UserDataHashOutputKey               string
+		UserDataHash                        string
+	}{
+		MasterPool:                          p,
+		Nodes:                               nodes,
+		LBPool:                              lbPool,
+		StackName:                           stackName,
+		StackNameMetadataKey:                stackNameMetadataKey,
+		NodeIDMetadataKey:                   nodeIDMetadataKey,
+		NodeIPMetadataKey:                   nodeIPMetadataKey,
+		VolumeIDMetadataKey:                 volumeIDMetadataKey,
+		AuthURLMetadataKey:                  authURLMetadataKey,
+		AuthURL:                             authURL,
+		RegionMetadataKey:                   regionMetadataKey,
+		Region:                              region,
+		CredentialIDMetadataKey:             credentialIDMetadataKey,
+		CredentialSecretMetadataKey:         credentialSecretMetadataKey,
+		UserData:                            string(p.UserData),
+		KubeAPIURL:                          kubeAPIURL,
+		LabelsOutputKey:                     labelsOutputKey,
+		Labels:                              util.StringMapToKVs(p.Labels),
+		Taints:                              util.StringMapToKVs(p.Taints),
+		ClusterNameOutputKey:                clusterNameOutputKey,
+		CoreOSVersionOutputKey:              coreOSVersionOutputKey,
+		PoolNameOutputKey:                   poolNameOutputKey,
+		StackTypeOutputKey:                  stackTypeOutputKey,
+		StackType:                           masterPoolStackType,
+		InternalClusterOutputKey:            internalClusterOutputKey,
+		AssetsBucketNameOutputKey:           assetsBucketNameOutputKey,
+		AssetsBucketName:                    assetsContainerName,
+		KubeAPIURLOutputKey:                 kubeAPIURLOutputKey,
+		MachineTypeOutputKey:                machineTypeOutputKey,
+		KubeVersionOutputKey:                kubeVersionOutputKey,
+		DiskSizeOutputKey:                   diskSizeOutputKey,
+		TaintsOutputKey:                     taintsOutputKey,
+		KubeletExtraArgsOutputKey:           kubeletExtraArgsOutputKey,
+		APIServerExtraArgsOutputKey:         apiServerExtraArgsOutputKey,
+		ControllerManagerExtraArgsOutputKey: controllerManagerExtraArgsOutputKey,
+		SchedulerExtraArgsOutputKey:         schedulerExtraArgsOutputKey,
+		UserDataHashOutputKey:               userDataHashOutputKey,
+		UserDataHash:                        userdata.Hash(p.UserData),
+	}
+
+	t := template.Must(template.New("master-stack").Funcs(funcMap).Parse(masterStackTemplate))
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func renderComputeStackTemplate(
+	p model.ComputePool,
+	subnet subnets.Subnet,
+	securityGroup string,
+	kubeAPIURL string,
+	stackName string,
+	authURL string,
+	region string,
+) (string, error) {
+
+	const (
+		computeStackTemplate = `---
+heat_template_version: rocky
+
+description: "Kubernetes cluster '{{ .ComputePool.ClusterName }}' compute nodepool stack"
+
+parameters:
+  NodeCredentialID:
+    type: string
+  NodeCredentialSecret:
+    type: string
+    hidden: true
+
+resources:
+  ASG:
+    type: OS::Heat::AutoScalingGroup
+    properties:
+      min_size: {{ .MinSize }}
+      max_size: {{ .MaxSize }}
+      desired_capacity: {{ .ComputePool.Size }}
+      resource:
+        type: OS::Nova::Server
+        properties:
+          flavor: "{{ .ComputePool.MachineType }}"
+          key_name: "{{ .ComputePool.SSHKey }}"
+          block_device_mapping_v2:
+            - boot_index: 0
+              image: "{{ .ComputePool.CoreOSVersion }}"
+              volume_size: {{ .ComputePool.DiskSize }}
+              delete_on_termination: true
+          networks:
+            - subnet: "{{ .Subnet.ID }}"
+          security_groups:
+            - "{{ .SecurityGroup }}"
+          metadata:
+            # Nodes find their stack and credential via the metadata service.
+            {{ .StackNameMetadataKey }}: "{{ .StackName }}"
+            {{ .AuthURLMetadataKey }}: "{{ .AuthURL }}"
+            {{ .RegionMetadataKey }}: "{{ .Region }}"
+            {{ .CredentialIDMetadataKey }}: { get_param: NodeCredentialID }
+            {{ .CredentialSecretMetadataKey }}: { get_param: NodeCredentialSecret }
+          user_data_format: RAW
+          user_data: {{ quote .UserData }}
+
+outputs:
+  {{ .ClusterNameOutputKey }}:
+    value: "{{ .ComputePool.ClusterName }}"
+
+  {{ .PoolNameOutputKey }}:
+    value: "{{ .ComputePool.Name }}"
+
+  {{ .CoreOSVersionOutputKey }}:
+    value: "{{ .ComputePool.CoreOSVersion }}"
+
+  {{ .KubeAPIURLOutputKey }}:
+    value: "{{ .KubeAPIURL }}"
+
+  {{ .MachineTypeOutputKey }}:
+    value: "{{ .ComputePool.MachineType }}"
+
+  {{ .KubeVersionOutputKey }}:
+    value: "{{ .ComputePool.KubeVersion }}"
+
+  {{ .DiskSizeOutputKey }}:
+    value: "{{ .ComputePool.DiskSize }}"
+
+  {{ .LabelsOutputKey }}:
+    value: "{{ .Labels }}"
+
+  {{ .StackTypeOutputKey }}:
+    value: "{{ .StackType }}"
+
+  {{ .TaintsOutputKey }}:
+    value: "{{ .Taints }}"
+
+  {{ .KubeletExtraArgsOutputKey }}:
+    value: "{{ .ComputePool.KubeletExtraArgs }}"
+
+  {{ .UserDataHashOutputKey }}:
+    value: "{{ .UserDataHash }}"
+`
+	)
+
+	// Pools created without min/max sizes are fixed in size.
+	minSize, maxSize := p.MinSize, p.MaxSize
+	if maxSize == 0 {
+		minSize, maxSize = p.Size, p.Size
+	}
+
+	data := struct {
+		ComputePool                 model.ComputePool
+		MinSize                     int
+		MaxSize                     int
+		Subnet                      subnets.Subnet
+		SecurityGroup               string
+		StackName                   string
+		StackNameMetadataKey        string
+		AuthURLMetadataKey          string
+		AuthURL                     string
+		RegionMetadataKey           string
+		Region                      string
+		CredentialIDMetadataKey     string
+		CredentialSecretMetadataKey string
+		UserData                    string
+		KubeAPIURL                  string
+		LabelsOutputKey             string
+		Labels                      string
+		Taints                      string
+		ClusterNameOutputKey        string
+		PoolNameOutputKey           string
+		CoreOSVersionOutputKey      string
+		StackTypeOutputKey          string
+		StackType                   string
+		KubeAPIURLOutputKey         string
+		MachineTypeOutputKey        string
+		KubeVersionOutputKey        string
+		DiskSizeOutputKey           string
+		TaintsOutputKey             string
+		KubeletExtraArgsOutputKey   string
+		UserDataHashOutputKey       string
+		UserDataHash                string
+	}{
+		ComputePool:                 p,
+		MinSize:                     minSize,
+		MaxSize:                     maxSize,
+		Subnet:                      subnet,
+		SecurityGroup:               securityGroup,
+		StackName:                   stackName,
+		StackNameMetadataKey:        stackNameMetadataKey,
+		AuthURLMetadataKey:          authURLMetadataKey,
+		AuthURL:                     authURL,
+		RegionMetadataKey:           regionMetadataKey,
+		Region:                      region,
+		CredentialIDMetadataKey:     credentialIDMetadataKey,
+		CredentialSecretMetadataKey: credentialSecretMetadataKey,
+		UserData:                    string(p.UserData),
+		KubeAPIURL:                  kubeAPIURL,
+		LabelsOutputKey:             labelsOutputKey,
+		Labels:                      util.StringMapToKVs(p.Labels),
+		Taints:                      util.StringMapToKVs(p.Taints),
+		ClusterNameOutputKey:        clusterNameOutputKey,
+		PoolNameOutputKey:           poolNameOutputKey,
+		CoreOSVersionOutputKey:      coreOSVersionOutputKey,
+		StackTypeOutputKey:          stackTypeOutputKey,
+		StackType:                   computePoolStackType,
+		KubeAPIURLOutputKey:         kubeAPIURLOutputKey,
+		MachineTypeOutputKey:        machineTypeOutputKey,
+		KubeVersionOutputKey:        kubeVersionOutputKey,
+		DiskSizeOutputKey:           diskSizeOutputKey,
+		TaintsOutputKey:             taintsOutputKey,
+		KubeletExtraArgsOutputKey:   kubeletExtraArgsOutputKey,
+		UserDataHashOutputKey:       userDataHashOutputKey,
+		UserDataHash:                userdata.Hash(p.UserData),
+	}
+
+	t := template.Must(template.New("compute-stack").Funcs(funcMap).Parse(computeStackTemplate))
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"testing"
+
+	"github.com/UKHomeOffice/keto/pkg/model"
+	"github.com/UKHomeOffice/keto/testutil"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/subnets"
+)
+
+func TestRenderClusterInfraStackTemplate(t *testing.T) {
+	list := []subnets.Subnet{
+		{ID: "subnet1", NetworkID: "network0"},
+		{ID: "subnet0", NetworkID: "network0"},
+	}
+	networks := getNodesDistributionAcrossNetworks(list)
+	if len(networks) != 5 {
+		t.Errorf("expected 5 nodes, got %d", len(networks))
+	}
+
+	cluster := model.Cluster{
+		ResourceMeta:    model.ResourceMeta{Name: "foo"},
+		NetworkProvider: "calico",
+	}
+
+	s, err := renderClusterInfraStackTemplate(cluster, networks)
+	if err != nil {
+		t.Error(err)
+	}
+	testutil.CheckTemplate(t, s, "keto-foo-assets")
+	testutil.CheckTemplate(t, s, `"NodeID=4"`)
+	testutil.CheckTemplate(t, s, `"cluster-name=foo"`)
+	testutil.CheckTemplate(t, s, "ComputePoolToMasterPoolIPIPSG:")
+	testutil.CheckTemplate(t, s, "Volume4:\n    value: { get_resource: Volume4 }")
+}
+
+func TestRenderLoadBalancerStackTemplate(t *testing.T) {
+	c := model.Cluster{
+		ResourceMeta: model.ResourceMeta{Name: "foo"},
+		DNSZone:      "example.com",
+	}
+
+	s, err := renderLoadBalancerStackTemplate(c, "subnet0", "public")
+	if err != nil {
+		t.Error(err)
+	}
+	testutil.CheckTemplate(t, s, `floating_network: "public"`)
+	testutil.CheckTemplate(t, s, `value: "kube-foo.example.com"`)
+}
+
+func TestRenderMasterStackTemplate(t *testing.T) {
+	p := model.MasterPool{NodePool: testutil.MakeNodePool("foo", "master")}
+	p.UserData = []byte("#cloud-config\nhostname: \"foo\"\n")
+	nodes := []nodesNetwork{
+		{Subnet: "subnet0", Network: "network0", NodeID: 0, Port: "port0", IP: "10.0.0.10", Volume: "volume0"},
+		{Subnet: "subnet1", Network: "network0", NodeID: 1, Port: "port1", IP: "10.0.1.10", Volume: "volume1"},
+	}
+
+	s, err := renderMasterStackTemplate(p, nodes, "keto-foo-assets", "pool0", "https://kube-foo", "keto-foo-masterpool-blue", "https://keystone/v3", "RegionOne")
+	if err != nil {
+		t.Error(err)
+	}
+	testutil.CheckTemplate(t, s, "Member1:")
+	testutil.CheckTemplate(t, s, `port: "port1"`)
+	testutil.CheckTemplate(t, s, `address: "10.0.1.10"`)
+	testutil.CheckTemplate(t, s, `volume_id: "volume1"`)
+	testutil.CheckTemplate(t, s, `keto-node-ip: "10.0.1.10"`)
+	testutil.CheckTemplate(t, s, `keto-auth-url: "https://keystone/v3"`)
+	testutil.CheckTemplate(t, s, `keto-credential-secret: { get_param: NodeCredentialSecret }`)
+	testutil.CheckTemplate(t, s, `keto-stack-name: "keto-foo-masterpool-blue"`)
+	testutil.CheckTemplate(t, s, `user_data: "#cloud-config\nhostname: \"foo\"\n"`)
+}
+
+func TestRenderComputeStackTemplate(t *testing.T) {
+	p := model.ComputePool{NodePool: testutil.MakeNodePool("foo", "compute")}
+
+	subnet := subnets.Subnet{ID: "subnet1", NetworkID: "network0"}
+
+	s, err := renderComputeStackTemplate(p, subnet, "sg0", "https://kube-foo", "keto-foo-compute-blue", "https://keystone/v3", "RegionOne")
+	if err != nil {
+		t.Error(err)
+	}
+	testutil.CheckTemplate(t, s, `subnet: "subnet1"`)
+	testutil.CheckTemplate(t, s, `min_size: 1`)
+	testutil.CheckTemplate(t, s, `max_size: 1`)
+	testutil.CheckTemplate(t, s, `keto-region: "RegionOne"`)
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/UKHomeOffice/keto/pkg/cloudprovider"
+	"github.com/UKHomeOffice/keto/pkg/keto/util"
+	"github.com/UKHomeOffice/keto/pkg/model"
+)
+
+const (
+	// defaultMetadataURL is the OpenStack metadata service document URL.
+	defaultMetadataURL = "http://169.254.169.254/openstack/latest/meta_data.json"
+
+	// stackNameMetadataKey is a server metadata key that holds a name of
+	// the stack the server was created by.
+	stackNameMetadataKey = "keto-stack-name"
+
+	// Master server metadata keys, read by the master cloud-config to mount
+	// the persistent volume and to find the etcd member ID and IP.
+	nodeIDMetadataKey   = "keto-node-id"
+	nodeIPMetadataKey   = "keto-node-ip"
+	volumeIDMetadataKey = "keto-volume-id"
+)
+
+// instanceMetadata is a subset of the metadata service document.
+type instanceMetadata struct {
+	UUID string            `json:"uuid"`
+	Meta map[string]string `json:"meta"`
+}
+
+// Node returns an implementation of Node interface for OpenStack Cloud.
+func (c *Cloud) Node() (cloudprovider.Node, bool) {
+	return c, true
+}
+
+// GetNodeData returns model.NodeData which contains information like node
+// labels, kube version, etc.
+func (c *Cloud) GetNodeData() (model.NodeData, error) {
+	var data model.NodeData
+
+	o, err := c.getNodeStackOutputs()
+	if err != nil {
+		return data, err
+	}
+
+	data.KubeAPIURL = o[kubeAPIURLOutputKey]
+	data.ClusterName = o[clusterNameOutputKey]
+	data.KubeVersion = o[kubeVersionOutputKey]
+	data.Labels = util.KVsToStringMap(strings.Split(o[labelsOutputKey], ","))
+	data.Taints = util.KVsToStringMap(strings.Split(o[taintsOutputKey], ","))
+	data.KubeletExtraArgs = o[kubeletExtraArgsOutputKey]
+	data.APIServerExtraArgs = o[apiServerExtraArgsOutputKey]
+	data.ControllerManagerExtraArgs = o[controllerManagerExtraArgsOutputKey]
+	data.SchedulerExtraArgs = o[schedulerExtraArgsOutputKey]
+
+	return data, nil
+}
+
+// GetAssets gets assets from a cloud.
+func (c *Cloud) GetAssets() (model.Assets, error) {
+	var a model.Assets
+
+	o, err := c.getNodeStackOutputs()
+	if err != nil {
+		return a, err
+	}
+	container := o[assetsBucketNameOutputKey]
+
+	etcdCACert, err := c.getObject(container, etcdCACertObjectName)
+	if err != nil {
+		return a, err
+	}
+	etcdCAKey, err := c.getObject(container, etcdCAKeyObjectName)
+	if err != nil {
+		return a, err
+	}
+	kubeCACert, err := c.getObject(container, kubeCACertObjectName)
+	if err != nil {
+		return a, err
+	}
+	kubeCAKey, err := c.getObject(container, kubeCAKeyObjectName)
+	if err != nil {
+		return a, err
+	}
+
+	a.EtcdCAKey = etcdCAKey
+	a.EtcdCACert = etcdCACert
+	a.KubeCAKey = kubeCAKey
+	a.KubeCACert = kubeCACert
+
+	return a, nil
+}
+
+// getInstanceMetadata returns an instance metadata document from the
+// OpenStack metadata service.
+func (c *Cloud) getInstanceMetadata() (instanceMetadata, error) {
+	return fetchInstanceMetadata(c.metadataURL)
+}
+
+// fetchInstanceMetadata returns an instance metadata document from a
+// metadata service URL.
+func fetchInstanceMetadata(url string) (instanceMetadata, error) {
+	var m instanceMetadata
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return m, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return m, fmt.Errorf("metadata service returned %s", resp.Status)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&m)
+	return m, err
+}
+
+// Returns heat stack outputs. It is the stack that the node was created by.
+// Should only be used from a node.
+func (c *Cloud) getNodeStackOutputs() (map[string]string, error) {
+	metadata, err := c.getInstanceMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	stackName, ok := metadata.Meta[stackNameMetadataKey]
+	if !ok {
+		return nil, fmt.Errorf("%s metadata key not found", stackNameMetadataKey)
+	}
+
+	s, err := c.getStack(stackName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe %q stack: %v", stackName, err)
+	}
+	if s == nil {
+		return nil, fmt.Errorf("stack %q not found", stackName)
+	}
+	return s.outputs(), nil
+}
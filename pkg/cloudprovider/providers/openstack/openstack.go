@@ -0,0 +1,779 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/UKHomeOffice/keto/pkg/cloudprovider"
+	"github.com/UKHomeOffice/keto/pkg/components"
+	"github.com/UKHomeOffice/keto/pkg/model"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/subnets"
+	"github.com/gophercloud/gophercloud/openstack/objectstorage/v1/objects"
+)
+
+const (
+	// ProviderName is the name of this provider.
+	ProviderName = "openstack"
+
+	// managedByKeto tag is needed for the cloudprovider to know which cloud
+	// resources are managed by keto.
+	managedByKetoTag = "managed-by-keto"
+
+	// Heat and neutron tags are plain strings, so key=value pairs are
+	// encoded into a single tag.
+	clusterNameTagKey = "cluster-name"
+	stackTypeTagKey   = "stack-type"
+	nodeIDTagKey      = "NodeID"
+
+	// externalNetworkEnv is an environment variable that specifies a name or
+	// an ID of a network used to allocate floating IPs from.
+	externalNetworkEnv = "KETO_OPENSTACK_EXTERNAL_NETWORK"
+
+	etcdCACertObjectName = "etcd_ca.crt"
+	etcdCAKeyObjectName  = "etcd_ca.key"
+	kubeCACertObjectName = "kube_ca.crt"
+	kubeCAKeyObjectName  = "kube_ca.key"
+)
+
+var (
+	// ErrNotImplemented defines an error for not implemented features.
+	ErrNotImplemented = errors.New("not implemented")
+	// ErrInstancesPolicyNotSupported defines an error for compute pools that
+	// use spot instances or multiple machine types.
+	ErrInstancesPolicyNotSupported = errors.New("spot and mixed-instance compute pools are not supported")
+	// ErrAutoscalingNotSupported defines an error for compute pools that have
+	// different min and max sizes.
+	ErrAutoscalingNotSupported = errors.New("autoscaling compute pools are not supported")
+	// ErrAccessNotSupported defines an error for clusters that restrict SSH
+	// or kube API access.
+	ErrAccessNotSupported = errors.New("cluster access rules are not supported")
+	// ErrAllowFromPoolsNotSupported defines an error for compute pools that
+	// declare allowed traffic from other pools.
+	ErrAllowFromPoolsNotSupported = errors.New("compute pool isolation is not supported")
+	// ErrLoadBalancerTypeNotSupported defines an error for clusters that ask
+	// for a kube API load balancer other than the default one.
+	ErrLoadBalancerTypeNotSupported = errors.New("load balancer type is not supported")
+	// ErrAPINamesNotSupported defines an error for clusters that set a custom
+	// kube API hostname, aliases or certificate SANs.
+	ErrAPINamesNotSupported = errors.New("custom kube API names are not supported")
+	// ErrDNSZoneSelectionNotSupported defines an error for clusters that
+	// select DNS zones by ID or ask for private DNS zones.
+	ErrDNSZoneSelectionNotSupported = errors.New("DNS zone selection is not supported")
+	// ErrNetworkCreationNotSupported defines an error for clusters that are
+	// created without networks.
+	ErrNetworkCreationNotSupported = errors.New("creating cluster networks is not supported, networks must be given")
+)
+
+// Cloud is an implementation of cloudprovider.Interface.
+type Cloud struct {
+	Logger cloudprovider.Logger
+	// heat is an orchestration service client.
+	heat *gophercloud.ServiceClient
+	// swift is an object storage service client, used for assets.
+	swift *gophercloud.ServiceClient
+	// network is a neutron networking service client.
+	network *gophercloud.ServiceClient
+	// identity is a keystone service client, used for node credentials.
+	identity *gophercloud.ServiceClient
+	// userID is an ID of the authenticated user.
+	userID string
+	// authURL and region are passed on to nodes along with their
+	// credential.
+	authURL string
+	region  string
+	// externalNetwork is the network floating IPs are allocated from.
+	externalNetwork string
+	// metadataURL is the metadata service URL, only used from a node.
+	metadataURL string
+}
+
+// Compile-time check whether Cloud type value implements
+// cloudprovider.Interface interface.
+var _ cloudprovider.Interface = (*Cloud)(nil)
+
+// ProviderName returns the cloud provider ID.
+func (c *Cloud) ProviderName() string {
+	return ProviderName
+}
+
+// Clusters returns an implementation of Clusters interface for OpenStack Cloud.
+func (c *Cloud) Clusters() (cloudprovider.Clusters, bool) {
+	return c, true
+}
+
+// NodePooler returns an implementation of NodePooler interface for
+// OpenStack Cloud.
+func (c *Cloud) NodePooler() (cloudprovider.NodePooler, bool) {
+	return c, true
+}
+
+// Bastions returns nil and false, because bastion pools are not supported on
+// OpenStack.
+func (c *Cloud) Bastions() (cloudprovider.Bastions, bool) {
+	return nil, false
+}
+
+// Checker returns nil and false, because cluster checks are not supported on
+// OpenStack.
+func (c *Cloud) Checker() (cloudprovider.Checker, bool) {
+	return nil, false
+}
+
+// CreateClusterInfra creates a new cluster, by creating persistent ports,
+// volumes, security groups and an assets container as well as a load
+// balancer for the Kubernetes API.
+func (c *Cloud) CreateClusterInfra(cluster model.Cluster) error {
+	if !cloudprovider.DefaultAccess(cluster.Access) {
+		return ErrAccessNotSupported
+	}
+	if !cloudprovider.DefaultLoadBalancerType(cluster.LoadBalancerType) {
+		return ErrLoadBalancerTypeNotSupported
+	}
+	if !cloudprovider.DefaultAPINames(cluster) {
+		return ErrAPINamesNotSupported
+	}
+	if !cloudprovider.PublicDNSZone(cluster) {
+		return ErrDNSZoneSelectionNotSupported
+	}
+	if !cloudprovider.ExistingNetworks(cluster) {
+		return ErrNetworkCreationNotSupported
+	}
+	subnets, err := c.describeSubnets(cluster.MasterPool.Networks)
+	if err != nil {
+		return err
+	}
+	if len(subnets) == 0 {
+		return errors.New("no networks found")
+	}
+	if !cluster.Internal && c.externalNetwork == "" {
+		return fmt.Errorf("%s must be set for clusters that are not internal", externalNetworkEnv)
+	}
+
+	// Subnet CIDRs are not proxied by nodes.
+	for _, s := range subnets {
+		cluster.NetworkCIDRs = append(cluster.NetworkCIDRs, s.CIDR)
+	}
+
+	if err := c.createClusterInfraStack(cluster, subnets); err != nil {
+		return err
+	}
+
+	// Nodes aren't given user credentials, they get an application
+	// credential that can only read cluster stacks and assets.
+	container, err := c.getAssetsContainerName(cluster.Name)
+	if err != nil {
+		return err
+	}
+	if err := c.createNodeCredential(cluster.Name, container); err != nil {
+		return err
+	}
+
+	// Load balancer scheme is determined via Masterpool.Internal
+	cluster.MasterPool.Internal = cluster.Internal
+
+	return c.createLoadBalancerStack(cluster, subnets)
+}
+
+// UpdateClusterInfra updates infra components of an existing cluster.
+func (c *Cloud) UpdateClusterInfra(cluster model.Cluster) error {
+	return ErrNotImplemented
+}
+
+// GetClusters returns a cluster by name or all clusters.
+func (c *Cloud) GetClusters(name string) ([]*model.Cluster, error) {
+	clusters := []*model.Cluster{}
+
+	stacks, err := c.getStacksByType(clusterInfraStackType)
+	if err != nil {
+		return clusters, err
+	}
+
+	for _, s := range stacks {
+		outputs := s.outputs()
+		if outputs[clusterNameOutputKey] == "" {
+			continue
+		}
+		if name != "" && outputs[clusterNameOutputKey] != name {
+			continue
+		}
+		cl := &model.Cluster{}
+		cl.Name = outputs[clusterNameOutputKey]
+		cl.Internal, _ = strconv.ParseBool(outputs[internalClusterOutputKey])
+		cl.Labels = getStackLabels(s)
+		cl.NetworkProvider = outputs[networkProviderOutputKey]
+		cl.PodCIDR = outputs[podCIDROutputKey]
+		cl.ServiceCIDR = outputs[serviceCIDROutputKey]
+		cl.RegistryMirror = outputs[registryMirrorOutputKey]
+		cl.ArtifactsURL = outputs[artifactsURLOutputKey]
+		cl.Proxy.HTTPProxy = outputs[httpProxyOutputKey]
+		cl.Proxy.HTTPSProxy = outputs[httpsProxyOutputKey]
+		if outputs[noProxyOutputKey] != "" {
+			cl.Proxy.NoProxy = strings.Split(outputs[noProxyOutputKey], ",")
+		}
+		if outputs[networkCIDRsOutputKey] != "" {
+			cl.NetworkCIDRs = strings.Split(outputs[networkCIDRsOutputKey], ",")
+		}
+		lb, err := c.getStack(makeLoadBalancerStackName(cl.Name))
+		if err != nil {
+			return clusters, err
+		}
+		if lb != nil {
+			cl.KubeAPIURL = formatKubeAPIURL(lb.outputs()[apiAddressOutputKey])
+		}
+		manifest, err := components.Decode(outputs[componentsOutputKey])
+		if err != nil {
+			return clusters, err
+		}
+		cl.Components = manifest
+		clusters = append(clusters, cl)
+	}
+	return clusters, nil
+}
+
+// DescribeCluster describes a given cluster.
+func (c *Cloud) DescribeCluster(name string) error {
+	return ErrNotImplemented
+}
+
+// DeleteCluster deletes a cluster.
+func (c *Cloud) DeleteCluster(name string) error {
+	c.Logger.Printf("deleting compute pools that belong to cluster %q", name)
+	if err := c.DeleteComputePool(name, ""); err != nil {
+		return err
+	}
+
+	c.Logger.Printf("deleting master pool that belongs to cluster %q", name)
+	if err := c.DeleteMasterPool(name); err != nil {
+		return err
+	}
+
+	c.Logger.Printf("deleting load balancer stack that belongs to cluster %q", name)
+	if err := c.deleteStack(makeLoadBalancerStackName(name)); err != nil {
+		return err
+	}
+
+	// A swift container must be empty before it can be deleted.
+	container, err := c.getAssetsContainerName(name)
+	if err != nil {
+		return err
+	}
+	if container != "" {
+		if err := c.deleteObjects(container, append(assetObjectNames(), nodeCredentialObjectName)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.deleteNodeCredential(name); err != nil {
+		return err
+	}
+
+	return c.deleteStack(makeClusterInfraStackName(name))
+}
+
+// GetMasterPersistentIPs returns a map of master persistent NodeID
+// values and private IPs for a given clusterName.
+func (c *Cloud) GetMasterPersistentIPs(clusterName string) (map[string]string, error) {
+	m := make(map[string]string)
+
+	pp, err := c.describePersistentPorts(clusterName)
+	if err != nil {
+		return m, err
+	}
+
+	for _, p := range pp {
+		id := getPortNodeID(p)
+		if id == "" || len(p.FixedIPs) == 0 {
+			continue
+		}
+		m[id] = p.FixedIPs[0].IPAddress
+	}
+	return m, nil
+}
+
+// getPortNodeID extracts a NodeID tag value from a port. Returns an empty
+// string if no such tag exists.
+func getPortNodeID(p ports.Port) string {
+	for _, t := range p.Tags {
+		if strings.HasPrefix(t, nodeIDTagKey+"=") {
+			return strings.TrimPrefix(t, nodeIDTagKey+"=")
+		}
+	}
+	return ""
+}
+
+// describePersistentPorts returns a list of persistent master ports, that are
+// used by etcd.
+func (c *Cloud) describePersistentPorts(clusterName string) ([]ports.Port, error) {
+	opts := ports.ListOpts{
+		Tags: strings.Join([]string{managedByKetoTag, makeTag(clusterNameTagKey, clusterName)}, ","),
+	}
+	pages, err := ports.List(c.network, opts).AllPages()
+	if err != nil {
+		return []ports.Port{}, err
+	}
+	pp, err := ports.ExtractPorts(pages)
+	if err != nil {
+		return []ports.Port{}, err
+	}
+
+	// Only ports with a NodeID tag are persistent.
+	persistent := []ports.Port{}
+	for _, p := range pp {
+		if getPortNodeID(p) != "" {
+			persistent = append(persistent, p)
+		}
+	}
+	return persistent, nil
+}
+
+// PushAssets pushes assets to a swift container.
+func (c *Cloud) PushAssets(clusterName string, a model.Assets) error {
+	container, err := c.getAssetsContainerName(clusterName)
+	if err != nil {
+		return err
+	}
+	if container == "" {
+		return fmt.Errorf("assets container of cluster %q not found", clusterName)
+	}
+
+	assets := map[string][]byte{
+		etcdCACertObjectName: a.EtcdCACert,
+		etcdCAKeyObjectName:  a.EtcdCAKey,
+		kubeCACertObjectName: a.KubeCACert,
+		kubeCAKeyObjectName:  a.KubeCAKey,
+	}
+	for _, name := range assetObjectNames() {
+		if err := c.putObject(container, name, assets[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func assetObjectNames() []string {
+	return []string{
+		etcdCACertObjectName,
+		etcdCAKeyObjectName,
+		kubeCACertObjectName,
+		kubeCAKeyObjectName,
+	}
+}
+
+// getAssetsContainerName returns assets swift container name from a cluster
+// infra stack.
+func (c *Cloud) getAssetsContainerName(clusterName string) (string, error) {
+	s, err := c.getStack(makeClusterInfraStackName(clusterName))
+	if err != nil || s == nil {
+		return "", err
+	}
+	return s.outputs()[assetsBucketNameOutputKey], nil
+}
+
+// putObject uploads b as objectName into a swift container.
+func (c *Cloud) putObject(container, objectName string, b []byte) error {
+	c.Logger.Printf("uploading object %q to swift container %q", objectName, container)
+	opts := objects.CreateOpts{
+		Content: bytes.NewReader(b),
+	}
+	return objects.Create(c.swift, container, objectName, opts).Err
+}
+
+// getObject downloads objectName from a swift container.
+func (c *Cloud) getObject(container, objectName string) ([]byte, error) {
+	c.Logger.Printf("fetching object %q from swift container %q", objectName, container)
+	r := objects.Download(c.swift, container, objectName, nil)
+	return r.ExtractContent()
+}
+
+// deleteObjects deletes given objects from a swift container. Objects that
+// do not exist are ignored.
+func (c *Cloud) deleteObjects(container string, names []string) error {
+	c.Logger.Printf("deleting objects %v from swift container %q", names, container)
+	for _, n := range names {
+		err := objects.Delete(c.swift, container, n, nil).Err
+		if _, ok := err.(gophercloud.ErrDefault404); ok {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateMasterPool creates a master node pool.
+func (c *Cloud) CreateMasterPool(p model.MasterPool) error {
+	// At this point a cluster infra has created persistent ports, master
+	// nodes are attached to them, so MasterPool.Networks are overwritten.
+	pp, err := c.describePersistentPorts(p.ClusterName)
+	if err != nil {
+		return err
+	}
+
+	infra, err := c.getStack(makeClusterInfraStackName(p.ClusterName))
+	if err != nil {
+		return err
+	}
+	lb, err := c.getStack(makeLoadBalancerStackName(p.ClusterName))
+	if err != nil {
+		return err
+	}
+	if infra == nil || lb == nil {
+		return fmt.Errorf("infra stacks of cluster %q not found", p.ClusterName)
+	}
+
+	nodes, err := makeMasterNodes(pp, infra.outputs())
+	if err != nil {
+		return err
+	}
+	p.Networks = []string{}
+	for _, n := range nodes {
+		p.Networks = appendUnique(p.Networks, n.Subnet)
+	}
+	sort.Strings(p.Networks)
+
+	return c.createMasterPoolStack(p, nodes, infra.outputs(), lb.outputs())
+}
+
+// makeMasterNodes returns master node placements given persistent ports
+// and volumes of a cluster infra stack, ordered by NodeID.
+func makeMasterNodes(pp []ports.Port, infraOutputs map[string]string) ([]nodesNetwork, error) {
+	nodes := []nodesNetwork{}
+	for _, port := range pp {
+		id, err := strconv.Atoi(getPortNodeID(port))
+		if err != nil {
+			return nodes, fmt.Errorf("invalid NodeID of port %q: %v", port.ID, err)
+		}
+		if len(port.FixedIPs) == 0 {
+			return nodes, fmt.Errorf("port %q has no IP", port.ID)
+		}
+		volume := infraOutputs[makeVolumeOutputKey(id)]
+		if volume == "" {
+			return nodes, fmt.Errorf("persistent volume of node %d not found", id)
+		}
+		nodes = append(nodes, nodesNetwork{
+			Subnet:  port.FixedIPs[0].SubnetID,
+			Network: port.NetworkID,
+			NodeID:  id,
+			Port:    port.ID,
+			IP:      port.FixedIPs[0].IPAddress,
+			Volume:  volume,
+		})
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].NodeID < nodes[j].NodeID })
+	return nodes, nil
+}
+
+// CreateComputePool creates a compute node pool.
+func (c *Cloud) CreateComputePool(p model.ComputePool) error {
+	if !cloudprovider.OnDemandOnly(p.InstancesPolicy) {
+		return ErrInstancesPolicyNotSupported
+	}
+	if !cloudprovider.FixedSize(p.NodePoolSpec) {
+		return ErrAutoscalingNotSupported
+	}
+	if len(p.AllowFromPools) > 0 {
+		return ErrAllowFromPoolsNotSupported
+	}
+	subnets, err := c.describeSubnets(p.Networks)
+	if err != nil {
+		return err
+	}
+	if len(subnets) == 0 {
+		return errors.New("no networks found")
+	}
+
+	infra, err := c.getStack(makeClusterInfraStackName(p.ClusterName))
+	if err != nil {
+		return err
+	}
+	lb, err := c.getStack(makeLoadBalancerStackName(p.ClusterName))
+	if err != nil {
+		return err
+	}
+	if infra == nil || lb == nil {
+		return fmt.Errorf("infra stacks of cluster %q not found", p.ClusterName)
+	}
+
+	return c.createComputePoolStack(p, subnets, infra.outputs(), lb.outputs())
+}
+
+// GetMasterPools returns a list of master pools. Pools can be filtered by
+// their name / cluster.
+func (c *Cloud) GetMasterPools(clusterName, name string) ([]*model.MasterPool, error) {
+	pools := []*model.MasterPool{}
+
+	stacks, err := c.getStacksByType(masterPoolStackType)
+	if err != nil {
+		return pools, err
+	}
+	for _, s := range stacks {
+		np, ok, err := stackNodePool(s, clusterName, name)
+		if err != nil {
+			return pools, err
+		}
+		if ok {
+			pools = append(pools, &model.MasterPool{NodePool: np})
+		}
+	}
+	return pools, nil
+}
+
+// GetComputePools returns a list of compute pools. Pools can be filtered by
+// their name / cluster.
+func (c *Cloud) GetComputePools(clusterName, name string) ([]*model.ComputePool, error) {
+	pools := []*model.ComputePool{}
+
+	stacks, err := c.getStacksByType(computePoolStackType)
+	if err != nil {
+		return pools, err
+	}
+	for _, s := range stacks {
+		np, ok, err := stackNodePool(s, clusterName, name)
+		if err != nil {
+			return pools, err
+		}
+		if ok {
+			pools = append(pools, &model.ComputePool{NodePool: np})
+		}
+	}
+	return pools, nil
+}
+
+// stackNodePool returns a model.NodePool made from a given stack outputs. It
+// returns false if the stack does not match the clusterName / name filters.
+func stackNodePool(s *stack, clusterName, name string) (model.NodePool, bool, error) {
+	p := model.NodePool{}
+	o := s.outputs()
+	if clusterName != "" && o[clusterNameOutputKey] != clusterName {
+		return p, false, nil
+	}
+	if name != "" && o[poolNameOutputKey] != name {
+		return p, false, nil
+	}
+
+	p.ClusterName = o[clusterNameOutputKey]
+	p.Name = o[poolNameOutputKey]
+	p.KubeVersion = o[kubeVersionOutputKey]
+	p.CoreOSVersion = o[coreOSVersionOutputKey]
+	p.MachineType = o[machineTypeOutputKey]
+	p.KubeletExtraArgs = o[kubeletExtraArgsOutputKey]
+	p.APIServerExtraArgs = o[apiServerExtraArgsOutputKey]
+	p.ControllerManagerExtraArgs = o[controllerManagerExtraArgsOutputKey]
+	p.SchedulerExtraArgs = o[schedulerExtraArgsOutputKey]
+	p.UserDataHash = o[userDataHashOutputKey]
+	if v := o[diskSizeOutputKey]; v != "" {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return p, false, err
+		}
+		p.DiskSize = i
+	}
+	p.Internal, _ = strconv.ParseBool(o[internalClusterOutputKey])
+	p.Labels = getStackLabels(s)
+	p.Taints = getStackTaints(s)
+	return p, true, nil
+}
+
+// DescribeNodePool describes a node pool.
+func (c *Cloud) DescribeNodePool() error {
+	return ErrNotImplemented
+}
+
+// GetPoolInstances returns a list of node pool instances.
+func (c *Cloud) GetPoolInstances(clusterName, poolName string) ([]*model.Instance, error) {
+	return nil, ErrNotImplemented
+}
+
+// GetInstanceConsoleOutput returns console output of an instance.
+func (c *Cloud) GetInstanceConsoleOutput(instanceID string) (string, error) {
+	return "", ErrNotImplemented
+}
+
+// UpgradeMasterPool upgrades a master node pool.
+func (c *Cloud) UpgradeMasterPool(p model.MasterPool) error {
+	return ErrNotImplemented
+}
+
+// UpgradeComputePool upgrades a compute node pool.
+func (c *Cloud) UpgradeComputePool(p model.ComputePool) error {
+	return ErrNotImplemented
+}
+
+// DeleteMasterPool deletes a master node pool.
+func (c *Cloud) DeleteMasterPool(clusterName string) error {
+	stacks, err := c.getStacksByType(masterPoolStackType)
+	if err != nil {
+		return err
+	}
+	for _, s := range stacks {
+		if s.outputs()[clusterNameOutputKey] == clusterName {
+			if err := c.deleteStack(s.Name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DeleteComputePool deletes a compute node pool. All compute pools of the
+// cluster are deleted if name is empty.
+func (c *Cloud) DeleteComputePool(clusterName, name string) error {
+	stacks, err := c.getStacksByType(computePoolStackType)
+	if err != nil {
+		return err
+	}
+	for _, s := range stacks {
+		o := s.outputs()
+		if o[clusterNameOutputKey] != clusterName {
+			continue
+		}
+		if name != "" && o[poolNameOutputKey] != name {
+			continue
+		}
+		if err := c.deleteStack(s.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// describeSubnets returns a list of neutron subnets given their IDs.
+func (c *Cloud) describeSubnets(ids []string) ([]subnets.Subnet, error) {
+	c.Logger.Printf("describing a list of subnets %v", ids)
+	list := []subnets.Subnet{}
+	for _, id := range ids {
+		s, err := subnets.Get(c.network, id).Extract()
+		if err != nil {
+			return list, fmt.Errorf("failed to describe subnet %q: %v", id, err)
+		}
+		list = append(list, *s)
+	}
+	// Make sure subnets are always in the same order.
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+// appendUnique appends v to s unless s already contains it.
+func appendUnique(s []string, v string) []string {
+	for _, i := range s {
+		if i == v {
+			return s
+		}
+	}
+	return append(s, v)
+}
+
+// makeTag returns a key=value string tag.
+func makeTag(k, v string) string {
+	return k + "=" + v
+}
+
+// init registers OpenStack cloud with the cloudprovider.
+func init() {
+	// f knows how to initialize the cloud
+	f := func(l cloudprovider.Logger) (cloudprovider.Interface, error) {
+		opts, region, err := authOptions()
+		if err != nil {
+			return &Cloud{}, err
+		}
+
+		provider, err := openstack.AuthenticatedClient(opts)
+		if err != nil {
+			return &Cloud{}, fmt.Errorf("failed to authenticate: %v", err)
+		}
+		return newCloud(provider, gophercloud.EndpointOpts{Region: region}, l)
+	}
+	cloudprovider.Register(ProviderName, f)
+}
+
+// authOptions returns auth options and a region from OS_* environment
+// variables. Nodes have no such variables, they use the node credential
+// from their instance metadata instead.
+func authOptions() (gophercloud.AuthOptions, string, error) {
+	if os.Getenv("OS_AUTH_URL") == "" {
+		m, err := fetchInstanceMetadata(defaultMetadataURL)
+		if err != nil {
+			return gophercloud.AuthOptions{}, "", fmt.Errorf("OS_AUTH_URL is not set and instance metadata is not available: %v", err)
+		}
+		return nodeAuthOptions(m)
+	}
+
+	opts, err := openstack.AuthOptionsFromEnv()
+	if err != nil {
+		return opts, "", err
+	}
+	// Allow tokens to be refreshed for long running stack operations.
+	opts.AllowReauth = true
+	return opts, os.Getenv("OS_REGION_NAME"), nil
+}
+
+// newCloud creates a new instance of OpenStack Cloud given an authenticated
+// provider client.
+func newCloud(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts, l cloudprovider.Logger) (*Cloud, error) {
+	heat, err := openstack.NewOrchestrationV1(provider, eo)
+	if err != nil {
+		return &Cloud{}, err
+	}
+	swift, err := openstack.NewObjectStorageV1(provider, eo)
+	if err != nil {
+		return &Cloud{}, err
+	}
+	network, err := openstack.NewNetworkV2(provider, eo)
+	if err != nil {
+		return &Cloud{}, err
+	}
+	identity, err := openstack.NewIdentityV3(provider, eo)
+	if err != nil {
+		return &Cloud{}, err
+	}
+
+	c := &Cloud{
+		Logger:          l,
+		heat:            heat,
+		swift:           swift,
+		network:         network,
+		identity:        identity,
+		authURL:         provider.IdentityEndpoint,
+		region:          eo.Region,
+		externalNetwork: os.Getenv(externalNetworkEnv),
+		metadataURL:     defaultMetadataURL,
+	}
+	// Keystone v3 token results know the authenticated user.
+	if r, ok := provider.GetAuthResult().(interface {
+		ExtractUser() (*tokens.User, error)
+	}); ok {
+		u, err := r.ExtractUser()
+		if err != nil {
+			return &Cloud{}, err
+		}
+		c.userID = u.ID
+	}
+	return c, nil
+}
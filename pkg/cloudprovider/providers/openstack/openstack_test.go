@@ -0,0 +1,351 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/UKHomeOffice/keto/pkg/model"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+)
+
+const (
+	clusterName = "foo"
+
+	infraStackJSON = `{
+  "stack": {
+    "id": "infra-id",
+    "stack_name": "keto-foo-infra",
+    "stack_status": "CREATE_COMPLETE",
+    "tags": ["managed-by-keto", "cluster-name=foo", "stack-type=infra"],
+    "outputs": [
+      {"output_key": "ClusterName", "output_value": "foo"},
+      {"output_key": "InternalCluster", "output_value": "true"},
+      {"output_key": "Labels", "output_value": "env=test"},
+      {"output_key": "AssetsBucketName", "output_value": "keto-foo-assets"}
+    ]
+  }
+}`
+
+	masterStackJSON = `{
+  "stack": {
+    "id": "master-id",
+    "stack_name": "keto-foo-masterpool-blue",
+    "stack_status": "CREATE_COMPLETE",
+    "tags": ["managed-by-keto", "cluster-name=foo", "stack-type=masterpool"],
+    "outputs": [
+      {"output_key": "ClusterName", "output_value": "foo"},
+      {"output_key": "PoolName", "output_value": "master"},
+      {"output_key": "KubeAPIURL", "output_value": "https://kube-foo.example.com"},
+      {"output_key": "KubeVersion", "output_value": "v1.7.0"},
+      {"output_key": "DiskSize", "output_value": "10"},
+      {"output_key": "Labels", "output_value": "role=master"},
+      {"output_key": "Taints", "output_value": ""},
+      {"output_key": "AssetsBucketName", "output_value": "keto-foo-assets"}
+    ]
+  }
+}`
+
+	portsJSON = `{
+  "ports": [
+    {
+      "id": "port0",
+      "tags": ["managed-by-keto", "cluster-name=foo", "NodeID=0"],
+      "fixed_ips": [{"subnet_id": "subnet0", "ip_address": "10.0.0.10"}]
+    },
+    {
+      "id": "port1",
+      "tags": ["managed-by-keto", "cluster-name=foo", "NodeID=1"],
+      "fixed_ips": [{"subnet_id": "subnet1", "ip_address": "10.0.1.10"}]
+    },
+    {
+      "id": "port2",
+      "tags": ["managed-by-keto", "cluster-name=foo"],
+      "fixed_ips": [{"subnet_id": "subnet1", "ip_address": "10.0.1.11"}]
+    }
+  ]
+}`
+)
+
+// newTestCloud returns a Cloud with all service clients pointing at a local
+// stub of the OpenStack APIs.
+func newTestCloud(mux *http.ServeMux) (*Cloud, func()) {
+	server := httptest.NewServer(mux)
+
+	provider := &gophercloud.ProviderClient{TokenID: "token"}
+	client := func(path string) *gophercloud.ServiceClient {
+		return &gophercloud.ServiceClient{
+			ProviderClient: provider,
+			Endpoint:       server.URL + path,
+		}
+	}
+
+	c := &Cloud{
+		Logger:      log.New(ioutil.Discard, "", 0),
+		heat:        client("/heat/"),
+		swift:       client("/swift/"),
+		metadataURL: server.URL + "/metadata/meta_data.json",
+	}
+	// Networking service resources live under a versioned path.
+	c.network = client("/network/")
+	c.network.ResourceBase = c.network.Endpoint + "v2.0/"
+
+	return c, server.Close
+}
+
+func writeJSON(w http.ResponseWriter, s string) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, s)
+}
+
+func TestGetClusters(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/heat/stacks", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("tags"); got != "managed-by-keto,stack-type=infra" {
+			t.Errorf("unexpected tags filter %q", got)
+		}
+		writeJSON(w, `{"stacks": [{"id": "infra-id", "stack_name": "keto-foo-infra"}]}`)
+	})
+	mux.HandleFunc("/heat/stacks/keto-foo-infra", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, infraStackJSON)
+	})
+
+	c, done := newTestCloud(mux)
+	defer done()
+
+	clusters, err := c.GetClusters(clusterName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clusters) != 1 {
+		t.Fatalf("expected 1 cluster, got %d", len(clusters))
+	}
+
+	expected := &model.Cluster{}
+	expected.Name = clusterName
+	expected.Internal = true
+	expected.Labels = model.Labels{"env": "test"}
+	if !reflect.DeepEqual(clusters[0], expected) {
+		t.Errorf("expected %#v, got %#v", expected, clusters[0])
+	}
+}
+
+func TestGetMasterPersistentIPs(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/network/v2.0/ports", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("tags"); got != "managed-by-keto,cluster-name=foo" {
+			t.Errorf("unexpected tags filter %q", got)
+		}
+		writeJSON(w, portsJSON)
+	})
+
+	c, done := newTestCloud(mux)
+	defer done()
+
+	ips, err := c.GetMasterPersistentIPs(clusterName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"0": "10.0.0.10",
+		"1": "10.0.1.10",
+	}
+	if !reflect.DeepEqual(ips, expected) {
+		t.Errorf("expected %v, got %v", expected, ips)
+	}
+}
+
+func TestPushAssets(t *testing.T) {
+	uploaded := map[string]string{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/heat/stacks/keto-foo-infra", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, infraStackJSON)
+	})
+	mux.HandleFunc("/swift/keto-foo-assets/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			t.Errorf("unexpected method %s", r.Method)
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		uploaded[r.URL.Path] = string(b)
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	c, done := newTestCloud(mux)
+	defer done()
+
+	a := model.Assets{
+		EtcdCACert: []byte("etcd cert"),
+		EtcdCAKey:  []byte("etcd key"),
+		KubeCACert: []byte("kube cert"),
+		KubeCAKey:  []byte("kube key"),
+	}
+	if err := c.PushAssets(clusterName, a); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"/swift/keto-foo-assets/etcd_ca.crt": "etcd cert",
+		"/swift/keto-foo-assets/etcd_ca.key": "etcd key",
+		"/swift/keto-foo-assets/kube_ca.crt": "kube cert",
+		"/swift/keto-foo-assets/kube_ca.key": "kube key",
+	}
+	if !reflect.DeepEqual(uploaded, expected) {
+		t.Errorf("expected %v, got %v", expected, uploaded)
+	}
+}
+
+func TestGetMasterPools(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/heat/stacks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, `{"stacks": [{"id": "master-id", "stack_name": "keto-foo-masterpool-blue"}]}`)
+	})
+	mux.HandleFunc("/heat/stacks/keto-foo-masterpool-blue", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, masterStackJSON)
+	})
+
+	c, done := newTestCloud(mux)
+	defer done()
+
+	pools, err := c.GetMasterPools(clusterName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pools) != 1 {
+		t.Fatalf("expected 1 pool, got %d", len(pools))
+	}
+	p := pools[0]
+	if p.Name != "master" || p.KubeVersion != "v1.7.0" || p.DiskSize != 10 {
+		t.Errorf("unexpected master pool %#v", p)
+	}
+
+	pools, err = c.GetMasterPools("bar", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pools) != 0 {
+		t.Errorf("expected no pools, got %d", len(pools))
+	}
+}
+
+func TestDeleteStackNotFound(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/heat/stacks/", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+
+	c, done := newTestCloud(mux)
+	defer done()
+
+	if err := c.deleteStack("keto-foo-lb"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGetNodeData(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metadata/meta_data.json", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, `{"uuid": "server0", "meta": {"keto-stack-name": "keto-foo-masterpool-blue"}}`)
+	})
+	mux.HandleFunc("/heat/stacks/keto-foo-masterpool-blue", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, masterStackJSON)
+	})
+
+	c, done := newTestCloud(mux)
+	defer done()
+
+	data, err := c.GetNodeData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := model.NodeData{
+		ClusterName: clusterName,
+		KubeAPIURL:  "https://kube-foo.example.com",
+		KubeVersion: "v1.7.0",
+		Labels:      model.Labels{"role": "master"},
+		Taints:      model.Taints{},
+	}
+	if !reflect.DeepEqual(data, expected) {
+		t.Errorf("expected %#v, got %#v", expected, data)
+	}
+}
+
+func TestMakeMasterNodes(t *testing.T) {
+	pp := []ports.Port{
+		{
+			ID:        "port1",
+			NetworkID: "network0",
+			Tags:      []string{"managed-by-keto", "cluster-name=foo", "NodeID=1"},
+			FixedIPs:  []ports.IP{{SubnetID: "subnet1", IPAddress: "10.0.1.10"}},
+		},
+		{
+			ID:        "port0",
+			NetworkID: "network0",
+			Tags:      []string{"managed-by-keto", "cluster-name=foo", "NodeID=0"},
+			FixedIPs:  []ports.IP{{SubnetID: "subnet0", IPAddress: "10.0.0.10"}},
+		},
+	}
+	outputs := map[string]string{"Volume0": "volume0", "Volume1": "volume1"}
+
+	nodes, err := makeMasterNodes(pp, outputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []nodesNetwork{
+		{Subnet: "subnet0", Network: "network0", NodeID: 0, Port: "port0", IP: "10.0.0.10", Volume: "volume0"},
+		{Subnet: "subnet1", Network: "network0", NodeID: 1, Port: "port1", IP: "10.0.1.10", Volume: "volume1"},
+	}
+	if !reflect.DeepEqual(nodes, expected) {
+		t.Errorf("expected %#v, got %#v", expected, nodes)
+	}
+
+	delete(outputs, "Volume1")
+	if _, err := makeMasterNodes(pp, outputs); err == nil {
+		t.Error("expected an error on a missing persistent volume")
+	}
+}
+
+func TestNodeAuthOptions(t *testing.T) {
+	m := instanceMetadata{Meta: map[string]string{
+		"keto-auth-url":          "https://keystone/v3",
+		"keto-region":            "RegionOne",
+		"keto-credential-id":     "credential0",
+		"keto-credential-secret": "secret",
+	}}
+
+	opts, region, err := nodeAuthOptions(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.IdentityEndpoint != "https://keystone/v3" || opts.ApplicationCredentialID != "credential0" ||
+		opts.ApplicationCredentialSecret != "secret" || region != "RegionOne" {
+		t.Errorf("unexpected auth options %+v, region %q", opts, region)
+	}
+
+	delete(m.Meta, "keto-credential-secret")
+	if _, _, err := nodeAuthOptions(m); err == nil {
+		t.Error("expected an error on a missing credential secret")
+	}
+}
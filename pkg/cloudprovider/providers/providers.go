@@ -19,4 +19,6 @@ package cloudprovider
 import (
 	// Register cloud providers.
 	_ "github.com/UKHomeOffice/keto/pkg/cloudprovider/providers/aws"
+	_ "github.com/UKHomeOffice/keto/pkg/cloudprovider/providers/gce"
+	_ "github.com/UKHomeOffice/keto/pkg/cloudprovider/providers/openstack"
 )
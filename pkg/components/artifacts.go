@@ -0,0 +1,272 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package components
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/UKHomeOffice/keto/pkg/constants"
+	"github.com/UKHomeOffice/keto/pkg/model"
+)
+
+const (
+	// ArtifactTypeImage is a container image artifact.
+	ArtifactTypeImage = "image"
+	// ArtifactTypeFile is a downloadable file artifact.
+	ArtifactTypeFile = "file"
+
+	// manifestFileName is a name of artifacts manifest in exported tarballs.
+	manifestFileName = "artifacts.json"
+)
+
+// Artifact is an image or a file which cluster nodes pull at boot.
+type Artifact struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Source string `json:"source"`
+	MD5Sum string `json:"md5sum,omitempty"`
+}
+
+// networkProviderImages are images that keto-k8 deploys for each network
+// provider.
+var networkProviderImages = map[string][]string{
+	constants.NetworkProviderCanal: {
+		"quay.io/calico/node:v2.4.1",
+		"quay.io/calico/cni:v1.10.0",
+		"quay.io/coreos/flannel:v0.8.0",
+	},
+	constants.NetworkProviderCalico: {
+		"quay.io/calico/node:v2.4.1",
+		"quay.io/calico/cni:v1.10.0",
+		"quay.io/calico/kube-policy-controller:v0.7.0",
+	},
+	constants.NetworkProviderFlannel: {
+		"quay.io/coreos/flannel:v0.8.0-amd64",
+	},
+	constants.NetworkProviderWeave: {
+		"weaveworks/weave-kube:2.0.1",
+		"weaveworks/weave-npc:2.0.1",
+	},
+}
+
+// Artifacts returns a list of artifacts that given components need, along
+// with kube control plane images of a kube version and images of a network
+// provider, which keto-k8 deploys.
+func Artifacts(c model.Components, kubeVersion, networkProvider string) []Artifact {
+	artifacts := []Artifact{
+		{Name: "keto-k8", Type: ArtifactTypeImage, Source: c.KetoK8Image},
+		{Name: "keto-tokens", Type: ArtifactTypeImage, Source: c.KetoTokensImage},
+		{Name: "etcd", Type: ArtifactTypeImage, Source: constants.DefaultEtcdImage + ":" + c.EtcdImageTag},
+		{Name: "smilodon", Type: ArtifactTypeFile, Source: c.SmilodonURL, MD5Sum: c.SmilodonMD5Sum},
+	}
+	images := KubeImages(kubeVersion)
+	images = append(images, networkProviderImages[networkProvider]...)
+	for _, i := range images {
+		artifacts = append(artifacts, Artifact{Name: imageName(i), Type: ArtifactTypeImage, Source: i})
+	}
+	return artifacts
+}
+
+// KubeImages returns kube control plane images of a given kube version.
+func KubeImages(kubeVersion string) []string {
+	images := []string{}
+	for _, n := range []string{"kube-apiserver", "kube-controller-manager", "kube-scheduler", "kube-proxy"} {
+		images = append(images, fmt.Sprintf("%s/%s-amd64:%s", constants.KubeImageRepository, n, kubeVersion))
+	}
+	images = append(images, fmt.Sprintf("%s/pause-amd64:%s", constants.KubeImageRepository, constants.KubePauseImageTag))
+	for _, n := range []string{"k8s-dns-kube-dns", "k8s-dns-dnsmasq-nanny", "k8s-dns-sidecar"} {
+		images = append(images, fmt.Sprintf("%s/%s-amd64:%s", constants.KubeImageRepository, n, constants.KubeDNSImageTag))
+	}
+	return images
+}
+
+// imageName returns an image name without its registry, path and tag, e.g.
+// node for quay.io/calico/node:v2.4.1.
+func imageName(image string) string {
+	return strings.SplitN(path.Base(image), ":", 2)[0]
+}
+
+// Mirror rewrites component images to be pulled from a registry mirror and
+// binaries to be downloaded from an artifacts base URL. Empty registry or
+// artifacts URL leaves respective components unchanged.
+func Mirror(c model.Components, registry, artifactsURL string) model.Components {
+	if registry != "" {
+		c.KetoK8Image = MirrorImage(c.KetoK8Image, registry)
+		c.KetoTokensImage = MirrorImage(c.KetoTokensImage, registry)
+	}
+	if artifactsURL != "" {
+		c.SmilodonURL = strings.TrimRight(artifactsURL, "/") + "/" + path.Base(c.SmilodonURL)
+	}
+	return c
+}
+
+// MirrorImage replaces a registry of an image reference with a given
+// registry mirror. Docker Hub images get their implicit library namespace.
+func MirrorImage(image, registry string) string {
+	parts := strings.SplitN(image, "/", 2)
+	name := image
+	switch {
+	case len(parts) == 1:
+		name = "library/" + image
+	case strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost":
+		name = parts[1]
+	}
+	return strings.TrimRight(registry, "/") + "/" + name
+}
+
+// ValidateMirror validates a registry mirror and an artifacts base URL.
+func ValidateMirror(registry, artifactsURL string) error {
+	if registry != "" && strings.Contains(registry, "://") {
+		return fmt.Errorf("invalid registry mirror %q, must not have a scheme", registry)
+	}
+	if artifactsURL != "" {
+		u, err := url.Parse(artifactsURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid artifacts URL %q, must be an http(s) URL", artifactsURL)
+		}
+	}
+	return nil
+}
+
+// Fetcher fetches an artifact and writes its content to w.
+type Fetcher func(a Artifact, w io.Writer) error
+
+// Export writes a gzipped tarball with given artifacts to w. Files are
+// written to files/ and can be served as an artifacts base URL. Images are
+// written to images/ as docker save tarballs. An artifacts.json manifest
+// lists all exported artifacts. Artifacts are fetched into a temporary file
+// one at a time, so images aren't held in memory.
+func Export(w io.Writer, artifacts []Artifact, fetch Fetcher) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	for _, a := range artifacts {
+		if err := exportArtifact(tw, a, fetch); err != nil {
+			return err
+		}
+	}
+
+	manifest, err := json.MarshalIndent(artifacts, "", "  ")
+	if err != nil {
+		return err
+	}
+	h := &tar.Header{
+		Name:    manifestFileName,
+		Mode:    0644,
+		Size:    int64(len(manifest)),
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(h); err != nil {
+		return err
+	}
+	if _, err := tw.Write(manifest); err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// exportArtifact fetches an artifact into a temporary file and copies it to
+// a tarball, as tar headers need a size upfront.
+func exportArtifact(tw *tar.Writer, a Artifact, fetch Fetcher) error {
+	f, err := ioutil.TempFile("", "keto-artifact-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if err := fetch(a, f); err != nil {
+		return fmt.Errorf("failed to fetch %s %q: %v", a.Type, a.Source, err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	h := &tar.Header{
+		Name:    artifactPath(a),
+		Mode:    0644,
+		Size:    fi.Size(),
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(h); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// artifactPath returns a path of an artifact in an exported tarball.
+func artifactPath(a Artifact) string {
+	if a.Type == ArtifactTypeImage {
+		return path.Join("images", a.Name+".tar")
+	}
+	return path.Join("files", path.Base(a.Source))
+}
+
+// Fetch fetches an artifact to w. Files are downloaded and their checksums
+// are verified, images are pulled and saved with docker.
+func Fetch(a Artifact, w io.Writer) error {
+	if a.Type == ArtifactTypeImage {
+		if err := exec.Command("docker", "pull", a.Source).Run(); err != nil {
+			return fmt.Errorf("docker pull failed: %v", err)
+		}
+		cmd := exec.Command("docker", "save", a.Source)
+		cmd.Stdout = w
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("docker save failed: %v", err)
+		}
+		return nil
+	}
+
+	resp, err := http.Get(a.Source)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %q", resp.Status)
+	}
+	sum := md5.New()
+	if _, err := io.Copy(io.MultiWriter(w, sum), resp.Body); err != nil {
+		return err
+	}
+	if a.MD5Sum != "" && hex.EncodeToString(sum.Sum(nil)) != a.MD5Sum {
+		return fmt.Errorf("md5sum mismatch")
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package components
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/UKHomeOffice/keto/pkg/constants"
+)
+
+func TestMirrorImage(t *testing.T) {
+	cases := map[string]string{
+		"quay.io/ukhomeofficedigital/keto-k8:v0.2.3": "mirror:5000/ukhomeofficedigital/keto-k8:v0.2.3",
+		"localhost/foo:v1":                           "mirror:5000/foo:v1",
+		"nginx:1.13":                                 "mirror:5000/library/nginx:1.13",
+		"coreos/etcd":                                "mirror:5000/coreos/etcd",
+	}
+	for image, want := range cases {
+		if got := MirrorImage(image, "mirror:5000/"); got != want {
+			t.Errorf("got %q; want %q", got, want)
+		}
+	}
+}
+
+func TestMirror(t *testing.T) {
+	c := Mirror(Default(), "", "")
+	if c != Default() {
+		t.Errorf("expected components to be unchanged without mirrors")
+	}
+
+	c = Mirror(Default(), "mirror:5000", "https://artifacts/keto")
+	if c.KetoK8Image != "mirror:5000/ukhomeofficedigital/keto-k8:v0.2.3" {
+		t.Errorf("unexpected keto-k8 image %q", c.KetoK8Image)
+	}
+	if c.KetoTokensImage != "mirror:5000/ukhomeofficedigital/keto-tokens:v0.0.3" {
+		t.Errorf("unexpected keto-tokens image %q", c.KetoTokensImage)
+	}
+	if c.SmilodonURL != "https://artifacts/keto/smilodon-0.1.0-linux-amd64" {
+		t.Errorf("unexpected smilodon URL %q", c.SmilodonURL)
+	}
+	if c.SmilodonMD5Sum != Default().SmilodonMD5Sum {
+		t.Errorf("expected smilodon md5sum to be unchanged")
+	}
+}
+
+func TestValidateMirror(t *testing.T) {
+	if err := ValidateMirror("mirror:5000", "https://artifacts/keto"); err != nil {
+		t.Error(err)
+	}
+	if err := ValidateMirror("https://mirror:5000", ""); err == nil {
+		t.Errorf("expected an error for a registry mirror with a scheme")
+	}
+	if err := ValidateMirror("", "artifacts/keto"); err == nil {
+		t.Errorf("expected an error for an artifacts URL without a scheme")
+	}
+}
+
+func TestArtifacts(t *testing.T) {
+	sources := make(map[string]bool)
+	for _, a := range Artifacts(Default(), "v1.7.2", constants.NetworkProviderWeave) {
+		sources[a.Source] = true
+	}
+	for _, s := range []string{
+		constants.DefaultKetoTokensImage,
+		"gcr.io/google_containers/kube-apiserver-amd64:v1.7.2",
+		"gcr.io/google_containers/kube-proxy-amd64:v1.7.2",
+		"gcr.io/google_containers/pause-amd64:3.0",
+		"weaveworks/weave-kube:2.0.1",
+	} {
+		if !sources[s] {
+			t.Errorf("expected %q in artifacts", s)
+		}
+	}
+	if sources["quay.io/calico/node:v2.4.1"] {
+		t.Errorf("expected no images of other network providers")
+	}
+}
+
+func TestExport(t *testing.T) {
+	var b bytes.Buffer
+	fetch := func(a Artifact, w io.Writer) error {
+		_, err := io.WriteString(w, a.Source)
+		return err
+	}
+	if err := Export(&b, Artifacts(Default(), "v1.7.2", constants.NetworkProviderCanal), fetch); err != nil {
+		t.Fatal(err)
+	}
+
+	gz, err := gzip.NewReader(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]bool)
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[h.Name] = true
+		if h.Name == "images/etcd.tar" {
+			content, err := ioutil.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := constants.DefaultEtcdImage + ":" + Default().EtcdImageTag; string(content) != want {
+				t.Errorf("got etcd image content %q; want %q", content, want)
+			}
+		}
+	}
+	for _, f := range []string{
+		"images/keto-k8.tar",
+		"images/keto-tokens.tar",
+		"images/etcd.tar",
+		"images/kube-apiserver-amd64.tar",
+		"images/k8s-dns-kube-dns-amd64.tar",
+		"images/flannel.tar",
+		"files/smilodon-0.1.0-linux-amd64",
+		manifestFileName,
+	} {
+		if !files[f] {
+			t.Errorf("expected %q in exported tarball", f)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package components manages cluster component manifests, which pin images,
+// binaries and their checksums used by cluster nodes.
+package components
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/UKHomeOffice/keto/pkg/constants"
+	"github.com/UKHomeOffice/keto/pkg/model"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Default returns a default component manifest.
+func Default() model.Components {
+	return model.Components{
+		Version:         constants.DefaultComponentsVersion,
+		KetoK8Image:     constants.DefaultKetoK8Image,
+		KetoTokensImage: constants.DefaultKetoTokensImage,
+		EtcdImageTag:    constants.DefaultEtcdImageTag,
+		SmilodonURL:     constants.DefaultSmilodonURL,
+		SmilodonMD5Sum:  constants.DefaultSmilodonMD5Sum,
+	}
+}
+
+// SetDefaults sets components that aren't specified to their defaults.
+func SetDefaults(c *model.Components) {
+	d := Default()
+	if c.Version == "" {
+		c.Version = d.Version
+	}
+	if c.KetoK8Image == "" {
+		c.KetoK8Image = d.KetoK8Image
+	}
+	if c.KetoTokensImage == "" {
+		c.KetoTokensImage = d.KetoTokensImage
+	}
+	if c.EtcdImageTag == "" {
+		c.EtcdImageTag = d.EtcdImageTag
+	}
+	// Checksum is only valid for its URL.
+	if c.SmilodonURL == "" {
+		c.SmilodonURL = d.SmilodonURL
+		c.SmilodonMD5Sum = d.SmilodonMD5Sum
+	}
+}
+
+// Read parses a YAML or JSON component manifest. Components that aren't
+// specified are set to their defaults.
+func Read(b []byte) (model.Components, error) {
+	c := model.Components{}
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		return c, fmt.Errorf("failed to parse component manifest: %v", err)
+	}
+	if c.Version == "" {
+		return c, fmt.Errorf("component manifest version must be set")
+	}
+	SetDefaults(&c)
+	return c, Validate(c)
+}
+
+// Validate validates a component manifest.
+func Validate(c model.Components) error {
+	if c.Version == "" {
+		return fmt.Errorf("component manifest version must be set")
+	}
+	if c.KetoK8Image == "" {
+		return fmt.Errorf("keto-k8 image must be set")
+	}
+	if c.KetoTokensImage == "" {
+		return fmt.Errorf("keto-tokens image must be set")
+	}
+	if c.EtcdImageTag == "" {
+		return fmt.Errorf("etcd image tag must be set")
+	}
+	u, err := url.Parse(c.SmilodonURL)
+	if err != nil || u.Scheme != "https" {
+		return fmt.Errorf("invalid smilodon URL %q, must be an https URL", c.SmilodonURL)
+	}
+	if b, err := hex.DecodeString(c.SmilodonMD5Sum); err != nil || len(b) != 16 {
+		return fmt.Errorf("invalid smilodon md5sum %q", c.SmilodonMD5Sum)
+	}
+	return nil
+}
+
+// Encode encodes a component manifest, so it can be stored in cloud
+// provider outputs.
+func Encode(c model.Components) (string, error) {
+	b, err := json.Marshal(c)
+	return string(b), err
+}
+
+// Decode decodes a component manifest from its encoded form. An empty string
+// decodes into an empty manifest, e.g. for clusters created before component
+// manifests were introduced.
+func Decode(s string) (model.Components, error) {
+	c := model.Components{}
+	if s == "" {
+		return c, nil
+	}
+	err := json.Unmarshal([]byte(s), &c)
+	return c, err
+}
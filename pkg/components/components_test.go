@@ -0,0 +1,90 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package components
+
+import (
+	"testing"
+
+	"github.com/UKHomeOffice/keto/pkg/constants"
+	"github.com/UKHomeOffice/keto/pkg/model"
+)
+
+func TestRead(t *testing.T) {
+	c, err := Read([]byte("version: \"2\"\nketo_k8_image: quay.io/foo/keto-k8:v1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Default()
+	want.Version = "2"
+	want.KetoK8Image = "quay.io/foo/keto-k8:v1"
+	if c != want {
+		t.Errorf("got %#v; want %#v", c, want)
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	cases := map[string]string{
+		"no version":       "keto_k8_image: foo\n",
+		"invalid yaml":     "version: [",
+		"http smilodon":    "version: \"2\"\nsmilodon_url: http://foo/smilodon\nsmilodon_md5sum: 500aa5f37a332d8e680c7d707b524077\n",
+		"missing md5sum":   "version: \"2\"\nsmilodon_url: https://foo/smilodon\n",
+		"invalid md5sum":   "version: \"2\"\nsmilodon_url: https://foo/smilodon\nsmilodon_md5sum: foo\n",
+		"not a map":        "- foo\n",
+		"unknown manifest": "",
+	}
+	for name, manifest := range cases {
+		if _, err := Read([]byte(manifest)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestSetDefaults(t *testing.T) {
+	c := model.Components{SmilodonMD5Sum: "foo"}
+	SetDefaults(&c)
+	if c != Default() {
+		t.Errorf("got %#v; want %#v", c, Default())
+	}
+	if err := Validate(c); err != nil {
+		t.Errorf("expected default components to be valid: %v", err)
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	s, err := Encode(Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := Decode(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c != Default() {
+		t.Errorf("got %#v; want %#v", c, Default())
+	}
+
+	c, err = Decode("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Version != "" {
+		t.Errorf("expected an empty manifest")
+	}
+	if Default().Version != constants.DefaultComponentsVersion {
+		t.Errorf("unexpected default version %q", Default().Version)
+	}
+}
@@ -4,11 +4,57 @@ const (
 	// DefaultKubeVersion specifies a default kubernetes version.
 	DefaultKubeVersion = "v1.7.2"
 	// DefaultNetworkProvider specifies what CNI provider to install
-	DefaultNetworkProvider = "canal"
+	DefaultNetworkProvider = NetworkProviderCanal
+	// DefaultPodCIDR specifies a default pod network CIDR.
+	DefaultPodCIDR = "10.244.0.0/16"
+	// DefaultServiceCIDR specifies a default kubernetes services CIDR.
+	DefaultServiceCIDR = "10.96.0.0/12"
+	// DefaultNetworkCIDR specifies a CIDR of a network that is created for a
+	// cluster when networks aren't given.
+	DefaultNetworkCIDR = "10.0.0.0/16"
+	// DefaultNetworkZones specifies a number of availability zones a created
+	// network spans.
+	DefaultNetworkZones = 3
+	// DefaultLoadBalancerType specifies a default kube API load balancer type.
+	DefaultLoadBalancerType = LoadBalancerTypeClassic
+	// DefaultAccessCIDR specifies a CIDR SSH and kube API access is allowed
+	// from by default.
+	DefaultAccessCIDR = "0.0.0.0/0"
+	// DefaultComponentsVersion specifies a version of the default component
+	// manifest. It must be bumped whenever any of default components change.
+	DefaultComponentsVersion = "2"
 	// DefaultKetoK8Image specifies the image to use for keto-k8 container
 	DefaultKetoK8Image = "quay.io/ukhomeofficedigital/keto-k8:v0.2.3"
+	// DefaultKetoTokensImage specifies the image compute nodes use to get
+	// kubelet bootstrap tokens.
+	DefaultKetoTokensImage = "quay.io/ukhomeofficedigital/keto-tokens:v0.0.3"
+	// KubeImageRepository specifies where kube control plane images that
+	// keto-k8 deploys are pulled from.
+	KubeImageRepository = "gcr.io/google_containers"
+	// KubePauseImageTag specifies a kube pod infra container image tag.
+	KubePauseImageTag = "3.0"
+	// KubeDNSImageTag specifies a kube-dns image tag.
+	KubeDNSImageTag = "1.14.4"
+	// DefaultEtcdImage specifies etcd image used by etcd-member.
+	DefaultEtcdImage = "quay.io/coreos/etcd"
+	// DefaultEtcdImageTag specifies etcd image tag used by etcd-member.
+	DefaultEtcdImageTag = "v3.1.5"
+	// DefaultSmilodonURL specifies where smilodon binary is downloaded from.
+	DefaultSmilodonURL = "https://github.com/UKHomeOffice/smilodon/releases/download/v0.1.0/smilodon-0.1.0-linux-amd64"
+	// DefaultSmilodonMD5Sum specifies smilodon binary md5 checksum.
+	DefaultSmilodonMD5Sum = "500aa5f37a332d8e680c7d707b524077"
 	// DefaultComputePoolSize specifies a default number of machines in a single compute pool.
 	DefaultComputePoolSize = 1
+	// DefaultBastionPoolSize specifies a default number of bastion hosts.
+	DefaultBastionPoolSize = 1
+	// DefaultSSHUser specifies a user that keto ssh logs in to nodes as.
+	DefaultSSHUser = "core"
+	// DefaultPurchaseStrategy specifies how compute pool instances are
+	// purchased by default.
+	DefaultPurchaseStrategy = PurchaseStrategyOnDemand
+	// DefaultOnDemandPercentage specifies a default percentage of on-demand
+	// instances in a mixed compute pool.
+	DefaultOnDemandPercentage = 50
 	// DefaultDiskSizeInGigabytes specifies a default node disk size in gigabytes.
 	DefaultDiskSizeInGigabytes = 10
 	// DefaultCoreOSVersion specifies a default CoreOS version.
@@ -20,4 +66,57 @@ const (
 	ClusterNameLabelKey = "cluster-name"
 	// PoolNameLabelKey label key name for pool name label.
 	PoolNameLabelKey = "pool-name"
+
+	// BastionPoolName is a name of a cluster bastion pool.
+	BastionPoolName = "bastion"
+
+	// NetworkProviderCanal is canal (flannel and calico policy) CNI provider.
+	NetworkProviderCanal = "canal"
+	// NetworkProviderCalico is calico CNI provider.
+	NetworkProviderCalico = "calico"
+	// NetworkProviderFlannel is flannel CNI provider.
+	NetworkProviderFlannel = "flannel"
+	// NetworkProviderWeave is weave net CNI provider.
+	NetworkProviderWeave = "weave"
+
+	// PurchaseStrategyOnDemand runs on-demand instances only.
+	PurchaseStrategyOnDemand = "on-demand"
+	// PurchaseStrategySpot runs spot instances above on-demand base capacity.
+	PurchaseStrategySpot = "spot"
+	// PurchaseStrategyMixed runs a percentage of on-demand instances above
+	// on-demand base capacity and spot instances for the rest.
+	PurchaseStrategyMixed = "mixed"
+
+	// LoadBalancerTypeClassic fronts kube API with a classic load balancer.
+	LoadBalancerTypeClassic = "classic"
+	// LoadBalancerTypeNetwork fronts kube API with a network load balancer.
+	LoadBalancerTypeNetwork = "network"
+
+	// TemplateOverlayStackInfra targets cluster template overlays at cluster
+	// infra resources.
+	TemplateOverlayStackInfra = "infra"
+	// TemplateOverlayStackLoadBalancer targets cluster template overlays at
+	// the kube API load balancer resources.
+	TemplateOverlayStackLoadBalancer = "loadbalancer"
 )
+
+// NetworkProviders is a list of supported CNI providers.
+var NetworkProviders = []string{
+	NetworkProviderCanal,
+	NetworkProviderCalico,
+	NetworkProviderFlannel,
+	NetworkProviderWeave,
+}
+
+// PurchaseStrategies is a list of supported compute pool purchase strategies.
+var PurchaseStrategies = []string{
+	PurchaseStrategyOnDemand,
+	PurchaseStrategySpot,
+	PurchaseStrategyMixed,
+}
+
+// LoadBalancerTypes is a list of supported kube API load balancer types.
+var LoadBalancerTypes = []string{
+	LoadBalancerTypeClassic,
+	LoadBalancerTypeNetwork,
+}
@@ -19,8 +19,13 @@ package controller
 import (
 	"errors"
 	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/UKHomeOffice/keto/pkg/cloudprovider"
+	"github.com/UKHomeOffice/keto/pkg/components"
 	"github.com/UKHomeOffice/keto/pkg/constants"
 	"github.com/UKHomeOffice/keto/pkg/model"
 	"github.com/UKHomeOffice/keto/pkg/userdata"
@@ -37,8 +42,34 @@ var (
 	ErrMasterPoolAlreadyExists = errors.New("masterpool already exists")
 	// ErrComputePoolAlreadyExists is an error to report an existing compute pool.
 	ErrComputePoolAlreadyExists = errors.New("computepool already exists")
+	// ErrMasterPoolDoesNotExist is an error to report a non-existing master pool.
+	ErrMasterPoolDoesNotExist = errors.New("masterpool does not exist")
+	// ErrComputePoolDoesNotExist is an error to report a non-existing compute pool.
+	ErrComputePoolDoesNotExist = errors.New("computepool does not exist")
+	// ErrBastionPoolAlreadyExists is an error to report an existing bastion pool.
+	ErrBastionPoolAlreadyExists = errors.New("bastion pool already exists")
+	// ErrBastionPoolDoesNotExist is an error to report a non-existing bastion pool.
+	ErrBastionPoolDoesNotExist = errors.New("bastion pool does not exist")
 )
 
+// PreflightError is an error to report failed pre-flight checks.
+type PreflightError struct {
+	Results []model.CheckResult
+}
+
+func (e *PreflightError) Error() string {
+	failed := []string{}
+	for _, r := range e.Results {
+		if !r.Passed {
+			failed = append(failed, fmt.Sprintf("%s: %s", r.Name, r.Message))
+		}
+	}
+	return "pre-flight checks failed: " + strings.Join(failed, "; ")
+}
+
+// dnsNameRegexp matches lower-case DNS names.
+var dnsNameRegexp = regexp.MustCompile(`^([a-z0-9]([-a-z0-9]*[a-z0-9])?\.)*[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
 // Controller represents a controller.
 type Controller struct {
 	Config
@@ -49,6 +80,10 @@ type Config struct {
 	Logger   logger
 	Cloud    cloudprovider.Interface
 	UserData userdata.UserDater
+	// WarnLogger reports warnings to users. Logger is used if it's nil.
+	WarnLogger logger
+	// SkipPreflight skips pre-flight checks of new clusters and pools.
+	SkipPreflight bool
 }
 
 // logger is a generic interface that is used for passing in a logger.
@@ -70,6 +105,15 @@ func New(cfg Config) *Controller {
 	return &Controller{Config: cfg}
 }
 
+// warnf reports a warning.
+func (c *Controller) warnf(format string, v ...interface{}) {
+	if c.WarnLogger != nil {
+		c.WarnLogger.Printf(format, v...)
+		return
+	}
+	c.Logger.Printf("warning: "+format, v...)
+}
+
 // CreateCluster creates a new cluster, which includes master node pool and
 // other supported resources that make up a cluster.
 func (c *Controller) CreateCluster(cluster model.Cluster, assets model.Assets) error {
@@ -94,11 +138,41 @@ func (c *Controller) CreateCluster(cluster model.Cluster, assets model.Assets) e
 		c.Logger.Printf("cluster is internal, node pools will also be internal")
 	}
 
+	if err := c.setClusterNetworkDefaults(&cluster); err != nil {
+		return err
+	}
+	if err := c.setClusterAccessDefaults(&cluster.Access); err != nil {
+		return err
+	}
+	if err := c.setClusterAPIDefaults(&cluster); err != nil {
+		return err
+	}
+
+	// Components that aren't pinned by a user manifest use keto defaults.
+	components.SetDefaults(&cluster.Components)
+	if err := components.Validate(cluster.Components); err != nil {
+		return err
+	}
+	if err := validateKetoK8Image(cluster); err != nil {
+		return err
+	}
+	c.Logger.Printf("using components manifest version %q", cluster.Components.Version)
+	if err := components.ValidateMirror(cluster.RegistryMirror, cluster.ArtifactsURL); err != nil {
+		return err
+	}
+	if err := userdata.ValidateProxy(cluster.Proxy); err != nil {
+		return err
+	}
+
 	// Initialize Labels map in case it hasn't been.
 	if cluster.Labels == nil {
 		cluster.Labels = model.Labels{}
 	}
 
+	if err := c.preflightCluster(cluster); err != nil {
+		return err
+	}
+
 	c.Logger.Printf("creating cluster %q infrastructure", cluster.Name)
 	if err := cl.CreateClusterInfra(cluster); err != nil {
 		return err
@@ -118,7 +192,8 @@ func (c *Controller) CreateCluster(cluster model.Cluster, assets model.Assets) e
 	if len(cluster.ComputePools) > 0 {
 		for i := 0; i < len(cluster.ComputePools); i++ {
 			c.Logger.Printf("creating computepool %q in cluster %q", cluster.ComputePools[i].Name, cluster.Name)
-			if err := c.CreateComputePool(cluster.ComputePools[i]); err != nil {
+			// Compute pools were checked along with the cluster.
+			if err := c.createComputePool(cluster.ComputePools[i], false); err != nil {
 				return err
 			}
 		}
@@ -127,6 +202,111 @@ func (c *Controller) CreateCluster(cluster model.Cluster, assets model.Assets) e
 	return nil
 }
 
+// UpdateCluster updates infra of an existing cluster in place, e.g. to change
+// its access rules. Access rules that are not set in cluster are kept.
+func (c *Controller) UpdateCluster(cluster model.Cluster) error {
+	cl, impl := c.Cloud.Clusters()
+	if !impl {
+		return ErrNotImplemented
+	}
+
+	clusters, err := c.GetClusters(cluster.Name)
+	if err != nil {
+		return err
+	}
+	if len(clusters) != 1 {
+		return ErrClusterDoesNotExist
+	}
+	existing := *clusters[0]
+	// Overlays are kept per stack, so they are checked per stack too.
+	for _, stack := range []string{constants.TemplateOverlayStackInfra, constants.TemplateOverlayStackLoadBalancer} {
+		names := templateOverlayNames(existing.TemplateOverlays, stack)
+		if len(names) > 0 && len(templateOverlayNames(cluster.TemplateOverlays, stack)) == 0 {
+			return fmt.Errorf("cluster %q %s was created with template overlays %s, they must be given again",
+				existing.Name, stack, strings.Join(names, ", "))
+		}
+	}
+	// Master pools are registered with the load balancer, it can't be
+	// replaced in place.
+	if cluster.LoadBalancerType != "" && cluster.LoadBalancerType != existing.LoadBalancerType {
+		return fmt.Errorf("load balancer type of cluster %q can't be changed from %q to %q",
+			existing.Name, existing.LoadBalancerType, cluster.LoadBalancerType)
+	}
+	existing.TemplateOverlays = cluster.TemplateOverlays
+	existing.Access = mergeAccess(existing.Access, cluster.Access)
+	if err := c.setClusterAccessDefaults(&existing.Access); err != nil {
+		return err
+	}
+
+	// Cluster infra is laid out across master pool networks.
+	pools, err := c.GetMasterPools(existing.Name)
+	if err != nil {
+		return err
+	}
+	if len(pools) == 0 {
+		return ErrMasterPoolDoesNotExist
+	}
+	existing.MasterPool = *pools[0]
+	if len(existing.MasterPool.Networks) == 0 {
+		return fmt.Errorf("masterpool networks of cluster %q are unknown, update the masterpool first", existing.Name)
+	}
+
+	if existing.Access.DisableSSH {
+		if err := c.warnBastionAccess(existing); err != nil {
+			return err
+		}
+	}
+
+	c.Logger.Printf("updating cluster %q infrastructure", existing.Name)
+	return cl.UpdateClusterInfra(existing)
+}
+
+// warnBastionAccess warns if nodes of a cluster with disabled SSH access
+// can't be reached through bastion hosts either. Bastion hosts keep their own
+// allowed CIDRs when direct SSH access to nodes is disabled.
+func (c *Controller) warnBastionAccess(cluster model.Cluster) error {
+	bastions, impl := c.Cloud.Bastions()
+	if !impl {
+		c.warnf("SSH access to cluster %q nodes is disabled, nodes won't be reachable over SSH", cluster.Name)
+		return nil
+	}
+	pools, err := bastions.GetBastionPools(cluster.Name)
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(pools) == 0:
+		c.warnf("SSH access to cluster %q nodes is disabled, create a bastion pool with --allowed-cidrs to reach them", cluster.Name)
+	case len(pools[0].AllowedCIDRs) == 0 && len(cluster.Access.SourceSecurityGroups) == 0:
+		c.warnf("SSH access to cluster %q nodes is disabled and its bastion hosts aren't allowed SSH access from anywhere, "+
+			"recreate the bastion pool with --allowed-cidrs", cluster.Name)
+	default:
+		c.Logger.Printf("SSH access to cluster %q nodes is disabled, nodes are only reachable through bastion hosts", cluster.Name)
+	}
+	return nil
+}
+
+// mergeAccess returns existing access rules with rules that are set in a.
+// Enabling SSH access from given CIDRs overrides disabled SSH access.
+func mergeAccess(existing, a model.Access) model.Access {
+	m := existing
+	if a.DisableSSH {
+		m.DisableSSH = true
+		m.SSHCIDRs = nil
+	}
+	if len(a.SSHCIDRs) > 0 {
+		m.DisableSSH = false
+		m.SSHCIDRs = a.SSHCIDRs
+	}
+	if len(a.APICIDRs) > 0 {
+		m.APICIDRs = a.APICIDRs
+	}
+	if a.SourceSecurityGroups != nil {
+		m.SourceSecurityGroups = a.SourceSecurityGroups
+	}
+	return m
+}
+
 // CreateMasterPool creates a master node pool.
 func (c *Controller) CreateMasterPool(p model.MasterPool) error {
 	cl, impl := c.Cloud.Clusters()
@@ -144,7 +324,7 @@ func (c *Controller) CreateMasterPool(p model.MasterPool) error {
 	if len(clusters) > 1 {
 		return fmt.Errorf("more than one cluster found matching %q name", p.ClusterName)
 	}
-	p.Internal = clusters[0].Internal
+	p.Internal = nodesInternal(*clusters[0])
 
 	c.Logger.Printf("checking whether masterpool %q already exists in cluster %q", p.Name, p.ClusterName)
 	m, err := c.GetMasterPools(p.ClusterName, "")
@@ -175,6 +355,67 @@ func (c *Controller) CreateMasterPool(p model.MasterPool) error {
 		return ErrNotImplemented
 	}
 
+	if err := c.renderMasterPoolUserData(cl, *clusters[0], &p); err != nil {
+		return err
+	}
+
+	// Cluster scope labels get applied to node pools by default.
+	if p.Labels == nil {
+		p.Labels = model.Labels{}
+	}
+	for k, v := range clusters[0].Labels {
+		p.Labels[k] = v
+	}
+	p.Labels[constants.PoolNameLabelKey] = p.Name
+
+	return pooler.CreateMasterPool(p)
+}
+
+// UpgradeMasterPool updates an existing master pool in place, e.g. to change
+// its machine type or to migrate it to the latest cloud resources. Values
+// that are not set in p are kept from the existing master pool.
+func (c *Controller) UpgradeMasterPool(p model.MasterPool) error {
+	cl, impl := c.Cloud.Clusters()
+	if !impl {
+		return ErrNotImplemented
+	}
+	pooler, impl := c.Cloud.NodePooler()
+	if !impl {
+		return ErrNotImplemented
+	}
+
+	clusters, err := c.GetClusters(p.ClusterName)
+	if err != nil {
+		return err
+	}
+	if len(clusters) != 1 {
+		return ErrClusterDoesNotExist
+	}
+
+	m, err := c.GetMasterPools(p.ClusterName, "")
+	if err != nil {
+		return err
+	}
+	if len(m) == 0 {
+		return ErrMasterPoolDoesNotExist
+	}
+	np, err := c.mergeNodePool(m[0].NodePool, p.NodePool)
+	if err != nil {
+		return err
+	}
+	p.NodePool = np
+	p.Internal = nodesInternal(*clusters[0])
+
+	if err := c.renderMasterPoolUserData(cl, *clusters[0], &p); err != nil {
+		return err
+	}
+
+	c.Logger.Printf("upgrading masterpool %q in cluster %q", p.Name, p.ClusterName)
+	return pooler.UpgradeMasterPool(p)
+}
+
+// renderMasterPoolUserData renders master pool user data for a cluster.
+func (c *Controller) renderMasterPoolUserData(cl cloudprovider.Clusters, cluster model.Cluster, p *model.MasterPool) error {
 	c.Logger.Printf("getting master persistent IP addresses and their IDs for cluster %q", p.ClusterName)
 	ips, err := cl.GetMasterPersistentIPs(p.ClusterName)
 	if err != nil {
@@ -182,22 +423,216 @@ func (c *Controller) CreateMasterPool(p model.MasterPool) error {
 	}
 	c.Logger.Printf("got IPs and IDs: %#v", ips)
 
-	cloudConfig, err := c.UserData.RenderMasterCloudConfig(c.Cloud.ProviderName(), p.ClusterName, p.KubeVersion, ips)
+	cloudConfig, err := c.UserData.RenderMasterCloudConfig(c.Cloud.ProviderName(), cluster, p.KubeVersion, ips)
 	if err != nil {
 		return err
 	}
-	p.UserData = cloudConfig
+	if len(p.ExtraUserData) > 0 {
+		c.Logger.Printf("merging extra user data into masterpool %q cloud-config", p.Name)
+		if cloudConfig, err = userdata.MergeCloudConfig(cloudConfig, p.ExtraUserData); err != nil {
+			return err
+		}
+	}
+	userData, err := c.UserData.RenderUserData(p.UserDataFormat, cloudConfig)
+	if err != nil {
+		return err
+	}
+	p.UserData = userData
+	return nil
+}
 
-	// Cluster scope labels get applied to node pools by default.
-	if p.Labels == nil {
-		p.Labels = model.Labels{}
+// setClusterNetworkDefaults sets cluster network defaults if values aren't
+// specified and validates the network provider, load balancer type and CIDRs.
+func (c *Controller) setClusterNetworkDefaults(cluster *model.Cluster) error {
+	if cluster.NetworkProvider == "" {
+		cluster.NetworkProvider = constants.DefaultNetworkProvider
+		c.Logger.Printf("network provider is not specified, using default %q", cluster.NetworkProvider)
 	}
-	for k, v := range clusters[0].Labels {
-		p.Labels[k] = v
+	if cluster.PodCIDR == "" {
+		cluster.PodCIDR = constants.DefaultPodCIDR
+		c.Logger.Printf("pod CIDR is not specified, using default %q", cluster.PodCIDR)
+	}
+	if cluster.ServiceCIDR == "" {
+		cluster.ServiceCIDR = constants.DefaultServiceCIDR
+		c.Logger.Printf("service CIDR is not specified, using default %q", cluster.ServiceCIDR)
 	}
-	p.Labels[constants.PoolNameLabelKey] = p.Name
 
-	return pooler.CreateMasterPool(p)
+	if cluster.LoadBalancerType == "" {
+		cluster.LoadBalancerType = constants.DefaultLoadBalancerType
+		c.Logger.Printf("load balancer type is not specified, using default %q", cluster.LoadBalancerType)
+	}
+
+	supported := false
+	for _, p := range constants.NetworkProviders {
+		if cluster.NetworkProvider == p {
+			supported = true
+		}
+	}
+	if !supported {
+		return fmt.Errorf("unsupported network provider %q, supported providers: %s",
+			cluster.NetworkProvider, strings.Join(constants.NetworkProviders, ", "))
+	}
+	if !stringInSlice(cluster.LoadBalancerType, constants.LoadBalancerTypes) {
+		return fmt.Errorf("unsupported load balancer type %q, supported types: %s",
+			cluster.LoadBalancerType, strings.Join(constants.LoadBalancerTypes, ", "))
+	}
+
+	_, pods, err := net.ParseCIDR(cluster.PodCIDR)
+	if err != nil {
+		return fmt.Errorf("invalid pod CIDR: %v", err)
+	}
+	_, services, err := net.ParseCIDR(cluster.ServiceCIDR)
+	if err != nil {
+		return fmt.Errorf("invalid service CIDR: %v", err)
+	}
+	if pods.Contains(services.IP) || services.Contains(pods.IP) {
+		return fmt.Errorf("pod CIDR %q and service CIDR %q overlap", cluster.PodCIDR, cluster.ServiceCIDR)
+	}
+
+	// A network is created for the cluster if networks aren't given.
+	if len(cluster.MasterPool.Networks) > 0 {
+		if cluster.NetworkCIDR != "" || cluster.NetworkZones != 0 {
+			return errors.New("network CIDR and zones can't be set when networks are given")
+		}
+		return nil
+	}
+	if cluster.NetworkCIDR == "" {
+		cluster.NetworkCIDR = constants.DefaultNetworkCIDR
+		c.Logger.Printf("networks are not specified, creating a network with default CIDR %q", cluster.NetworkCIDR)
+	}
+	if cluster.NetworkZones == 0 {
+		cluster.NetworkZones = constants.DefaultNetworkZones
+		c.Logger.Printf("network zones are not specified, using default %d", cluster.NetworkZones)
+	}
+	if cluster.NetworkZones < 0 {
+		return fmt.Errorf("invalid number of network zones %d", cluster.NetworkZones)
+	}
+	_, network, err := net.ParseCIDR(cluster.NetworkCIDR)
+	if err != nil {
+		return fmt.Errorf("invalid network CIDR: %v", err)
+	}
+	for _, n := range []*net.IPNet{pods, services} {
+		if n.Contains(network.IP) || network.Contains(n.IP) {
+			return fmt.Errorf("network CIDR %q overlaps with pod CIDR %q or service CIDR %q",
+				cluster.NetworkCIDR, cluster.PodCIDR, cluster.ServiceCIDR)
+		}
+	}
+	return nil
+}
+
+// nodesInternal returns true if cluster nodes don't get public IPs, i.e. a
+// cluster is internal or its nodes are in private subnets of a network that
+// was created for the cluster.
+func nodesInternal(cluster model.Cluster) bool {
+	return cluster.Internal || cluster.NetworkCIDR != ""
+}
+
+// setClusterAccessDefaults allows SSH and kube API access from anywhere if
+// access CIDRs aren't specified and validates access rules.
+func (c *Controller) setClusterAccessDefaults(a *model.Access) error {
+	if a.DisableSSH && len(a.SSHCIDRs) > 0 {
+		return errors.New("SSH CIDRs must not be specified when SSH access is disabled")
+	}
+	if !a.DisableSSH && len(a.SSHCIDRs) == 0 {
+		a.SSHCIDRs = []string{constants.DefaultAccessCIDR}
+		c.Logger.Printf("SSH CIDRs are not specified, using default %q", constants.DefaultAccessCIDR)
+	}
+	if len(a.APICIDRs) == 0 {
+		a.APICIDRs = []string{constants.DefaultAccessCIDR}
+		c.Logger.Printf("API CIDRs are not specified, using default %q", constants.DefaultAccessCIDR)
+	}
+
+	for _, cidr := range a.SSHCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid SSH CIDR: %v", err)
+		}
+	}
+	for _, cidr := range a.APICIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid API CIDR: %v", err)
+		}
+	}
+	for _, g := range a.SourceSecurityGroups {
+		if g == "" {
+			return errors.New("source security groups must not be empty")
+		}
+	}
+	return nil
+}
+
+// setClusterAPIDefaults sets a default kube API hostname if a DNS zone is
+// specified and validates DNS zone selection and kube API names. Names are
+// lower-cased, as Kubernetes does not like mixed-case DNS names.
+func (c *Controller) setClusterAPIDefaults(cluster *model.Cluster) error {
+	zone := strings.ToLower(cluster.DNSZone)
+	if zone == "" && !cloudprovider.PublicDNSZone(*cluster) {
+		return errors.New("DNS zone IDs, private and split-horizon DNS zones require a DNS zone")
+	}
+	if len(cluster.DNSZoneIDs) > 0 && (cluster.PrivateDNSZone || cluster.SplitHorizonDNS) {
+		return errors.New("DNS zone IDs must not be specified with private or split-horizon DNS zones")
+	}
+	// Private zones can't be associated with a network that doesn't exist yet.
+	if cluster.NetworkCIDR != "" && (cluster.PrivateDNSZone || cluster.SplitHorizonDNS) {
+		return errors.New("private and split-horizon DNS zones require existing networks")
+	}
+	if cluster.APIHostname == "" && zone != "" {
+		cluster.APIHostname = fmt.Sprintf("kube-%s.%s", cluster.Name, zone)
+		c.Logger.Printf("API hostname is not specified, using default %q", cluster.APIHostname)
+	}
+	cluster.APIHostname = strings.ToLower(cluster.APIHostname)
+	if cluster.APIHostname != "" && !dnsNameRegexp.MatchString(cluster.APIHostname) {
+		return fmt.Errorf("invalid API hostname %q", cluster.APIHostname)
+	}
+
+	aliases := []string{}
+	for _, a := range cluster.APIAliases {
+		a = strings.ToLower(a)
+		if zone == "" {
+			return errors.New("API aliases require a DNS zone")
+		}
+		if !dnsNameRegexp.MatchString(a) || !strings.HasSuffix(a, "."+zone) {
+			return fmt.Errorf("API alias %q is not a DNS name in zone %q", a, zone)
+		}
+		aliases = append(aliases, a)
+	}
+	if len(aliases) > 0 {
+		cluster.APIAliases = aliases
+	}
+
+	sans := []string{}
+	for _, n := range cluster.APIExtraSANs {
+		if net.ParseIP(n) == nil {
+			n = strings.ToLower(n)
+			if !dnsNameRegexp.MatchString(strings.TrimPrefix(n, "*.")) {
+				return fmt.Errorf("invalid API SAN %q, it must be a DNS name or an IP address", n)
+			}
+		}
+		sans = append(sans, n)
+	}
+	if len(sans) > 0 {
+		cluster.APIExtraSANs = sans
+	}
+	return nil
+}
+
+// validateKetoK8Image checks that the keto-k8 image supports cluster settings
+// that masters pass to it. The default image predates flags for custom pod and
+// service CIDRs, extra API SANs and registry mirrors.
+func validateKetoK8Image(cluster model.Cluster) error {
+	image := cluster.Components.KetoK8Image
+	if image != constants.DefaultKetoK8Image {
+		return nil
+	}
+	if cluster.PodCIDR != constants.DefaultPodCIDR || cluster.ServiceCIDR != constants.DefaultServiceCIDR {
+		return fmt.Errorf("keto-k8 image %q doesn't support custom pod and service CIDRs, set keto_k8_image in a components manifest", image)
+	}
+	if len(cluster.APIAliases) > 0 || len(cluster.APIExtraSANs) > 0 {
+		return fmt.Errorf("keto-k8 image %q doesn't support API aliases and extra SANs, set keto_k8_image in a components manifest", image)
+	}
+	if cluster.RegistryMirror != "" {
+		return fmt.Errorf("keto-k8 image %q doesn't support registry mirrors, set keto_k8_image in a components manifest", image)
+	}
+	return nil
 }
 
 func (c *Controller) clusterExists(name string, cl cloudprovider.Clusters) (bool, error) {
@@ -210,6 +645,12 @@ func (c *Controller) clusterExists(name string, cl cloudprovider.Clusters) (bool
 
 // CreateComputePool create a compute node pool.
 func (c *Controller) CreateComputePool(p model.ComputePool) error {
+	return c.createComputePool(p, true)
+}
+
+// createComputePool creates a compute node pool, running pre-flight checks
+// of the pool first if preflight is true.
+func (c *Controller) createComputePool(p model.ComputePool, preflight bool) error {
 	pooler, impl := c.Cloud.NodePooler()
 	if !impl {
 		return ErrNotImplemented
@@ -219,13 +660,13 @@ func (c *Controller) CreateComputePool(p model.ComputePool) error {
 	if err != nil {
 		return err
 	}
-	if len(clusters) != 1 {
+	if len(clusters) == 0 {
 		return ErrClusterDoesNotExist
 	}
 	if len(clusters) > 1 {
 		return fmt.Errorf("more than one cluster found matching %q name", p.ClusterName)
 	}
-	p.Internal = clusters[0].Internal
+	p.Internal = nodesInternal(*clusters[0])
 
 	// Check if a compute pool with the same name exists already.
 	c.Logger.Printf("checking whether computepool %q already exists in cluster %q", p.Name, p.ClusterName)
@@ -243,10 +684,23 @@ func (c *Controller) CreateComputePool(p model.ComputePool) error {
 		p.DiskSize = constants.DefaultDiskSizeInGigabytes
 		c.Logger.Printf("disk size is not specified, using default %d", p.DiskSize)
 	}
+	if p.Size == 0 && p.MinSize > 0 {
+		p.Size = p.MinSize
+		c.Logger.Printf("compute pool size is not specified, using min size %d", p.Size)
+	}
 	if p.Size == 0 {
 		p.Size = constants.DefaultComputePoolSize
 		c.Logger.Printf("compute pool size is not specified, using default %d", p.Size)
 	}
+	if err := setPoolSizeDefaults(&p.NodePoolSpec); err != nil {
+		return err
+	}
+	if err := c.validateAllowFromPools(p, pooler); err != nil {
+		return err
+	}
+	if err := c.setInstancesPolicyDefaults(&p); err != nil {
+		return err
+	}
 
 	// TODO get the missing properties from the masterpool. If not specified,
 	// use versions that the masterpool is using? On the other hand, how can we
@@ -261,11 +715,18 @@ func (c *Controller) CreateComputePool(p model.ComputePool) error {
 		c.Logger.Printf("coreos version is not specified, using default %q", p.CoreOSVersion)
 	}
 
-	cloudConfig, err := c.UserData.RenderComputeCloudConfig(c.Cloud.ProviderName(), p.ClusterName, p.KubeVersion)
-	if err != nil {
+	if preflight {
+		name := fmt.Sprintf("computepool %q", p.Name)
+		if err := c.preflight(name, func(ch cloudprovider.Checker) ([]model.CheckResult, error) {
+			return ch.ValidateComputePool(p)
+		}); err != nil {
+			return err
+		}
+	}
+
+	if err := c.renderComputePoolUserData(*clusters[0], &p); err != nil {
 		return err
 	}
-	p.UserData = cloudConfig
 
 	// Cluster scope labels get applied to node pools by default.
 	if p.Labels == nil {
@@ -279,6 +740,287 @@ func (c *Controller) CreateComputePool(p model.ComputePool) error {
 	return pooler.CreateComputePool(p)
 }
 
+// UpgradeComputePool updates an existing compute pool in place, e.g. to
+// change its machine type or to migrate it to the latest cloud resources.
+// Values that are not set in p are kept from the existing compute pool.
+func (c *Controller) UpgradeComputePool(p model.ComputePool) error {
+	pooler, impl := c.Cloud.NodePooler()
+	if !impl {
+		return ErrNotImplemented
+	}
+
+	clusters, err := c.GetClusters(p.ClusterName)
+	if err != nil {
+		return err
+	}
+	if len(clusters) != 1 {
+		return ErrClusterDoesNotExist
+	}
+
+	pools, err := c.GetComputePools(p.ClusterName, p.Name)
+	if err != nil {
+		return err
+	}
+	// GetComputePools returns all pools when none match the name, so the
+	// pool to upgrade has to be matched by name here.
+	var existing *model.ComputePool
+	for _, pool := range pools {
+		if pool.Name == p.Name {
+			existing = pool
+			break
+		}
+	}
+	if existing == nil {
+		return ErrComputePoolDoesNotExist
+	}
+	np, err := c.mergeNodePool(existing.NodePool, p.NodePool)
+	if err != nil {
+		return err
+	}
+	p.NodePool = np
+	p.Internal = nodesInternal(*clusters[0])
+	if p.PurchaseStrategy == "" {
+		p.InstancesPolicy = existing.InstancesPolicy
+	}
+	if p.AllowFromPools == nil {
+		p.AllowFromPools = existing.AllowFromPools
+	}
+	if p.Size == 0 {
+		return fmt.Errorf("size of computepool %q is unknown, it must be specified", p.Name)
+	}
+	if err := setPoolSizeDefaults(&p.NodePoolSpec); err != nil {
+		return err
+	}
+	if err := c.validateAllowFromPools(p, pooler); err != nil {
+		return err
+	}
+	if err := c.setInstancesPolicyDefaults(&p); err != nil {
+		return err
+	}
+
+	if err := c.renderComputePoolUserData(*clusters[0], &p); err != nil {
+		return err
+	}
+
+	c.Logger.Printf("upgrading computepool %q in cluster %q", p.Name, p.ClusterName)
+	return pooler.UpgradeComputePool(p)
+}
+
+// renderComputePoolUserData renders compute pool user data for a cluster.
+func (c *Controller) renderComputePoolUserData(cluster model.Cluster, p *model.ComputePool) error {
+	cloudConfig, err := c.UserData.RenderComputeCloudConfig(c.Cloud.ProviderName(), cluster, p.KubeVersion)
+	if err != nil {
+		return err
+	}
+	if len(p.ExtraUserData) > 0 {
+		c.Logger.Printf("merging extra user data into computepool %q cloud-config", p.Name)
+		if cloudConfig, err = userdata.MergeCloudConfig(cloudConfig, p.ExtraUserData); err != nil {
+			return err
+		}
+	}
+	userData, err := c.UserData.RenderUserData(p.UserDataFormat, cloudConfig)
+	if err != nil {
+		return err
+	}
+	p.UserData = userData
+	return nil
+}
+
+// templateOverlayNames returns names of template overlays that target a
+// given stack.
+func templateOverlayNames(overlays []model.TemplateOverlay, stack string) []string {
+	names := []string{}
+	for _, o := range overlays {
+		if o.Stack == stack {
+			names = append(names, o.Name)
+		}
+	}
+	return names
+}
+
+// mergeNodePool returns an existing node pool with values that are set in p.
+// Extra user data and template overlays are not kept with node pools, so
+// they have to be given again.
+func (c *Controller) mergeNodePool(existing, p model.NodePool) (model.NodePool, error) {
+	if len(existing.TemplateOverlays) > 0 && len(p.TemplateOverlays) == 0 {
+		names := []string{}
+		for _, o := range existing.TemplateOverlays {
+			names = append(names, o.Name)
+		}
+		return p, fmt.Errorf("node pool %q was created with template overlays %s, they must be given again",
+			existing.Name, strings.Join(names, ", "))
+	}
+	if len(p.ExtraUserData) == 0 {
+		c.Logger.Printf("extra user data is not given, node pool %q user data is rendered without it", existing.Name)
+	}
+
+	m := existing
+	m.ExtraUserData = p.ExtraUserData
+	m.TemplateOverlays = p.TemplateOverlays
+	if m.Labels == nil {
+		m.Labels = model.Labels{}
+	}
+	for k, v := range p.Labels {
+		m.Labels[k] = v
+	}
+	if p.Taints != nil {
+		m.Taints = p.Taints
+	}
+	if p.KubeVersion != "" {
+		m.KubeVersion = p.KubeVersion
+	}
+	if p.CoreOSVersion != "" {
+		m.CoreOSVersion = p.CoreOSVersion
+	}
+	if p.MachineType != "" {
+		m.MachineType = p.MachineType
+	}
+	if p.SSHKey != "" {
+		m.SSHKey = p.SSHKey
+	}
+	if p.DiskSize != 0 {
+		m.DiskSize = p.DiskSize
+	}
+	if p.Size != 0 {
+		m.Size = p.Size
+	}
+	// Fixed size pools keep following their size unless min and max sizes
+	// are given.
+	if m.MinSize == m.MaxSize {
+		m.MinSize, m.MaxSize = 0, 0
+	}
+	if p.MinSizeSet {
+		m.MinSize = p.MinSize
+	}
+	if p.MaxSizeSet {
+		m.MaxSize = p.MaxSize
+	}
+	if len(p.Networks) > 0 {
+		m.Networks = p.Networks
+	}
+	if p.UserDataFormat != "" {
+		m.UserDataFormat = p.UserDataFormat
+	}
+	if p.KubeletExtraArgs != "" {
+		m.KubeletExtraArgs = p.KubeletExtraArgs
+	}
+	if p.APIServerExtraArgs != "" {
+		m.APIServerExtraArgs = p.APIServerExtraArgs
+	}
+	if p.ControllerManagerExtraArgs != "" {
+		m.ControllerManagerExtraArgs = p.ControllerManagerExtraArgs
+	}
+	if p.SchedulerExtraArgs != "" {
+		m.SchedulerExtraArgs = p.SchedulerExtraArgs
+	}
+	// IOPS and throughput depend on a volume type, so they are not kept
+	// when the volume type changes.
+	if p.VolumeType != "" && p.VolumeType != m.VolumeType {
+		m.VolumeType = p.VolumeType
+		m.VolumeIOPS = 0
+		m.VolumeThroughput = 0
+	}
+	if p.VolumeIOPS != 0 {
+		m.VolumeIOPS = p.VolumeIOPS
+	}
+	if p.VolumeThroughput != 0 {
+		m.VolumeThroughput = p.VolumeThroughput
+	}
+	if p.IMDSTokens != "" {
+		m.IMDSTokens = p.IMDSTokens
+	}
+	if p.IMDSHopLimit != 0 {
+		m.IMDSHopLimit = p.IMDSHopLimit
+	}
+	if p.CreditSpecification != "" {
+		m.CreditSpecification = p.CreditSpecification
+	}
+	return m, nil
+}
+
+// setPoolSizeDefaults makes a node pool fixed in size if min and max sizes
+// aren't specified and validates that the size is within min and max sizes.
+func setPoolSizeDefaults(p *model.NodePoolSpec) error {
+	if p.MinSize == 0 && p.MaxSize == 0 {
+		p.MinSize, p.MaxSize = p.Size, p.Size
+		return nil
+	}
+	if p.MaxSize == 0 {
+		return errors.New("max size must be specified together with min size")
+	}
+	if p.MinSize < 0 || p.MinSize > p.MaxSize {
+		return fmt.Errorf("invalid min size %d, must be between 0 and max size %d", p.MinSize, p.MaxSize)
+	}
+	if p.Size < p.MinSize || p.Size > p.MaxSize {
+		return fmt.Errorf("invalid size %d, must be between min size %d and max size %d", p.Size, p.MinSize, p.MaxSize)
+	}
+	return nil
+}
+
+// setInstancesPolicyDefaults sets compute pool instances policy defaults if
+// values aren't specified and validates the purchase strategy.
+func (c *Controller) setInstancesPolicyDefaults(p *model.ComputePool) error {
+	if p.PurchaseStrategy == "" {
+		p.PurchaseStrategy = constants.DefaultPurchaseStrategy
+		c.Logger.Printf("purchase strategy is not specified, using default %q", p.PurchaseStrategy)
+	}
+
+	switch p.PurchaseStrategy {
+	case constants.PurchaseStrategyOnDemand:
+		if p.SpotMaxPrice != "" {
+			return fmt.Errorf("spot max price requires %q or %q purchase strategy",
+				constants.PurchaseStrategySpot, constants.PurchaseStrategyMixed)
+		}
+		p.OnDemandPercentage = 100
+	case constants.PurchaseStrategySpot:
+		p.OnDemandPercentage = 0
+	case constants.PurchaseStrategyMixed:
+		if p.OnDemandPercentage == 0 {
+			p.OnDemandPercentage = constants.DefaultOnDemandPercentage
+			c.Logger.Printf("on-demand percentage is not specified, using default %d", p.OnDemandPercentage)
+		}
+		if p.OnDemandPercentage < 0 || p.OnDemandPercentage > 100 {
+			return fmt.Errorf("invalid on-demand percentage %d, must be between 0 and 100", p.OnDemandPercentage)
+		}
+	default:
+		return fmt.Errorf("unsupported purchase strategy %q, supported strategies: %s",
+			p.PurchaseStrategy, strings.Join(constants.PurchaseStrategies, ", "))
+	}
+
+	if p.OnDemandBaseCapacity < 0 {
+		return fmt.Errorf("invalid on-demand base capacity %d", p.OnDemandBaseCapacity)
+	}
+	if p.SpotMaxPrice != "" {
+		if price, err := strconv.ParseFloat(p.SpotMaxPrice, 64); err != nil || price <= 0 {
+			return fmt.Errorf("invalid spot max price %q", p.SpotMaxPrice)
+		}
+	}
+	for _, t := range p.MachineTypes {
+		if t == "" {
+			return errors.New("machine types must not be empty")
+		}
+	}
+	return nil
+}
+
+// validateAllowFromPools checks that compute pools which are allowed to send
+// traffic to p exist in the same cluster.
+func (c *Controller) validateAllowFromPools(p model.ComputePool, pooler cloudprovider.NodePooler) error {
+	for _, name := range p.AllowFromPools {
+		if name == p.Name {
+			return fmt.Errorf("traffic within computepool %q is always allowed", p.Name)
+		}
+		exists, err := c.computePoolExists(p.ClusterName, name, pooler)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("computepool %q to allow traffic from does not exist in cluster %q", name, p.ClusterName)
+		}
+	}
+	return nil
+}
+
 func (c *Controller) computePoolExists(clusterName, name string, pooler cloudprovider.NodePooler) (bool, error) {
 	p, err := pooler.GetComputePools(clusterName, name)
 	if err != nil || len(p) == 0 {
@@ -321,6 +1063,115 @@ func (c *Controller) GetComputePools(clusterName string, names ...string) ([]*mo
 	return filterComputePools(p, names), nil
 }
 
+// GetPoolInstances returns a list of node pool instances of a cluster. An
+// empty pool name returns instances of all pools.
+func (c *Controller) GetPoolInstances(clusterName, poolName string) ([]*model.Instance, error) {
+	pooler, impl := c.Cloud.NodePooler()
+	if !impl {
+		return []*model.Instance{}, ErrNotImplemented
+	}
+
+	c.Logger.Printf("getting instances of cluster %q", clusterName)
+	return pooler.GetPoolInstances(clusterName, poolName)
+}
+
+// GetInstanceConsoleOutput returns console output of a node pool instance.
+func (c *Controller) GetInstanceConsoleOutput(instanceID string) (string, error) {
+	pooler, impl := c.Cloud.NodePooler()
+	if !impl {
+		return "", ErrNotImplemented
+	}
+
+	c.Logger.Printf("getting console output of instance %q", instanceID)
+	return pooler.GetInstanceConsoleOutput(instanceID)
+}
+
+// GetMasterPersistentIPs returns a map of master persistent node IDs to IPs,
+// which etcd members are at.
+func (c *Controller) GetMasterPersistentIPs(clusterName string) (map[string]string, error) {
+	cl, impl := c.Cloud.Clusters()
+	if !impl {
+		return map[string]string{}, ErrNotImplemented
+	}
+
+	c.Logger.Printf("getting master persistent IPs of cluster %q", clusterName)
+	return cl.GetMasterPersistentIPs(clusterName)
+}
+
+// CheckClusterInfra runs cloud provider health checks of a cluster.
+func (c *Controller) CheckClusterInfra(clusterName string) ([]model.CheckResult, error) {
+	checker, impl := c.Cloud.Checker()
+	if !impl {
+		return []model.CheckResult{}, ErrNotImplemented
+	}
+
+	c.Logger.Printf("checking cluster %q infrastructure", clusterName)
+	return checker.CheckClusterInfra(clusterName)
+}
+
+// preflightCluster runs pre-flight checks of a cluster and its pools. Pool
+// versions and sizes that aren't specified are checked with their defaults.
+func (c *Controller) preflightCluster(cluster model.Cluster) error {
+	if cluster.MasterPool.CoreOSVersion == "" {
+		cluster.MasterPool.CoreOSVersion = constants.DefaultCoreOSVersion
+	}
+	pools := make([]model.ComputePool, len(cluster.ComputePools))
+	for i, p := range cluster.ComputePools {
+		if p.CoreOSVersion == "" {
+			p.CoreOSVersion = constants.DefaultCoreOSVersion
+		}
+		if p.Size == 0 && p.MinSize > 0 {
+			p.Size = p.MinSize
+		}
+		if p.Size == 0 {
+			p.Size = constants.DefaultComputePoolSize
+		}
+		pools[i] = p
+	}
+	cluster.ComputePools = pools
+
+	name := fmt.Sprintf("cluster %q", cluster.Name)
+	return c.preflight(name, func(ch cloudprovider.Checker) ([]model.CheckResult, error) {
+		return ch.ValidateCluster(cluster)
+	})
+}
+
+// preflight runs pre-flight checks with a cloud provider checker and
+// returns a PreflightError if any of them fail. Checks that pass with a
+// warning are reported. Checks are skipped if the cloud provider doesn't
+// support them or if they are disabled.
+func (c *Controller) preflight(name string, validate func(cloudprovider.Checker) ([]model.CheckResult, error)) error {
+	if c.SkipPreflight {
+		c.warnf("skipping pre-flight checks of %s", name)
+		return nil
+	}
+	checker, impl := c.Cloud.Checker()
+	if !impl {
+		c.Logger.Printf("pre-flight checks are not supported, skipping")
+		return nil
+	}
+
+	c.Logger.Printf("running pre-flight checks of %s", name)
+	results, err := validate(checker)
+	if err != nil {
+		return err
+	}
+	failed := false
+	for _, r := range results {
+		c.Logger.Printf("pre-flight check %q passed: %t, %s", r.Name, r.Passed, r.Message)
+		if !r.Passed {
+			failed = true
+		}
+		if r.Passed && r.Warning {
+			c.warnf("pre-flight check %q of %s: %s", r.Name, name, r.Message)
+		}
+	}
+	if failed {
+		return &PreflightError{Results: results}
+	}
+	return nil
+}
+
 // GetClusters gets a list of clusters.
 func (c *Controller) GetClusters(names ...string) ([]*model.Cluster, error) {
 	cl, impl := c.Cloud.Clusters()
@@ -384,6 +1235,106 @@ func (c *Controller) DeleteComputePool(clusterName string, names ...string) erro
 	return nil
 }
 
+// CreateBastionPool creates a bastion pool, which provides SSH access to
+// nodes of a cluster. A cluster has at most one bastion pool.
+func (c *Controller) CreateBastionPool(p model.BastionPool) error {
+	bastions, impl := c.Cloud.Bastions()
+	if !impl {
+		return ErrNotImplemented
+	}
+
+	clusters, err := c.GetClusters(p.ClusterName)
+	if err != nil {
+		return err
+	}
+	if len(clusters) != 1 {
+		return ErrClusterDoesNotExist
+	}
+	// Bastion hosts are allowed SSH access from cluster SSH CIDRs by default.
+	// Direct SSH access to nodes may be disabled, in which case nodes are
+	// only reachable through bastion hosts that need CIDRs of their own.
+	if len(p.AllowedCIDRs) == 0 {
+		if clusters[0].Access.DisableSSH {
+			return fmt.Errorf("SSH access to cluster %q is disabled, bastion allowed CIDRs must be specified", p.ClusterName)
+		}
+		p.AllowedCIDRs = clusters[0].Access.SSHCIDRs
+		c.Logger.Printf("bastion allowed CIDRs are not specified, using cluster SSH CIDRs %v", p.AllowedCIDRs)
+	}
+	for _, cidr := range p.AllowedCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid bastion allowed CIDR: %v", err)
+		}
+	}
+
+	c.Logger.Printf("checking whether cluster %q has a bastion pool already", p.ClusterName)
+	pools, err := bastions.GetBastionPools(p.ClusterName)
+	if err != nil {
+		return err
+	}
+	if len(pools) > 0 {
+		return ErrBastionPoolAlreadyExists
+	}
+
+	// Use defaults if values aren't specified.
+	p.Name = constants.BastionPoolName
+	if p.DiskSize == 0 {
+		p.DiskSize = constants.DefaultDiskSizeInGigabytes
+		c.Logger.Printf("disk size is not specified, using default %d", p.DiskSize)
+	}
+	if p.Size == 0 {
+		p.Size = constants.DefaultBastionPoolSize
+		c.Logger.Printf("bastion pool size is not specified, using default %d", p.Size)
+	}
+	if p.Size < 0 {
+		return fmt.Errorf("invalid bastion pool size %d", p.Size)
+	}
+	if p.CoreOSVersion == "" {
+		p.CoreOSVersion = constants.DefaultCoreOSVersion
+		c.Logger.Printf("coreos version is not specified, using default %q", p.CoreOSVersion)
+	}
+
+	// Cluster scope labels get applied to bastion pools too.
+	if p.Labels == nil {
+		p.Labels = model.Labels{}
+	}
+	for k, v := range clusters[0].Labels {
+		p.Labels[k] = v
+	}
+	p.Labels[constants.PoolNameLabelKey] = p.Name
+
+	return bastions.CreateBastionPool(p)
+}
+
+// GetBastionPools returns a list of bastion pools.
+func (c *Controller) GetBastionPools(clusterName string) ([]*model.BastionPool, error) {
+	bastions, impl := c.Cloud.Bastions()
+	if !impl {
+		return []*model.BastionPool{}, ErrNotImplemented
+	}
+
+	c.Logger.Printf("getting bastion pool in cluster %q", clusterName)
+	return bastions.GetBastionPools(clusterName)
+}
+
+// DeleteBastionPool deletes a bastion pool of a cluster.
+func (c *Controller) DeleteBastionPool(clusterName string) error {
+	bastions, impl := c.Cloud.Bastions()
+	if !impl {
+		return ErrNotImplemented
+	}
+
+	pools, err := bastions.GetBastionPools(clusterName)
+	if err != nil {
+		return err
+	}
+	if len(pools) == 0 {
+		return ErrBastionPoolDoesNotExist
+	}
+
+	c.Logger.Printf("deleting bastion pool of cluster %q", clusterName)
+	return bastions.DeleteBastionPool(clusterName)
+}
+
 func filterMasterPools(pools []*model.MasterPool, names []string) []*model.MasterPool {
 	filteredPools := []*model.MasterPool{}
 
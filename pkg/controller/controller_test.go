@@ -17,16 +17,22 @@ limitations under the License.
 package controller
 
 import (
+	"bytes"
 	"log"
 	"os"
+	"strings"
 	"testing"
 
+	"github.com/UKHomeOffice/keto/pkg/cloudprovider"
 	cloudProviderMocks "github.com/UKHomeOffice/keto/pkg/cloudprovider/mocks"
 	userdataMocks "github.com/UKHomeOffice/keto/pkg/userdata/mocks"
 
+	"github.com/UKHomeOffice/keto/pkg/components"
 	"github.com/UKHomeOffice/keto/pkg/constants"
 	"github.com/UKHomeOffice/keto/pkg/model"
 	"github.com/UKHomeOffice/keto/testutil"
+
+	"github.com/stretchr/testify/mock"
 )
 
 const cloudProviderName = "mock"
@@ -36,6 +42,8 @@ type testMock struct {
 	Clusters   *cloudProviderMocks.Clusters
 	NodePooler *cloudProviderMocks.NodePooler
 	Node       *cloudProviderMocks.Node
+	Bastions   *cloudProviderMocks.Bastions
+	Checker    *cloudProviderMocks.Checker
 	UserData   *userdataMocks.UserDater
 }
 
@@ -50,11 +58,24 @@ func TestCreateCluster(t *testing.T) {
 				constants.ClusterNameLabelKey: "foo",
 			},
 		},
-		MasterPool: model.MasterPool{NodePool: testutil.MakeNodePool("foo", "master")},
+		MasterPool:       model.MasterPool{NodePool: testutil.MakeNodePool("foo", "master")},
+		NetworkProvider:  constants.DefaultNetworkProvider,
+		PodCIDR:          constants.DefaultPodCIDR,
+		ServiceCIDR:      constants.DefaultServiceCIDR,
+		LoadBalancerType: constants.DefaultLoadBalancerType,
+		Components:       components.Default(),
+		Access: model.Access{
+			SSHCIDRs: []string{constants.DefaultAccessCIDR},
+			APICIDRs: []string{constants.DefaultAccessCIDR},
+		},
 	}
 	cluster.MasterPool.Labels = cluster.Labels
 
 	m.Clusters.On("GetClusters", cluster.Name).Return([]*model.Cluster{}, nil).Once()
+	m.Provider.On("Checker").Return(m.Checker, true)
+	m.Checker.On("ValidateCluster", mock.MatchedBy(func(c model.Cluster) bool {
+		return c.Name == cluster.Name && c.MasterPool.CoreOSVersion != ""
+	})).Return([]model.CheckResult{{Name: "stack names", Passed: true}}, nil)
 	m.Clusters.On("CreateClusterInfra", cluster).Return(nil)
 	m.Clusters.On("PushAssets", cluster.Name, model.Assets{}).Return(nil)
 
@@ -66,10 +87,13 @@ func TestCreateCluster(t *testing.T) {
 
 	m.UserData.On("RenderMasterCloudConfig",
 		cloudProviderName,
-		cluster.Name,
+		cluster,
 		cluster.MasterPool.KubeVersion,
 		persistentIPs).Return(cluster.MasterPool.UserData,
 		nil)
+	m.UserData.On("RenderUserData",
+		cluster.MasterPool.UserDataFormat,
+		cluster.MasterPool.UserData).Return(cluster.MasterPool.UserData, nil)
 
 	m.NodePooler.On("CreateMasterPool", cluster.MasterPool).Return(nil)
 
@@ -81,6 +105,40 @@ func TestCreateCluster(t *testing.T) {
 	m.UserData.AssertExpectations(t)
 	m.NodePooler.AssertExpectations(t)
 	m.Provider.AssertExpectations(t)
+	m.Checker.AssertExpectations(t)
+}
+
+func TestCreateClusterPreflightFailed(t *testing.T) {
+	m, ctrl := makeTestMock()
+
+	cluster := model.Cluster{
+		ResourceMeta: model.ResourceMeta{Name: "foo"},
+		MasterPool:   model.MasterPool{NodePool: testutil.MakeNodePool("foo", "master")},
+	}
+	results := []model.CheckResult{
+		{Name: "ssh key", Passed: true, Message: "key pairs foo exist"},
+		{Name: "quotas", Message: "limits exceeded: instances 19+3 of 20"},
+	}
+
+	m.Clusters.On("GetClusters", cluster.Name).Return([]*model.Cluster{}, nil).Once()
+	m.Provider.On("Checker").Return(m.Checker, true)
+	m.Checker.On("ValidateCluster", mock.AnythingOfType("model.Cluster")).Return(results, nil)
+
+	err := ctrl.CreateCluster(cluster, model.Assets{})
+	perr, ok := err.(*PreflightError)
+	if !ok {
+		t.Fatalf("got error %v; want a pre-flight error", err)
+	}
+	if len(perr.Results) != len(results) {
+		t.Errorf("got %d results; want %d", len(perr.Results), len(results))
+	}
+	want := "pre-flight checks failed: quotas: limits exceeded: instances 19+3 of 20"
+	if perr.Error() != want {
+		t.Errorf("got error %q; want %q", perr.Error(), want)
+	}
+
+	m.Clusters.AssertExpectations(t)
+	m.Checker.AssertExpectations(t)
 }
 
 func TestCreateClusterAlreadyExists(t *testing.T) {
@@ -100,6 +158,52 @@ func TestCreateClusterAlreadyExists(t *testing.T) {
 	m.Clusters.AssertExpectations(t)
 }
 
+func TestCreateClusterInvalidNetwork(t *testing.T) {
+	cases := map[string]model.Cluster{
+		"unsupported network provider": {NetworkProvider: "foo"},
+		"invalid pod CIDR":             {PodCIDR: "10.244.0.0"},
+		"overlapping CIDRs":            {PodCIDR: "10.0.0.0/8", ServiceCIDR: "10.96.0.0/12"},
+		"unsupported load balancer":    {LoadBalancerType: "application"},
+		"invalid network CIDR":         {NetworkCIDR: "10.0.0.0"},
+		"overlapping network CIDR":     {NetworkCIDR: "10.96.0.0/16"},
+		"invalid network zones":        {NetworkZones: -1},
+		"network CIDR with networks": {
+			NetworkCIDR: constants.DefaultNetworkCIDR,
+			MasterPool:  model.MasterPool{NodePool: testutil.MakeNodePool("foo", "master")},
+		},
+	}
+	for name, cluster := range cases {
+		m, ctrl := makeTestMock()
+		cluster.Name = "foo"
+		m.Clusters.On("GetClusters", cluster.Name).Return([]*model.Cluster{}, nil).Once()
+
+		if err := ctrl.CreateCluster(cluster, model.Assets{}); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestSetClusterNetworkDefaults(t *testing.T) {
+	_, ctrl := makeTestMock()
+
+	cluster := model.Cluster{}
+	if err := ctrl.setClusterNetworkDefaults(&cluster); err != nil {
+		t.Fatal(err)
+	}
+	if cluster.NetworkCIDR != constants.DefaultNetworkCIDR || cluster.NetworkZones != constants.DefaultNetworkZones {
+		t.Errorf("got network %q in %d zones; want %q in %d zones", cluster.NetworkCIDR, cluster.NetworkZones,
+			constants.DefaultNetworkCIDR, constants.DefaultNetworkZones)
+	}
+
+	cluster = model.Cluster{MasterPool: model.MasterPool{NodePool: testutil.MakeNodePool("foo", "master")}}
+	if err := ctrl.setClusterNetworkDefaults(&cluster); err != nil {
+		t.Fatal(err)
+	}
+	if cluster.NetworkCIDR != "" || cluster.NetworkZones != 0 {
+		t.Errorf("got network %q in %d zones; want no network to be created", cluster.NetworkCIDR, cluster.NetworkZones)
+	}
+}
+
 func TestCreateMasterPoolAlreadyExists(t *testing.T) {
 	m, ctrl := makeTestMock()
 
@@ -119,6 +223,332 @@ func TestCreateMasterPoolAlreadyExists(t *testing.T) {
 	m.NodePooler.AssertExpectations(t)
 }
 
+func TestCreateComputePoolAmbiguousCluster(t *testing.T) {
+	m, ctrl := makeTestMock()
+	p := model.ComputePool{NodePool: testutil.MakeNodePool("foo", "compute0")}
+	clusters := []*model.Cluster{
+		{ResourceMeta: model.ResourceMeta{Name: "bar"}},
+		{ResourceMeta: model.ResourceMeta{Name: "baz"}},
+	}
+	m.Clusters.On("GetClusters", "").Return(clusters, nil).Once()
+
+	err := ctrl.CreateComputePool(p)
+	if err == nil || !strings.Contains(err.Error(), "more than one cluster") {
+		t.Errorf("got error %v; want more than one cluster found", err)
+	}
+	m.NodePooler.AssertNotCalled(t, "CreateComputePool", mock.Anything)
+}
+
+func TestCreateComputePoolInvalidInstancesPolicy(t *testing.T) {
+	cases := map[string]model.InstancesPolicy{
+		"unsupported purchase strategy": {PurchaseStrategy: "foo"},
+		"on-demand spot max price":      {PurchaseStrategy: constants.PurchaseStrategyOnDemand, SpotMaxPrice: "0.1"},
+		"invalid spot max price":        {PurchaseStrategy: constants.PurchaseStrategySpot, SpotMaxPrice: "foo"},
+		"invalid on-demand percentage":  {PurchaseStrategy: constants.PurchaseStrategyMixed, OnDemandPercentage: 101},
+		"invalid on-demand base":        {PurchaseStrategy: constants.PurchaseStrategySpot, OnDemandBaseCapacity: -1},
+	}
+	for name, policy := range cases {
+		m, ctrl := makeTestMock()
+		clusterName := "foo"
+		p := model.ComputePool{
+			NodePool:        testutil.MakeNodePool(clusterName, "compute"),
+			InstancesPolicy: policy,
+		}
+		m.Clusters.On("GetClusters", "").Return([]*model.Cluster{&model.Cluster{ResourceMeta: model.ResourceMeta{Name: clusterName}}}, nil).Once()
+		m.NodePooler.On("GetComputePools", clusterName, p.Name).Return([]*model.ComputePool{}, nil)
+
+		if err := ctrl.CreateComputePool(p); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestCreateComputePoolInvalidSize(t *testing.T) {
+	cases := map[string]model.NodePoolSpec{
+		"min size without max size": {Size: 2, MinSize: 1},
+		"min size above max size":   {Size: 2, MinSize: 3, MaxSize: 2},
+		"size below min size":       {Size: 1, MinSize: 2, MaxSize: 5},
+		"size above max size":       {Size: 6, MinSize: 2, MaxSize: 5},
+	}
+	for name, spec := range cases {
+		m, ctrl := makeTestMock()
+		clusterName := "foo"
+		p := model.ComputePool{NodePool: testutil.MakeNodePool(clusterName, "compute")}
+		p.Size, p.MinSize, p.MaxSize = spec.Size, spec.MinSize, spec.MaxSize
+		m.Clusters.On("GetClusters", "").Return([]*model.Cluster{&model.Cluster{ResourceMeta: model.ResourceMeta{Name: clusterName}}}, nil).Once()
+		m.NodePooler.On("GetComputePools", clusterName, p.Name).Return([]*model.ComputePool{}, nil)
+
+		if err := ctrl.CreateComputePool(p); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestCreateComputePoolAllowFromPools(t *testing.T) {
+	m, ctrl := makeTestMock()
+	clusterName := "foo"
+	p := model.ComputePool{
+		NodePool:       testutil.MakeNodePool(clusterName, "compute1"),
+		AllowFromPools: []string{"compute0"},
+	}
+	m.Clusters.On("GetClusters", "").Return([]*model.Cluster{&model.Cluster{ResourceMeta: model.ResourceMeta{Name: clusterName}}}, nil).Once()
+	m.NodePooler.On("GetComputePools", clusterName, p.Name).Return([]*model.ComputePool{}, nil)
+	m.NodePooler.On("GetComputePools", clusterName, "compute0").Return([]*model.ComputePool{}, nil)
+
+	if err := ctrl.CreateComputePool(p); err == nil {
+		t.Error("expected an error for a computepool that does not exist")
+	}
+
+	p.AllowFromPools = []string{p.Name}
+	m.Clusters.On("GetClusters", "").Return([]*model.Cluster{&model.Cluster{ResourceMeta: model.ResourceMeta{Name: clusterName}}}, nil).Once()
+	if err := ctrl.CreateComputePool(p); err == nil {
+		t.Error("expected an error for a computepool that allows traffic from itself")
+	}
+}
+
+func TestCreateComputePoolPreflightFailed(t *testing.T) {
+	m, ctrl := makeTestMock()
+	clusterName := "foo"
+	p := model.ComputePool{NodePool: testutil.MakeNodePool(clusterName, "compute0")}
+
+	m.Clusters.On("GetClusters", "").Return([]*model.Cluster{&model.Cluster{ResourceMeta: model.ResourceMeta{Name: clusterName}}}, nil).Once()
+	m.NodePooler.On("GetComputePools", clusterName, p.Name).Return([]*model.ComputePool{}, nil)
+	m.Provider.On("Checker").Return(m.Checker, true)
+	m.Checker.On("ValidateComputePool", mock.MatchedBy(func(v model.ComputePool) bool {
+		return v.Name == p.Name && v.CoreOSVersion != ""
+	})).Return([]model.CheckResult{{Name: "free ips", Message: "subnet-1 has 1 free IPs, 2 needed"}}, nil)
+
+	if err := ctrl.CreateComputePool(p); err == nil {
+		t.Error("expected an error for failed pre-flight checks")
+	} else if _, ok := err.(*PreflightError); !ok {
+		t.Errorf("got error %v; want a pre-flight error", err)
+	}
+
+	m.Checker.AssertExpectations(t)
+}
+
+func TestPreflight(t *testing.T) {
+	m, ctrl := makeTestMock()
+	var warnings bytes.Buffer
+	ctrl.WarnLogger = log.New(&warnings, "", 0)
+
+	m.Provider.On("Checker").Return(m.Checker, true).Once()
+	validate := func(ch cloudprovider.Checker) ([]model.CheckResult, error) {
+		return []model.CheckResult{
+			{Name: "ssh key", Passed: true, Message: "key pairs foo exist"},
+			{Name: "quotas", Passed: true, Warning: true, Message: "instances limit is unknown"},
+		}, nil
+	}
+	if err := ctrl.preflight("cluster \"foo\"", validate); err != nil {
+		t.Fatalf("expected checks with warnings to pass: %v", err)
+	}
+	if !strings.Contains(warnings.String(), "instances limit is unknown") {
+		t.Errorf("expected a quotas warning, got %q", warnings.String())
+	}
+
+	ctrl.SkipPreflight = true
+	if err := ctrl.preflight("cluster \"foo\"", func(cloudprovider.Checker) ([]model.CheckResult, error) {
+		t.Error("expected pre-flight checks to be skipped")
+		return nil, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Provider.AssertNumberOfCalls(t, "Checker", 1)
+}
+
+func TestCreateBastionPool(t *testing.T) {
+	m, ctrl := makeTestMock()
+	cluster := &model.Cluster{
+		ResourceMeta: model.ResourceMeta{Name: "foo", Labels: model.Labels{"env": "dev"}},
+		Access:       model.Access{SSHCIDRs: []string{"10.0.0.0/8"}},
+	}
+	p := model.BastionPool{}
+	p.ClusterName = cluster.Name
+	p.MachineType = "t3.nano"
+
+	m.Provider.On("Bastions").Return(m.Bastions, true)
+	m.Clusters.On("GetClusters", "").Return([]*model.Cluster{cluster}, nil)
+	m.Bastions.On("GetBastionPools", cluster.Name).Return([]*model.BastionPool{}, nil).Once()
+	m.Bastions.On("CreateBastionPool", mock.MatchedBy(func(p model.BastionPool) bool {
+		return p.Name == constants.BastionPoolName &&
+			p.Size == constants.DefaultBastionPoolSize &&
+			p.DiskSize == constants.DefaultDiskSizeInGigabytes &&
+			p.CoreOSVersion == constants.DefaultCoreOSVersion &&
+			p.Labels["env"] == "dev" &&
+			len(p.AllowedCIDRs) == 1 && p.AllowedCIDRs[0] == "10.0.0.0/8"
+	})).Return(nil).Once()
+	if err := ctrl.CreateBastionPool(p); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Bastions.On("GetBastionPools", cluster.Name).Return([]*model.BastionPool{&p}, nil).Once()
+	if err := ctrl.CreateBastionPool(p); err != ErrBastionPoolAlreadyExists {
+		t.Errorf("got error %v; want %v", err, ErrBastionPoolAlreadyExists)
+	}
+
+	cluster.Access = model.Access{DisableSSH: true}
+	if err := ctrl.CreateBastionPool(p); err == nil {
+		t.Error("expected an error for a cluster with disabled SSH access")
+	}
+
+	// Bastion hosts with their own CIDRs reach nodes of a cluster with
+	// disabled SSH access.
+	p.AllowedCIDRs = []string{"192.168.0.0/16"}
+	m.Bastions.On("GetBastionPools", cluster.Name).Return([]*model.BastionPool{}, nil).Once()
+	m.Bastions.On("CreateBastionPool", mock.MatchedBy(func(p model.BastionPool) bool {
+		return len(p.AllowedCIDRs) == 1 && p.AllowedCIDRs[0] == "192.168.0.0/16"
+	})).Return(nil).Once()
+	if err := ctrl.CreateBastionPool(p); err != nil {
+		t.Fatal(err)
+	}
+
+	p.AllowedCIDRs = []string{"192.168.0.0"}
+	if err := ctrl.CreateBastionPool(p); err == nil {
+		t.Error("expected an error for an invalid bastion allowed CIDR")
+	}
+
+	m.Bastions.AssertExpectations(t)
+}
+
+func TestWarnBastionAccess(t *testing.T) {
+	m, ctrl := makeTestMock()
+	var warnings bytes.Buffer
+	ctrl.WarnLogger = log.New(&warnings, "", 0)
+	cluster := model.Cluster{ResourceMeta: model.ResourceMeta{Name: "foo"}, Access: model.Access{DisableSSH: true}}
+	withCIDRs := &model.BastionPool{AllowedCIDRs: []string{"10.0.0.0/8"}}
+
+	m.Provider.On("Bastions").Return(m.Bastions, true)
+	m.Bastions.On("GetBastionPools", cluster.Name).Return([]*model.BastionPool{}, nil).Once()
+	m.Bastions.On("GetBastionPools", cluster.Name).Return([]*model.BastionPool{{}}, nil).Once()
+	m.Bastions.On("GetBastionPools", cluster.Name).Return([]*model.BastionPool{withCIDRs}, nil).Once()
+
+	for _, want := range []string{"create a bastion pool", "recreate the bastion pool", ""} {
+		warnings.Reset()
+		if err := ctrl.warnBastionAccess(cluster); err != nil {
+			t.Fatal(err)
+		}
+		if want == "" && warnings.Len() > 0 {
+			t.Errorf("got warning %q; want none", warnings.String())
+		}
+		if !strings.Contains(warnings.String(), want) {
+			t.Errorf("got warning %q; want %q", warnings.String(), want)
+		}
+	}
+
+	m.Bastions.AssertExpectations(t)
+}
+
+func TestSetPoolSizeDefaults(t *testing.T) {
+	p := model.NodePoolSpec{Size: 3}
+	if err := setPoolSizeDefaults(&p); err != nil {
+		t.Fatal(err)
+	}
+	if p.MinSize != 3 || p.MaxSize != 3 {
+		t.Errorf("expected a fixed size pool, got min %d, max %d", p.MinSize, p.MaxSize)
+	}
+}
+
+func TestUpgradeComputePool(t *testing.T) {
+	m, ctrl := makeTestMock()
+
+	cluster := model.Cluster{ResourceMeta: model.ResourceMeta{Name: "foo"}}
+	existing := model.ComputePool{NodePool: testutil.MakeNodePool(cluster.Name, "compute0")}
+	existing.Size = 3
+	existing.VolumeType = "io1"
+	existing.VolumeIOPS = 1000
+
+	p := model.ComputePool{}
+	p.Name = existing.Name
+	p.ClusterName = cluster.Name
+	p.MachineType = "large"
+	p.VolumeType = "gp3"
+
+	m.Clusters.On("GetClusters", "").Return([]*model.Cluster{&cluster}, nil).Once()
+	m.NodePooler.On("GetComputePools", cluster.Name, "").Return([]*model.ComputePool{&existing}, nil)
+	m.Provider.On("ProviderName").Return(cloudProviderName)
+	m.UserData.On("RenderComputeCloudConfig", cloudProviderName, cluster, existing.KubeVersion).Return([]byte("cloud-config"), nil)
+	m.UserData.On("RenderUserData", existing.UserDataFormat, []byte("cloud-config")).Return([]byte("user-data"), nil)
+	m.NodePooler.On("UpgradeComputePool", mock.MatchedBy(func(u model.ComputePool) bool {
+		return u.MachineType == "large" &&
+			u.Size == existing.Size &&
+			u.SSHKey == existing.SSHKey &&
+			u.VolumeType == "gp3" &&
+			u.VolumeIOPS == 0 &&
+			u.PurchaseStrategy == constants.DefaultPurchaseStrategy &&
+			string(u.UserData) == "user-data"
+	})).Return(nil)
+
+	if err := ctrl.UpgradeComputePool(p); err != nil {
+		t.Error(err)
+	}
+
+	m.Clusters.AssertExpectations(t)
+	m.UserData.AssertExpectations(t)
+	m.NodePooler.AssertExpectations(t)
+}
+
+func TestUpgradeComputePoolZeroMinSize(t *testing.T) {
+	m, ctrl := makeTestMock()
+
+	cluster := model.Cluster{ResourceMeta: model.ResourceMeta{Name: "foo"}}
+	existing := model.ComputePool{NodePool: testutil.MakeNodePool(cluster.Name, "compute0")}
+	existing.Size, existing.MinSize, existing.MaxSize = 2, 1, 5
+
+	p := model.ComputePool{}
+	p.Name = existing.Name
+	p.ClusterName = cluster.Name
+	p.MinSizeSet = true
+
+	m.Clusters.On("GetClusters", "").Return([]*model.Cluster{&cluster}, nil).Once()
+	m.NodePooler.On("GetComputePools", cluster.Name, "").Return([]*model.ComputePool{&existing}, nil)
+	m.Provider.On("ProviderName").Return(cloudProviderName)
+	m.UserData.On("RenderComputeCloudConfig", cloudProviderName, cluster, existing.KubeVersion).Return([]byte("cloud-config"), nil)
+	m.UserData.On("RenderUserData", existing.UserDataFormat, []byte("cloud-config")).Return([]byte("user-data"), nil)
+	m.NodePooler.On("UpgradeComputePool", mock.MatchedBy(func(u model.ComputePool) bool {
+		return u.Size == 2 && u.MinSize == 0 && u.MaxSize == 5
+	})).Return(nil)
+
+	if err := ctrl.UpgradeComputePool(p); err != nil {
+		t.Error(err)
+	}
+
+	m.NodePooler.AssertExpectations(t)
+}
+
+func TestUpgradeComputePoolDoesNotExist(t *testing.T) {
+	m, ctrl := makeTestMock()
+
+	cluster := model.Cluster{ResourceMeta: model.ResourceMeta{Name: "foo"}}
+	p := model.ComputePool{NodePool: testutil.MakeNodePool(cluster.Name, "compute0")}
+
+	m.Clusters.On("GetClusters", "").Return([]*model.Cluster{&cluster}, nil).Once()
+	m.NodePooler.On("GetComputePools", cluster.Name, "").Return([]*model.ComputePool{}, nil)
+
+	if err := ctrl.UpgradeComputePool(p); err != ErrComputePoolDoesNotExist {
+		t.Errorf("wrong error; got %q; want %q", err, ErrComputePoolDoesNotExist)
+	}
+}
+
+func TestUpgradeComputePoolUnknownName(t *testing.T) {
+	m, ctrl := makeTestMock()
+
+	cluster := model.Cluster{ResourceMeta: model.ResourceMeta{Name: "foo"}}
+	other := model.ComputePool{NodePool: testutil.MakeNodePool(cluster.Name, "compute0")}
+	other.Size = 3
+	p := model.ComputePool{}
+	p.Name = "typo"
+	p.ClusterName = cluster.Name
+
+	m.Clusters.On("GetClusters", "").Return([]*model.Cluster{&cluster}, nil).Once()
+	m.NodePooler.On("GetComputePools", cluster.Name, "").Return([]*model.ComputePool{&other}, nil)
+
+	if err := ctrl.UpgradeComputePool(p); err != ErrComputePoolDoesNotExist {
+		t.Errorf("wrong error; got %q; want %q", err, ErrComputePoolDoesNotExist)
+	}
+	m.NodePooler.AssertNotCalled(t, "UpgradeComputePool", mock.Anything)
+}
+
 func TestDeleteCluster(t *testing.T) {
 	m, ctrl := makeTestMock()
 	m.Clusters.On("DeleteCluster", "foo").Return(nil)
@@ -136,6 +566,8 @@ func makeTestMock() (*testMock, *Controller) {
 		Clusters:   &cloudProviderMocks.Clusters{},
 		NodePooler: &cloudProviderMocks.NodePooler{},
 		Node:       &cloudProviderMocks.Node{},
+		Bastions:   &cloudProviderMocks.Bastions{},
+		Checker:    &cloudProviderMocks.Checker{},
 		UserData:   &userdataMocks.UserDater{},
 	}
 
@@ -149,3 +581,131 @@ func makeTestMock() (*testMock, *Controller) {
 	})
 	return m, ctrl
 }
+
+func TestSetClusterAccessDefaults(t *testing.T) {
+	_, ctrl := makeTestMock()
+
+	a := model.Access{}
+	if err := ctrl.setClusterAccessDefaults(&a); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.SSHCIDRs) != 1 || a.SSHCIDRs[0] != constants.DefaultAccessCIDR {
+		t.Errorf("got SSH CIDRs %v; want default", a.SSHCIDRs)
+	}
+	if len(a.APICIDRs) != 1 || a.APICIDRs[0] != constants.DefaultAccessCIDR {
+		t.Errorf("got API CIDRs %v; want default", a.APICIDRs)
+	}
+
+	a = model.Access{DisableSSH: true}
+	if err := ctrl.setClusterAccessDefaults(&a); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.SSHCIDRs) != 0 {
+		t.Errorf("got SSH CIDRs %v when SSH is disabled", a.SSHCIDRs)
+	}
+
+	for name, a := range map[string]model.Access{
+		"disabled SSH with CIDRs": {DisableSSH: true, SSHCIDRs: []string{"10.0.0.0/8"}},
+		"invalid SSH CIDR":        {SSHCIDRs: []string{"10.0.0.0"}},
+		"invalid API CIDR":        {APICIDRs: []string{"foo"}},
+		"empty security group":    {SourceSecurityGroups: []string{""}},
+	} {
+		if err := ctrl.setClusterAccessDefaults(&a); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestSetClusterAPIDefaults(t *testing.T) {
+	_, ctrl := makeTestMock()
+
+	c := model.Cluster{ResourceMeta: model.ResourceMeta{Name: "foo"}, DNSZone: "Example.com"}
+	if err := ctrl.setClusterAPIDefaults(&c); err != nil {
+		t.Fatal(err)
+	}
+	if c.APIHostname != "kube-foo.example.com" {
+		t.Errorf("got API hostname %q; want %q", c.APIHostname, "kube-foo.example.com")
+	}
+
+	c = model.Cluster{
+		ResourceMeta: model.ResourceMeta{Name: "foo"},
+		DNSZone:      "example.com",
+		APIHostname:  "API.corp.local",
+		APIAliases:   []string{"kube.example.com"},
+		APIExtraSANs: []string{"*.corp.local", "10.0.0.10"},
+	}
+	if err := ctrl.setClusterAPIDefaults(&c); err != nil {
+		t.Fatal(err)
+	}
+	if c.APIHostname != "api.corp.local" {
+		t.Errorf("got API hostname %q; want %q", c.APIHostname, "api.corp.local")
+	}
+
+	cases := map[string]model.Cluster{
+		"invalid hostname":          {APIHostname: "https://api.example.com"},
+		"alias without zone":        {APIAliases: []string{"kube.example.com"}},
+		"alias outside zone":        {DNSZone: "example.com", APIAliases: []string{"kube.corp.local"}},
+		"invalid SAN":               {APIExtraSANs: []string{"kube corp"}},
+		"empty SAN":                 {APIExtraSANs: []string{""}},
+		"alias equal to zone":       {DNSZone: "example.com", APIAliases: []string{"example.com"}},
+		"private zone without zone": {PrivateDNSZone: true},
+		"zone IDs and split DNS":    {DNSZone: "example.com", DNSZoneIDs: []string{"Z1"}, SplitHorizonDNS: true},
+		"private zone of new VPC":   {DNSZone: "example.com", PrivateDNSZone: true, NetworkCIDR: "10.0.0.0/16"},
+	}
+	for name, c := range cases {
+		c.Name = "foo"
+		if err := ctrl.setClusterAPIDefaults(&c); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestValidateKetoK8Image(t *testing.T) {
+	c := model.Cluster{
+		PodCIDR:     constants.DefaultPodCIDR,
+		ServiceCIDR: constants.DefaultServiceCIDR,
+		Components:  model.Components{KetoK8Image: constants.DefaultKetoK8Image},
+	}
+	if err := validateKetoK8Image(c); err != nil {
+		t.Error(err)
+	}
+
+	cases := map[string]model.Cluster{
+		"custom pod CIDR":     {PodCIDR: "10.32.0.0/12", ServiceCIDR: constants.DefaultServiceCIDR},
+		"custom service CIDR": {PodCIDR: constants.DefaultPodCIDR, ServiceCIDR: "10.96.0.0/16"},
+		"API aliases":         {PodCIDR: constants.DefaultPodCIDR, ServiceCIDR: constants.DefaultServiceCIDR, APIAliases: []string{"kube.example.com"}},
+		"extra SANs":          {PodCIDR: constants.DefaultPodCIDR, ServiceCIDR: constants.DefaultServiceCIDR, APIExtraSANs: []string{"10.0.0.10"}},
+		"registry mirror":     {PodCIDR: constants.DefaultPodCIDR, ServiceCIDR: constants.DefaultServiceCIDR, RegistryMirror: "mirror:5000"},
+	}
+	for name, c := range cases {
+		c.Components.KetoK8Image = constants.DefaultKetoK8Image
+		if err := validateKetoK8Image(c); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+		c.Components.KetoK8Image = "registry.local/keto-k8:latest"
+		if err := validateKetoK8Image(c); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestMergeAccess(t *testing.T) {
+	existing := model.Access{
+		SSHCIDRs:             []string{"10.0.0.0/8"},
+		APICIDRs:             []string{"0.0.0.0/0"},
+		SourceSecurityGroups: []string{"sg-1"},
+	}
+
+	m := mergeAccess(existing, model.Access{DisableSSH: true, SourceSecurityGroups: []string{}})
+	if !m.DisableSSH || len(m.SSHCIDRs) != 0 || len(m.SourceSecurityGroups) != 0 {
+		t.Errorf("expected SSH and source security groups to be removed, got %+v", m)
+	}
+	if len(m.APICIDRs) != 1 {
+		t.Errorf("expected API CIDRs to be kept, got %v", m.APICIDRs)
+	}
+
+	m = mergeAccess(m, model.Access{SSHCIDRs: []string{"192.168.0.0/16"}})
+	if m.DisableSSH || len(m.SSHCIDRs) != 1 {
+		t.Errorf("expected SSH to be enabled, got %+v", m)
+	}
+}
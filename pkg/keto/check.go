@@ -0,0 +1,376 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keto
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/UKHomeOffice/keto/pkg/model"
+)
+
+const (
+	kubeAPIHealthzCheckName      = "api healthz"
+	etcdMembersCheckName         = "etcd members"
+	kubeAPICertificatesCheckName = "api certificates"
+
+	// etcdClientPort is a port etcd members serve clients on.
+	etcdClientPort = "2379"
+)
+
+// CheckKubeAPI checks that the kube API /healthz endpoint reports ok and
+// that certificates served by the API are valid for at least minValidity. The
+// API server certificate is verified with caCert if one is given.
+func CheckKubeAPI(kubeAPIURL string, caCert []byte, minValidity, timeout time.Duration) []model.CheckResult {
+	if kubeAPIURL == "" {
+		return []model.CheckResult{
+			{Name: kubeAPIHealthzCheckName, Message: "cluster has no kube API URL"},
+			{Name: kubeAPICertificatesCheckName, Message: "cluster has no kube API URL"},
+		}
+	}
+	return []model.CheckResult{
+		checkKubeAPIHealthz(kubeAPIURL, caCert, timeout),
+		checkKubeAPICertificates(kubeAPIURL, minValidity, timeout),
+	}
+}
+
+// checkKubeAPIHealthz checks that the kube API /healthz endpoint responds
+// with a 200 status.
+func checkKubeAPIHealthz(kubeAPIURL string, caCert []byte, timeout time.Duration) model.CheckResult {
+	r := model.CheckResult{Name: kubeAPIHealthzCheckName}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: true}
+	if len(caCert) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			r.Message = "invalid kube CA certificate"
+			return r
+		}
+		tlsConfig = &tls.Config{RootCAs: pool}
+	}
+	client := &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+
+	resp, err := client.Get(strings.TrimRight(kubeAPIURL, "/") + "/healthz")
+	if err != nil {
+		r.Message = err.Error()
+		return r
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	r.Message = fmt.Sprintf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	r.Passed = resp.StatusCode == http.StatusOK
+	return r
+}
+
+// etcdMember is a member of an etcd /v2/members list.
+type etcdMember struct {
+	Name     string   `json:"name"`
+	PeerURLs []string `json:"peerURLs"`
+}
+
+// CheckEtcdMembers checks that etcd members are the masters at persistent
+// IPs, i.e. that there are as many members as persistent IPs and that every
+// member's peer URL is at one of them. Members are listed from the first
+// master that answers, with a client certificate that is signed by the etcd
+// CA. The result is unknown without the etcd CA certificate and key.
+func CheckEtcdMembers(persistentIPs map[string]string, etcdCACert, etcdCAKey []byte, timeout time.Duration) model.CheckResult {
+	endpoints := []string{}
+	for _, ip := range persistentIPs {
+		endpoints = append(endpoints, "https://"+net.JoinHostPort(ip, etcdClientPort))
+	}
+	sort.Strings(endpoints)
+	return checkEtcdMembers(endpoints, persistentIPs, etcdCACert, etcdCAKey, timeout)
+}
+
+// checkEtcdMembers lists etcd members from the first of endpoints that
+// answers and compares them with persistent IPs.
+func checkEtcdMembers(endpoints []string, persistentIPs map[string]string, etcdCACert, etcdCAKey []byte, timeout time.Duration) model.CheckResult {
+	r := model.CheckResult{Name: etcdMembersCheckName}
+	if len(etcdCACert) == 0 || len(etcdCAKey) == 0 {
+		r.Passed, r.Warning = true, true
+		r.Message = "unknown, members can only be listed with the etcd CA"
+		return r
+	}
+	if len(persistentIPs) == 0 {
+		r.Message = "no master persistent IPs found"
+		return r
+	}
+
+	tlsConfig, err := etcdClientTLSConfig(etcdCACert, etcdCAKey)
+	if err != nil {
+		r.Message = err.Error()
+		return r
+	}
+	client := &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+
+	var members []etcdMember
+	errs := []string{}
+	for _, e := range endpoints {
+		if members, err = listEtcdMembers(client, e); err == nil {
+			break
+		}
+		errs = append(errs, err.Error())
+	}
+	if len(errs) == len(endpoints) {
+		r.Message = "failed to list members: " + strings.Join(errs, "; ")
+		return r
+	}
+
+	ips := make(map[string]bool)
+	for _, ip := range persistentIPs {
+		ips[ip] = true
+	}
+	unknown := []string{}
+	for _, m := range members {
+		ip := etcdMemberIP(m)
+		if !ips[ip] {
+			unknown = append(unknown, fmt.Sprintf("%s (%s)", m.Name, ip))
+			continue
+		}
+		delete(ips, ip)
+	}
+	missing := []string{}
+	for ip := range ips {
+		missing = append(missing, ip)
+	}
+	sort.Strings(missing)
+
+	problems := []string{}
+	if len(unknown) > 0 {
+		problems = append(problems, "members not at persistent IPs: "+strings.Join(unknown, ", "))
+	}
+	if len(missing) > 0 {
+		problems = append(problems, "persistent IPs without members: "+strings.Join(missing, ", "))
+	}
+	r.Message = fmt.Sprintf("%d members, %d persistent IPs", len(members), len(persistentIPs))
+	if len(problems) > 0 {
+		r.Message += ", " + strings.Join(problems, ", ")
+		return r
+	}
+	r.Passed = len(members) == len(persistentIPs)
+	return r
+}
+
+// listEtcdMembers lists members of an etcd cluster through the v2 members
+// API of an endpoint.
+func listEtcdMembers(client *http.Client, endpoint string) ([]etcdMember, error) {
+	resp, err := client.Get(endpoint + "/v2/members")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: unexpected status %q", endpoint, resp.Status)
+	}
+	list := struct {
+		Members []etcdMember `json:"members"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("%s: %v", endpoint, err)
+	}
+	return list.Members, nil
+}
+
+// etcdMemberIP returns a host of the first peer URL of an etcd member.
+func etcdMemberIP(m etcdMember) string {
+	if len(m.PeerURLs) == 0 {
+		return ""
+	}
+	u, err := url.Parse(m.PeerURLs[0])
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// etcdClientTLSConfig returns a TLS config that verifies etcd members with
+// the etcd CA and authenticates with a short lived client certificate, which
+// is signed by the etcd CA.
+func etcdClientTLSConfig(caCert, caKey []byte) (*tls.Config, error) {
+	b, _ := pem.Decode(caCert)
+	if b == nil {
+		return nil, errors.New("invalid etcd CA certificate")
+	}
+	ca, err := x509.ParseCertificate(b.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid etcd CA certificate: %v", err)
+	}
+	signer, err := parsePrivateKey(caKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid etcd CA key: %v", err)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "keto-check"},
+		NotBefore:    now.Add(-5 * time.Minute),
+		NotAfter:     now.Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, key.Public(), signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign an etcd client certificate: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	return &tls.Config{
+		RootCAs:      pool,
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}, nil
+}
+
+// parsePrivateKey parses a PEM encoded RSA, EC or PKCS8 private key.
+func parsePrivateKey(pemData []byte) (crypto.Signer, error) {
+	b, _ := pem.Decode(pemData)
+	if b == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	if k, err := x509.ParsePKCS1PrivateKey(b.Bytes); err == nil {
+		return k, nil
+	}
+	if k, err := x509.ParseECPrivateKey(b.Bytes); err == nil {
+		return k, nil
+	}
+	k, err := x509.ParsePKCS8PrivateKey(b.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := k.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported private key type")
+	}
+	return signer, nil
+}
+
+// checkKubeAPICertificates checks expiry of certificates served by the kube
+// API. The chain isn't verified, so that expired certificates are reported.
+func checkKubeAPICertificates(kubeAPIURL string, minValidity, timeout time.Duration) model.CheckResult {
+	r := model.CheckResult{Name: kubeAPICertificatesCheckName}
+
+	u, err := url.Parse(kubeAPIURL)
+	if err != nil {
+		r.Message = fmt.Sprintf("invalid kube API URL %q: %v", kubeAPIURL, err)
+		return r
+	}
+	port := u.Port()
+	if port == "" {
+		port = "443"
+	}
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", net.JoinHostPort(u.Hostname(), port),
+		&tls.Config{InsecureSkipVerify: true, ServerName: u.Hostname()})
+	if err != nil {
+		r.Message = err.Error()
+		return r
+	}
+	defer conn.Close()
+	return checkCertificates(r.Name, conn.ConnectionState().PeerCertificates, minValidity)
+}
+
+// CheckCertificateExpiry checks that all PEM encoded certificates are valid
+// for at least minValidity.
+func CheckCertificateExpiry(name string, pemData []byte, minValidity time.Duration) model.CheckResult {
+	certs := []*x509.Certificate{}
+	for {
+		var b *pem.Block
+		b, pemData = pem.Decode(pemData)
+		if b == nil {
+			break
+		}
+		if b.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(b.Bytes)
+		if err != nil {
+			return model.CheckResult{Name: name, Message: fmt.Sprintf("invalid certificate: %v", err)}
+		}
+		certs = append(certs, cert)
+	}
+	return checkCertificates(name, certs, minValidity)
+}
+
+// checkCertificates checks that certificates are valid for at least
+// minValidity and reports the one that expires first.
+func checkCertificates(name string, certs []*x509.Certificate, minValidity time.Duration) model.CheckResult {
+	r := model.CheckResult{Name: name}
+	if len(certs) == 0 {
+		r.Message = "no certificates found"
+		return r
+	}
+
+	first := certs[0]
+	for _, c := range certs[1:] {
+		if c.NotAfter.Before(first.NotAfter) {
+			first = c
+		}
+	}
+	expiry := first.NotAfter.UTC().Format(time.RFC3339)
+	now := time.Now()
+	switch {
+	case now.After(first.NotAfter):
+		r.Message = fmt.Sprintf("%q expired at %s", first.Subject.CommonName, expiry)
+	case now.Add(minValidity).After(first.NotAfter):
+		r.Message = fmt.Sprintf("%q expires soon at %s", first.Subject.CommonName, expiry)
+	default:
+		r.Passed = true
+		r.Message = fmt.Sprintf("%q expires at %s", first.Subject.CommonName, expiry)
+	}
+	return r
+}
+
+// ChecksPassed returns whether all checks passed.
+func ChecksPassed(results []model.CheckResult) bool {
+	for _, r := range results {
+		if !r.Passed {
+			return false
+		}
+	}
+	return true
+}
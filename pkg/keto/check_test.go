@@ -0,0 +1,224 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keto
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/UKHomeOffice/keto/pkg/model"
+)
+
+func TestCheckKubeAPI(t *testing.T) {
+	healthy := true
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/healthz" && healthy:
+			fmt.Fprint(w, "ok")
+		case r.URL.Path == "/healthz":
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "[-]etcd failed")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+
+	testCases := []struct {
+		name        string
+		healthy     bool
+		caCert      []byte
+		minValidity time.Duration
+		want        []bool
+	}{
+		{"healthy", true, nil, 24 * time.Hour, []bool{true, true}},
+		{"healthy with CA", true, caCert, 24 * time.Hour, []bool{true, true}},
+		{"invalid CA", true, []byte("foo"), 24 * time.Hour, []bool{false, true}},
+		{"unhealthy", false, nil, 24 * time.Hour, []bool{false, true}},
+		{"certificate expires soon", true, nil, 200 * 365 * 24 * time.Hour, []bool{true, false}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			healthy = tc.healthy
+			results := CheckKubeAPI(ts.URL, tc.caCert, tc.minValidity, 5*time.Second)
+			if len(results) != len(tc.want) {
+				t.Fatalf("got %d results; want %d", len(results), len(tc.want))
+			}
+			for i, r := range results {
+				if r.Passed != tc.want[i] {
+					t.Errorf("got %q passed %t; want %t: %s", r.Name, r.Passed, tc.want[i], r.Message)
+				}
+			}
+		})
+	}
+
+	for _, r := range CheckKubeAPI("", nil, 0, time.Second) {
+		if r.Passed {
+			t.Errorf("expected %q to fail without a kube API URL", r.Name)
+		}
+	}
+}
+
+func TestCheckEtcdMembers(t *testing.T) {
+	caCert, caKey, ca, signer := makeTestCA(t)
+	serverCert := makeTestServerCert(t, ca, signer)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	peerIPs := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/members" {
+			http.NotFound(w, r)
+			return
+		}
+		members := []etcdMember{}
+		for i, ip := range peerIPs {
+			members = append(members, etcdMember{Name: fmt.Sprintf("Node%d", i), PeerURLs: []string{"https://" + ip + ":2380"}})
+		}
+		json.NewEncoder(w).Encode(map[string][]etcdMember{"members": members})
+	}))
+	ts.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	ips := map[string]string{"0": "10.0.0.1", "1": "10.0.0.2", "2": "10.0.0.3"}
+	testCases := []struct {
+		name      string
+		endpoints []string
+		peerIPs   []string
+		ips       map[string]string
+		passed    bool
+	}{
+		{"all members", []string{ts.URL}, peerIPs, ips, true},
+		{"first endpoint unreachable", []string{"https://127.0.0.1:1", ts.URL}, peerIPs, ips, true},
+		{"missing member", []string{ts.URL}, peerIPs[:2], ips, false},
+		{"member not at persistent IP", []string{ts.URL}, []string{"10.0.0.1", "10.0.0.2", "10.0.0.9"}, ips, false},
+		{"no persistent IPs", []string{ts.URL}, peerIPs, map[string]string{}, false},
+		{"unreachable", []string{"https://127.0.0.1:1"}, peerIPs, ips, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			peerIPs = tc.peerIPs
+			r := checkEtcdMembers(tc.endpoints, tc.ips, caCert, caKey, 5*time.Second)
+			if r.Passed != tc.passed || r.Warning {
+				t.Errorf("got passed %t, warning %t; want passed %t: %s", r.Passed, r.Warning, tc.passed, r.Message)
+			}
+		})
+	}
+
+	if r := CheckEtcdMembers(ips, nil, nil, time.Second); !r.Passed || !r.Warning {
+		t.Errorf("expected an unknown result without the etcd CA, got %+v", r)
+	}
+}
+
+// makeTestCA returns a PEM encoded self-signed CA certificate and key, along
+// with the parsed certificate and key.
+func makeTestCA(t *testing.T) ([]byte, []byte, *x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "etcd ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), ca, key
+}
+
+// makeTestServerCert returns a 127.0.0.1 server certificate signed by ca.
+func makeTestServerCert(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "etcd"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, key.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestCheckCertificateExpiry(t *testing.T) {
+	ts := httptest.NewTLSServer(http.NotFoundHandler())
+	ts.Close()
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+
+	if r := CheckCertificateExpiry("ca", cert, 24*time.Hour); !r.Passed {
+		t.Errorf("expected certificate check to pass: %s", r.Message)
+	}
+	if r := CheckCertificateExpiry("ca", cert, 200*365*24*time.Hour); r.Passed {
+		t.Error("expected certificate check to fail for a certificate that expires soon")
+	}
+	if r := CheckCertificateExpiry("ca", []byte("foo"), 24*time.Hour); r.Passed {
+		t.Error("expected certificate check to fail without certificates")
+	}
+}
+
+func TestChecksPassed(t *testing.T) {
+	if !ChecksPassed([]model.CheckResult{{Name: "a", Passed: true}, {Name: "b", Passed: true}}) {
+		t.Error("expected all checks to pass")
+	}
+	if ChecksPassed([]model.CheckResult{{Name: "a", Passed: true}, {Name: "b"}}) {
+		t.Error("expected a check to fail")
+	}
+}
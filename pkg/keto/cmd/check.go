@@ -0,0 +1,166 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/UKHomeOffice/keto/pkg/controller"
+	"github.com/UKHomeOffice/keto/pkg/keto"
+	"github.com/UKHomeOffice/keto/pkg/model"
+
+	"github.com/spf13/cobra"
+)
+
+// checkRetryInterval is how often checks are retried while waiting for a
+// cluster to become healthy.
+const checkRetryInterval = 15 * time.Second
+
+// errChecksFailed is returned when a cluster fails any of its checks.
+var errChecksFailed = errors.New("cluster checks failed")
+
+// checkCmd represents the 'check' command
+var checkCmd = &cobra.Command{
+	Use:   "check <subcommand>",
+	Short: "Check health of resources",
+}
+
+var checkClusterCmd = &cobra.Command{
+	Use:     "cluster NAME",
+	Aliases: clusterCmdAliases,
+	Short:   "Check cluster health",
+	Long: "Check that cluster stacks are complete, autoscaling groups are at their desired " +
+		"capacity, load balancer instances are healthy, every persistent ENI is attached to " +
+		"a master, the kube API /healthz endpoint reports ok, etcd members are the masters at " +
+		"persistent IPs and certificates are not about to expire. Etcd members are only listed " +
+		"with --assets-dir. Exits with 1 if any check fails.",
+	SilenceUsage: true,
+	PreRunE: func(c *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("cluster name must be specified")
+		}
+		return nil
+	},
+	RunE: func(c *cobra.Command, args []string) error {
+		return checkClusterCmdFunc(c, args)
+	},
+}
+
+func checkClusterCmdFunc(c *cobra.Command, args []string) error {
+	opts := checkOptions{clusterName: args[0]}
+	var err error
+	if opts.minValidity, err = c.Flags().GetDuration("cert-min-validity"); err != nil {
+		return err
+	}
+	if opts.timeout, err = c.Flags().GetDuration("timeout"); err != nil {
+		return err
+	}
+	wait, err := c.Flags().GetDuration("wait")
+	if err != nil {
+		return err
+	}
+	assetsDir, err := c.Flags().GetString("assets-dir")
+	if err != nil {
+		return err
+	}
+
+	cli, err := newCLI(c)
+	if err != nil {
+		return err
+	}
+	if assetsDir != "" {
+		if opts.assets, err = cli.readAssetFiles(assetsDir); err != nil {
+			return err
+		}
+	}
+
+	deadline := time.Now().Add(wait)
+	for {
+		results, err := checkCluster(cli, opts)
+		if err != nil {
+			return err
+		}
+		if keto.ChecksPassed(results) || time.Now().Add(checkRetryInterval).After(deadline) {
+			if err := keto.PrintCheckResults(keto.GetPrinter(os.Stdout), results, true); err != nil {
+				return err
+			}
+			if !keto.ChecksPassed(results) {
+				return errChecksFailed
+			}
+			return nil
+		}
+		cli.logger.Printf("cluster %q is not healthy yet, retrying in %s", opts.clusterName, checkRetryInterval)
+		time.Sleep(checkRetryInterval)
+	}
+}
+
+// checkOptions are options of cluster checks.
+type checkOptions struct {
+	clusterName string
+	// assets are optional CA certificates, which are checked for expiry and
+	// used to verify the kube API server certificate. The etcd CA is used to
+	// list etcd members.
+	assets      model.Assets
+	minValidity time.Duration
+	timeout     time.Duration
+}
+
+// checkCluster runs cloud provider checks of a cluster, if the provider
+// supports them, followed by kube API, etcd members and CA certificate checks.
+func checkCluster(cli *cli, opts checkOptions) ([]model.CheckResult, error) {
+	results, err := cli.ctrl.CheckClusterInfra(opts.clusterName)
+	if err != nil && err != controller.ErrNotImplemented {
+		return nil, err
+	}
+
+	clusters, err := cli.ctrl.GetClusters(opts.clusterName)
+	if err != nil {
+		return nil, err
+	}
+	if len(clusters) != 1 {
+		return nil, fmt.Errorf("cluster %q not found", opts.clusterName)
+	}
+	results = append(results, keto.CheckKubeAPI(clusters[0].KubeAPIURL, opts.assets.KubeCACert, opts.minValidity, opts.timeout)...)
+
+	ips, err := cli.ctrl.GetMasterPersistentIPs(opts.clusterName)
+	if err != nil && err != controller.ErrNotImplemented {
+		return nil, err
+	}
+	results = append(results, keto.CheckEtcdMembers(ips, opts.assets.EtcdCACert, opts.assets.EtcdCAKey, opts.timeout))
+
+	if len(opts.assets.KubeCACert) > 0 {
+		results = append(results,
+			keto.CheckCertificateExpiry("kube CA certificate", opts.assets.KubeCACert, opts.minValidity),
+			keto.CheckCertificateExpiry("etcd CA certificate", opts.assets.EtcdCACert, opts.minValidity),
+		)
+	}
+	return results, nil
+}
+
+func init() {
+	checkCmd.AddCommand(
+		checkClusterCmd,
+	)
+
+	addAssetsDirFlag(checkClusterCmd)
+	checkClusterCmd.Flags().Duration("cert-min-validity", 30*24*time.Hour, "Minimum time certificates must remain valid for")
+	checkClusterCmd.Flags().Duration("timeout", 10*time.Second, "Timeout of kube API and etcd requests")
+	checkClusterCmd.Flags().Duration("wait", 0, "Time to retry checks for until they all pass, e.g. after creating a cluster")
+}
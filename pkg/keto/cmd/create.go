@@ -24,6 +24,10 @@ import (
 	"path"
 	"strconv"
 
+	"github.com/UKHomeOffice/keto/pkg/components"
+	"github.com/UKHomeOffice/keto/pkg/constants"
+	"github.com/UKHomeOffice/keto/pkg/controller"
+	"github.com/UKHomeOffice/keto/pkg/keto"
 	"github.com/UKHomeOffice/keto/pkg/keto/util"
 	"github.com/UKHomeOffice/keto/pkg/model"
 
@@ -55,7 +59,7 @@ var createClusterCmd = &cobra.Command{
 func validateCreateFlags(c *cobra.Command, args []string) error {
 	// Check if cluster name has been set. TODO(vaijab): should controller take
 	// care of validation?
-	if c.Name() == "masterpool" || c.Name() == "computepool" {
+	if c.Name() == "masterpool" || c.Name() == "computepool" || c.Name() == "bastion" {
 		if !c.Flags().Changed("cluster") {
 			return fmt.Errorf("cluster name must be set")
 		}
@@ -80,6 +84,9 @@ func createClusterCmdFunc(c *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	if cli.ctrl.SkipPreflight, err = c.Flags().GetBool("skip-preflight"); err != nil {
+		return err
+	}
 
 	if len(args) != 1 {
 		return errors.New("cluster name is not specified")
@@ -120,6 +127,46 @@ func createClusterCmdFunc(c *cobra.Command, args []string) error {
 		return err
 	}
 	cluster.DNSZone = dnsZone
+	if cluster.DNSZoneIDs, err = c.Flags().GetStringSlice("dns-zone-ids"); err != nil {
+		return err
+	}
+	if cluster.PrivateDNSZone, err = c.Flags().GetBool("private-dns-zone"); err != nil {
+		return err
+	}
+	if cluster.SplitHorizonDNS, err = c.Flags().GetBool("split-horizon-dns"); err != nil {
+		return err
+	}
+
+	// API names are validated by the controller.
+	if cluster.APIHostname, err = c.Flags().GetString("api-hostname"); err != nil {
+		return err
+	}
+	if cluster.APIAliases, err = c.Flags().GetStringSlice("api-aliases"); err != nil {
+		return err
+	}
+	if cluster.APIExtraSANs, err = c.Flags().GetStringSlice("api-extra-sans"); err != nil {
+		return err
+	}
+
+	// Network provider and CIDRs are validated by the controller.
+	if cluster.NetworkProvider, err = c.Flags().GetString("network-provider"); err != nil {
+		return err
+	}
+	if cluster.PodCIDR, err = c.Flags().GetString("pod-cidr"); err != nil {
+		return err
+	}
+	if cluster.ServiceCIDR, err = c.Flags().GetString("service-cidr"); err != nil {
+		return err
+	}
+	if cluster.NetworkCIDR, err = c.Flags().GetString("network-cidr"); err != nil {
+		return err
+	}
+	if cluster.NetworkZones, err = c.Flags().GetInt("network-zones"); err != nil {
+		return err
+	}
+	if cluster.LoadBalancerType, err = c.Flags().GetString("load-balancer-type"); err != nil {
+		return err
+	}
 
 	labels, err := c.Flags().GetStringSlice("labels")
 	if err != nil {
@@ -127,12 +174,53 @@ func createClusterCmdFunc(c *cobra.Command, args []string) error {
 	}
 	cluster.Labels = util.KVsToStringMap(labels)
 
+	// Controller uses default components if a manifest is not given.
+	manifest, err := readComponentsFile(*c)
+	if err != nil {
+		return err
+	}
+	cluster.Components = manifest
+
+	if cluster.RegistryMirror, err = c.Flags().GetString("registry-mirror"); err != nil {
+		return err
+	}
+	if cluster.ArtifactsURL, err = c.Flags().GetString("artifacts-url"); err != nil {
+		return err
+	}
+
+	if cluster.Proxy.HTTPProxy, err = c.Flags().GetString("http-proxy"); err != nil {
+		return err
+	}
+	if cluster.Proxy.HTTPSProxy, err = c.Flags().GetString("https-proxy"); err != nil {
+		return err
+	}
+	if cluster.Proxy.NoProxy, err = c.Flags().GetStringSlice("no-proxy"); err != nil {
+		return err
+	}
+
+	if cluster.Access, err = makeAccess(*c); err != nil {
+		return err
+	}
+
+	if cluster.TemplateOverlays, err = readClusterTemplateOverlays(*c); err != nil {
+		return err
+	}
+
 	p, err := makeMasterPool("master", name, *c)
 	if err != nil {
 		return err
 	}
 	cluster.MasterPool = p
 
+	masterOverlays, err := readTemplateOverlays(*c, "master-template-overlays", "")
+	if err != nil {
+		return err
+	}
+	cluster.MasterPool.TemplateOverlays = masterOverlays
+	if cluster.MasterPool.ExtraUserData, err = readUserDataFile(*c, "master-user-data-file"); err != nil {
+		return err
+	}
+
 	// Set API server extra arguments.
 	apiServerExtraArgs, err := c.Flags().GetString("api-server-extra-args")
 	if err != nil {
@@ -161,6 +249,15 @@ func createClusterCmdFunc(c *cobra.Command, args []string) error {
 	}
 	cluster.MasterPool.KubeletExtraArgs = kubeletExtraArgs
 
+	computeOverlays, err := readTemplateOverlays(*c, "compute-template-overlays", "")
+	if err != nil {
+		return err
+	}
+	computeUserData, err := readUserDataFile(*c, "compute-user-data-file")
+	if err != nil {
+		return err
+	}
+
 	numComputePools, err := c.Flags().GetInt("compute-pools")
 	if err != nil {
 		return err
@@ -171,17 +268,32 @@ func createClusterCmdFunc(c *cobra.Command, args []string) error {
 			return err
 		}
 		p.KubeletExtraArgs = kubeletExtraArgs
+		p.TemplateOverlays = computeOverlays
+		p.ExtraUserData = computeUserData
 		cluster.ComputePools = append(cluster.ComputePools, p)
 	}
 
 	cli.logger.Printf("Creating cluster %q", cluster.Name)
 	if err := cli.ctrl.CreateCluster(cluster, a); err != nil {
-		return err
+		return printPreflightError(err)
 	}
 	cli.logger.Printf("Cluster %q successfully created", cluster.Name)
 	return nil
 }
 
+// printPreflightError prints a report of all pre-flight checks if err is a
+// pre-flight checks error. The error is returned as is.
+func printPreflightError(err error) error {
+	perr, ok := err.(*controller.PreflightError)
+	if !ok {
+		return err
+	}
+	if e := keto.PrintCheckResults(keto.GetPrinter(os.Stdout), perr.Results, true); e != nil {
+		return e
+	}
+	return err
+}
+
 // readAssetFiles reads asset files as byte arrays from the directory d and returns
 // model.Assets.
 func (c cli) readAssetFiles(d string) (model.Assets, error) {
@@ -243,6 +355,60 @@ func (c cli) readAssetFiles(d string) (model.Assets, error) {
 	return a, nil
 }
 
+// readComponentsFile reads a component manifest file if one is given.
+func readComponentsFile(c cobra.Command) (model.Components, error) {
+	f, err := c.Flags().GetString("components-file")
+	if err != nil || f == "" {
+		return model.Components{}, err
+	}
+	b, err := ioutil.ReadFile(f)
+	if err != nil {
+		return model.Components{}, err
+	}
+	return components.Read(b)
+}
+
+// readUserDataFile reads a user data file given in a flag if one is given.
+func readUserDataFile(c cobra.Command, flag string) ([]byte, error) {
+	f, err := c.Flags().GetString(flag)
+	if err != nil || f == "" {
+		return nil, err
+	}
+	return ioutil.ReadFile(f)
+}
+
+// readClusterTemplateOverlays reads cluster infra and load balancer template
+// overlay files.
+func readClusterTemplateOverlays(c cobra.Command) ([]model.TemplateOverlay, error) {
+	overlays, err := readTemplateOverlays(c, "template-overlays", constants.TemplateOverlayStackInfra)
+	if err != nil {
+		return overlays, err
+	}
+	lbOverlays, err := readTemplateOverlays(c, "lb-template-overlays", constants.TemplateOverlayStackLoadBalancer)
+	if err != nil {
+		return overlays, err
+	}
+	return append(overlays, lbOverlays...), nil
+}
+
+// readTemplateOverlays reads template overlay files given in a flag. Cluster
+// overlays are targeted at a stack, pool overlays leave it empty.
+func readTemplateOverlays(c cobra.Command, flag, stack string) ([]model.TemplateOverlay, error) {
+	overlays := []model.TemplateOverlay{}
+	files, err := c.Flags().GetStringSlice(flag)
+	if err != nil {
+		return overlays, err
+	}
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return overlays, err
+		}
+		overlays = append(overlays, model.TemplateOverlay{Name: path.Base(f), Stack: stack, Data: b})
+	}
+	return overlays, nil
+}
+
 func fileExists(f string) bool {
 	if _, err := os.Stat(f); os.IsNotExist(err) && err != nil {
 		return false
@@ -278,6 +444,14 @@ func createMasterPoolCmdFunc(c *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	if p.ExtraUserData, err = readUserDataFile(*c, "user-data-file"); err != nil {
+		return err
+	}
+	overlays, err := readTemplateOverlays(*c, "template-overlays", "")
+	if err != nil {
+		return err
+	}
+	p.TemplateOverlays = overlays
 
 	cli, err := newCLI(c)
 	if err != nil {
@@ -360,6 +534,16 @@ func makeMasterPool(name, clusterName string, c cobra.Command) (model.MasterPool
 	}
 	p.KubeletExtraArgs = kubeletExtraArgs
 
+	userDataFormat, err := c.Flags().GetString("user-data-format")
+	if err != nil {
+		return p, err
+	}
+	p.UserDataFormat = userDataFormat
+
+	if err := setLaunchTemplateOptions(&p.NodePoolSpec, c); err != nil {
+		return p, err
+	}
+
 	p.Name = name
 	p.ClusterName = clusterName
 	p.CoreOSVersion = coreOSVersion
@@ -399,14 +583,25 @@ func createComputePoolCmdFunc(c *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	if p.ExtraUserData, err = readUserDataFile(*c, "user-data-file"); err != nil {
+		return err
+	}
+	overlays, err := readTemplateOverlays(*c, "template-overlays", "")
+	if err != nil {
+		return err
+	}
+	p.TemplateOverlays = overlays
 
 	cli, err := newCLI(c)
 	if err != nil {
 		return err
 	}
+	if cli.ctrl.SkipPreflight, err = c.Flags().GetBool("skip-preflight"); err != nil {
+		return err
+	}
 	cli.logger.Printf("Creating computepool %q for cluster %q", p.Name, p.ClusterName)
 	if err := cli.ctrl.CreateComputePool(p); err != nil {
-		return err
+		return printPreflightError(err)
 	}
 	cli.logger.Printf("Masterpool %q successfully created", p.Name)
 	return nil
@@ -435,6 +630,12 @@ func makeComputePool(name, clusterName string, c cobra.Command) (model.ComputePo
 	if err != nil {
 		return p, err
 	}
+	if p.MinSize, err = c.Flags().GetInt("pool-min-size"); err != nil {
+		return p, err
+	}
+	if p.MaxSize, err = c.Flags().GetInt("pool-max-size"); err != nil {
+		return p, err
+	}
 	diskSize, err := c.Flags().GetInt("disk-size")
 	if err != nil {
 		return p, err
@@ -463,6 +664,28 @@ func makeComputePool(name, clusterName string, c cobra.Command) (model.ComputePo
 	}
 	p.KubeletExtraArgs = kubeletExtraArgs
 
+	userDataFormat, err := c.Flags().GetString("user-data-format")
+	if err != nil {
+		return p, err
+	}
+	p.UserDataFormat = userDataFormat
+
+	if p.InstancesPolicy, err = makeInstancesPolicy(c); err != nil {
+		return p, err
+	}
+
+	// Compute pools created along with a cluster don't exist yet, so they
+	// can't be allowed traffic from each other.
+	if c.Flags().Lookup("allow-from-pools") != nil {
+		if p.AllowFromPools, err = c.Flags().GetStringSlice("allow-from-pools"); err != nil {
+			return p, err
+		}
+	}
+
+	if err := setLaunchTemplateOptions(&p.NodePoolSpec, c); err != nil {
+		return p, err
+	}
+
 	p.Name = name
 	p.ClusterName = clusterName
 	p.CoreOSVersion = coreOSVersion
@@ -475,17 +698,152 @@ func makeComputePool(name, clusterName string, c cobra.Command) (model.ComputePo
 	return p, nil
 }
 
+var createBastionPoolCmd = &cobra.Command{
+	Use:          "bastion",
+	Aliases:      bastionCmdAliases,
+	Short:        "Create a bastion pool",
+	Long:         "Create a bastion pool, which provides SSH access to cluster nodes",
+	SilenceUsage: true,
+	PreRunE: func(c *cobra.Command, args []string) error {
+		return validateCreateFlags(c, args)
+	},
+	RunE: func(c *cobra.Command, args []string) error {
+		return createBastionPoolCmdFunc(c, args)
+	},
+}
+
+func createBastionPoolCmdFunc(c *cobra.Command, args []string) error {
+	p := model.BastionPool{}
+
+	var err error
+	if p.ClusterName, err = c.Flags().GetString("cluster"); err != nil {
+		return err
+	}
+	if p.CoreOSVersion, err = c.Flags().GetString("coreos-version"); err != nil {
+		return err
+	}
+	if p.SSHKey, err = c.Flags().GetString("ssh-key"); err != nil {
+		return err
+	}
+	if p.MachineType, err = c.Flags().GetString("machine-type"); err != nil {
+		return err
+	}
+	if p.DiskSize, err = c.Flags().GetInt("disk-size"); err != nil {
+		return err
+	}
+	if p.Size, err = c.Flags().GetInt("pool-size"); err != nil {
+		return err
+	}
+	if p.Networks, err = c.Flags().GetStringSlice("networks"); err != nil {
+		return err
+	}
+	if p.AllowedCIDRs, err = c.Flags().GetStringSlice("allowed-cidrs"); err != nil {
+		return err
+	}
+
+	cli, err := newCLI(c)
+	if err != nil {
+		return err
+	}
+	cli.logger.Printf("Creating bastion pool for cluster %q", p.ClusterName)
+	if err := cli.ctrl.CreateBastionPool(p); err != nil {
+		return err
+	}
+	cli.logger.Printf("Bastion pool successfully created")
+	return nil
+}
+
+// setLaunchTemplateOptions sets node pool boot disk, instance metadata
+// service and CPU credit options from flags.
+func setLaunchTemplateOptions(p *model.NodePoolSpec, c cobra.Command) error {
+	var err error
+	if p.VolumeType, err = c.Flags().GetString("volume-type"); err != nil {
+		return err
+	}
+	if p.VolumeIOPS, err = c.Flags().GetInt("volume-iops"); err != nil {
+		return err
+	}
+	if p.VolumeThroughput, err = c.Flags().GetInt("volume-throughput"); err != nil {
+		return err
+	}
+	if p.IMDSTokens, err = c.Flags().GetString("imds-tokens"); err != nil {
+		return err
+	}
+	if p.IMDSHopLimit, err = c.Flags().GetInt("imds-hop-limit"); err != nil {
+		return err
+	}
+	p.CreditSpecification, err = c.Flags().GetString("credit-specification")
+	return err
+}
+
+// makeAccess returns cluster access rules from flags. Source security groups
+// are only set if the flag is given, so that an empty list removes them.
+func makeAccess(c cobra.Command) (model.Access, error) {
+	a := model.Access{}
+
+	var err error
+	if a.SSHCIDRs, err = c.Flags().GetStringSlice("ssh-cidrs"); err != nil {
+		return a, err
+	}
+	if a.DisableSSH, err = c.Flags().GetBool("disable-ssh"); err != nil {
+		return a, err
+	}
+	if a.APICIDRs, err = c.Flags().GetStringSlice("api-cidrs"); err != nil {
+		return a, err
+	}
+	if c.Flags().Changed("source-security-groups") {
+		if a.SourceSecurityGroups, err = c.Flags().GetStringSlice("source-security-groups"); err != nil {
+			return a, err
+		}
+	}
+	return a, nil
+}
+
+func makeInstancesPolicy(c cobra.Command) (model.InstancesPolicy, error) {
+	p := model.InstancesPolicy{}
+
+	strategy, err := c.Flags().GetString("purchase-strategy")
+	if err != nil {
+		return p, err
+	}
+	machineTypes, err := c.Flags().GetStringSlice("machine-types")
+	if err != nil {
+		return p, err
+	}
+	base, err := c.Flags().GetInt("on-demand-base-capacity")
+	if err != nil {
+		return p, err
+	}
+	percentage, err := c.Flags().GetInt("on-demand-percentage")
+	if err != nil {
+		return p, err
+	}
+	spotMaxPrice, err := c.Flags().GetString("spot-max-price")
+	if err != nil {
+		return p, err
+	}
+
+	p.PurchaseStrategy = strategy
+	p.MachineTypes = machineTypes
+	p.OnDemandBaseCapacity = base
+	p.OnDemandPercentage = percentage
+	p.SpotMaxPrice = spotMaxPrice
+	return p, nil
+}
+
 func init() {
 	createCmd.AddCommand(
 		createClusterCmd,
 		createMasterPoolCmd,
 		createComputePoolCmd,
+		createBastionPoolCmd,
 	)
 
 	// Add flags that are relevant to different subcommands.
 	addClusterFlag(
 		createMasterPoolCmd,
 		createComputePoolCmd,
+		createBastionPoolCmd,
 	)
 
 	addInternalFlag(
@@ -496,30 +854,35 @@ func init() {
 		createClusterCmd,
 		createMasterPoolCmd,
 		createComputePoolCmd,
+		createBastionPoolCmd,
 	)
 
 	addCoreOSVersionFlag(
 		createClusterCmd,
 		createMasterPoolCmd,
 		createComputePoolCmd,
+		createBastionPoolCmd,
 	)
 
 	addSSHKeyFlag(
 		createClusterCmd,
 		createMasterPoolCmd,
 		createComputePoolCmd,
+		createBastionPoolCmd,
 	)
 
 	addDiskSizeFlag(
 		createClusterCmd,
 		createMasterPoolCmd,
 		createComputePoolCmd,
+		createBastionPoolCmd,
 	)
 
 	addMachineTypeFlag(
 		createClusterCmd,
 		createMasterPoolCmd,
 		createComputePoolCmd,
+		createBastionPoolCmd,
 	)
 
 	addLabelsFlag(
@@ -548,6 +911,34 @@ func init() {
 		createClusterCmd,
 	)
 
+	addBastionPoolSizeFlag(
+		createBastionPoolCmd,
+	)
+
+	addBastionAllowedCIDRsFlag(
+		createBastionPoolCmd,
+	)
+
+	addSkipPreflightFlag(
+		createClusterCmd,
+		createComputePoolCmd,
+	)
+
+	addInstancesPolicyFlags(
+		createClusterCmd,
+		createComputePoolCmd,
+	)
+
+	addAllowFromPoolsFlag(
+		createComputePoolCmd,
+	)
+
+	addLaunchTemplateFlags(
+		createClusterCmd,
+		createMasterPoolCmd,
+		createComputePoolCmd,
+	)
+
 	addAssetsDirFlag(
 		createClusterCmd,
 	)
@@ -556,6 +947,38 @@ func init() {
 		createClusterCmd,
 	)
 
+	addDNSZoneSelectionFlags(
+		createClusterCmd,
+	)
+
+	addAPINamesFlags(
+		createClusterCmd,
+	)
+
+	addNetworkFlags(
+		createClusterCmd,
+	)
+
+	addLoadBalancerTypeFlag(
+		createClusterCmd,
+	)
+
+	addComponentsFileFlag(
+		createClusterCmd,
+	)
+
+	addMirrorFlags(
+		createClusterCmd,
+	)
+
+	addProxyFlags(
+		createClusterCmd,
+	)
+
+	addAccessFlags(
+		createClusterCmd,
+	)
+
 	addKubeletExtraArgsFlag(
 		createClusterCmd,
 		createComputePoolCmd,
@@ -576,4 +999,33 @@ func init() {
 		createClusterCmd,
 		createMasterPoolCmd,
 	)
+
+	addUserDataFileFlag(
+		createMasterPoolCmd,
+		createComputePoolCmd,
+	)
+
+	addPoolUserDataFileFlags(
+		createClusterCmd,
+	)
+
+	addUserDataFormatFlag(
+		createClusterCmd,
+		createMasterPoolCmd,
+		createComputePoolCmd,
+	)
+
+	addTemplateOverlaysFlag(
+		createClusterCmd,
+		createMasterPoolCmd,
+		createComputePoolCmd,
+	)
+
+	addPoolTemplateOverlaysFlags(
+		createClusterCmd,
+	)
+
+	addLoadBalancerTemplateOverlaysFlag(
+		createClusterCmd,
+	)
 }
@@ -125,10 +125,41 @@ func deleteComputePoolCmdFunc(c *cobra.Command, args []string) error {
 	return nil
 }
 
+var deleteBastionPoolCmd = &cobra.Command{
+	Use:          "bastion",
+	Aliases:      bastionCmdAliases,
+	Short:        "Delete a bastion pool",
+	SilenceUsage: true,
+	PreRunE: func(c *cobra.Command, args []string) error {
+		return validateDeleteFlags(c, args)
+	},
+	RunE: func(c *cobra.Command, args []string) error {
+		return deleteBastionPoolCmdFunc(c, args)
+	},
+}
+
+func deleteBastionPoolCmdFunc(c *cobra.Command, args []string) error {
+	clusterName, err := c.Flags().GetString("cluster")
+	if err != nil {
+		return err
+	}
+
+	cli, err := newCLI(c)
+	if err != nil {
+		return err
+	}
+	cli.logger.Printf("Deleting bastion pool of cluster %q", clusterName)
+	if err := cli.ctrl.DeleteBastionPool(clusterName); err != nil {
+		return err
+	}
+	cli.logger.Printf("Bastion pool successfully deleted")
+	return nil
+}
+
 func validateDeleteFlags(c *cobra.Command, args []string) error {
 	// Check if cluster name has been set. TODO(vaijab): should controller take
 	// care of validation?
-	if c.Name() == "masterpool" || c.Name() == "computepool" {
+	if c.Name() == "masterpool" || c.Name() == "computepool" || c.Name() == "bastion" {
 		if !c.Flags().Changed("cluster") {
 			return fmt.Errorf("cluster name must be set")
 		}
@@ -141,11 +172,13 @@ func init() {
 		deleteClusterCmd,
 		deleteMasterPoolCmd,
 		deleteComputePoolCmd,
+		deleteBastionPoolCmd,
 	)
 
 	// Add flags that are relevant to delete subcommands.
 	addClusterFlag(
 		deleteMasterPoolCmd,
 		deleteComputePoolCmd,
+		deleteBastionPoolCmd,
 	)
 }
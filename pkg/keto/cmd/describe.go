@@ -17,6 +17,11 @@ limitations under the License.
 package cmd
 
 import (
+	"fmt"
+	"os"
+
+	"github.com/UKHomeOffice/keto/pkg/keto"
+
 	"github.com/spf13/cobra"
 )
 
@@ -32,10 +37,30 @@ var describeClusterCmd = &cobra.Command{
 	Short:        "Describe a cluster",
 	SilenceUsage: true,
 	RunE: func(c *cobra.Command, args []string) error {
-		return errNotImplemented
+		return describeClusterCmdFunc(c, args)
 	},
 }
 
+func describeClusterCmdFunc(c *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("cluster name must be set")
+	}
+
+	cli, err := newCLI(c)
+	if err != nil {
+		return err
+	}
+
+	clusters, err := cli.ctrl.GetClusters(args[0])
+	if err != nil {
+		return err
+	}
+	if len(clusters) == 0 {
+		return fmt.Errorf("cluster %q does not exist", args[0])
+	}
+	return keto.DescribeCluster(keto.GetPrinter(os.Stdout), clusters[0])
+}
+
 var describeMasterPoolCmd = &cobra.Command{
 	Use:          "masterpool <NAME>",
 	Aliases:      masterPoolCmdAliases,
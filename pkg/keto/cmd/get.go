@@ -17,6 +17,7 @@ limitations under the License.
 package cmd
 
 import (
+	"errors"
 	"os"
 
 	"github.com/UKHomeOffice/keto/pkg/keto"
@@ -105,6 +106,75 @@ func getComputePoolCmdFunc(c *cobra.Command, args []string) error {
 	return listComputePools(cli, clusterName, args...)
 }
 
+var getBastionPoolCmd = &cobra.Command{
+	Use:          "bastion",
+	Aliases:      bastionCmdAliases,
+	Short:        "Get bastion pools",
+	Long:         "Get bastion pools and addresses of their running hosts",
+	SilenceUsage: true,
+	RunE: func(c *cobra.Command, args []string) error {
+		return getBastionPoolCmdFunc(c, args)
+	},
+}
+
+func getBastionPoolCmdFunc(c *cobra.Command, args []string) error {
+	clusterName, err := c.Flags().GetString("cluster")
+	if err != nil {
+		return err
+	}
+
+	cli, err := newCLI(c)
+	if err != nil {
+		return err
+	}
+
+	pools, err := cli.ctrl.GetBastionPools(clusterName)
+	if err != nil {
+		return err
+	}
+	return keto.PrintBastionPools(keto.GetPrinter(os.Stdout), pools, true)
+}
+
+var getNodesCmd = &cobra.Command{
+	Use:          "nodes",
+	Aliases:      []string{"node", "instances"},
+	Short:        "Get nodes",
+	Long:         "Get instances of a cluster or a node pool",
+	SuggestFor:   []string{"machines"},
+	SilenceUsage: true,
+	PreRunE: func(c *cobra.Command, args []string) error {
+		if !c.Flags().Changed("cluster") {
+			return errors.New("cluster name must be set")
+		}
+		return nil
+	},
+	RunE: func(c *cobra.Command, args []string) error {
+		return getNodesCmdFunc(c, args)
+	},
+}
+
+func getNodesCmdFunc(c *cobra.Command, args []string) error {
+	clusterName, err := c.Flags().GetString("cluster")
+	if err != nil {
+		return err
+	}
+	poolName, err := c.Flags().GetString("pool")
+	if err != nil {
+		return err
+	}
+
+	cli, err := newCLI(c)
+	if err != nil {
+		return err
+	}
+
+	instances, err := cli.ctrl.GetPoolInstances(clusterName, poolName)
+	if err != nil {
+		return err
+	}
+	return keto.PrintInstances(keto.GetPrinter(os.Stdout), instances, true)
+}
+
 func listMasterPools(cli *cli, clusterName string, names ...string) error {
 	pools, err := cli.ctrl.GetMasterPools(clusterName, names...)
 	if err != nil {
@@ -134,11 +204,19 @@ func init() {
 		getClusterCmd,
 		getMasterPoolCmd,
 		getComputePoolCmd,
+		getBastionPoolCmd,
+		getNodesCmd,
 	)
 
 	// Add flags that are relevant to different subcommands.
 	addClusterFlag(
 		getMasterPoolCmd,
 		getComputePoolCmd,
+		getBastionPoolCmd,
+		getNodesCmd,
+	)
+
+	addPoolFlag(
+		getNodesCmd,
 	)
 }
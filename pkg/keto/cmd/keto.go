@@ -54,12 +54,17 @@ var (
 	clusterCmdAliases     = []string{"cl", "clusters"}
 	masterPoolCmdAliases  = []string{"mp", "master", "masters", "masterpools"}
 	computePoolCmdAliases = []string{"cp", "compute", "computes", "computepools"}
+	bastionCmdAliases     = []string{"bastions", "bastionpool", "bastionpools"}
 )
 
 // Execute adds all child commands to the root command sets flags appropriately.
 // This is called by main(). It only needs to happen once to the rootCmd.
 func Execute() {
 	if err := KetoCmd.Execute(); err != nil {
+		// Failed checks are told apart from errors running them.
+		if err == errChecksFailed {
+			os.Exit(1)
+		}
 		os.Exit(-1)
 	}
 }
@@ -101,9 +106,10 @@ func newCLI(c *cobra.Command) (*cli, error) {
 	ud := userdata.New(debugLogger)
 	ctrl := controller.New(
 		controller.Config{
-			Logger:   debugLogger,
-			Cloud:    cloud,
-			UserData: ud,
+			Logger:     debugLogger,
+			Cloud:      cloud,
+			UserData:   ud,
+			WarnLogger: log.New(os.Stderr, "warning: ", 0),
 		})
 
 	return &cli{
@@ -130,6 +136,10 @@ func init() {
 		deleteCmd,
 		describeCmd,
 		updateCmd,
+		mirrorCmd,
+		sshCmd,
+		logsCmd,
+		checkCmd,
 		versionCmd,
 	)
 }
@@ -141,6 +151,13 @@ func addClusterFlag(c ...*cobra.Command) {
 	}
 }
 
+// addPoolFlag adds a node pool flag
+func addPoolFlag(c ...*cobra.Command) {
+	for _, i := range c {
+		i.Flags().String("pool", "", "Node pool name, either a masterpool or a computepool")
+	}
+}
+
 // addInternalFlag adds an internal flag
 func addInternalFlag(c ...*cobra.Command) {
 	for _, i := range c {
@@ -183,11 +200,28 @@ func addMachineTypeFlag(c ...*cobra.Command) {
 	}
 }
 
-// addPoolSizeFlag adds a size flag
+// addPoolSizeFlag adds size, min and max size flags
 func addPoolSizeFlag(c ...*cobra.Command) {
 	for _, i := range c {
 		i.Flags().Int("pool-size", 0,
 			fmt.Sprintf("Number of nodes in the compute pool (default %d)", constants.DefaultComputePoolSize))
+		i.Flags().Int("pool-min-size", 0, "Minimum number of nodes the compute pool can be scaled down to by cluster-autoscaler")
+		i.Flags().Int("pool-max-size", 0, "Maximum number of nodes the compute pool can be scaled up to by cluster-autoscaler (default pool-size)")
+	}
+}
+
+// addBastionPoolSizeFlag adds a bastion pool size flag
+func addBastionPoolSizeFlag(c ...*cobra.Command) {
+	for _, i := range c {
+		i.Flags().Int("pool-size", 0, fmt.Sprintf("Number of bastion hosts (default %d)", constants.DefaultBastionPoolSize))
+	}
+}
+
+// addBastionAllowedCIDRsFlag adds a bastion allowed CIDRs flag
+func addBastionAllowedCIDRsFlag(c ...*cobra.Command) {
+	for _, i := range c {
+		i.Flags().StringSlice("allowed-cidrs", []string{},
+			"List of comma separated CIDRs that SSH access to bastion hosts is allowed from (default cluster SSH CIDRs)")
 	}
 }
 
@@ -198,6 +232,86 @@ func addDNSZoneFlag(c ...*cobra.Command) {
 	}
 }
 
+// addDNSZoneSelectionFlags adds DNS zone IDs, private and split-horizon DNS
+// zone flags
+func addDNSZoneSelectionFlags(c ...*cobra.Command) {
+	for _, i := range c {
+		i.Flags().StringSlice("dns-zone-ids", []string{}, "List of comma separated IDs of DNS zones named after --dns-zone to create kube API records in")
+		i.Flags().Bool("private-dns-zone", false, "Use a private DNS zone that is associated with cluster networks")
+		i.Flags().Bool("split-horizon-dns", false, "Create kube API records in both a public and a private DNS zone")
+	}
+}
+
+// addAPINamesFlags adds kube API hostname, aliases and certificate SANs flags
+func addAPINamesFlags(c ...*cobra.Command) {
+	for _, i := range c {
+		i.Flags().String("api-hostname", "", "Kube API DNS name (default kube-<cluster>.<dns-zone>)")
+		i.Flags().StringSlice("api-aliases", []string{}, "List of comma separated extra kube API DNS names in the DNS zone")
+		i.Flags().StringSlice("api-extra-sans", []string{},
+			"List of comma separated extra kube API server certificate SANs, e.g. DNS names managed outside of the DNS zone")
+	}
+}
+
+// addNetworkFlags adds network provider, CIDR and created network flags
+func addNetworkFlags(c ...*cobra.Command) {
+	for _, i := range c {
+		i.Flags().String("network-provider", constants.DefaultNetworkProvider,
+			"CNI network provider. Supported providers: "+strings.Join(constants.NetworkProviders, ", "))
+		i.Flags().String("pod-cidr", constants.DefaultPodCIDR, "Pod network CIDR")
+		i.Flags().String("service-cidr", constants.DefaultServiceCIDR, "Kubernetes services CIDR")
+		i.Flags().String("network-cidr", "",
+			fmt.Sprintf("CIDR of a network that is created for the cluster if --networks are not given (default %q)", constants.DefaultNetworkCIDR))
+		i.Flags().Int("network-zones", 0,
+			fmt.Sprintf("Number of availability zones a created network spans (default %d)", constants.DefaultNetworkZones))
+	}
+}
+
+// addLoadBalancerTypeFlag adds a kube API load balancer type flag
+func addLoadBalancerTypeFlag(c ...*cobra.Command) {
+	for _, i := range c {
+		i.Flags().String("load-balancer-type", constants.DefaultLoadBalancerType,
+			"Type of load balancer that fronts kube API. Supported types: "+strings.Join(constants.LoadBalancerTypes, ", "))
+	}
+}
+
+// addComponentsFileFlag adds a component manifest file flag
+func addComponentsFileFlag(c ...*cobra.Command) {
+	for _, i := range c {
+		i.Flags().String("components-file", "", "Path to a component manifest that pins component images and versions")
+	}
+}
+
+// addMirrorFlags adds registry mirror and artifacts URL flags
+func addMirrorFlags(c ...*cobra.Command) {
+	for _, i := range c {
+		i.Flags().String("registry-mirror", "", "Registry that component images are pulled from, e.g. registry.example.com:5000")
+		i.Flags().String("artifacts-url", "", "Base URL that component binaries are downloaded from")
+	}
+}
+
+// addProxyFlags adds HTTP(S) proxy flags
+func addProxyFlags(c ...*cobra.Command) {
+	for _, i := range c {
+		i.Flags().String("http-proxy", "", "HTTP proxy URL that nodes use for egress")
+		i.Flags().String("https-proxy", "", "HTTPS proxy URL that nodes use for egress")
+		i.Flags().StringSlice("no-proxy", []string{},
+			"List of comma separated hosts, domains and CIDRs that are not proxied, cluster API and networks are added automatically")
+	}
+}
+
+// addAccessFlags adds SSH and kube API access flags
+func addAccessFlags(c ...*cobra.Command) {
+	for _, i := range c {
+		i.Flags().StringSlice("ssh-cidrs", []string{},
+			fmt.Sprintf("List of comma separated CIDRs that SSH access to nodes is allowed from (default %s)", constants.DefaultAccessCIDR))
+		i.Flags().Bool("disable-ssh", false, "Disallow SSH access to nodes")
+		i.Flags().StringSlice("api-cidrs", []string{},
+			fmt.Sprintf("List of comma separated CIDRs that kube API access is allowed from (default %s)", constants.DefaultAccessCIDR))
+		i.Flags().StringSlice("source-security-groups", []string{},
+			"List of comma separated security groups that are allowed SSH and kube API access")
+	}
+}
+
 // addLabelsFlag adds labels flag
 func addLabelsFlag(c ...*cobra.Command) {
 	for _, i := range c {
@@ -233,6 +347,41 @@ func addComputePoolsFlag(c ...*cobra.Command) {
 	}
 }
 
+// addLaunchTemplateFlags adds node pool boot disk, instance metadata service
+// and CPU credit flags
+func addLaunchTemplateFlags(c ...*cobra.Command) {
+	for _, i := range c {
+		i.Flags().String("volume-type", "", "Node boot disk volume type, e.g. gp3 (default gp2, AWS only)")
+		i.Flags().Int("volume-iops", 0, "Node boot disk provisioned IOPS (AWS only)")
+		i.Flags().Int("volume-throughput", 0, "Node boot disk provisioned throughput in MiB/s (AWS only)")
+		i.Flags().String("imds-tokens", "", "Whether instance metadata service requires session tokens, optional or required (AWS only)")
+		i.Flags().Int("imds-hop-limit", 0, "Instance metadata service response hop limit (AWS only)")
+		i.Flags().String("credit-specification", "", "CPU credits of burstable machine types, standard or unlimited (AWS only)")
+	}
+}
+
+// addAllowFromPoolsFlag adds a compute pool allow from pools flag
+func addAllowFromPoolsFlag(c ...*cobra.Command) {
+	for _, i := range c {
+		i.Flags().StringSlice("allow-from-pools", []string{},
+			"List of comma separated computepools in the same cluster that are allowed to send traffic to the computepool")
+	}
+}
+
+// addInstancesPolicyFlags adds compute pool purchase strategy flags
+func addInstancesPolicyFlags(c ...*cobra.Command) {
+	for _, i := range c {
+		i.Flags().String("purchase-strategy", constants.DefaultPurchaseStrategy,
+			"Compute pool purchase strategy. Supported strategies: "+strings.Join(constants.PurchaseStrategies, ", "))
+		i.Flags().StringSlice("machine-types", []string{},
+			"List of comma separated machine types compute pool instances can be launched as in addition to machine-type")
+		i.Flags().Int("on-demand-base-capacity", 0, "Number of on-demand compute pool instances before spot instances are used")
+		i.Flags().Int("on-demand-percentage", 0,
+			fmt.Sprintf("Percentage of on-demand instances above base capacity in a mixed compute pool (default %d)", constants.DefaultOnDemandPercentage))
+		i.Flags().String("spot-max-price", "", "Maximum hourly price paid for a spot instance (default on-demand price)")
+	}
+}
+
 // addKubeletExtraArgsFlag adds a kubelet extra arguments flag
 func addKubeletExtraArgsFlag(c ...*cobra.Command) {
 	for _, i := range c {
@@ -260,3 +409,59 @@ func addSchedulerExtraArgsFlag(c ...*cobra.Command) {
 		i.Flags().String("scheduler-extra-args", "", "Kubernetes scheduler extra arguments")
 	}
 }
+
+// addUserDataFileFlag adds a user data file flag
+func addUserDataFileFlag(c ...*cobra.Command) {
+	for _, i := range c {
+		i.Flags().String("user-data-file", "", "Path to a cloud-config snippet that is merged into node pool user data")
+	}
+}
+
+// addPoolUserDataFileFlags adds master and compute pool user data file flags
+func addPoolUserDataFileFlags(c ...*cobra.Command) {
+	for _, i := range c {
+		i.Flags().String("master-user-data-file", "", "Path to a cloud-config snippet that is merged into masterpool user data")
+		i.Flags().String("compute-user-data-file", "", "Path to a cloud-config snippet that is merged into computepool user data")
+	}
+}
+
+// addUserDataFormatFlag adds a user data format flag
+func addUserDataFormatFlag(c ...*cobra.Command) {
+	for _, i := range c {
+		i.Flags().String("user-data-format", userdata.FormatCloudConfig,
+			"Node pool user data format. Supported formats: "+strings.Join(userdata.Formats(), ", "))
+	}
+}
+
+// addTemplateOverlaysFlag adds a template overlays flag
+func addTemplateOverlaysFlag(c ...*cobra.Command) {
+	for _, i := range c {
+		i.Flags().StringSlice("template-overlays", []string{},
+			"List of comma separated template overlay files, either JSON patch or merge fragments")
+	}
+}
+
+// addLoadBalancerTemplateOverlaysFlag adds a load balancer template overlays flag
+func addLoadBalancerTemplateOverlaysFlag(c ...*cobra.Command) {
+	for _, i := range c {
+		i.Flags().StringSlice("lb-template-overlays", []string{},
+			"List of comma separated kube API load balancer template overlay files")
+	}
+}
+
+// addSkipPreflightFlag adds a skip pre-flight checks flag
+func addSkipPreflightFlag(c ...*cobra.Command) {
+	for _, i := range c {
+		i.Flags().Bool("skip-preflight", false, "Skip pre-flight checks, e.g. when a check can't pass for an account")
+	}
+}
+
+// addPoolTemplateOverlaysFlags adds master and compute pool template overlays flags
+func addPoolTemplateOverlaysFlags(c ...*cobra.Command) {
+	for _, i := range c {
+		i.Flags().StringSlice("master-template-overlays", []string{},
+			"List of comma separated masterpool template overlay files")
+		i.Flags().StringSlice("compute-template-overlays", []string{},
+			"List of comma separated computepool template overlay files")
+	}
+}
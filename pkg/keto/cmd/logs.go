@@ -0,0 +1,135 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/UKHomeOffice/keto/pkg/keto"
+
+	"github.com/spf13/cobra"
+)
+
+// logsCmd represents the 'logs' command
+var logsCmd = &cobra.Command{
+	Use:   "logs <subcommand>",
+	Short: "Get logs of resources",
+}
+
+var logsNodeCmd = &cobra.Command{
+	Use:     "node [NODE]",
+	Aliases: []string{"nodes", "instance"},
+	Short:   "Get console output of nodes",
+	Long: "Get console output of a node, selected by its instance ID, node ID or IP, " +
+		"or of all nodes of a cluster or a pool. Lines reporting boot failures, e.g. " +
+		"of etcd-member, keto-k8 or smilodon units, are marked with \"" + keto.BootFailureMarker + "\".",
+	SilenceUsage: true,
+	PreRunE: func(c *cobra.Command, args []string) error {
+		if !c.Flags().Changed("cluster") {
+			return errors.New("cluster name must be set")
+		}
+		if len(args) > 0 && c.Flags().Changed("index") {
+			return errors.New("only one of a node and --index may be specified")
+		}
+		return nil
+	},
+	RunE: func(c *cobra.Command, args []string) error {
+		return logsNodeCmdFunc(c, args)
+	},
+}
+
+func logsNodeCmdFunc(c *cobra.Command, args []string) error {
+	clusterName, err := c.Flags().GetString("cluster")
+	if err != nil {
+		return err
+	}
+	poolName, err := c.Flags().GetString("pool")
+	if err != nil {
+		return err
+	}
+	index, err := c.Flags().GetInt("index")
+	if err != nil {
+		return err
+	}
+	if !c.Flags().Changed("index") {
+		index = -1
+	}
+	failuresOnly, err := c.Flags().GetBool("failures-only")
+	if err != nil {
+		return err
+	}
+	node := ""
+	if len(args) > 0 {
+		node = args[0]
+	}
+
+	cli, err := newCLI(c)
+	if err != nil {
+		return err
+	}
+
+	all, err := cli.ctrl.GetPoolInstances(clusterName, poolName)
+	if err != nil {
+		return err
+	}
+	instances, err := keto.SelectInstances(all, node, index)
+	if err != nil {
+		return err
+	}
+
+	failed := []string{}
+	for _, i := range instances {
+		output, err := cli.ctrl.GetInstanceConsoleOutput(i.ID)
+		if err != nil {
+			return err
+		}
+		failures := keto.FindBootFailures(output)
+		if len(failures) > 0 {
+			failed = append(failed, i.ID)
+		}
+
+		fmt.Fprintf(os.Stdout, "==> %s %s (%s) <==\n", i.PoolName, i.ID, i.PrivateIP)
+		switch {
+		case output == "":
+			fmt.Fprintln(os.Stdout, "no console output available yet")
+		case failuresOnly:
+			for _, f := range failures {
+				fmt.Fprintln(os.Stdout, f)
+			}
+		default:
+			fmt.Fprint(os.Stdout, keto.HighlightBootFailures(output))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("boot failures found on %d of %d nodes: %v", len(failed), len(instances), failed)
+	}
+	return nil
+}
+
+func init() {
+	logsCmd.AddCommand(
+		logsNodeCmd,
+	)
+
+	addClusterFlag(logsNodeCmd)
+	addPoolFlag(logsNodeCmd)
+	logsNodeCmd.Flags().Int("index", 0, "Node index in a list of cluster or pool nodes, starting at 0")
+	logsNodeCmd.Flags().Bool("failures-only", false, "Print only lines reporting boot failures")
+}
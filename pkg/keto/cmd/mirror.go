@@ -0,0 +1,146 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/UKHomeOffice/keto/pkg/components"
+	"github.com/UKHomeOffice/keto/pkg/constants"
+	"github.com/UKHomeOffice/keto/pkg/keto"
+	"github.com/UKHomeOffice/keto/pkg/model"
+
+	"github.com/spf13/cobra"
+)
+
+// mirrorCmd represents the 'mirror' command
+var mirrorCmd = &cobra.Command{
+	Use:   "mirror",
+	Short: "List or export artifacts a cluster needs",
+	Long: "List or export artifacts, i.e. images and files, that cluster nodes pull at boot. " +
+		"Artifacts of an existing cluster, a component manifest or keto defaults are listed. " +
+		"Exported tarballs can be loaded into a registry mirror and served as an artifacts base URL.",
+	SilenceUsage: true,
+	RunE: func(c *cobra.Command, args []string) error {
+		return mirrorCmdFunc(c, args)
+	},
+}
+
+func mirrorCmdFunc(c *cobra.Command, args []string) error {
+	manifest, networkProvider, kubeVersion, err := getMirrorComponents(c)
+	if err != nil {
+		return err
+	}
+	if kubeVersion == "" || c.Flags().Changed("kube-version") {
+		if kubeVersion, err = c.Flags().GetString("kube-version"); err != nil {
+			return err
+		}
+	}
+	artifacts := components.Artifacts(manifest, kubeVersion, networkProvider)
+
+	export, err := c.Flags().GetString("export")
+	if err != nil {
+		return err
+	}
+	if export == "" {
+		return keto.PrintArtifacts(keto.GetPrinter(os.Stdout), artifacts, true)
+	}
+
+	f, err := os.Create(export)
+	if err != nil {
+		return err
+	}
+	if err := components.Export(f, artifacts, components.Fetch); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// getMirrorComponents returns components, a network provider and a master
+// kube version of a cluster, or a component manifest file or keto defaults and
+// a network provider flag.
+func getMirrorComponents(c *cobra.Command) (model.Components, string, string, error) {
+	clusterName, err := c.Flags().GetString("cluster")
+	if err != nil {
+		return model.Components{}, "", "", err
+	}
+	if clusterName == "" {
+		manifest, err := readComponentsFile(*c)
+		if err != nil {
+			return manifest, "", "", err
+		}
+		components.SetDefaults(&manifest)
+		networkProvider, err := c.Flags().GetString("network-provider")
+		return manifest, networkProvider, "", err
+	}
+
+	cli, err := newCLI(c)
+	if err != nil {
+		return model.Components{}, "", "", err
+	}
+	clusters, err := cli.ctrl.GetClusters(clusterName)
+	if err != nil {
+		return model.Components{}, "", "", err
+	}
+	// GetClusters returns all clusters when none match the name.
+	var cluster *model.Cluster
+	for _, cl := range clusters {
+		if cl.Name == clusterName {
+			cluster = cl
+			break
+		}
+	}
+	if cluster == nil {
+		return model.Components{}, "", "", fmt.Errorf("cluster %q does not exist", clusterName)
+	}
+	manifest := cluster.Components
+	components.SetDefaults(&manifest)
+	networkProvider := cluster.NetworkProvider
+	if networkProvider == "" {
+		networkProvider = constants.DefaultNetworkProvider
+	}
+
+	pools, err := cli.ctrl.GetMasterPools(clusterName)
+	if err != nil {
+		return model.Components{}, "", "", err
+	}
+	kubeVersion := ""
+	if len(pools) > 0 {
+		kubeVersion = pools[0].KubeVersion
+	}
+	return manifest, networkProvider, kubeVersion, nil
+}
+
+func init() {
+	addClusterFlag(
+		mirrorCmd,
+	)
+
+	addComponentsFileFlag(
+		mirrorCmd,
+	)
+
+	addKubeVersionFlag(
+		mirrorCmd,
+	)
+
+	mirrorCmd.Flags().String("network-provider", constants.DefaultNetworkProvider,
+		"CNI network provider whose images are listed if --cluster is not given")
+	mirrorCmd.Flags().String("export", "", "Export artifacts to a given tar.gz file")
+}
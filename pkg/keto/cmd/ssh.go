@@ -0,0 +1,158 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/UKHomeOffice/keto/pkg/constants"
+	"github.com/UKHomeOffice/keto/pkg/controller"
+	"github.com/UKHomeOffice/keto/pkg/keto"
+
+	"github.com/spf13/cobra"
+)
+
+// sshCmd represents the 'ssh' command
+var sshCmd = &cobra.Command{
+	Use:   "ssh CLUSTER [--pool P] [--node N|--index i] [-- COMMAND...]",
+	Short: "SSH to cluster nodes",
+	Long: "SSH to cluster nodes. A node is selected by its instance ID, node ID or IP, " +
+		"or by its index in 'keto get nodes' output. A remote command without a node " +
+		"selector is run on every node of a cluster or a pool in turn. Nodes are " +
+		"reached through a bastion host of the cluster automatically if it has a bastion pool.",
+	SilenceUsage: true,
+	RunE: func(c *cobra.Command, args []string) error {
+		return sshCmdFunc(c, args)
+	},
+}
+
+func sshCmdFunc(c *cobra.Command, args []string) error {
+	if len(args) < 1 {
+		return errors.New("cluster name must be specified")
+	}
+	clusterName := args[0]
+
+	pool, err := c.Flags().GetString("pool")
+	if err != nil {
+		return err
+	}
+	node, err := c.Flags().GetString("node")
+	if err != nil {
+		return err
+	}
+	index, err := c.Flags().GetInt("index")
+	if err != nil {
+		return err
+	}
+	if node != "" && c.Flags().Changed("index") {
+		return errors.New("only one of --node and --index may be specified")
+	}
+	if !c.Flags().Changed("index") {
+		index = -1
+	}
+
+	t := keto.SSHTarget{Command: args[1:]}
+	if t.User, err = c.Flags().GetString("user"); err != nil {
+		return err
+	}
+	if t.IdentityFile, err = c.Flags().GetString("identity-file"); err != nil {
+		return err
+	}
+	if t.Bastion, err = c.Flags().GetString("bastion"); err != nil {
+		return err
+	}
+	sshArgs, err := c.Flags().GetString("ssh-args")
+	if err != nil {
+		return err
+	}
+	t.Options = strings.Fields(sshArgs)
+
+	cli, err := newCLI(c)
+	if err != nil {
+		return err
+	}
+
+	all, err := cli.ctrl.GetPoolInstances(clusterName, pool)
+	if err != nil {
+		return err
+	}
+	instances, err := keto.SelectInstances(all, node, index)
+	if err != nil {
+		return err
+	}
+	if len(instances) > 1 && len(t.Command) == 0 {
+		return fmt.Errorf("%d nodes match, select one with --node or --index", len(instances))
+	}
+
+	// Providers without bastion pools reach nodes directly.
+	if t.Bastion == "" {
+		pools, err := cli.ctrl.GetBastionPools(clusterName)
+		if err != nil && err != controller.ErrNotImplemented {
+			return err
+		}
+		if len(pools) > 0 {
+			if len(pools[0].Hosts) == 0 {
+				return fmt.Errorf("bastion pool of cluster %q has no running hosts", clusterName)
+			}
+			t.Bastion = pools[0].Hosts[0]
+		}
+	}
+
+	failed := 0
+	for _, i := range instances {
+		t.Host = keto.InstanceAddress(i, t.Bastion != "")
+		if len(instances) > 1 {
+			cli.logger.Printf("==> %s %s (%s)", i.PoolName, i.ID, t.Host)
+		}
+		if err := runSSH(cli, t); err != nil {
+			if len(instances) == 1 {
+				return err
+			}
+			cli.logger.Printf("ssh to %s failed: %v", i.ID, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("ssh failed on %d of %d nodes", failed, len(instances))
+	}
+	return nil
+}
+
+// runSSH runs ssh to a target with the standard streams attached.
+func runSSH(cli *cli, t keto.SSHTarget) error {
+	sshArgs := keto.MakeSSHArgs(t)
+	cli.debugLogger.Printf("running ssh %s", strings.Join(sshArgs, " "))
+	cmd := exec.Command("ssh", sshArgs...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func init() {
+	addPoolFlag(sshCmd)
+	sshCmd.Flags().String("node", "", "Node instance ID, node ID or IP")
+	sshCmd.Flags().Int("index", 0, "Node index in a list of cluster or pool nodes, starting at 0")
+	sshCmd.Flags().String("user", constants.DefaultSSHUser, "User to log in to nodes and bastion hosts as")
+	sshCmd.Flags().StringP("identity-file", "i", "", "Private key file used for nodes and bastion hosts")
+	sshCmd.Flags().String("bastion", "", "Bastion host to reach nodes through, as host or user@host. Defaults to a host of the cluster bastion pool")
+	sshCmd.Flags().String("ssh-args", "", "Extra ssh arguments, e.g. \"-A -L 8080:localhost:8080\"")
+}
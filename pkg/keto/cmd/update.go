@@ -17,6 +17,12 @@ limitations under the License.
 package cmd
 
 import (
+	"errors"
+	"fmt"
+
+	"github.com/UKHomeOffice/keto/pkg/keto/util"
+	"github.com/UKHomeOffice/keto/pkg/model"
+
 	"github.com/spf13/cobra"
 )
 
@@ -30,36 +36,341 @@ var updateClusterCmd = &cobra.Command{
 	Use:          "cluster <NAME>",
 	Aliases:      clusterCmdAliases,
 	Short:        "Update a cluster",
+	Long:         "Update cluster infra in place, e.g. to change SSH and kube API access rules. Access rules that are not given are kept.",
 	SilenceUsage: true,
 	RunE: func(c *cobra.Command, args []string) error {
-		return errNotImplemented
+		return updateClusterCmdFunc(c, args)
 	},
 }
 
+func updateClusterCmdFunc(c *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return errors.New("cluster name is not specified")
+	}
+
+	cluster := model.Cluster{}
+	cluster.Name = args[0]
+
+	var err error
+	if cluster.Access, err = makeAccess(*c); err != nil {
+		return err
+	}
+	if cluster.TemplateOverlays, err = readClusterTemplateOverlays(*c); err != nil {
+		return err
+	}
+
+	cli, err := newCLI(c)
+	if err != nil {
+		return err
+	}
+	cli.logger.Printf("Updating cluster %q", cluster.Name)
+	if err := cli.ctrl.UpdateCluster(cluster); err != nil {
+		return err
+	}
+	cli.logger.Printf("Cluster %q successfully updated", cluster.Name)
+	return nil
+}
+
 var updateMasterPoolCmd = &cobra.Command{
-	Use:          "masterpool <NAME>",
+	Use:          "masterpool",
 	Aliases:      masterPoolCmdAliases,
 	Short:        "Update a masterpool",
+	Long:         "Update a masterpool in place. Settings that are not given are kept, stacks that use launch configurations are migrated to launch templates.",
 	SilenceUsage: true,
+	PreRunE: func(c *cobra.Command, args []string) error {
+		return validateUpdateFlags(c, args)
+	},
 	RunE: func(c *cobra.Command, args []string) error {
-		return errNotImplemented
+		return updateMasterPoolCmdFunc(c, args)
 	},
 }
 
+func updateMasterPoolCmdFunc(c *cobra.Command, args []string) error {
+	clusterName, err := c.Flags().GetString("cluster")
+	if err != nil {
+		return err
+	}
+	np, err := makeNodePoolUpdate(*c)
+	if err != nil {
+		return err
+	}
+	p := model.MasterPool{NodePool: np}
+	p.ClusterName = clusterName
+
+	if p.APIServerExtraArgs, err = changedString(*c, "api-server-extra-args"); err != nil {
+		return err
+	}
+	if p.ControllerManagerExtraArgs, err = changedString(*c, "controller-manager-extra-args"); err != nil {
+		return err
+	}
+	if p.SchedulerExtraArgs, err = changedString(*c, "scheduler-extra-args"); err != nil {
+		return err
+	}
+
+	cli, err := newCLI(c)
+	if err != nil {
+		return err
+	}
+	cli.logger.Printf("Updating masterpool of cluster %q", clusterName)
+	if err := cli.ctrl.UpgradeMasterPool(p); err != nil {
+		return err
+	}
+	cli.logger.Printf("Masterpool successfully updated")
+	return nil
+}
+
 var updateComputePoolCmd = &cobra.Command{
 	Use:          "computepool <NAME>",
 	Aliases:      computePoolCmdAliases,
 	Short:        "Update a computepool",
+	Long:         "Update a computepool in place. Settings that are not given are kept, stacks that use launch configurations are migrated to launch templates.",
 	SilenceUsage: true,
+	PreRunE: func(c *cobra.Command, args []string) error {
+		return validateUpdateFlags(c, args)
+	},
 	RunE: func(c *cobra.Command, args []string) error {
-		return errNotImplemented
+		return updateComputePoolCmdFunc(c, args)
 	},
 }
 
+func updateComputePoolCmdFunc(c *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return errors.New("computepool name is not specified")
+	}
+
+	clusterName, err := c.Flags().GetString("cluster")
+	if err != nil {
+		return err
+	}
+	np, err := makeNodePoolUpdate(*c)
+	if err != nil {
+		return err
+	}
+	p := model.ComputePool{NodePool: np}
+	p.Name = args[0]
+	p.ClusterName = clusterName
+
+	if c.Flags().Changed("pool-size") {
+		if p.Size, err = c.Flags().GetInt("pool-size"); err != nil {
+			return err
+		}
+	}
+	if c.Flags().Changed("networks") {
+		if p.Networks, err = c.Flags().GetStringSlice("networks"); err != nil {
+			return err
+		}
+	}
+	if c.Flags().Changed("purchase-strategy") {
+		if p.InstancesPolicy, err = makeInstancesPolicy(*c); err != nil {
+			return err
+		}
+	}
+	if c.Flags().Changed("allow-from-pools") {
+		if p.AllowFromPools, err = c.Flags().GetStringSlice("allow-from-pools"); err != nil {
+			return err
+		}
+	}
+
+	cli, err := newCLI(c)
+	if err != nil {
+		return err
+	}
+	cli.logger.Printf("Updating computepool %q of cluster %q", p.Name, clusterName)
+	if err := cli.ctrl.UpgradeComputePool(p); err != nil {
+		return err
+	}
+	cli.logger.Printf("Computepool %q successfully updated", p.Name)
+	return nil
+}
+
+func validateUpdateFlags(c *cobra.Command, args []string) error {
+	if !c.Flags().Changed("cluster") {
+		return fmt.Errorf("cluster name must be set")
+	}
+	return nil
+}
+
+// makeNodePoolUpdate returns a node pool with only values of flags that were
+// set, so the rest are kept from an existing node pool.
+func makeNodePoolUpdate(c cobra.Command) (model.NodePool, error) {
+	p := model.NodePool{}
+
+	var err error
+	if p.KubeVersion, err = changedString(c, "kube-version"); err != nil {
+		return p, err
+	}
+	if p.CoreOSVersion, err = changedString(c, "coreos-version"); err != nil {
+		return p, err
+	}
+	if p.MachineType, err = changedString(c, "machine-type"); err != nil {
+		return p, err
+	}
+	if p.SSHKey, err = changedString(c, "ssh-key"); err != nil {
+		return p, err
+	}
+	if p.KubeletExtraArgs, err = changedString(c, "kubelet-extra-args"); err != nil {
+		return p, err
+	}
+	if p.UserDataFormat, err = changedString(c, "user-data-format"); err != nil {
+		return p, err
+	}
+	if p.DiskSize, err = c.Flags().GetInt("disk-size"); err != nil {
+		return p, err
+	}
+	if p.MinSizeSet = c.Flags().Changed("pool-min-size"); p.MinSizeSet {
+		if p.MinSize, err = c.Flags().GetInt("pool-min-size"); err != nil {
+			return p, err
+		}
+	}
+	if p.MaxSizeSet = c.Flags().Changed("pool-max-size"); p.MaxSizeSet {
+		if p.MaxSize, err = c.Flags().GetInt("pool-max-size"); err != nil {
+			return p, err
+		}
+	}
+	if c.Flags().Changed("labels") {
+		labels, err := c.Flags().GetStringSlice("labels")
+		if err != nil {
+			return p, err
+		}
+		p.Labels = util.KVsToStringMap(labels)
+	}
+	if c.Flags().Changed("taints") {
+		taints, err := c.Flags().GetStringSlice("taints")
+		if err != nil {
+			return p, err
+		}
+		p.Taints = util.KVsToStringMap(taints)
+	}
+	if p.ExtraUserData, err = readUserDataFile(c, "user-data-file"); err != nil {
+		return p, err
+	}
+	if p.TemplateOverlays, err = readTemplateOverlays(c, "template-overlays", ""); err != nil {
+		return p, err
+	}
+	if err := setLaunchTemplateOptions(&p.NodePoolSpec, c); err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+// changedString returns a string flag value if the flag was set, otherwise an
+// empty string is returned.
+func changedString(c cobra.Command, name string) (string, error) {
+	if !c.Flags().Changed(name) {
+		return "", nil
+	}
+	return c.Flags().GetString(name)
+}
+
 func init() {
 	updateCmd.AddCommand(
 		updateClusterCmd,
 		updateMasterPoolCmd,
 		updateComputePoolCmd,
 	)
+
+	addClusterFlag(
+		updateMasterPoolCmd,
+		updateComputePoolCmd,
+	)
+
+	addAccessFlags(
+		updateClusterCmd,
+	)
+
+	addTemplateOverlaysFlag(
+		updateClusterCmd,
+	)
+
+	addLoadBalancerTemplateOverlaysFlag(
+		updateClusterCmd,
+	)
+
+	addNetworksFlag(
+		updateComputePoolCmd,
+	)
+
+	addCoreOSVersionFlag(
+		updateMasterPoolCmd,
+		updateComputePoolCmd,
+	)
+
+	addSSHKeyFlag(
+		updateMasterPoolCmd,
+		updateComputePoolCmd,
+	)
+
+	addDiskSizeFlag(
+		updateMasterPoolCmd,
+		updateComputePoolCmd,
+	)
+
+	addMachineTypeFlag(
+		updateMasterPoolCmd,
+		updateComputePoolCmd,
+	)
+
+	addLabelsFlag(
+		updateMasterPoolCmd,
+		updateComputePoolCmd,
+	)
+
+	addTaintsFlag(
+		updateMasterPoolCmd,
+		updateComputePoolCmd,
+	)
+
+	addKubeVersionFlag(
+		updateMasterPoolCmd,
+		updateComputePoolCmd,
+	)
+
+	addPoolSizeFlag(
+		updateComputePoolCmd,
+	)
+
+	addInstancesPolicyFlags(
+		updateComputePoolCmd,
+	)
+
+	addAllowFromPoolsFlag(
+		updateComputePoolCmd,
+	)
+
+	addLaunchTemplateFlags(
+		updateMasterPoolCmd,
+		updateComputePoolCmd,
+	)
+
+	addKubeletExtraArgsFlag(
+		updateMasterPoolCmd,
+		updateComputePoolCmd,
+	)
+
+	addAPIServerExtraArgsFlag(
+		updateMasterPoolCmd,
+	)
+
+	addControllerManagerExtraArgsFlag(
+		updateMasterPoolCmd,
+	)
+
+	addSchedulerExtraArgsFlag(
+		updateMasterPoolCmd,
+	)
+
+	addUserDataFileFlag(
+		updateMasterPoolCmd,
+		updateComputePoolCmd,
+	)
+
+	addUserDataFormatFlag(
+		updateMasterPoolCmd,
+		updateComputePoolCmd,
+	)
+
+	addTemplateOverlaysFlag(
+		updateMasterPoolCmd,
+		updateComputePoolCmd,
+	)
 }
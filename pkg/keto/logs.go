@@ -0,0 +1,105 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keto
+
+import (
+	"regexp"
+	"strings"
+)
+
+// BootFailureMarker prefixes boot failure lines of highlighted console output.
+const BootFailureMarker = ">>> "
+
+var (
+	// bootUnits are units and tools that bootstrap cluster nodes.
+	bootUnits = []string{
+		"coreos-cloudinit",
+		"cloud-config",
+		"ignition",
+		"etcd-member",
+		"keto-k8",
+		"keto-tokens",
+		"smilodon",
+	}
+	// failureWords mark lines of boot units that report a failure.
+	failureWords = []string{
+		"fail",
+		"error",
+		"timed out",
+		"dependency",
+	}
+	// ansiEscapes matches terminal colour codes systemd writes to consoles.
+	ansiEscapes = regexp.MustCompile("\x1b\\[[0-9;]*[a-zA-Z]")
+)
+
+// isBootFailure returns whether a console output line reports a failure of a
+// systemd unit or a failure of a unit bootstrapping a node.
+func isBootFailure(line string) bool {
+	if strings.Contains(line, "[FAILED]") {
+		return true
+	}
+	l := strings.ToLower(line)
+	for _, u := range bootUnits {
+		if !strings.Contains(l, u) {
+			continue
+		}
+		for _, w := range failureWords {
+			if strings.Contains(l, w) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// splitConsoleOutput splits console output into lines without colour codes
+// and carriage returns.
+func splitConsoleOutput(output string) []string {
+	output = ansiEscapes.ReplaceAllString(output, "")
+	output = strings.Replace(output, "\r", "", -1)
+	return strings.Split(strings.TrimRight(output, "\n"), "\n")
+}
+
+// FindBootFailures returns lines of node console output that report boot
+// failures, e.g. of etcd-member, keto-k8 or smilodon units.
+func FindBootFailures(output string) []string {
+	failures := []string{}
+	if output == "" {
+		return failures
+	}
+	for _, l := range splitConsoleOutput(output) {
+		if isBootFailure(l) {
+			failures = append(failures, l)
+		}
+	}
+	return failures
+}
+
+// HighlightBootFailures returns node console output with boot failure lines
+// prefixed with BootFailureMarker.
+func HighlightBootFailures(output string) string {
+	if output == "" {
+		return output
+	}
+	lines := splitConsoleOutput(output)
+	for i, l := range lines {
+		if isBootFailure(l) {
+			lines[i] = BootFailureMarker + l
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keto
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testConsoleOutput = "[    1.0] systemd[1]: Started Journal Service.\r\n" +
+	"[\x1b[0;1;31mFAILED\x1b[0m] Failed to start etcd (System Application Container).\r\n" +
+	"[    9.1] systemd[1]: etcd-member.service: Main process exited, code=exited, status=1/FAILURE\r\n" +
+	"[    9.2] smilodon[812]: attached network interface eni-0\r\n" +
+	"[    9.3] keto-k8[901]: error: unable to reach etcd\r\n" +
+	"[  OK  ] Started Docker Application Container Engine.\r\n"
+
+func TestFindBootFailures(t *testing.T) {
+	want := []string{
+		"[FAILED] Failed to start etcd (System Application Container).",
+		"[    9.1] systemd[1]: etcd-member.service: Main process exited, code=exited, status=1/FAILURE",
+		"[    9.3] keto-k8[901]: error: unable to reach etcd",
+	}
+	if got := FindBootFailures(testConsoleOutput); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	if got := FindBootFailures(""); len(got) != 0 {
+		t.Errorf("got %q; want no failures", got)
+	}
+}
+
+func TestHighlightBootFailures(t *testing.T) {
+	want := "[    1.0] systemd[1]: Started Journal Service.\n" +
+		">>> [FAILED] Failed to start etcd (System Application Container).\n" +
+		">>> [    9.1] systemd[1]: etcd-member.service: Main process exited, code=exited, status=1/FAILURE\n" +
+		"[    9.2] smilodon[812]: attached network interface eni-0\n" +
+		">>> [    9.3] keto-k8[901]: error: unable to reach etcd\n" +
+		"[  OK  ] Started Docker Application Container Engine.\n"
+	if got := HighlightBootFailures(testConsoleOutput); got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
@@ -19,9 +19,13 @@ package keto
 import (
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
 	"text/tabwriter"
+	"time"
 
+	"github.com/UKHomeOffice/keto/pkg/components"
+	"github.com/UKHomeOffice/keto/pkg/constants"
 	"github.com/UKHomeOffice/keto/pkg/keto/util"
 	"github.com/UKHomeOffice/keto/pkg/model"
 )
@@ -35,8 +39,13 @@ const (
 )
 
 var (
-	clusterColumns  = []string{"NAME", "LABELS"}
-	nodePoolColumns = []string{"NAME", "CLUSTER", "KUBEVERSION", "OSVERSION", "MACHINETYPE", "LABELS"}
+	clusterColumns     = []string{"NAME", "LABELS"}
+	nodePoolColumns    = []string{"NAME", "CLUSTER", "KUBEVERSION", "OSVERSION", "MACHINETYPE", "LABELS"}
+	computePoolColumns = []string{"NAME", "CLUSTER", "KUBEVERSION", "OSVERSION", "MACHINETYPE", "SIZE", "STRATEGY", "LABELS"}
+	bastionPoolColumns = []string{"NAME", "CLUSTER", "OSVERSION", "MACHINETYPE", "SIZE", "HOSTS"}
+	artifactColumns    = []string{"NAME", "TYPE", "SOURCE", "MD5SUM"}
+	instanceColumns    = []string{"ID", "CLUSTER", "POOL", "ZONE", "PRIVATEIP", "PUBLICIP", "LAUNCHED", "LIFECYCLE", "HEALTH", "IMAGE", "ENI", "NODEID"}
+	checkColumns       = []string{"CHECK", "RESULT", "MESSAGE"}
 )
 
 // GetPrinter configures a new tabwriter Writer and returns it.
@@ -59,6 +68,33 @@ func PrintClusters(w *tabwriter.Writer, clusters []*model.Cluster, headers bool)
 	return w.Flush()
 }
 
+// DescribeCluster writes a detailed description of a cluster to w,
+// including its component manifest.
+func DescribeCluster(w *tabwriter.Writer, c *model.Cluster) error {
+	data := [][]string{
+		{"Name:", c.Name},
+		{"Labels:", util.StringMapToKVs(c.Labels)},
+		{"Internal:", fmt.Sprintf("%t", c.Internal)},
+		{"Network Provider:", c.NetworkProvider},
+		{"Pod CIDR:", c.PodCIDR},
+		{"Service CIDR:", c.ServiceCIDR},
+		{"Registry Mirror:", c.RegistryMirror},
+		{"Artifacts URL:", c.ArtifactsURL},
+		{"HTTP Proxy:", c.Proxy.HTTPProxy},
+		{"HTTPS Proxy:", c.Proxy.HTTPSProxy},
+		{"No Proxy:", strings.Join(c.Proxy.NoProxy, ",")},
+		{"Components:", ""},
+		{"  Version:", c.Components.Version},
+		{"  keto-k8:", c.Components.KetoK8Image},
+		{"  keto-tokens:", c.Components.KetoTokensImage},
+		{"  etcd:", c.Components.EtcdImageTag},
+		{"  smilodon:", c.Components.SmilodonURL},
+		{"  smilodon md5sum:", c.Components.SmilodonMD5Sum},
+	}
+	fmt.Fprintln(w, formatData(data))
+	return w.Flush()
+}
+
 // PrintMasterPool formats a slice of master pools into [][]string format with
 // optional headers and calls writeToPrinter to write to w.
 func PrintMasterPool(w *tabwriter.Writer, pools []*model.MasterPool, headers bool) error {
@@ -79,16 +115,118 @@ func PrintMasterPool(w *tabwriter.Writer, pools []*model.MasterPool, headers boo
 func PrintComputePool(w *tabwriter.Writer, pools []*model.ComputePool, headers bool) error {
 	data := [][]string{}
 	if headers {
-		data = append(data, nodePoolColumns)
+		data = append(data, computePoolColumns)
 	}
 	for _, p := range pools {
 		labels := util.StringMapToKVs(p.Labels)
-		data = append(data, []string{p.Name, p.ClusterName, p.KubeVersion, p.CoreOSVersion, p.MachineType, labels})
+		machineTypes := strings.Join(append([]string{p.MachineType}, p.MachineTypes...), ",")
+		data = append(data, []string{p.Name, p.ClusterName, p.KubeVersion, p.CoreOSVersion, machineTypes, formatPoolSize(p.NodePoolSpec), formatInstancesPolicy(p.InstancesPolicy), labels})
+	}
+	fmt.Fprintln(w, formatData(data))
+	return w.Flush()
+}
+
+// PrintBastionPools formats a slice of bastion pools into [][]string format
+// with optional headers and calls writeToPrinter to write to w.
+func PrintBastionPools(w *tabwriter.Writer, pools []*model.BastionPool, headers bool) error {
+	data := [][]string{}
+	if headers {
+		data = append(data, bastionPoolColumns)
+	}
+	for _, p := range pools {
+		data = append(data, []string{p.Name, p.ClusterName, p.CoreOSVersion, p.MachineType, strconv.Itoa(p.Size), strings.Join(p.Hosts, ",")})
+	}
+	fmt.Fprintln(w, formatData(data))
+	return w.Flush()
+}
+
+// PrintInstances formats a slice of node pool instances into [][]string
+// format with optional headers and calls writeToPrinter to write to w.
+func PrintInstances(w *tabwriter.Writer, instances []*model.Instance, headers bool) error {
+	data := [][]string{}
+	if headers {
+		data = append(data, instanceColumns)
+	}
+	for _, i := range instances {
+		data = append(data, []string{i.ID, i.ClusterName, i.PoolName, i.Zone, i.PrivateIP, i.PublicIP,
+			formatTime(i.Launched), i.LifecycleState, i.HealthStatus, i.ImageID, i.NetworkInterface, i.NodeID})
+	}
+	fmt.Fprintln(w, formatData(data))
+	return w.Flush()
+}
+
+// PrintCheckResults formats a slice of check results into [][]string format
+// with optional headers and calls writeToPrinter to write to w.
+func PrintCheckResults(w *tabwriter.Writer, results []model.CheckResult, headers bool) error {
+	data := [][]string{}
+	if headers {
+		data = append(data, checkColumns)
+	}
+	for _, r := range results {
+		result := "FAIL"
+		switch {
+		case r.Passed && r.Warning:
+			result = "WARN"
+		case r.Passed:
+			result = "PASS"
+		}
+		data = append(data, []string{r.Name, result, r.Message})
+	}
+	fmt.Fprintln(w, formatData(data))
+	return w.Flush()
+}
+
+// PrintArtifacts formats a slice of artifacts into [][]string format with
+// optional headers and calls writeToPrinter to write to w.
+func PrintArtifacts(w *tabwriter.Writer, artifacts []components.Artifact, headers bool) error {
+	data := [][]string{}
+	if headers {
+		data = append(data, artifactColumns)
+	}
+	for _, a := range artifacts {
+		data = append(data, []string{a.Name, a.Type, a.Source, a.MD5Sum})
 	}
 	fmt.Fprintln(w, formatData(data))
 	return w.Flush()
 }
 
+// formatPoolSize formats a node pool size. Autoscaling pools include their
+// min and max sizes, e.g. "3(1-10)".
+func formatPoolSize(p model.NodePoolSpec) string {
+	if p.MinSize == p.MaxSize {
+		return strconv.Itoa(p.Size)
+	}
+	return fmt.Sprintf("%d(%d-%d)", p.Size, p.MinSize, p.MaxSize)
+}
+
+// formatInstancesPolicy formats a compute pool purchase strategy, e.g.
+// "mixed(base=1,on-demand=50%)".
+func formatInstancesPolicy(p model.InstancesPolicy) string {
+	switch p.PurchaseStrategy {
+	case "":
+		return constants.DefaultPurchaseStrategy
+	case constants.PurchaseStrategyOnDemand:
+		return p.PurchaseStrategy
+	}
+	opts := []string{fmt.Sprintf("base=%d", p.OnDemandBaseCapacity)}
+	if p.PurchaseStrategy == constants.PurchaseStrategyMixed {
+		opts = append(opts, fmt.Sprintf("on-demand=%d%%", p.OnDemandPercentage))
+	}
+	if p.SpotMaxPrice != "" {
+		opts = append(opts, "max-price="+p.SpotMaxPrice)
+	}
+	return fmt.Sprintf("%s(%s)", p.PurchaseStrategy, strings.Join(opts, ","))
+}
+
+// formatTime formats seconds since the epoch as an RFC 3339 UTC time. Zero
+// time is formatted as an empty string.
+func formatTime(sec int64) string {
+	if sec == 0 {
+		return ""
+	}
+	return time.Unix(sec, 0).UTC().Format(time.RFC3339)
+}
+
 // formatData formats data of slices of string slices ready for tabwriter.
 func formatData(data [][]string) string {
 	rows := []string{}
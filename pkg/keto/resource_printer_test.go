@@ -17,8 +17,11 @@ limitations under the License.
 package keto
 
 import (
+	"bytes"
 	"strings"
 	"testing"
+
+	"github.com/UKHomeOffice/keto/pkg/model"
 )
 
 func TestFormatData(t *testing.T) {
@@ -54,3 +57,58 @@ func TestFormatData(t *testing.T) {
 		})
 	}
 }
+
+func TestDescribeCluster(t *testing.T) {
+	c := &model.Cluster{
+		ResourceMeta: model.ResourceMeta{Name: "foo"},
+		Components:   model.Components{Version: "1", KetoK8Image: "keto-k8:v1"},
+	}
+	var b bytes.Buffer
+	if err := DescribeCluster(GetPrinter(&b), c); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"foo", "keto-k8:v1", "Version:"} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("expected %q in cluster description, got %q", s, b.String())
+		}
+	}
+}
+
+func TestPrintCheckResults(t *testing.T) {
+	results := []model.CheckResult{
+		{Name: "stacks", Passed: true},
+		{Name: "quotas", Passed: true, Warning: true},
+		{Name: "free ips"},
+	}
+	var b bytes.Buffer
+	if err := PrintCheckResults(GetPrinter(&b), results, false); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	for i, want := range []string{"PASS", "WARN", "FAIL"} {
+		if i >= len(lines) || !strings.Contains(lines[i], want) {
+			t.Errorf("expected %q in line %d of %q", want, i, b.String())
+		}
+	}
+}
+
+func TestFormatInstancesPolicy(t *testing.T) {
+	testCases := []struct {
+		input model.InstancesPolicy
+		want  string
+	}{
+		{model.InstancesPolicy{}, "on-demand"},
+		{model.InstancesPolicy{PurchaseStrategy: "on-demand", OnDemandPercentage: 100}, "on-demand"},
+		{model.InstancesPolicy{PurchaseStrategy: "spot", OnDemandBaseCapacity: 1}, "spot(base=1)"},
+		{
+			model.InstancesPolicy{PurchaseStrategy: "mixed", OnDemandPercentage: 25, SpotMaxPrice: "0.1"},
+			"mixed(base=0,on-demand=25%,max-price=0.1)",
+		},
+	}
+
+	for _, tc := range testCases {
+		if got := formatInstancesPolicy(tc.input); got != tc.want {
+			t.Errorf("got %q; want %q", got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keto
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/UKHomeOffice/keto/pkg/model"
+)
+
+// SSHTarget describes how a node is reached over SSH.
+type SSHTarget struct {
+	// User is a user to log in as, both to the node and the bastion host.
+	User string
+	// IdentityFile is an optional private key file.
+	IdentityFile string
+	// Host is a node address.
+	Host string
+	// Bastion is an optional bastion host address the node is reached
+	// through. It may include its own user as in "user@host".
+	Bastion string
+	// Options are extra ssh arguments passed before the destination.
+	Options []string
+	// Command is an optional remote command.
+	Command []string
+}
+
+// MakeSSHArgs returns ssh command arguments for a target. Nodes behind a
+// bastion host are reached through a proxy command, so that the identity file
+// is used for both hops.
+func MakeSSHArgs(t SSHTarget) []string {
+	args := []string{}
+	if t.IdentityFile != "" {
+		args = append(args, "-i", t.IdentityFile)
+	}
+	if t.Bastion != "" {
+		proxy := []string{"ssh"}
+		if t.IdentityFile != "" {
+			proxy = append(proxy, "-i", t.IdentityFile)
+		}
+		bastion := t.Bastion
+		if !strings.Contains(bastion, "@") {
+			bastion = fmt.Sprintf("%s@%s", t.User, bastion)
+		}
+		proxy = append(proxy, "-W", "%h:%p", bastion)
+		args = append(args, "-o", "ProxyCommand="+strings.Join(proxy, " "))
+	}
+	args = append(args, t.Options...)
+	args = append(args, fmt.Sprintf("%s@%s", t.User, t.Host))
+	return append(args, t.Command...)
+}
+
+// SelectInstances returns instances matching a node selector. A node is
+// matched by its instance ID, NodeID or one of its IPs. An index selects an
+// instance by its position in the list, as listed by 'keto get nodes', unless
+// it's negative. Only instances that have an address are returned, without a
+// selector all of them.
+func SelectInstances(instances []*model.Instance, node string, index int) ([]*model.Instance, error) {
+	if index >= 0 {
+		if index >= len(instances) {
+			return []*model.Instance{}, fmt.Errorf("node index %d out of range, there are %d nodes", index, len(instances))
+		}
+		if instances[index].PrivateIP == "" {
+			return []*model.Instance{}, fmt.Errorf("node %d (%s) has no address, it may not be running", index, instances[index].ID)
+		}
+		return []*model.Instance{instances[index]}, nil
+	}
+
+	reachable := []*model.Instance{}
+	for _, i := range instances {
+		if i.PrivateIP != "" {
+			reachable = append(reachable, i)
+		}
+	}
+	if len(reachable) == 0 {
+		return reachable, errors.New("no running nodes found")
+	}
+
+	if node != "" {
+		for _, i := range reachable {
+			if node == i.ID || node == i.NodeID || node == i.PrivateIP || node == i.PublicIP {
+				return []*model.Instance{i}, nil
+			}
+		}
+		return []*model.Instance{}, fmt.Errorf("node %q not found", node)
+	}
+	return reachable, nil
+}
+
+// InstanceAddress returns an address an instance is reached at over SSH.
+// Instances reached through a bastion host, internal instances and instances
+// without a public IP are reached at their private IP.
+func InstanceAddress(i *model.Instance, viaBastion bool) string {
+	if viaBastion || i.Internal || i.PublicIP == "" {
+		return i.PrivateIP
+	}
+	return i.PublicIP
+}
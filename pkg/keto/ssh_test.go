@@ -0,0 +1,144 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keto
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/UKHomeOffice/keto/pkg/model"
+)
+
+func TestMakeSSHArgs(t *testing.T) {
+	testCases := []struct {
+		name   string
+		target SSHTarget
+		want   []string
+	}{
+		{
+			"direct",
+			SSHTarget{User: "core", Host: "10.0.0.1"},
+			[]string{"core@10.0.0.1"},
+		},
+		{
+			"command",
+			SSHTarget{User: "core", Host: "10.0.0.1", Command: []string{"uptime"}},
+			[]string{"core@10.0.0.1", "uptime"},
+		},
+		{
+			"bastion",
+			SSHTarget{User: "core", Host: "10.0.0.1", Bastion: "1.2.3.4"},
+			[]string{"-o", "ProxyCommand=ssh -W %h:%p core@1.2.3.4", "core@10.0.0.1"},
+		},
+		{
+			"bastion with identity file",
+			SSHTarget{User: "core", IdentityFile: "key.pem", Host: "10.0.0.1", Bastion: "1.2.3.4"},
+			[]string{"-i", "key.pem", "-o", "ProxyCommand=ssh -i key.pem -W %h:%p core@1.2.3.4", "core@10.0.0.1"},
+		},
+		{
+			"bastion with user",
+			SSHTarget{User: "core", Host: "10.0.0.1", Bastion: "admin@jump.example.com"},
+			[]string{"-o", "ProxyCommand=ssh -W %h:%p admin@jump.example.com", "core@10.0.0.1"},
+		},
+		{
+			"options and command",
+			SSHTarget{User: "core", Host: "10.0.0.1", Options: []string{"-A", "-L", "8080:localhost:8080"}, Command: []string{"ls", "-l"}},
+			[]string{"-A", "-L", "8080:localhost:8080", "core@10.0.0.1", "ls", "-l"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := MakeSSHArgs(tc.target); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %q; want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSelectInstances(t *testing.T) {
+	instances := []*model.Instance{
+		{ID: "i-0", PrivateIP: "10.0.0.10", PublicIP: "1.2.3.4", NodeID: "0"},
+		{ID: "i-1", PrivateIP: "10.0.1.10", NodeID: "1"},
+		{ID: "i-2"},
+		{ID: "i-3", PrivateIP: "10.0.2.10"},
+	}
+
+	testCases := []struct {
+		name    string
+		node    string
+		index   int
+		want    []string
+		wantErr bool
+	}{
+		{"all", "", -1, []string{"i-0", "i-1", "i-3"}, false},
+		{"instance ID", "i-1", -1, []string{"i-1"}, false},
+		{"node ID", "0", -1, []string{"i-0"}, false},
+		{"private IP", "10.0.2.10", -1, []string{"i-3"}, false},
+		{"public IP", "1.2.3.4", -1, []string{"i-0"}, false},
+		{"not found", "i-9", -1, nil, true},
+		{"not reachable", "i-2", -1, nil, true},
+		{"index", "", 3, []string{"i-3"}, false},
+		{"index not reachable", "", 2, nil, true},
+		{"index out of range", "", 4, nil, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := SelectInstances(instances, tc.node, tc.index)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v; want error %v", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			ids := []string{}
+			for _, i := range got {
+				ids = append(ids, i.ID)
+			}
+			if !reflect.DeepEqual(ids, tc.want) {
+				t.Errorf("got %q; want %q", ids, tc.want)
+			}
+		})
+	}
+
+	if _, err := SelectInstances([]*model.Instance{{ID: "i-2"}}, "", -1); err == nil {
+		t.Error("expected an error without running nodes")
+	}
+}
+
+func TestInstanceAddress(t *testing.T) {
+	testCases := []struct {
+		name       string
+		instance   model.Instance
+		viaBastion bool
+		want       string
+	}{
+		{"public", model.Instance{PrivateIP: "10.0.0.10", PublicIP: "1.2.3.4"}, false, "1.2.3.4"},
+		{"internal", model.Instance{PrivateIP: "10.0.0.10", PublicIP: "1.2.3.4", Internal: true}, false, "10.0.0.10"},
+		{"no public IP", model.Instance{PrivateIP: "10.0.0.10"}, false, "10.0.0.10"},
+		{"via bastion", model.Instance{PrivateIP: "10.0.0.10", PublicIP: "1.2.3.4"}, true, "10.0.0.10"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := InstanceAddress(&tc.instance, tc.viaBastion); got != tc.want {
+				t.Errorf("got %q; want %q", got, tc.want)
+			}
+		})
+	}
+}
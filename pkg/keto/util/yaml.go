@@ -0,0 +1,98 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import yaml "gopkg.in/yaml.v3"
+
+// MergeYAMLNodes merges src node into dst node. Maps are merged recursively
+// and lists are appended to, unless nodes are tagged differently, e.g. a map
+// and a !Ref, in which case dst is replaced like anything else. List item
+// maps that have the same scalar value of any of itemKeys are merged instead
+// of appended.
+func MergeYAMLNodes(dst, src *yaml.Node, itemKeys ...string) {
+	switch {
+	case dst.Kind == yaml.MappingNode && src.Kind == yaml.MappingNode && dst.Tag == src.Tag:
+		for i := 0; i+1 < len(src.Content); i += 2 {
+			key, value := src.Content[i].Value, src.Content[i+1]
+			if v := MappingValue(dst, key); v != nil {
+				MergeYAMLNodes(v, value, itemKeys...)
+				continue
+			}
+			dst.Content = append(dst.Content, src.Content[i], value)
+		}
+	case dst.Kind == yaml.SequenceNode && src.Kind == yaml.SequenceNode && dst.Tag == src.Tag:
+	outer:
+		for _, item := range src.Content {
+			if k, v := listItemKey(item, itemKeys); k != "" {
+				for _, d := range dst.Content {
+					if dk, dv := listItemKey(d, itemKeys); dk == k && dv == v {
+						MergeYAMLNodes(d, item, itemKeys...)
+						continue outer
+					}
+				}
+			}
+			dst.Content = append(dst.Content, item)
+		}
+	default:
+		*dst = *src
+	}
+}
+
+// listItemKey returns the first of keys that a list item map has and its
+// value.
+func listItemKey(n *yaml.Node, keys []string) (string, string) {
+	if n.Kind != yaml.MappingNode {
+		return "", ""
+	}
+	for _, k := range keys {
+		if v := MappingValue(n, k); v != nil && v.Kind == yaml.ScalarNode {
+			return k, v.Value
+		}
+	}
+	return "", ""
+}
+
+// MappingValue returns a value node of a given key in a map node or nil.
+func MappingValue(n *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// SetMappingValue sets a value of a given key in a map node.
+func SetMappingValue(n *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			n.Content[i+1] = value
+			return
+		}
+	}
+	n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+}
+
+// DeleteMappingValue deletes a given key from a map node.
+func DeleteMappingValue(n *yaml.Node, key string) {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			n.Content = append(n.Content[:i], n.Content[i+2:]...)
+			return
+		}
+	}
+}
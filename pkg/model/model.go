@@ -31,9 +31,89 @@ type Cluster struct {
 	ComputePools []ComputePool
 	DNSZone      string
 	KubeAPIURL   string
+	// DNSZoneIDs are IDs of DNS zones named DNSZone that kube API records
+	// are created in. Zones are looked up by name if IDs aren't set.
+	DNSZoneIDs []string
+	// PrivateDNSZone looks up a private DNS zone that is associated with
+	// cluster networks instead of a public one.
+	PrivateDNSZone bool
+	// SplitHorizonDNS looks up both a public and a private DNS zone.
+	SplitHorizonDNS bool
+	// APIHostname is a kube API DNS name, kube-<cluster>.<DNS zone> by
+	// default. Its DNS record is only managed if it is in the DNS zone.
+	APIHostname string
+	// APIAliases are extra kube API DNS names in the DNS zone.
+	APIAliases []string
+	// APIExtraSANs are extra kube API server certificate subject alternative
+	// names, e.g. DNS names that are managed outside of the DNS zone.
+	APIExtraSANs []string
+	// NetworkProvider is a CNI provider, e.g. canal, calico, flannel or weave.
+	NetworkProvider string
+	// PodCIDR is a CIDR pod IP addresses are allocated from.
+	PodCIDR string
+	// ServiceCIDR is a CIDR service cluster IP addresses are allocated from.
+	ServiceCIDR string
+	// TemplateOverlays are applied to cluster scope cloud resources, such
+	// as cluster infra and load balancer, each to the one set by its Stack.
+	TemplateOverlays []TemplateOverlay
+	// Components pins images and binaries of cluster components.
+	Components Components
+	// RegistryMirror is a registry that component images are pulled from
+	// instead of their upstream registries, e.g. registry.example.com:5000.
+	RegistryMirror string
+	// ArtifactsURL is a base URL that component binaries are downloaded
+	// from instead of their upstream URLs.
+	ArtifactsURL string
+	// Proxy is an HTTP(S) proxy that nodes use for egress.
+	Proxy Proxy
+	// NetworkCIDRs are CIDRs of cluster networks, e.g. a VPC CIDR.
+	NetworkCIDRs []string
+	// NetworkCIDR is a CIDR of a network that is created for and owned by
+	// the cluster when master pool networks aren't given.
+	NetworkCIDR string
+	// NetworkZones is a number of availability zones a created network
+	// spans.
+	NetworkZones int
+	// Access is a set of network access rules for SSH and kube API.
+	Access Access
+	// LoadBalancerType is a type of load balancer that fronts kube API,
+	// e.g. classic or network.
+	LoadBalancerType string
 	Status
 }
 
+// Access is a representation of cluster network access rules.
+type Access struct {
+	// SSHCIDRs are CIDRs that SSH access to nodes is allowed from.
+	SSHCIDRs []string
+	// DisableSSH disallows SSH access to nodes from anywhere.
+	DisableSSH bool
+	// APICIDRs are CIDRs that kube API access is allowed from.
+	APICIDRs []string
+	// SourceSecurityGroups are extra security groups that are allowed SSH
+	// and kube API access.
+	SourceSecurityGroups []string
+}
+
+// Proxy is a representation of HTTP(S) proxy settings.
+type Proxy struct {
+	HTTPProxy  string
+	HTTPSProxy string
+	// NoProxy is a list of hosts, domains and CIDRs that are not proxied.
+	NoProxy []string
+}
+
+// Components is a versioned manifest of component images, binaries and their
+// checksums that are used by cluster nodes.
+type Components struct {
+	Version         string `json:"version" yaml:"version"`
+	KetoK8Image     string `json:"keto_k8_image" yaml:"keto_k8_image"`
+	KetoTokensImage string `json:"keto_tokens_image" yaml:"keto_tokens_image"`
+	EtcdImageTag    string `json:"etcd_image_tag" yaml:"etcd_image_tag"`
+	SmilodonURL     string `json:"smilodon_url" yaml:"smilodon_url"`
+	SmilodonMD5Sum  string `json:"smilodon_md5sum" yaml:"smilodon_md5sum"`
+}
+
 // Labels a map of labels.
 type Labels map[string]string
 
@@ -48,6 +128,40 @@ type MasterPool struct {
 // ComputePool is a representation of a compute node pool.
 type ComputePool struct {
 	NodePool
+	InstancesPolicy `json:"instances_policy,omitempty"`
+	// AllowFromPools are names of compute pools in the same cluster that are
+	// allowed to send traffic to this pool. Compute pools are isolated from
+	// each other otherwise.
+	AllowFromPools []string `json:"allow_from_pools,omitempty"`
+}
+
+// BastionPool is a representation of a bastion host pool, which nodes of a
+// cluster are reached through over SSH.
+type BastionPool struct {
+	NodePool
+	// AllowedCIDRs are CIDRs that SSH access to bastion hosts is allowed from.
+	AllowedCIDRs []string `json:"allowed_cidrs,omitempty"`
+	// Hosts are public addresses of running bastion hosts.
+	Hosts []string `json:"hosts,omitempty"`
+}
+
+// InstancesPolicy describes how compute pool instances are purchased. It
+// allows running spot instances and mixing multiple machine types in a pool.
+type InstancesPolicy struct {
+	// PurchaseStrategy is either on-demand, spot or mixed.
+	PurchaseStrategy string
+	// MachineTypes is a list of machine types that pool instances can be
+	// launched as in addition to MachineType.
+	MachineTypes []string
+	// OnDemandBaseCapacity is a number of on-demand instances that a pool
+	// always runs before spot instances are used.
+	OnDemandBaseCapacity int
+	// OnDemandPercentage is a percentage of on-demand instances above the
+	// base capacity.
+	OnDemandPercentage int
+	// SpotMaxPrice is a maximum hourly price paid for a spot instance. An
+	// empty price means on-demand price.
+	SpotMaxPrice string
 }
 
 // NodePool is a representation of a single node pool.
@@ -55,6 +169,10 @@ type NodePool struct {
 	ResourceMeta
 	NodePoolSpec
 	Status
+	// MinSizeSet and MaxSizeSet mark min and max sizes that are given in a
+	// node pool update, where a zero size otherwise means an unchanged one.
+	MinSizeSet bool `json:"-"`
+	MaxSizeSet bool `json:"-"`
 }
 
 // NodePoolSpec represent a node pool.
@@ -69,6 +187,83 @@ type NodePoolSpec struct {
 	UserData      []byte   `json:"user_data,omitempty"`
 	Taints        `json:"taints,omitempty"`
 	KubeArgs      `json:"kube_args,omitempty"`
+	// ExtraUserData is a user supplied cloud-config snippet, which is merged
+	// into the rendered cloud-config.
+	ExtraUserData []byte `json:"extra_user_data,omitempty"`
+	// UserDataFormat is a format user data is rendered in, e.g. cloud-config
+	// or ignition.
+	UserDataFormat string `json:"user_data_format,omitempty"`
+	// UserDataHash is a hash of the user data a node pool was created with.
+	UserDataHash string `json:"user_data_hash,omitempty"`
+	// TemplateOverlays are applied to node pool cloud resources.
+	TemplateOverlays []TemplateOverlay `json:"template_overlays,omitempty"`
+	// VolumeType is a boot disk volume type, e.g. gp3.
+	VolumeType string `json:"volume_type,omitempty"`
+	// VolumeIOPS is a number of provisioned boot disk IOPS.
+	VolumeIOPS int `json:"volume_iops,omitempty"`
+	// VolumeThroughput is a provisioned boot disk throughput in MiB/s.
+	VolumeThroughput int `json:"volume_throughput,omitempty"`
+	// IMDSTokens is whether the instance metadata service requires session
+	// tokens, either optional or required.
+	IMDSTokens string `json:"imds_tokens,omitempty"`
+	// IMDSHopLimit is an instance metadata service response hop limit.
+	IMDSHopLimit int `json:"imds_hop_limit,omitempty"`
+	// CreditSpecification is a CPU credit option of burstable machine types,
+	// either standard or unlimited.
+	CreditSpecification string `json:"credit_specification,omitempty"`
+	// MinSize is a minimum number of nodes a pool can be scaled down to.
+	MinSize int `json:"min_size,omitempty"`
+	// MaxSize is a maximum number of nodes a pool can be scaled up to. A pool
+	// with MaxSize greater than MinSize is managed by cluster-autoscaler.
+	MaxSize int `json:"max_size,omitempty"`
+}
+
+// Instance is a representation of a single node pool machine.
+type Instance struct {
+	ID          string `json:"id,omitempty"`
+	ClusterName string `json:"cluster_name,omitempty"`
+	PoolName    string `json:"pool_name,omitempty"`
+	Zone        string `json:"zone,omitempty"`
+	PrivateIP   string `json:"private_ip,omitempty"`
+	PublicIP    string `json:"public_ip,omitempty"`
+	ImageID     string `json:"image_id,omitempty"`
+	// Internal is whether the instance belongs to an internal pool, which is
+	// only reachable at its private IP.
+	Internal bool `json:"internal,omitempty"`
+	// Launched is an instance launch time in seconds since the epoch.
+	Launched int64 `json:"launched,omitempty"`
+	// LifecycleState is a pool lifecycle state of an instance, e.g.
+	// InService or Pending.
+	LifecycleState string `json:"lifecycle_state,omitempty"`
+	// HealthStatus is either Healthy or Unhealthy.
+	HealthStatus string `json:"health_status,omitempty"`
+	// NetworkInterface is a persistent network interface that is attached
+	// to a master instance.
+	NetworkInterface string `json:"network_interface,omitempty"`
+	// NodeID is a persistent master node ID, which etcd members are named
+	// after.
+	NodeID string `json:"node_id,omitempty"`
+}
+
+// CheckResult is a result of a single cluster health check.
+type CheckResult struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	// Message explains the result, e.g. which resources failed a check.
+	Message string `json:"message,omitempty"`
+	// Warning marks a passed check whose result couldn't be fully verified.
+	Warning bool `json:"warning,omitempty"`
+}
+
+// TemplateOverlay is a user supplied fragment that gets applied to a cloud
+// provider resource template. Data is either a list of JSON patch operations
+// or a fragment that is merged into a template.
+type TemplateOverlay struct {
+	Name string `json:"name,omitempty"`
+	// Stack is a cluster scope template the overlay applies to, either infra
+	// or loadbalancer. It isn't set for node pool overlays.
+	Stack string `json:"stack,omitempty"`
+	Data  []byte `json:"-"`
 }
 
 // ResourceMeta is a resource metadata.
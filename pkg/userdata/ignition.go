@@ -0,0 +1,263 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package userdata
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+const (
+	// FormatCloudConfig is a legacy Container Linux cloud-config format.
+	FormatCloudConfig = "cloud-config"
+	// FormatIgnition is an Ignition spec v2 format.
+	FormatIgnition = "ignition"
+	// FormatIgnitionV3 is an Ignition spec v3 format.
+	FormatIgnitionV3 = "ignition-v3"
+
+	ignitionV2Version = "2.2.0"
+	ignitionV3Version = "3.0.0"
+
+	// updateConfPath is where Container Linux reads update settings from.
+	updateConfPath = "/etc/coreos/update.conf"
+	// networkdUnitsDir is where networkd units get written to.
+	networkdUnitsDir = "/etc/systemd/network"
+
+	defaultFileMode  = 0644
+	defaultUnitMode  = 0644
+	installSection   = "[Install]"
+	defaultWantedBy  = "WantedBy=multi-user.target"
+	unitCommandStart = "start"
+	unitCommandStop  = "stop"
+)
+
+// Formats returns a list of supported user data formats.
+func Formats() []string {
+	return []string{FormatCloudConfig, FormatIgnition, FormatIgnitionV3}
+}
+
+// RenderUserData renders user data in a given format from a cloud-config.
+// An empty format defaults to cloud-config, which is returned as is.
+func (u UserData) RenderUserData(format string, cloudConfig []byte) ([]byte, error) {
+	switch format {
+	case "", FormatCloudConfig:
+		return cloudConfig, nil
+	case FormatIgnition:
+		return CloudConfigToIgnition(cloudConfig, ignitionV2Version)
+	case FormatIgnitionV3:
+		return CloudConfigToIgnition(cloudConfig, ignitionV3Version)
+	}
+	return nil, fmt.Errorf("unsupported user data format %q, supported formats: %s",
+		format, strings.Join(Formats(), ", "))
+}
+
+// cloudConfig is a subset of cloud-config that keto renders.
+type cloudConfig struct {
+	CoreOS struct {
+		Update struct {
+			RebootStrategy string `yaml:"reboot-strategy"`
+		} `yaml:"update"`
+		Units []cloudConfigUnit `yaml:"units"`
+	} `yaml:"coreos"`
+	WriteFiles []cloudConfigFile `yaml:"write_files"`
+}
+
+type cloudConfigUnit struct {
+	Name    string `yaml:"name"`
+	Command string `yaml:"command"`
+	Enable  bool   `yaml:"enable"`
+	Mask    bool   `yaml:"mask"`
+	Runtime bool   `yaml:"runtime"`
+	Content string `yaml:"content"`
+	DropIns []struct {
+		Name    string `yaml:"name"`
+		Content string `yaml:"content"`
+	} `yaml:"drop-ins"`
+}
+
+type cloudConfigFile struct {
+	Path        string `yaml:"path"`
+	Permissions string `yaml:"permissions"`
+	Owner       string `yaml:"owner"`
+	Content     string `yaml:"content"`
+}
+
+// ignitionConfig is a subset of Ignition spec, which is common to v2 and v3.
+type ignitionConfig struct {
+	Ignition struct {
+		Version string `json:"version"`
+	} `json:"ignition"`
+	Storage struct {
+		Files []ignitionFile `json:"files,omitempty"`
+	} `json:"storage"`
+	Systemd struct {
+		Units []ignitionUnit `json:"units,omitempty"`
+	} `json:"systemd"`
+}
+
+type ignitionFile struct {
+	// Filesystem is only used by v2.
+	Filesystem string `json:"filesystem,omitempty"`
+	Path       string `json:"path"`
+	Mode       int    `json:"mode"`
+	// Overwrite is only used by v3.
+	Overwrite *bool `json:"overwrite,omitempty"`
+	User      *struct {
+		Name string `json:"name"`
+	} `json:"user,omitempty"`
+	Contents struct {
+		Source string `json:"source"`
+	} `json:"contents"`
+}
+
+type ignitionUnit struct {
+	Name     string           `json:"name"`
+	Enabled  *bool            `json:"enabled,omitempty"`
+	Mask     bool             `json:"mask,omitempty"`
+	Contents string           `json:"contents,omitempty"`
+	Dropins  []ignitionDropin `json:"dropins,omitempty"`
+}
+
+type ignitionDropin struct {
+	Name     string `json:"name"`
+	Contents string `json:"contents"`
+}
+
+// CloudConfigToIgnition converts a cloud-config to an Ignition config of a
+// given spec version. Units, drop-ins, files and the update reboot strategy
+// are converted. Other cloud-config keys are not supported.
+func CloudConfigToIgnition(b []byte, version string) ([]byte, error) {
+	if err := checkCloudConfigKeys(b); err != nil {
+		return nil, err
+	}
+	var c cloudConfig
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse cloud-config: %v", err)
+	}
+
+	ign := ignitionConfig{}
+	ign.Ignition.Version = version
+	v2 := strings.HasPrefix(version, "2.")
+
+	addFile := func(p string, mode int, owner, content string) {
+		f := ignitionFile{Path: p, Mode: mode}
+		if v2 {
+			f.Filesystem = "root"
+		} else {
+			overwrite := true
+			f.Overwrite = &overwrite
+		}
+		if owner != "" {
+			f.User = &struct {
+				Name string `json:"name"`
+			}{Name: owner}
+		}
+		f.Contents.Source = "data:;base64," + base64.StdEncoding.EncodeToString([]byte(content))
+		ign.Storage.Files = append(ign.Storage.Files, f)
+	}
+
+	if s := c.CoreOS.Update.RebootStrategy; s != "" {
+		addFile(updateConfPath, defaultFileMode, "", fmt.Sprintf("REBOOT_STRATEGY=%s\n", s))
+	}
+
+	for _, f := range c.WriteFiles {
+		mode := defaultFileMode
+		if f.Permissions != "" {
+			m, err := strconv.ParseInt(f.Permissions, 8, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %q permissions %q", f.Path, f.Permissions)
+			}
+			mode = int(m)
+		}
+		addFile(f.Path, mode, f.Owner, f.Content)
+	}
+
+	for _, u := range c.CoreOS.Units {
+		// Networkd units are plain files, Ignition v3 has no networkd section.
+		if isNetworkdUnit(u.Name) {
+			addFile(path.Join(networkdUnitsDir, u.Name), defaultUnitMode, "", u.Content)
+			continue
+		}
+
+		unit := ignitionUnit{Name: u.Name, Mask: u.Mask, Contents: u.Content}
+		switch u.Command {
+		case unitCommandStart:
+			u.Enable = true
+		case unitCommandStop:
+			// Units that cloud-config stops on every boot never run.
+			unit.Mask = true
+		}
+		// Other commands, e.g. restart, are only needed by cloud-config to
+		// pick up written files, Ignition writes files before units start.
+		if u.Enable && !unit.Mask {
+			enabled := true
+			unit.Enabled = &enabled
+			// Units are enabled via their install section.
+			if unit.Contents != "" && !strings.Contains(unit.Contents, installSection) {
+				unit.Contents = strings.TrimRight(unit.Contents, "\n") + "\n\n" + installSection + "\n" + defaultWantedBy + "\n"
+			}
+		}
+		for _, d := range u.DropIns {
+			unit.Dropins = append(unit.Dropins, ignitionDropin{Name: d.Name, Contents: d.Content})
+		}
+		ign.Systemd.Units = append(ign.Systemd.Units, unit)
+	}
+
+	return json.MarshalIndent(ign, "", "  ")
+}
+
+// checkCloudConfigKeys returns an error if a cloud-config has keys that
+// cannot be converted.
+func checkCloudConfigKeys(b []byte) error {
+	var c map[string]interface{}
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		return fmt.Errorf("failed to parse cloud-config: %v", err)
+	}
+	for k, v := range c {
+		switch k {
+		case "write_files":
+		case "coreos":
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("cloud-config coreos must be a map")
+			}
+			for k := range m {
+				if k != "update" && k != "units" {
+					return fmt.Errorf("cloud-config coreos.%s is not supported by ignition", k)
+				}
+			}
+		default:
+			return fmt.Errorf("cloud-config %s is not supported by ignition", k)
+		}
+	}
+	return nil
+}
+
+// isNetworkdUnit returns true if a unit is a networkd unit.
+func isNetworkdUnit(name string) bool {
+	switch path.Ext(name) {
+	case ".network", ".netdev", ".link":
+		return true
+	}
+	return false
+}
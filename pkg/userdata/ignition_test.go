@@ -0,0 +1,154 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package userdata
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"path"
+	"strings"
+	"testing"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// checkIgnitionEquivalence checks that an Ignition config has the same
+// units, drop-ins and files as a cloud-config.
+func checkIgnitionEquivalence(t *testing.T, cc, ign []byte, version string) {
+	var c cloudConfig
+	if err := yaml.Unmarshal(cc, &c); err != nil {
+		t.Fatal(err)
+	}
+	var i ignitionConfig
+	if err := json.Unmarshal(ign, &i); err != nil {
+		t.Fatal(err)
+	}
+	if i.Ignition.Version != version {
+		t.Errorf("got version %q; want %q", i.Ignition.Version, version)
+	}
+
+	files := make(map[string]string)
+	modes := make(map[string]int)
+	for _, f := range i.Storage.Files {
+		modes[f.Path] = f.Mode
+		b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(f.Contents.Source, "data:;base64,"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Path] = string(b)
+	}
+	units := make(map[string]ignitionUnit)
+	for _, u := range i.Systemd.Units {
+		units[u.Name] = u
+	}
+
+	if files[updateConfPath] != "REBOOT_STRATEGY="+c.CoreOS.Update.RebootStrategy+"\n" {
+		t.Errorf("unexpected %s content %q", updateConfPath, files[updateConfPath])
+	}
+	for _, f := range c.WriteFiles {
+		if files[f.Path] != f.Content {
+			t.Errorf("file %q differs; got %q; want %q", f.Path, files[f.Path], f.Content)
+		}
+		if (f.Permissions == "0600" && modes[f.Path] != 0600) || (f.Permissions == "0644" && modes[f.Path] != 0644) {
+			t.Errorf("file %q mode differs; got %o; want %s", f.Path, modes[f.Path], f.Permissions)
+		}
+	}
+	for _, u := range c.CoreOS.Units {
+		if isNetworkdUnit(u.Name) {
+			if p := path.Join(networkdUnitsDir, u.Name); files[p] != u.Content {
+				t.Errorf("networkd unit %q differs; got %q; want %q", u.Name, files[p], u.Content)
+			}
+			continue
+		}
+		iu, ok := units[u.Name]
+		if !ok {
+			t.Errorf("unit %q not found", u.Name)
+			continue
+		}
+		if !strings.HasPrefix(iu.Contents, u.Content) {
+			t.Errorf("unit %q contents differ; got %q; want %q", u.Name, iu.Contents, u.Content)
+		}
+		if u.Mask != iu.Mask && u.Command != unitCommandStop {
+			t.Errorf("unit %q mask differs", u.Name)
+		}
+		enabled := (u.Enable || u.Command == unitCommandStart) && u.Command != unitCommandStop
+		if enabled != (iu.Enabled != nil && *iu.Enabled) {
+			t.Errorf("unit %q enabled differs; want %v", u.Name, enabled)
+		}
+		if enabled && iu.Contents != "" && !strings.Contains(iu.Contents, installSection) {
+			t.Errorf("enabled unit %q has no install section", u.Name)
+		}
+		if len(iu.Dropins) != len(u.DropIns) {
+			t.Errorf("unit %q has %d drop-ins; want %d", u.Name, len(iu.Dropins), len(u.DropIns))
+			continue
+		}
+		for n, d := range u.DropIns {
+			if iu.Dropins[n].Name != d.Name || iu.Dropins[n].Contents != d.Content {
+				t.Errorf("unit %q drop-in %q differs", u.Name, d.Name)
+			}
+		}
+	}
+}
+
+func TestRenderUserDataIgnition(t *testing.T) {
+	u := New(log.New(ioutil.Discard, "", 0))
+	master, err := u.RenderMasterCloudConfig("aws", testCluster, "v1.7.0", map[string]string{"0": "10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compute, err := u.RenderComputeCloudConfig("aws", testCluster, "v1.7.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]string{
+		FormatIgnition:   ignitionV2Version,
+		FormatIgnitionV3: ignitionV3Version,
+	}
+	for format, version := range cases {
+		for _, cc := range [][]byte{master, compute} {
+			ign, err := u.RenderUserData(format, cc)
+			if err != nil {
+				t.Fatalf("%s: %v", format, err)
+			}
+			checkIgnitionEquivalence(t, cc, ign, version)
+		}
+	}
+}
+
+func TestRenderUserDataFormats(t *testing.T) {
+	u := New(log.New(ioutil.Discard, "", 0))
+	cc := []byte("#cloud-config\ncoreos:\n  units:\n  - name: foo.service\n")
+
+	for _, format := range []string{"", FormatCloudConfig} {
+		b, err := u.RenderUserData(format, cc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != string(cc) {
+			t.Errorf("expected cloud-config to be returned as is for %q format", format)
+		}
+	}
+	if _, err := u.RenderUserData("foo", cc); err == nil {
+		t.Errorf("expected an error for unsupported format")
+	}
+	if _, err := u.RenderUserData(FormatIgnition, []byte("#cloud-config\nhostname: foo\n")); err == nil {
+		t.Errorf("expected an error for unsupported cloud-config keys")
+	}
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package userdata
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/UKHomeOffice/keto/pkg/keto/util"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+const (
+	cloudConfigHeader = "#cloud-config"
+
+	// sysctlsKey is a keto specific key of user supplied cloud-config
+	// snippets. Sysctls are written to sysctlsFilePath.
+	sysctlsKey      = "sysctls"
+	sysctlsFilePath = "/etc/sysctl.d/90-keto.conf"
+	sysctlsUnitName = "systemd-sysctl.service"
+)
+
+// listItemKeys are keys which identify cloud-config list items, e.g. units
+// by name and write_files by path.
+var listItemKeys = []string{"name", "path"}
+
+// MergeCloudConfig merges a user supplied cloud-config snippet into a
+// rendered cloud-config. Maps are merged recursively, list items that have
+// the same name or path are merged, other list items are appended and
+// anything else is replaced. Sysctls can be set via a top level sysctls map.
+// The result is validated before it is returned.
+func MergeCloudConfig(cloudConfig, snippet []byte) ([]byte, error) {
+	base, err := parseCloudConfig(cloudConfig)
+	if err != nil {
+		return nil, err
+	}
+	extra, err := parseCloudConfig(snippet)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse user data: %v", err)
+	}
+
+	if err := expandSysctls(extra); err != nil {
+		return nil, err
+	}
+	util.MergeYAMLNodes(base, extra, listItemKeys...)
+
+	if err := validateCloudConfig(base); err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	b.WriteString(cloudConfigHeader + "\n")
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(base); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// Hash returns a hex encoded sha256 hash of user data. Hashes are stored
+// alongside node pools, so user data drift can be detected.
+func Hash(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+// parseCloudConfig parses cloud-config and returns its top level map.
+func parseCloudConfig(b []byte) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return &yaml.Node{Kind: yaml.MappingNode}, nil
+	}
+	n := doc.Content[0]
+	if n.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("cloud-config must be a map")
+	}
+	// Header is added back when cloud-config is encoded.
+	n.HeadComment = ""
+	return n, nil
+}
+
+// expandSysctls replaces a sysctls map with a sysctl.d file and a unit that
+// applies it.
+func expandSysctls(n *yaml.Node) error {
+	sysctls := util.MappingValue(n, sysctlsKey)
+	if sysctls == nil {
+		return nil
+	}
+	util.DeleteMappingValue(n, sysctlsKey)
+
+	var m map[string]string
+	if err := sysctls.Decode(&m); err != nil {
+		return fmt.Errorf("%s must be a map: %v", sysctlsKey, err)
+	}
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	lines := []string{}
+	for _, k := range keys {
+		lines = append(lines, fmt.Sprintf("%s = %s", k, m[k]))
+	}
+
+	var extra yaml.Node
+	err := extra.Encode(map[string]interface{}{
+		"write_files": []map[string]string{{
+			"path":        sysctlsFilePath,
+			"permissions": "0644",
+			"owner":       "root",
+			"content":     strings.Join(lines, "\n") + "\n",
+		}},
+		"coreos": map[string]interface{}{
+			"units": []map[string]string{{
+				"name":    sysctlsUnitName,
+				"command": "restart",
+			}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	util.MergeYAMLNodes(n, &extra, listItemKeys...)
+	return nil
+}
+
+// validateCloudConfig checks that all units have unique names and all files
+// have unique paths.
+func validateCloudConfig(n *yaml.Node) error {
+	var c struct {
+		CoreOS struct {
+			Units []struct {
+				Name string `yaml:"name"`
+			} `yaml:"units"`
+		} `yaml:"coreos"`
+		WriteFiles []struct {
+			Path string `yaml:"path"`
+		} `yaml:"write_files"`
+	}
+	if err := n.Decode(&c); err != nil {
+		return fmt.Errorf("invalid cloud-config: %v", err)
+	}
+
+	units := make(map[string]bool)
+	for _, u := range c.CoreOS.Units {
+		if u.Name == "" {
+			return fmt.Errorf("invalid cloud-config: unit name must be set")
+		}
+		if units[u.Name] {
+			return fmt.Errorf("invalid cloud-config: duplicate unit %q", u.Name)
+		}
+		units[u.Name] = true
+	}
+
+	files := make(map[string]bool)
+	for _, f := range c.WriteFiles {
+		if f.Path == "" {
+			return fmt.Errorf("invalid cloud-config: file path must be set")
+		}
+		if files[f.Path] {
+			return fmt.Errorf("invalid cloud-config: duplicate file %q", f.Path)
+		}
+		files[f.Path] = true
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package userdata
+
+import (
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+const testSnippet = `#cloud-config
+coreos:
+  units:
+  - name: docker.service
+    drop-ins:
+    - name: 20-proxy.conf
+      content: |
+        [Service]
+        Environment="HTTP_PROXY=http://proxy:3128"
+  - name: log-shipper.service
+    command: start
+    content: |
+      [Service]
+      ExecStart=/usr/bin/true
+write_files:
+- path: /etc/log-shipper.conf
+  content: |
+    foo
+sysctls:
+  vm.max_map_count: "262144"
+`
+
+func TestMergeCloudConfig(t *testing.T) {
+	u := New(log.New(ioutil.Discard, "", 0))
+	cloudConfig, err := u.RenderComputeCloudConfig("aws", testCluster, "v1.7.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := MergeCloudConfig(cloudConfig, []byte(testSnippet))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(b), cloudConfigHeader+"\n") {
+		t.Errorf("expected merged cloud-config to start with %q", cloudConfigHeader)
+	}
+
+	var c struct {
+		CoreOS struct {
+			Units []struct {
+				Name    string `yaml:"name"`
+				Command string `yaml:"command"`
+				DropIns []struct {
+					Name string `yaml:"name"`
+				} `yaml:"drop-ins"`
+			} `yaml:"units"`
+		} `yaml:"coreos"`
+		WriteFiles []struct {
+			Path    string `yaml:"path"`
+			Content string `yaml:"content"`
+		} `yaml:"write_files"`
+		Sysctls map[string]string `yaml:"sysctls"`
+	}
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		t.Fatal(err)
+	}
+
+	units := make(map[string]int)
+	for _, u := range c.CoreOS.Units {
+		units[u.Name]++
+		if u.Name == "docker.service" && len(u.DropIns) != 2 {
+			t.Errorf("expected docker.service drop-ins to be merged, got %v", u.DropIns)
+		}
+		if u.Name == sysctlsUnitName && u.Command != "restart" {
+			t.Errorf("expected %s to be restarted", sysctlsUnitName)
+		}
+	}
+	for _, n := range []string{"docker.service", "log-shipper.service", "keto-k8.service", sysctlsUnitName} {
+		if units[n] != 1 {
+			t.Errorf("expected a single %q unit, got %d", n, units[n])
+		}
+	}
+
+	files := make(map[string]string)
+	for _, f := range c.WriteFiles {
+		files[f.Path] = f.Content
+	}
+	if _, ok := files["/etc/log-shipper.conf"]; !ok {
+		t.Errorf("expected /etc/log-shipper.conf to be added")
+	}
+	if files[sysctlsFilePath] != "vm.max_map_count = 262144\n" {
+		t.Errorf("unexpected %s content %q", sysctlsFilePath, files[sysctlsFilePath])
+	}
+	if c.Sysctls != nil {
+		t.Errorf("expected sysctls to be removed from cloud-config")
+	}
+}
+
+func TestMergeCloudConfigInvalid(t *testing.T) {
+	cases := map[string]string{
+		"unit without a name": "coreos:\n  units:\n  - command: start\n",
+		"file without a path": "write_files:\n- content: foo\n",
+		"not a map":           "- foo\n",
+		"invalid yaml":        "coreos: [",
+		"invalid sysctls":     "sysctls: [foo]\n",
+	}
+	for name, snippet := range cases {
+		if _, err := MergeCloudConfig([]byte("#cloud-config\n"), []byte(snippet)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestHash(t *testing.T) {
+	if Hash([]byte("a")) == Hash([]byte("b")) {
+		t.Errorf("expected different hashes")
+	}
+	if len(Hash(nil)) != 64 {
+		t.Errorf("expected a hex encoded sha256 hash")
+	}
+}
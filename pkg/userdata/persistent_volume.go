@@ -0,0 +1,69 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package userdata
+
+import "strings"
+
+// mountPersistentVolumeScript formats a master node persistent volume if
+// needed, mounts it and writes the node environment. DEVICE, NODE_ID and
+// NODE_IP are set by provider specific scripts.
+const mountPersistentVolumeScript = `until [[ -b ${DEVICE} ]]; do sleep 5; done
+blkid ${DEVICE} || mkfs.ext4 ${DEVICE}
+mkdir -p /data
+grep -q ' /data ' /proc/mounts || mount ${DEVICE} /data
+mkdir -p /run/smilodon
+printf 'NODE_ID=%s\nNODE_IP=%s\n' ${NODE_ID} ${NODE_IP} > /run/smilodon/environment
+`
+
+// openstackPersistentVolumeScript mounts a cinder volume that heat attaches
+// to a master node. Node ID, IP and volume ID come from server metadata.
+const openstackPersistentVolumeScript = `#!/bin/bash
+set -e
+until META=$(curl -sf http://169.254.169.254/openstack/latest/meta_data.json); do sleep 5; done
+meta() { echo "${META}" | jq -r ".meta[\"$1\"] // empty"; }
+NODE_ID=$(meta keto-node-id)
+NODE_IP=$(meta keto-node-ip)
+VOLUME_ID=$(meta keto-volume-id)
+[[ -n ${NODE_ID} && -n ${NODE_IP} && -n ${VOLUME_ID} ]]
+# Virtio disk serials are volume IDs cut to 20 characters.
+DEVICE=/dev/disk/by-id/virtio-${VOLUME_ID:0:20}
+` + mountPersistentVolumeScript
+
+// gcePersistentVolumeScript mounts a persistent disk that a stateful
+// instance group attaches to a master node. Node ID and IP come from
+// per-instance metadata.
+const gcePersistentVolumeScript = `#!/bin/bash
+set -e
+attr() { curl -sf -H 'Metadata-Flavor: Google' "http://169.254.169.254/computeMetadata/v1/instance/attributes/$1"; }
+until NODE_ID=$(attr keto-node-id) && NODE_IP=$(attr keto-node-ip); do sleep 5; done
+DEVICE=/dev/disk/by-id/google-keto-persistent
+` + mountPersistentVolumeScript
+
+// persistentVolumeScripts are keyed by cloud provider name. Smilodon only
+// manages EBS volumes and ENIs, so master nodes of other providers mount
+// their persistent volume with a script. It writes the same environment
+// file as smilodon, which etcd reads NODE_ID and NODE_IP from.
+var persistentVolumeScripts = map[string]string{
+	"gce":       gcePersistentVolumeScript,
+	"openstack": openstackPersistentVolumeScript,
+}
+
+// indent indents every line of s by n spaces.
+func indent(n int, s string) string {
+	pad := strings.Repeat(" ", n)
+	return pad + strings.Replace(strings.TrimSuffix(s, "\n"), "\n", "\n"+pad, -1)
+}
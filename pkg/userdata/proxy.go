@@ -0,0 +1,155 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package userdata
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/UKHomeOffice/keto/pkg/model"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+const (
+	// proxyEnvFilePath is a systemd environment file with proxy settings.
+	proxyEnvFilePath = "/etc/keto/proxy.env"
+	// proxyDropInName is a name of systemd drop-ins that load proxy settings.
+	proxyDropInName = "20-proxy.conf"
+	// proxyEnvironmentUnitName is a unit that adds proxy settings to
+	// /etc/environment, which is written by coreos-cloudinit at boot.
+	proxyEnvironmentUnitName = "proxy-environment.service"
+	// metadataIP is a link-local cloud metadata service IP address.
+	metadataIP = "169.254.169.254"
+)
+
+var (
+	masterProxyUnits  = []string{"docker.service", "etcd-member.service", "keto-k8.service"}
+	computeProxyUnits = []string{"docker.service", "keto-k8.service", "keto-tokens.service"}
+)
+
+// ValidateProxy validates proxy settings.
+func ValidateProxy(p model.Proxy) error {
+	for _, s := range []string{p.HTTPProxy, p.HTTPSProxy} {
+		if s == "" {
+			continue
+		}
+		u, err := url.Parse(s)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid proxy %q, must be an http(s) URL", s)
+		}
+	}
+	if len(p.NoProxy) > 0 && p.HTTPProxy == "" && p.HTTPSProxy == "" {
+		return fmt.Errorf("no proxy is set without http or https proxy")
+	}
+	return nil
+}
+
+// NoProxy returns a list of hosts that are not proxied. The cluster API
+// endpoint, cluster networks, the metadata service and localhost are added
+// to user specified ones.
+func NoProxy(c model.Cluster) []string {
+	list := []string{"localhost", "127.0.0.1", metadataIP}
+	if u, err := url.Parse(c.KubeAPIURL); err == nil && u.Hostname() != "" {
+		list = append(list, u.Hostname())
+	}
+	list = append(list, c.NetworkCIDRs...)
+	list = append(list, c.Proxy.NoProxy...)
+
+	seen := make(map[string]bool)
+	hosts := []string{}
+	for _, h := range list {
+		if h == "" || seen[h] {
+			continue
+		}
+		seen[h] = true
+		hosts = append(hosts, h)
+	}
+	return hosts
+}
+
+// proxyEnvFile returns a path of the proxy environment file, which is passed
+// to containers that reach the network, or an empty string if no proxy is set.
+// Systemd drop-ins only pass proxy settings to the docker client.
+func proxyEnvFile(c model.Cluster) string {
+	if c.Proxy.HTTPProxy == "" && c.Proxy.HTTPSProxy == "" {
+		return ""
+	}
+	return proxyEnvFilePath
+}
+
+// mergeProxyCloudConfig merges proxy settings into a cloud-config. Given units
+// get a drop-in that loads proxy settings, which are also appended to
+// /etc/environment. Cloud-config is returned as is if no proxy is set.
+func mergeProxyCloudConfig(cloudConfig []byte, c model.Cluster, units []string) ([]byte, error) {
+	if proxyEnvFile(c) == "" {
+		return cloudConfig, nil
+	}
+
+	noProxy := strings.Join(NoProxy(c), ",")
+	env := []string{}
+	for _, kv := range [][]string{
+		{"HTTP_PROXY", c.Proxy.HTTPProxy},
+		{"HTTPS_PROXY", c.Proxy.HTTPSProxy},
+		{"NO_PROXY", noProxy},
+	} {
+		if kv[1] == "" {
+			continue
+		}
+		env = append(env, kv[0]+"="+kv[1], strings.ToLower(kv[0])+"="+kv[1])
+	}
+
+	dropIns := []map[string]interface{}{}
+	for _, u := range units {
+		dropIns = append(dropIns, map[string]interface{}{
+			"name": u,
+			"drop-ins": []map[string]string{{
+				"name":    proxyDropInName,
+				"content": "[Service]\nEnvironmentFile=" + proxyEnvFilePath + "\n",
+			}},
+		})
+	}
+	dropIns = append(dropIns, map[string]interface{}{
+		"name":    proxyEnvironmentUnitName,
+		"command": "start",
+		"content": fmt.Sprintf(`[Unit]
+Description=Add proxy settings to /etc/environment
+Before=%s
+[Service]
+Type=oneshot
+RemainAfterExit=true
+ExecStart=/usr/bin/bash -c 'grep -q "^HTTP.*_PROXY=" /etc/environment || cat %s >> /etc/environment'
+`, strings.Join(units, " "), proxyEnvFilePath),
+	})
+
+	snippet, err := yaml.Marshal(map[string]interface{}{
+		"write_files": []map[string]string{{
+			"path":        proxyEnvFilePath,
+			"permissions": "0644",
+			"owner":       "root",
+			"content":     strings.Join(env, "\n") + "\n",
+		}},
+		"coreos": map[string]interface{}{
+			"units": dropIns,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return MergeCloudConfig(cloudConfig, snippet)
+}
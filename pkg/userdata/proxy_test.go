@@ -0,0 +1,116 @@
+/*
+Copyright 2017 The Keto Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package userdata
+
+import (
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/UKHomeOffice/keto/pkg/model"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+func TestRenderCloudConfigProxy(t *testing.T) {
+	u := New(log.New(ioutil.Discard, "", 0))
+	c := testCluster
+	c.KubeAPIURL = "https://api.foo.example.com"
+	c.NetworkCIDRs = []string{"10.0.0.0/16"}
+	c.Proxy = model.Proxy{
+		HTTPProxy:  "http://proxy:3128",
+		HTTPSProxy: "http://proxy:3128",
+		NoProxy:    []string{".example.com", "localhost"},
+	}
+
+	master, err := u.RenderMasterCloudConfig("aws", c, "v1.7.0", map[string]string{"0": "10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compute, err := u.RenderComputeCloudConfig("aws", c, "v1.7.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// keto-k8 and keto-tokens containers don't get the docker daemon proxy.
+	envFile := "--env-file " + proxyEnvFilePath + " \\\n"
+	if n := strings.Count(string(master), envFile); n != 2 {
+		t.Errorf("expected 2 master containers to get proxy settings, got %d", n)
+	}
+	if n := strings.Count(string(compute), envFile); n != 2 {
+		t.Errorf("expected 2 compute containers to get proxy settings, got %d", n)
+	}
+
+	for b, units := range map[string][]string{string(master): masterProxyUnits, string(compute): computeProxyUnits} {
+		var cc cloudConfig
+		if err := yaml.Unmarshal([]byte(b), &cc); err != nil {
+			t.Fatal(err)
+		}
+		dropIns := make(map[string]bool)
+		for _, u := range cc.CoreOS.Units {
+			for _, d := range u.DropIns {
+				if d.Name == proxyDropInName {
+					dropIns[u.Name] = true
+				}
+			}
+		}
+		for _, u := range units {
+			if !dropIns[u] {
+				t.Errorf("expected %s to have a proxy drop-in", u)
+			}
+		}
+
+		env := ""
+		for _, f := range cc.WriteFiles {
+			if f.Path == proxyEnvFilePath {
+				env = f.Content
+			}
+		}
+		want := "NO_PROXY=localhost,127.0.0.1,169.254.169.254,api.foo.example.com,10.0.0.0/16,.example.com\n"
+		if !strings.Contains(env, "HTTPS_PROXY=http://proxy:3128\n") || !strings.Contains(env, want) {
+			t.Errorf("unexpected proxy environment %q", env)
+		}
+	}
+}
+
+func TestRenderCloudConfigNoProxy(t *testing.T) {
+	u := New(log.New(ioutil.Discard, "", 0))
+	b, err := u.RenderComputeCloudConfig("aws", testCluster, "v1.7.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), proxyEnvFilePath) {
+		t.Errorf("expected no proxy settings")
+	}
+}
+
+func TestValidateProxy(t *testing.T) {
+	if err := ValidateProxy(model.Proxy{HTTPProxy: "http://proxy:3128", NoProxy: []string{"foo"}}); err != nil {
+		t.Error(err)
+	}
+	invalid := []model.Proxy{
+		{HTTPProxy: "proxy:3128"},
+		{HTTPSProxy: "ftp://proxy"},
+		{NoProxy: []string{"foo"}},
+	}
+	for _, p := range invalid {
+		if err := ValidateProxy(p); err == nil {
+			t.Errorf("expected an error for %#v", p)
+		}
+	}
+}
@@ -18,16 +18,19 @@ package userdata
 
 import (
 	"bytes"
-	"os"
+	"strings"
 	"text/template"
 
+	"github.com/UKHomeOffice/keto/pkg/components"
 	"github.com/UKHomeOffice/keto/pkg/constants"
+	"github.com/UKHomeOffice/keto/pkg/model"
 )
 
 // UserDater is an abstract interface for UserData, mainly for testing.
 type UserDater interface {
-	RenderMasterCloudConfig(string, string, string, map[string]string) ([]byte, error)
-	RenderComputeCloudConfig(string, string, string) ([]byte, error)
+	RenderMasterCloudConfig(string, model.Cluster, string, map[string]string) ([]byte, error)
+	RenderComputeCloudConfig(string, model.Cluster, string) ([]byte, error)
+	RenderUserData(string, []byte) ([]byte, error)
 }
 
 // UserData defines a user data struct.
@@ -48,10 +51,12 @@ func New(logger logger) *UserData {
 	return &UserData{Logger: logger}
 }
 
-// RenderMasterCloudConfig renders a master cloud-config.
+// RenderMasterCloudConfig renders a master cloud-config. Cluster network
+// provider, CIDRs and components fall back to defaults if they aren't set.
+// Images and downloads are rewritten to cluster mirrors if they are set.
 func (u UserData) RenderMasterCloudConfig(
 	cloudProviderName string,
-	clusterName string,
+	cluster model.Cluster,
 	kubeVersion string,
 	masterPersistentNodeIDIP map[string]string,
 ) ([]byte, error) {
@@ -73,6 +78,19 @@ coreos:
   - name: update-engine.service
     command: stop
     enable: false
+  {{- if .PersistentVolumeScript }}
+  - name: keto-persistent-volume.service
+    command: start
+    enable: true
+    content: |
+      [Unit]
+      Description=Mount the persistent volume of a master node
+      [Service]
+      ExecStart=/opt/bin/keto-persistent-volume
+      Restart=on-failure
+      RestartSec=10
+      TimeoutStartSec=300
+  {{- else }}
   - name: smilodon.service
     command: start
     enable: true
@@ -80,9 +98,9 @@ coreos:
       [Unit]
       Description=Smilodon - manage ebs+eni attachment
       [Service]
-      Environment="URL=https://github.com/UKHomeOffice/smilodon/releases/download/v0.1.0/smilodon-0.1.0-linux-amd64"
+      Environment="URL={{ .Components.SmilodonURL }}"
       Environment="OUTPUT_FILE=/opt/bin/smilodon"
-      Environment="MD5SUM=500aa5f37a332d8e680c7d707b524077"
+      Environment="MD5SUM={{ .Components.SmilodonMD5Sum }}"
       ExecStartPre=/usr/bin/mkdir -p /opt/bin
       ExecStartPre=/usr/bin/bash -c 'until [[ -x ${OUTPUT_FILE} ]] && [[ $(md5sum ${OUTPUT_FILE} | cut -f1 -d" ") == ${MD5SUM} ]]; do wget -q -O ${OUTPUT_FILE} ${URL} && chmod +x ${OUTPUT_FILE}; done'
       ExecStart=/opt/bin/smilodon \
@@ -93,6 +111,7 @@ coreos:
       Restart=always
       RestartSec=10
       TimeoutStartSec=300
+  {{- end }}
   # This is a dirty workaround hack until this has been fixed: https://github.com/systemd/systemd/issues/1784
   - name: networkd-restart.service
     command: start
@@ -126,7 +145,10 @@ coreos:
         EnvironmentFile=/run/smilodon/environment
         Environment=ETCD_CLIENT_CERT_AUTH=true
         Environment=ETCD_INITIAL_CLUSTER_STATE=new
-        Environment=ETCD_IMAGE_TAG=v3.1.5
+        Environment=ETCD_IMAGE_TAG={{ .Components.EtcdImageTag }}
+        {{- if .EtcdImage }}
+        Environment=ETCD_IMAGE_URL=docker://{{ .EtcdImage }}
+        {{- end }}
         Environment=ETCD_SSL_DIR=/run/etcd/certs
         Environment=ETCD_DATA_DIR=/data/etcd
 
@@ -137,7 +159,10 @@ coreos:
           --net host \
           -v /data/ca:/data/ca \
           -e ETCD_CA_FILE \
-          {{ .KetoK8Image }} \
+          {{- if .ProxyEnvFile }}
+          --env-file {{ .ProxyEnvFile }} \
+          {{- end }}
+          {{ .Components.KetoK8Image }} \
           save-assets \
           --cloud-provider={{ .CloudProviderName }} \
           --etcd-ca-key /data/ca/etcd/ca.key \
@@ -156,7 +181,7 @@ coreos:
           -e ETCD_KEY_FILE \
           -e ETCD_PEER_CERT_FILE \
           -e ETCD_PEER_KEY_FILE \
-          {{ .KetoK8Image }} \
+          {{ .Components.KetoK8Image }} \
           etcdcerts \
           --etcd-ca-key /data/ca/etcd/ca.key \
           --etcd-client-cert /run/kubeapiserver/etcd-client.crt \
@@ -165,7 +190,7 @@ coreos:
 
         # Only mount the public key for ETCD
         Environment="RKT_RUN_ARGS=\
-          --volume data-ca-etcd,kind=host,source=/data/ca/etcd/ca.crt --mount volume=data-ca-etcd,target=/data/ca/etcd/ca.crt"
+          --volume data-ca-etcd,kind=host,source=/data/ca/etcd/ca.crt --mount volume=data-ca-etcd,target=/data/ca/etcd/ca.crt{{ if .EtcdImage }} --insecure-options=image{{ end }}"
 
         ExecStartPre=/usr/bin/chown -R etcd:etcd ${ETCD_SSL_DIR}
 
@@ -211,9 +236,18 @@ coreos:
         -e ETCD_INITIAL_CLUSTER \
         -e ETCD_ADVERTISE_CLIENT_URLS \
         -e ETCD_CA_FILE \
-        {{ .KetoK8Image }} \
+{{- if .ProxyEnvFile }}
+        --env-file {{ .ProxyEnvFile }} \
+{{- end }}
+        {{ .Components.KetoK8Image }} \
         master \
         --cloud-provider={{ .CloudProviderName }} \
+{{- if .RegistryMirror }}
+        --registry-mirror={{ .RegistryMirror }} \
+{{- end }}
+{{- if .APICertSANs }}
+        --apiserver-cert-extra-sans={{ .APICertSANs }} \
+{{- end }}
         --etcd-client-ca /run/kubeapiserver/etcd-ca.crt \
         --etcd-client-cert /run/kubeapiserver/etcd-client.crt \
         --etcd-client-key /run/kubeapiserver/etcd-client.key \
@@ -221,11 +255,24 @@ coreos:
         --kube-ca-cert=/data/ca/kube/ca.crt \
         --kube-ca-key=/data/ca/kube/ca.key \
         --network-provider={{ .NetworkProvider }}
+{{- if .PodCIDR }} \
+        --pod-network-cidr={{ .PodCIDR }}
+{{- end }}
+{{- if .ServiceCIDR }} \
+        --service-cidr={{ .ServiceCIDR }}
+{{- end }}
       TimeoutStartSec=infinity
       RestartSec=20
       Restart=always
 
 write_files:
+{{- if .PersistentVolumeScript }}
+- path: /opt/bin/keto-persistent-volume
+  permissions: "0755"
+  owner: root
+  content: |
+{{ indent 4 .PersistentVolumeScript }}
+{{- end }}
 - path: /etc/etcd.env
   permissions: "0644"
   owner: root
@@ -265,34 +312,69 @@ write_files:
 `
 
 	data := struct {
+		APICertSANs              string
 		CloudProviderName        string
 		ClusterName              string
 		KubeVersion              string
-		KetoK8Image              string
+		Components               model.Components
+		EtcdImage                string
 		MasterPersistentNodeIDIP map[string]string
 		NetworkProvider          string
+		PersistentVolumeScript   string
+		PodCIDR                  string
+		ProxyEnvFile             string
+		RegistryMirror           string
+		ServiceCIDR              string
 	}{
+		APICertSANs:              strings.Join(apiCertSANs(cluster), ","),
 		CloudProviderName:        cloudProviderName,
-		ClusterName:              clusterName,
+		ClusterName:              cluster.Name,
 		KubeVersion:              kubeVersion,
-		KetoK8Image:              constants.DefaultKetoK8Image,
+		Components:               cluster.Components,
 		MasterPersistentNodeIDIP: masterPersistentNodeIDIP,
 		NetworkProvider:          constants.DefaultNetworkProvider,
+		PersistentVolumeScript:   persistentVolumeScripts[cloudProviderName],
+		ProxyEnvFile:             proxyEnvFile(cluster),
+		RegistryMirror:           cluster.RegistryMirror,
+	}
+	components.SetDefaults(&data.Components)
+	data.Components = components.Mirror(data.Components, cluster.RegistryMirror, cluster.ArtifactsURL)
+	// etcd-wrapper pulls etcd from quay.io unless a mirror is set.
+	if cluster.RegistryMirror != "" {
+		data.EtcdImage = components.MirrorImage(constants.DefaultEtcdImage, cluster.RegistryMirror)
+	}
+	if cluster.NetworkProvider != "" {
+		data.NetworkProvider = cluster.NetworkProvider
+	}
+	// keto-k8 only gets network CIDRs that differ from its defaults, which
+	// older keto-k8 images don't have flags for.
+	if cluster.PodCIDR != constants.DefaultPodCIDR {
+		data.PodCIDR = cluster.PodCIDR
+	}
+	if cluster.ServiceCIDR != constants.DefaultServiceCIDR {
+		data.ServiceCIDR = cluster.ServiceCIDR
 	}
 
-	t := template.Must(template.New("master-cloud-config").Parse(masterTemplate))
+	t := template.Must(template.New("master-cloud-config").Funcs(template.FuncMap{"indent": indent}).Parse(masterTemplate))
 	var b bytes.Buffer
 	if err := t.Execute(&b, data); err != nil {
 		return b.Bytes(), err
 	}
 
-	u.Logger.Printf("cloud-config for masterpool: %s", b.String())
+	cloudConfig, err := mergeProxyCloudConfig(b.Bytes(), cluster, masterProxyUnits)
+	if err != nil {
+		return nil, err
+	}
+
+	u.Logger.Printf("cloud-config for masterpool: %s", cloudConfig)
 
-	return b.Bytes(), nil
+	return cloudConfig, nil
 }
 
-// RenderComputeCloudConfig renders a compute cloud-config.
-func (u UserData) RenderComputeCloudConfig(cloudProviderName, clusterName, kubeVersion string) ([]byte, error) {
+// RenderComputeCloudConfig renders a compute cloud-config. Cluster components
+// fall back to defaults if they aren't set and are rewritten to cluster
+// mirrors if they are set.
+func (u UserData) RenderComputeCloudConfig(cloudProviderName string, cluster model.Cluster, kubeVersion string) ([]byte, error) {
 	const computeTemplate = `#cloud-config
 coreos:
   update:
@@ -326,9 +408,15 @@ coreos:
         -v /etc/kubernetes/:/etc/kubernetes/ \
         -v /var/run/dbus/:/var/run/dbus/ \
         -v /etc/systemd/system/:/etc/systemd/system/ \
-        {{ .KetoK8Image }} \
+{{- if .ProxyEnvFile }}
+        --env-file {{ .ProxyEnvFile }} \
+{{- end }}
+        {{ .Components.KetoK8Image }} \
         setup-compute \
         --cloud-provider={{ .CloudProviderName }}
+{{- if .RegistryMirror }} \
+        --registry-mirror={{ .RegistryMirror }}
+{{- end }}
 
   - name: keto-tokens.service
     command: start
@@ -346,7 +434,10 @@ coreos:
         --rm \
         --net host \
         -v /etc/kubernetes/:/etc/kubernetes/ \
-        ${KETO_TOKENS_IMAGE} \
+{{- if .ProxyEnvFile }}
+        --env-file {{ .ProxyEnvFile }} \
+{{- end }}
+        {{ .Components.KetoTokensImage }} \
         --verbose \
         --cloud=${KETO_TOKENS_CLOUD} \
         client \
@@ -383,23 +474,23 @@ write_files:
     vm.max_map_count=262144
 `
 
-	// TODO: remove this. This is only for testing until we find a better and safer way.
-	ketoK8ImageURI := constants.DefaultKetoK8Image
-	if uri := os.Getenv("KETO_K8_IMAGE_URI"); uri != "" {
-		ketoK8ImageURI = uri
-	}
-
 	data := struct {
 		ClusterName       string
 		KubeVersion       string
 		CloudProviderName string
-		KetoK8Image       string
+		Components        model.Components
+		ProxyEnvFile      string
+		RegistryMirror    string
 	}{
-		ClusterName:       clusterName,
+		ClusterName:       cluster.Name,
 		KubeVersion:       kubeVersion,
 		CloudProviderName: cloudProviderName,
-		KetoK8Image:       ketoK8ImageURI,
+		Components:        cluster.Components,
+		ProxyEnvFile:      proxyEnvFile(cluster),
+		RegistryMirror:    cluster.RegistryMirror,
 	}
+	components.SetDefaults(&data.Components)
+	data.Components = components.Mirror(data.Components, cluster.RegistryMirror, cluster.ArtifactsURL)
 
 	t := template.Must(template.New("compute-cloud-config").Parse(computeTemplate))
 	var b bytes.Buffer
@@ -407,7 +498,36 @@ write_files:
 		return b.Bytes(), err
 	}
 
-	u.Logger.Printf("cloud-config for computepool: %s", b.String())
+	cloudConfig, err := mergeProxyCloudConfig(b.Bytes(), cluster, computeProxyUnits)
+	if err != nil {
+		return nil, err
+	}
 
-	return b.Bytes(), nil
+	u.Logger.Printf("cloud-config for computepool: %s", cloudConfig)
+
+	return cloudConfig, nil
+}
+
+// apiCertSANs returns extra kube API server certificate subject alternative
+// names, which are the kube API hostname, its aliases and extra SANs. keto-k8
+// already gets the hostname from the kube API URL, so there are none unless
+// aliases or extra SANs are set.
+func apiCertSANs(c model.Cluster) []string {
+	if len(c.APIAliases) == 0 && len(c.APIExtraSANs) == 0 {
+		return nil
+	}
+	list := []string{c.APIHostname}
+	list = append(list, c.APIAliases...)
+	list = append(list, c.APIExtraSANs...)
+
+	seen := make(map[string]bool)
+	sans := []string{}
+	for _, n := range list {
+		if n == "" || seen[n] {
+			continue
+		}
+		seen[n] = true
+		sans = append(sans, n)
+	}
+	return sans
 }
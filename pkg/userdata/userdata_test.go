@@ -14,32 +14,157 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-//go:generate mockery -dir $GOPATH/src/github.com/UKHomeOffice/keto/pkg/userdata -name=UserDater
+//go:generate mockery --dir $GOPATH/src/github.com/UKHomeOffice/keto/pkg/userdata --name UserDater
 
 package userdata
 
 import (
 	"log"
 	"os"
+	"strings"
 	"testing"
 
+	"github.com/UKHomeOffice/keto/pkg/constants"
+	"github.com/UKHomeOffice/keto/pkg/model"
 	"github.com/UKHomeOffice/keto/testutil"
+
+	yaml "gopkg.in/yaml.v3"
 )
 
 const clusterName = "foo"
 
+var testCluster = model.Cluster{ResourceMeta: model.ResourceMeta{Name: clusterName}}
+
 func TestRenderMasterCloudConfig(t *testing.T) {
 	u := New(log.New(os.Stderr, "", log.LstdFlags))
-	s, err := u.RenderMasterCloudConfig("aws", clusterName, "v1.7.0", map[string]string{"0": "10.0.0.1"})
+	s, err := u.RenderMasterCloudConfig("aws", testCluster, "v1.7.0", map[string]string{"0": "10.0.0.1"})
 	if err != nil {
 		t.Error(err)
 	}
 	testutil.CheckTemplate(t, string(s), clusterName)
 }
 
+func TestRenderMasterCloudConfigPersistentVolume(t *testing.T) {
+	u := New(log.New(os.Stderr, "", log.LstdFlags))
+	b, err := u.RenderMasterCloudConfig("openstack", testCluster, "v1.7.0", map[string]string{"0": "10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cc map[string]interface{}
+	if err := yaml.Unmarshal(b, &cc); err != nil {
+		t.Fatalf("invalid cloud-config: %v", err)
+	}
+	if strings.Contains(string(b), "smilodon.service") {
+		t.Error("smilodon must only run on aws")
+	}
+	testutil.CheckTemplate(t, string(b), "ExecStart=/opt/bin/keto-persistent-volume")
+	testutil.CheckTemplate(t, string(b), "    DEVICE=/dev/disk/by-id/virtio-${VOLUME_ID:0:20}\n")
+
+	b, err = u.RenderMasterCloudConfig("gce", testCluster, "v1.7.0", map[string]string{"0": "10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "smilodon.service") {
+		t.Error("smilodon must only run on aws")
+	}
+	testutil.CheckTemplate(t, string(b), "    DEVICE=/dev/disk/by-id/google-keto-persistent\n")
+
+	b, err = u.RenderMasterCloudConfig("aws", testCluster, "v1.7.0", map[string]string{"0": "10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "keto-persistent-volume") {
+		t.Error("aws masters must not run the persistent volume script")
+	}
+}
+
+func TestRenderMasterCloudConfigNetwork(t *testing.T) {
+	u := New(log.New(os.Stderr, "", log.LstdFlags))
+	c := testCluster
+	c.PodCIDR = constants.DefaultPodCIDR
+	c.ServiceCIDR = constants.DefaultServiceCIDR
+	b, err := u.RenderMasterCloudConfig("aws", c, "v1.7.0", map[string]string{"0": "10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckTemplate(t, string(b), "--network-provider=canal\n      TimeoutStartSec")
+
+	c.NetworkProvider = "weave"
+	c.PodCIDR = "10.32.0.0/12"
+	c.ServiceCIDR = "10.96.0.0/16"
+	b, err = u.RenderMasterCloudConfig("aws", c, "v1.7.0", map[string]string{"0": "10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"--network-provider=weave",
+		"--pod-network-cidr=10.32.0.0/12",
+		"--service-cidr=10.96.0.0/16",
+	} {
+		if !strings.Contains(string(b), s) {
+			t.Errorf("expected master cloud-config to contain %q", s)
+		}
+	}
+}
+
+func TestRenderMasterCloudConfigAPICertSANs(t *testing.T) {
+	u := New(log.New(os.Stderr, "", log.LstdFlags))
+	c := testCluster
+	b, err := u.RenderMasterCloudConfig("aws", c, "v1.7.0", map[string]string{"0": "10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "--apiserver-cert-extra-sans") {
+		t.Error("extra SANs must not be passed when there are none")
+	}
+
+	c.APIHostname = "api.example.com"
+	if b, err = u.RenderMasterCloudConfig("aws", c, "v1.7.0", map[string]string{"0": "10.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "--apiserver-cert-extra-sans") {
+		t.Error("extra SANs must not be passed for the kube API hostname alone")
+	}
+
+	c.APIHostname = "api.example.com"
+	c.APIAliases = []string{"kube.example.com"}
+	c.APIExtraSANs = []string{"kube.corp.local", "api.example.com", "10.0.0.10"}
+	if b, err = u.RenderMasterCloudConfig("aws", c, "v1.7.0", map[string]string{"0": "10.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckTemplate(t, string(b),
+		"--apiserver-cert-extra-sans=api.example.com,kube.example.com,kube.corp.local,10.0.0.10 \\\n        --etcd-client-ca")
+}
+
+func TestRenderCloudConfigMirror(t *testing.T) {
+	u := New(log.New(os.Stderr, "", log.LstdFlags))
+	c := testCluster
+	c.RegistryMirror = "registry.local:5000"
+	c.ArtifactsURL = "https://artifacts.local/keto/"
+	master, err := u.RenderMasterCloudConfig("aws", c, "v1.7.0", map[string]string{"0": "10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compute, err := u.RenderComputeCloudConfig("aws", c, "v1.7.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"quay.io", constants.DefaultSmilodonURL} {
+		if strings.Contains(string(master), s) || strings.Contains(string(compute), s) {
+			t.Errorf("expected %q references to be rewritten", s)
+		}
+	}
+	testutil.CheckTemplate(t, string(master), "ETCD_IMAGE_URL=docker://registry.local:5000/coreos/etcd")
+	testutil.CheckTemplate(t, string(master), "URL=https://artifacts.local/keto/smilodon-")
+	testutil.CheckTemplate(t, string(compute), "registry.local:5000/ukhomeofficedigital/keto-k8:")
+	testutil.CheckTemplate(t, string(compute), "registry.local:5000/ukhomeofficedigital/keto-tokens:")
+	testutil.CheckTemplate(t, string(master), "--registry-mirror=registry.local:5000 \\\n")
+	testutil.CheckTemplate(t, string(compute), "--registry-mirror=registry.local:5000\n")
+}
+
 func TestRenderComputeCloudConfig(t *testing.T) {
 	u := New(log.New(os.Stderr, "", log.LstdFlags))
-	s, err := u.RenderComputeCloudConfig("aws", clusterName, "v1.7.0")
+	s, err := u.RenderComputeCloudConfig("aws", testCluster, "v1.7.0")
 	if err != nil {
 		t.Error(err)
 	}